@@ -0,0 +1,186 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	DefaultIdleCpuAlarmMinutes = int32(30)
+	// CloudWatch rejects alarms whose periods span more than a day
+	MaxIdleCpuAlarmMinutes = int32(24 * 60)
+	idleCpuAlarmPeriodSecs = int32(60)
+)
+
+func getIdleCpuAlarmName(tagPrefix string, instanceId string) string {
+	if tagPrefix == "" {
+		tagPrefix = DefaultTagPrefix
+	}
+
+	return tagPrefix + "-idle-cpu-" + instanceId
+}
+
+// createIdleCpuAlarm creates a CloudWatch alarm which terminates instanceId
+// once its average cpu utilization has remained below thresholdPct for
+// idleMins consecutive minutes. The instance must have detailed monitoring
+// enabled in order for 1 minute datapoints to be available.
+func createIdleCpuAlarm(ctx context.Context, awsCfg aws.Config,
+	tagPrefix string, instanceId string, thresholdPct float64,
+	idleMins int32) error {
+
+	if idleMins <= 0 {
+		idleMins = DefaultIdleCpuAlarmMinutes
+	}
+
+	cwClient := newCloudwatchClient(awsCfg)
+	terminateAction := fmt.Sprintf("arn:aws:automate:%v:ec2:terminate",
+		awsCfg.Region)
+	alarmInput := &cloudwatch.PutMetricAlarmInput{
+		AlarmName: aws.String(getIdleCpuAlarmName(tagPrefix, instanceId)),
+		AlarmDescription: aws.String(fmt.Sprintf("terminate %v when idle (added by spotsh)",
+			instanceId)),
+		AlarmActions:       []string{terminateAction},
+		ComparisonOperator: cwtypes.ComparisonOperatorLessThanThreshold,
+		Dimensions: []cwtypes.Dimension{
+			{
+				Name:  aws.String("InstanceId"),
+				Value: aws.String(instanceId),
+			},
+		},
+		EvaluationPeriods: aws.Int32(idleMins * 60 / idleCpuAlarmPeriodSecs),
+		MetricName:        aws.String("CPUUtilization"),
+		Namespace:         aws.String("AWS/EC2"),
+		Period:            aws.Int32(idleCpuAlarmPeriodSecs),
+		Statistic:         cwtypes.StatisticAverage,
+		Threshold:         aws.Float64(thresholdPct),
+		TreatMissingData:  aws.String("notBreaching"),
+		Unit:              cwtypes.StandardUnitPercent,
+	}
+
+	_, err := cwClient.PutMetricAlarm(ctx, alarmInput)
+	return err
+}
+
+// DeleteIdleCpuAlarm removes the idle cpu alarm associated with instanceId if
+// one exists.
+func DeleteIdleCpuAlarm(awsCfg aws.Config, tagPrefix string,
+	instanceId string) error {
+
+	cwClient := newCloudwatchClient(awsCfg)
+	alarmName := getIdleCpuAlarmName(tagPrefix, instanceId)
+
+	ctx := context.Background()
+	descInput := &cloudwatch.DescribeAlarmsInput{
+		AlarmNames: []string{alarmName},
+	}
+	descOutput, err := cwClient.DescribeAlarms(ctx, descInput)
+	if err != nil {
+		return err
+	}
+	if len(descOutput.MetricAlarms) == 0 {
+		return nil
+	}
+
+	deleteInput := &cloudwatch.DeleteAlarmsInput{
+		AlarmNames: []string{alarmName},
+	}
+	_, err = cwClient.DeleteAlarms(ctx, deleteInput)
+	return err
+}
+
+// DeleteStaleIdleCpuAlarms removes the idle cpu alarms of instances which
+// have since terminated, including those the alarm itself terminated, and
+// returns the names of the deleted alarms.
+func DeleteStaleIdleCpuAlarms(awsCfg aws.Config,
+	tagPrefix string) ([]string, error) {
+
+	return deleteStaleIdleCpuAlarms(context.Background(),
+		newCloudwatchClient(awsCfg), newEc2Client(awsCfg), tagPrefix)
+}
+
+func deleteStaleIdleCpuAlarms(ctx context.Context, cwClient cloudwatchApi,
+	ec2Client ec2Api, tagPrefix string) ([]string, error) {
+
+	namePrefix := getIdleCpuAlarmName(tagPrefix, "")
+	descInput := &cloudwatch.DescribeAlarmsInput{
+		AlarmNamePrefix: aws.String(namePrefix),
+	}
+	alarmsByInstance := make(map[string]string)
+	paginator := cloudwatch.NewDescribeAlarmsPaginator(cwClient, descInput)
+	for paginator.HasMorePages() {
+		descOutput, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to describe alarms: %w", err)
+		}
+		for _, alarm := range descOutput.MetricAlarms {
+			alarmName := aws.ToString(alarm.AlarmName)
+			alarmsByInstance[strings.TrimPrefix(alarmName, namePrefix)] =
+				alarmName
+		}
+	}
+	if len(alarmsByInstance) == 0 {
+		return nil, nil
+	}
+
+	instanceIds := make([]string, 0, len(alarmsByInstance))
+	for instanceId := range alarmsByInstance {
+		instanceIds = append(instanceIds, instanceId)
+	}
+	// filtering rather than specifying InstanceIds avoids failing once a
+	// terminated instance is no longer visible
+	instInput := &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("instance-id"),
+				Values: instanceIds,
+			},
+		},
+	}
+	instOutput, err := ec2Client.DescribeInstances(ctx, instInput)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe instances: %w", err)
+	}
+	live := make(map[string]bool)
+	for _, resv := range instOutput.Reservations {
+		for _, inst := range resv.Instances {
+			if inst.State != nil &&
+				inst.State.Name == types.InstanceStateNameTerminated {
+				continue
+			}
+			live[aws.ToString(inst.InstanceId)] = true
+		}
+	}
+
+	var stale []string
+	for instanceId, alarmName := range alarmsByInstance {
+		if !live[instanceId] {
+			stale = append(stale, alarmName)
+		}
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	sort.Strings(stale)
+	deleteInput := &cloudwatch.DeleteAlarmsInput{
+		AlarmNames: stale,
+	}
+	_, err = cwClient.DeleteAlarms(ctx, deleteInput)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to delete alarms %v: %w",
+			strings.Join(stale, ","), err)
+	}
+
+	return stale, nil
+}
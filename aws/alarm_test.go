@@ -0,0 +1,75 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestDeleteStaleIdleCpuAlarms(t *testing.T) {
+	var deletedNames []string
+	cwMock := &mockCloudwatchClient{
+		describeAlarms: func(input *cloudwatch.DescribeAlarmsInput) (*cloudwatch.DescribeAlarmsOutput, error) {
+			if aws.ToString(input.AlarmNamePrefix) != "spotsh-idle-cpu-" {
+				t.Errorf("unexpected alarm name prefix %v",
+					aws.ToString(input.AlarmNamePrefix))
+			}
+			return &cloudwatch.DescribeAlarmsOutput{
+				MetricAlarms: []cwtypes.MetricAlarm{
+					{AlarmName: aws.String("spotsh-idle-cpu-i-running")},
+					{AlarmName: aws.String("spotsh-idle-cpu-i-terminated")},
+					{AlarmName: aws.String("spotsh-idle-cpu-i-gone")},
+				},
+			}, nil
+		},
+		deleteAlarms: func(input *cloudwatch.DeleteAlarmsInput) (*cloudwatch.DeleteAlarmsOutput, error) {
+			deletedNames = input.AlarmNames
+			return &cloudwatch.DeleteAlarmsOutput{}, nil
+		},
+	}
+	ec2Mock := &mockEc2Client{
+		describeInstances: func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{{
+					Instances: []types.Instance{
+						{
+							InstanceId: aws.String("i-running"),
+							State: &types.InstanceState{
+								Name: types.InstanceStateNameRunning,
+							},
+						},
+						{
+							InstanceId: aws.String("i-terminated"),
+							State: &types.InstanceState{
+								Name: types.InstanceStateNameTerminated,
+							},
+						},
+					},
+				}},
+			}, nil
+		},
+	}
+
+	deleted, err := deleteStaleIdleCpuAlarms(context.Background(), cwMock,
+		ec2Mock, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"spotsh-idle-cpu-i-gone",
+		"spotsh-idle-cpu-i-terminated"}
+	if !reflect.DeepEqual(deleted, expected) ||
+		!reflect.DeepEqual(deletedNames, expected) {
+		t.Errorf("expected %v deleted but got %v/%v", expected, deleted,
+			deletedNames)
+	}
+}
@@ -0,0 +1,35 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"fmt"
+	"strings"
+)
+
+// authorizedKeysScriptFmt appends additional public keys to the ssh user's
+// authorized_keys; cloud-init runs user scripts after it has installed the
+// instance's own keypair so the file & its permissions already exist
+const authorizedKeysScriptFmt = `#!/bin/bash
+# spotsh: authorize additional ssh public keys
+AUTH_KEYS=$(getent passwd %v | cut -d: -f6)/.ssh/authorized_keys
+cat >> "$AUTH_KEYS" <<'SPOTSH_EOF'
+%vSPOTSH_EOF
+`
+
+// getAuthorizedKeysUserData returns a user data script authorizing
+// authorizedKeys for user followed by initCmd which, when set, must itself
+// be a shell script
+func getAuthorizedKeysUserData(user string, authorizedKeys []string,
+	initCmd string) (string, error) {
+
+	var sb strings.Builder
+	for _, key := range authorizedKeys {
+		sb.WriteString(strings.TrimSpace(key) + "\n")
+	}
+
+	return prependUserDataScript(fmt.Sprintf(authorizedKeysScriptFmt,
+		shellQuote(user), sb.String()), initCmd)
+}
@@ -0,0 +1,29 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetAuthorizedKeysUserData(t *testing.T) {
+	userData, err := getAuthorizedKeysUserData("ubuntu",
+		[]string{"ssh-ed25519 AAAA1 alice", "ssh-rsa AAAA2\n"},
+		"#!/bin/bash\necho hello\n")
+	if err != nil || strings.Count(userData, "#!") != 1 ||
+		!strings.Contains(userData, "getent passwd 'ubuntu' ") ||
+		!strings.Contains(userData,
+			"\nssh-ed25519 AAAA1 alice\nssh-rsa AAAA2\nSPOTSH_EOF\n") ||
+		!strings.HasSuffix(userData, "SPOTSH_EOF\necho hello\n") {
+		t.Errorf("unexpected user data %v err:%v", userData, err)
+	}
+
+	_, err = getAuthorizedKeysUserData("ubuntu", []string{"ssh-rsa AAAA2"},
+		"#cloud-config\npackages: [git]\n")
+	if err == nil {
+		t.Errorf("expected error for a #cloud-config init command")
+	}
+}
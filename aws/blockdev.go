@@ -0,0 +1,103 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// DefaultBlockDeviceVolumeType is used when a BlockDevice's spec omits the
+// volume type
+const DefaultBlockDeviceVolumeType = types.VolumeTypeGp3
+
+// BlockDevice is an additional EBS volume attached to the instance at
+// launch & deleted when the instance is terminated
+type BlockDevice struct {
+	DeviceName string // e.g. /dev/sdf
+	SizeInGiB  int32
+	VolumeType types.VolumeType // defaults to DefaultBlockDeviceVolumeType
+}
+
+// ParseBlockDevice parses a <device>:<sizeGiB>[:<type>] spec such as
+// /dev/sdf:100:gp3
+func ParseBlockDevice(spec string) (BlockDevice, error) {
+	fields := strings.Split(spec, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return BlockDevice{}, fmt.Errorf("Invalid block device '%v'; must be <device>:<sizeGiB>[:<type>] such as /dev/sdf:100:gp3",
+			spec)
+	}
+	blockDev := BlockDevice{
+		DeviceName: fields[0],
+		VolumeType: DefaultBlockDeviceVolumeType,
+	}
+	if !strings.HasPrefix(blockDev.DeviceName, "/dev/") {
+		return BlockDevice{}, fmt.Errorf("Invalid block device name '%v'; must be of the form /dev/sd[f-p]",
+			blockDev.DeviceName)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil || size <= 0 {
+		return BlockDevice{}, fmt.Errorf("Invalid block device size '%v'; must be a positive number of GiB",
+			fields[1])
+	}
+	blockDev.SizeInGiB = int32(size)
+	if len(fields) == 3 {
+		blockDev.VolumeType = types.VolumeType(fields[2])
+		if !slices.Contains(blockDev.VolumeType.Values(), blockDev.VolumeType) {
+			return BlockDevice{}, fmt.Errorf("Invalid block device volume type '%v'; must be one of %v",
+				fields[2], blockDev.VolumeType.Values())
+		}
+	}
+
+	return blockDev, nil
+}
+
+// getBlockDeviceMaps returns launch template mappings for blockDevs after
+// verifying none collide w/ each other or w/ any of usedNames (e.g. the
+// root volume's device name)
+func getBlockDeviceMaps(blockDevs []BlockDevice,
+	usedNames []string) ([]types.LaunchTemplateBlockDeviceMappingRequest, error) {
+
+	used := make(map[string]bool)
+	for _, name := range usedNames {
+		used[canonicalDeviceName(name)] = true
+	}
+	blockMaps := make([]types.LaunchTemplateBlockDeviceMappingRequest, 0,
+		len(blockDevs))
+	for _, blockDev := range blockDevs {
+		name := canonicalDeviceName(blockDev.DeviceName)
+		if used[name] {
+			return nil, fmt.Errorf("Block device %v collides w/ another of the instance's devices",
+				blockDev.DeviceName)
+		}
+		used[name] = true
+		blockMaps = append(blockMaps, types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName: aws.String(blockDev.DeviceName),
+			Ebs: &types.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeSize:          aws.Int32(blockDev.SizeInGiB),
+				VolumeType:          blockDev.VolumeType,
+				DeleteOnTermination: aws.Bool(true),
+			},
+		})
+	}
+
+	return blockMaps, nil
+}
+
+// canonicalDeviceName maps the equivalent /dev/sdX & /dev/xvdX forms of a
+// device name (optionally w/ a partition number) to the same string
+func canonicalDeviceName(name string) string {
+	name = strings.TrimPrefix(name, "/dev/")
+	if strings.HasPrefix(name, "xvd") {
+		name = "sd" + strings.TrimPrefix(name, "xvd")
+	}
+
+	return strings.TrimRight(name, "0123456789")
+}
@@ -0,0 +1,65 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestParseBlockDevice(t *testing.T) {
+	blockDev, err := ParseBlockDevice("/dev/sdf:100:io2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blockDev.DeviceName != "/dev/sdf" || blockDev.SizeInGiB != 100 ||
+		blockDev.VolumeType != types.VolumeTypeIo2 {
+		t.Errorf("unexpected block device %+v", blockDev)
+	}
+
+	blockDev, err = ParseBlockDevice("/dev/sdg:20")
+	if err != nil || blockDev.VolumeType != DefaultBlockDeviceVolumeType {
+		t.Errorf("expected default volume type; got %+v err:%v", blockDev,
+			err)
+	}
+
+	for _, spec := range []string{"/dev/sdf", "sdf:100", "/dev/sdf:0",
+		"/dev/sdf:big", "/dev/sdf:100:gp9", "/dev/sdf:1:gp3:x"} {
+		_, err = ParseBlockDevice(spec)
+		if err == nil {
+			t.Errorf("expected error for %v", spec)
+		}
+	}
+}
+
+func TestGetBlockDeviceMaps(t *testing.T) {
+	blockDevs := []BlockDevice{
+		{DeviceName: "/dev/sdf", SizeInGiB: 100, VolumeType: types.VolumeTypeGp3},
+		{DeviceName: "/dev/sdg", SizeInGiB: 50, VolumeType: types.VolumeTypeSt1},
+	}
+	blockMaps, err := getBlockDeviceMaps(blockDevs, []string{"/dev/xvda"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(blockMaps) != 2 || *blockMaps[1].DeviceName != "/dev/sdg" ||
+		*blockMaps[1].Ebs.VolumeSize != 50 ||
+		blockMaps[1].Ebs.VolumeType != types.VolumeTypeSt1 ||
+		!aws.ToBool(blockMaps[1].Ebs.DeleteOnTermination) {
+		t.Errorf("unexpected block maps %+v", blockMaps)
+	}
+
+	_, err = getBlockDeviceMaps([]BlockDevice{{DeviceName: "/dev/sda",
+		SizeInGiB: 10}}, []string{"/dev/xvda"})
+	if err == nil {
+		t.Errorf("expected collision w/ root device")
+	}
+	_, err = getBlockDeviceMaps(append(blockDevs, BlockDevice{
+		DeviceName: "/dev/xvdf", SizeInGiB: 10}), []string{"/dev/sda1"})
+	if err == nil {
+		t.Errorf("expected collision between block devices")
+	}
+}
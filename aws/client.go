@@ -0,0 +1,220 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/smithy-go"
+)
+
+// ec2Api is the subset of the EC2 client's operations that spotsh uses. It
+// exists so that tests can substitute a mock via newEc2Client.
+type ec2Api interface {
+	AllocateAddress(ctx context.Context, params *ec2.AllocateAddressInput,
+		optFns ...func(*ec2.Options)) (*ec2.AllocateAddressOutput, error)
+	AssociateAddress(ctx context.Context, params *ec2.AssociateAddressInput,
+		optFns ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error)
+	AuthorizeSecurityGroupIngress(ctx context.Context,
+		params *ec2.AuthorizeSecurityGroupIngressInput,
+		optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error)
+	CreateFleet(ctx context.Context, params *ec2.CreateFleetInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateFleetOutput, error)
+	CreateImage(ctx context.Context, params *ec2.CreateImageInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error)
+	CreateKeyPair(ctx context.Context, params *ec2.CreateKeyPairInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateKeyPairOutput, error)
+	CreateLaunchTemplate(ctx context.Context,
+		params *ec2.CreateLaunchTemplateInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateLaunchTemplateOutput, error)
+	CreateTags(ctx context.Context, params *ec2.CreateTagsInput,
+		optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+	DeleteFleets(ctx context.Context, params *ec2.DeleteFleetsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DeleteFleetsOutput, error)
+	DeleteKeyPair(ctx context.Context, params *ec2.DeleteKeyPairInput,
+		optFns ...func(*ec2.Options)) (*ec2.DeleteKeyPairOutput, error)
+	DeleteLaunchTemplate(ctx context.Context,
+		params *ec2.DeleteLaunchTemplateInput,
+		optFns ...func(*ec2.Options)) (*ec2.DeleteLaunchTemplateOutput, error)
+	DeleteTags(ctx context.Context, params *ec2.DeleteTagsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error)
+	DescribeAddresses(ctx context.Context, params *ec2.DescribeAddressesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error)
+	DescribeAvailabilityZones(ctx context.Context,
+		params *ec2.DescribeAvailabilityZonesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeAvailabilityZonesOutput, error)
+	DescribeFleets(ctx context.Context, params *ec2.DescribeFleetsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeFleetsOutput, error)
+	DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	DescribeInstanceStatus(ctx context.Context,
+		params *ec2.DescribeInstanceStatusInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error)
+	DescribeInstanceTypeOfferings(ctx context.Context,
+		params *ec2.DescribeInstanceTypeOfferingsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error)
+	DescribeInstanceTypes(ctx context.Context,
+		params *ec2.DescribeInstanceTypesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error)
+	DescribeKeyPairs(ctx context.Context, params *ec2.DescribeKeyPairsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeKeyPairsOutput, error)
+	DescribeLaunchTemplateVersions(ctx context.Context,
+		params *ec2.DescribeLaunchTemplateVersionsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplateVersionsOutput, error)
+	DescribeLaunchTemplates(ctx context.Context,
+		params *ec2.DescribeLaunchTemplatesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplatesOutput, error)
+	DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error)
+	DescribeSecurityGroups(ctx context.Context,
+		params *ec2.DescribeSecurityGroupsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error)
+	DescribeSpotPriceHistory(ctx context.Context,
+		params *ec2.DescribeSpotPriceHistoryInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeSpotPriceHistoryOutput, error)
+	DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error)
+	DescribeTags(ctx context.Context, params *ec2.DescribeTagsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeTagsOutput, error)
+	DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error)
+	DescribeVpcs(ctx context.Context, params *ec2.DescribeVpcsInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeVpcsOutput, error)
+	DisassociateAddress(ctx context.Context,
+		params *ec2.DisassociateAddressInput,
+		optFns ...func(*ec2.Options)) (*ec2.DisassociateAddressOutput, error)
+	GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput,
+		optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error)
+	GetSpotPlacementScores(ctx context.Context,
+		params *ec2.GetSpotPlacementScoresInput,
+		optFns ...func(*ec2.Options)) (*ec2.GetSpotPlacementScoresOutput, error)
+	ModifyInstanceAttribute(ctx context.Context,
+		params *ec2.ModifyInstanceAttributeInput,
+		optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error)
+	ReleaseAddress(ctx context.Context, params *ec2.ReleaseAddressInput,
+		optFns ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error)
+	StartInstances(ctx context.Context, params *ec2.StartInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error)
+	StopInstances(ctx context.Context, params *ec2.StopInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error)
+	TerminateInstances(ctx context.Context,
+		params *ec2.TerminateInstancesInput,
+		optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error)
+}
+
+// IsUnauthorized returns true when err indicates that the caller's
+// credentials lack permission for the attempted EC2 operation
+func IsUnauthorized(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.ErrorCode() == "UnauthorizedOperation"
+}
+
+// IsExpiredCredentials returns true when err indicates that the caller's
+// credentials (e.g. an AWS SSO session) have expired & must be refreshed
+func IsExpiredCredentials(err error) bool {
+	var ssoErr *ssocreds.InvalidTokenError
+	if errors.As(err, &ssoErr) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "ExpiredToken", "ExpiredTokenException":
+		return true
+	}
+
+	return false
+}
+
+// ssmApi is the subset of the SSM client's operations that spotsh uses
+type ssmApi interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput,
+		optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// pricingApi is the subset of the Price List API's operations that spotsh
+// uses
+type pricingApi interface {
+	GetProducts(ctx context.Context, params *pricing.GetProductsInput,
+		optFns ...func(*pricing.Options)) (*pricing.GetProductsOutput, error)
+}
+
+// cloudwatchApi is the subset of the CloudWatch client's operations that
+// spotsh uses
+type cloudwatchApi interface {
+	GetMetricData(ctx context.Context, params *cloudwatch.GetMetricDataInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error)
+	PutMetricAlarm(ctx context.Context, params *cloudwatch.PutMetricAlarmInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricAlarmOutput, error)
+	DescribeAlarms(ctx context.Context, params *cloudwatch.DescribeAlarmsInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.DescribeAlarmsOutput, error)
+	DeleteAlarms(ctx context.Context, params *cloudwatch.DeleteAlarmsInput,
+		optFns ...func(*cloudwatch.Options)) (*cloudwatch.DeleteAlarmsOutput, error)
+}
+
+// costExplorerApi is the subset of the Cost Explorer client's operations
+// that spotsh uses
+type costExplorerApi interface {
+	GetCostAndUsage(ctx context.Context,
+		params *costexplorer.GetCostAndUsageInput,
+		optFns ...func(*costexplorer.Options)) (*costexplorer.GetCostAndUsageOutput, error)
+}
+
+// newEc2Client, newSsmClient, newPricingClient, newCloudwatchClient, &
+// newCostExplorerClient construct the clients used throughout this package;
+// tests may replace them in order to inject mocks
+var newEc2Client = func(awsCfg aws.Config) ec2Api {
+	return ec2.NewFromConfig(awsCfg)
+}
+
+// ssmMaxAttempts bounds the adaptive retryer used for SSM; resolving the
+// latest ami for every OS (and region) in quick succession readily trips
+// SSM's GetParameter throttling
+const ssmMaxAttempts = 10
+
+var newSsmClient = func(awsCfg aws.Config) ssmApi {
+	return ssm.NewFromConfig(awsCfg, func(o *ssm.Options) {
+		o.Retryer = retry.NewAdaptiveMode(func(ao *retry.AdaptiveModeOptions) {
+			ao.StandardOptions = append(ao.StandardOptions,
+				func(so *retry.StandardOptions) {
+					so.MaxAttempts = ssmMaxAttempts
+				})
+		})
+	})
+}
+
+var newPricingClient = func(awsCfg aws.Config) pricingApi {
+	return pricing.NewFromConfig(awsCfg, func(o *pricing.Options) {
+		o.Region = pricingApiRegion
+	})
+}
+
+var newCloudwatchClient = func(awsCfg aws.Config) cloudwatchApi {
+	return cloudwatch.NewFromConfig(awsCfg)
+}
+
+var newCostExplorerClient = func(awsCfg aws.Config) costExplorerApi {
+	return costexplorer.NewFromConfig(awsCfg, func(o *costexplorer.Options) {
+		o.Region = costExplorerApiRegion
+	})
+}
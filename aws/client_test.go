@@ -0,0 +1,749 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/smithy-go"
+
+	"github.com/mikeb26/spotsh"
+)
+
+// mockEc2Client implements ec2Api; operations without a corresponding func
+// field set will panic via the nil embedded interface
+type mockEc2Client struct {
+	ec2Api
+
+	describeInstances func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	describeKeyPairs  func(*ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error)
+	describeSubnets   func(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error)
+	describeSpotPrice func(*ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error)
+	terminate         func(*ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error)
+	describeVpcs      func(*ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error)
+	describeSgs       func(*ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error)
+	describeAzs       func(*ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error)
+	placementScores   func(*ec2.GetSpotPlacementScoresInput) (*ec2.GetSpotPlacementScoresOutput, error)
+	instanceStatus    func(*ec2.DescribeInstanceStatusInput) (*ec2.DescribeInstanceStatusOutput, error)
+	describeITypes    func(*ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error)
+	ltVersions        func(*ec2.DescribeLaunchTemplateVersionsInput) (*ec2.DescribeLaunchTemplateVersionsOutput, error)
+	iTypeOfferings    func(*ec2.DescribeInstanceTypeOfferingsInput) (*ec2.DescribeInstanceTypeOfferingsOutput, error)
+	modifyAttribute   func(*ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error)
+	consoleOutput     func(*ec2.GetConsoleOutputInput) (*ec2.GetConsoleOutputOutput, error)
+	createTags        func(*ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error)
+	describeAddrs     func(*ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error)
+	allocateAddr      func(*ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error)
+	associateAddr     func(*ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error)
+	disassociateAddr  func(*ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error)
+	releaseAddr       func(*ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error)
+	describeImages    func(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
+	createImage       func(*ec2.CreateImageInput) (*ec2.CreateImageOutput, error)
+	createFleet       func(*ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error)
+	createKeyPair     func(*ec2.CreateKeyPairInput) (*ec2.CreateKeyPairOutput, error)
+	deleteKeyPair     func(*ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error)
+	describeLts       func(*ec2.DescribeLaunchTemplatesInput) (*ec2.DescribeLaunchTemplatesOutput, error)
+	deleteLt          func(*ec2.DeleteLaunchTemplateInput) (*ec2.DeleteLaunchTemplateOutput, error)
+	describeFleets    func(*ec2.DescribeFleetsInput) (*ec2.DescribeFleetsOutput, error)
+	createLt          func(*ec2.CreateLaunchTemplateInput) (*ec2.CreateLaunchTemplateOutput, error)
+}
+
+func (m *mockEc2Client) CreateLaunchTemplate(ctx context.Context,
+	params *ec2.CreateLaunchTemplateInput,
+	optFns ...func(*ec2.Options)) (*ec2.CreateLaunchTemplateOutput, error) {
+
+	return m.createLt(params)
+}
+
+func (m *mockEc2Client) DescribeLaunchTemplates(ctx context.Context,
+	params *ec2.DescribeLaunchTemplatesInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplatesOutput, error) {
+
+	return m.describeLts(params)
+}
+
+func (m *mockEc2Client) DeleteLaunchTemplate(ctx context.Context,
+	params *ec2.DeleteLaunchTemplateInput,
+	optFns ...func(*ec2.Options)) (*ec2.DeleteLaunchTemplateOutput, error) {
+
+	return m.deleteLt(params)
+}
+
+func (m *mockEc2Client) DescribeFleets(ctx context.Context,
+	params *ec2.DescribeFleetsInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeFleetsOutput, error) {
+
+	return m.describeFleets(params)
+}
+
+func (m *mockEc2Client) CreateKeyPair(ctx context.Context,
+	params *ec2.CreateKeyPairInput,
+	optFns ...func(*ec2.Options)) (*ec2.CreateKeyPairOutput, error) {
+
+	return m.createKeyPair(params)
+}
+
+func (m *mockEc2Client) DeleteKeyPair(ctx context.Context,
+	params *ec2.DeleteKeyPairInput,
+	optFns ...func(*ec2.Options)) (*ec2.DeleteKeyPairOutput, error) {
+
+	return m.deleteKeyPair(params)
+}
+
+func (m *mockEc2Client) CreateImage(ctx context.Context,
+	params *ec2.CreateImageInput,
+	optFns ...func(*ec2.Options)) (*ec2.CreateImageOutput, error) {
+
+	return m.createImage(params)
+}
+
+func (m *mockEc2Client) CreateFleet(ctx context.Context,
+	params *ec2.CreateFleetInput,
+	optFns ...func(*ec2.Options)) (*ec2.CreateFleetOutput, error) {
+
+	return m.createFleet(params)
+}
+
+func (m *mockEc2Client) DescribeInstances(ctx context.Context,
+	params *ec2.DescribeInstancesInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+
+	return m.describeInstances(params)
+}
+
+func (m *mockEc2Client) DescribeKeyPairs(ctx context.Context,
+	params *ec2.DescribeKeyPairsInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeKeyPairsOutput, error) {
+
+	return m.describeKeyPairs(params)
+}
+
+func (m *mockEc2Client) DescribeSubnets(ctx context.Context,
+	params *ec2.DescribeSubnetsInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+
+	return m.describeSubnets(params)
+}
+
+func (m *mockEc2Client) DescribeSpotPriceHistory(ctx context.Context,
+	params *ec2.DescribeSpotPriceHistoryInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeSpotPriceHistoryOutput, error) {
+
+	return m.describeSpotPrice(params)
+}
+
+func (m *mockEc2Client) TerminateInstances(ctx context.Context,
+	params *ec2.TerminateInstancesInput,
+	optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+
+	return m.terminate(params)
+}
+
+func (m *mockEc2Client) DescribeVpcs(ctx context.Context,
+	params *ec2.DescribeVpcsInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeVpcsOutput, error) {
+
+	return m.describeVpcs(params)
+}
+
+func (m *mockEc2Client) DescribeSecurityGroups(ctx context.Context,
+	params *ec2.DescribeSecurityGroupsInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+
+	return m.describeSgs(params)
+}
+
+func (m *mockEc2Client) DescribeAvailabilityZones(ctx context.Context,
+	params *ec2.DescribeAvailabilityZonesInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeAvailabilityZonesOutput, error) {
+
+	return m.describeAzs(params)
+}
+
+func (m *mockEc2Client) GetSpotPlacementScores(ctx context.Context,
+	params *ec2.GetSpotPlacementScoresInput,
+	optFns ...func(*ec2.Options)) (*ec2.GetSpotPlacementScoresOutput, error) {
+
+	return m.placementScores(params)
+}
+
+func (m *mockEc2Client) DescribeInstanceStatus(ctx context.Context,
+	params *ec2.DescribeInstanceStatusInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error) {
+
+	return m.instanceStatus(params)
+}
+
+func (m *mockEc2Client) DescribeLaunchTemplateVersions(ctx context.Context,
+	params *ec2.DescribeLaunchTemplateVersionsInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+
+	return m.ltVersions(params)
+}
+
+func (m *mockEc2Client) DescribeInstanceTypeOfferings(ctx context.Context,
+	params *ec2.DescribeInstanceTypeOfferingsInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+
+	return m.iTypeOfferings(params)
+}
+
+func (m *mockEc2Client) DescribeInstanceTypes(ctx context.Context,
+	params *ec2.DescribeInstanceTypesInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+
+	return m.describeITypes(params)
+}
+
+func (m *mockEc2Client) ModifyInstanceAttribute(ctx context.Context,
+	params *ec2.ModifyInstanceAttributeInput,
+	optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error) {
+
+	return m.modifyAttribute(params)
+}
+
+func (m *mockEc2Client) GetConsoleOutput(ctx context.Context,
+	params *ec2.GetConsoleOutputInput,
+	optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error) {
+
+	return m.consoleOutput(params)
+}
+
+func (m *mockEc2Client) CreateTags(ctx context.Context,
+	params *ec2.CreateTagsInput,
+	optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+
+	return m.createTags(params)
+}
+
+func (m *mockEc2Client) DescribeAddresses(ctx context.Context,
+	params *ec2.DescribeAddressesInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error) {
+
+	return m.describeAddrs(params)
+}
+
+func (m *mockEc2Client) AllocateAddress(ctx context.Context,
+	params *ec2.AllocateAddressInput,
+	optFns ...func(*ec2.Options)) (*ec2.AllocateAddressOutput, error) {
+
+	return m.allocateAddr(params)
+}
+
+func (m *mockEc2Client) AssociateAddress(ctx context.Context,
+	params *ec2.AssociateAddressInput,
+	optFns ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error) {
+
+	return m.associateAddr(params)
+}
+
+func (m *mockEc2Client) DisassociateAddress(ctx context.Context,
+	params *ec2.DisassociateAddressInput,
+	optFns ...func(*ec2.Options)) (*ec2.DisassociateAddressOutput, error) {
+
+	return m.disassociateAddr(params)
+}
+
+func (m *mockEc2Client) ReleaseAddress(ctx context.Context,
+	params *ec2.ReleaseAddressInput,
+	optFns ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error) {
+
+	return m.releaseAddr(params)
+}
+
+func (m *mockEc2Client) DescribeImages(ctx context.Context,
+	params *ec2.DescribeImagesInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+
+	return m.describeImages(params)
+}
+
+// useMockEc2Client substitutes mock for the real EC2 client for the duration
+// of the calling test
+func useMockEc2Client(t *testing.T, mock *mockEc2Client) {
+	origNewEc2Client := newEc2Client
+	newEc2Client = func(awsCfg aws.Config) ec2Api {
+		return mock
+	}
+	t.Cleanup(func() {
+		newEc2Client = origNewEc2Client
+	})
+}
+
+type mockPricingClient struct {
+	getProducts func(*pricing.GetProductsInput) (*pricing.GetProductsOutput, error)
+}
+
+func (m *mockPricingClient) GetProducts(ctx context.Context,
+	params *pricing.GetProductsInput,
+	optFns ...func(*pricing.Options)) (*pricing.GetProductsOutput, error) {
+
+	return m.getProducts(params)
+}
+
+// useMockPricingClient substitutes mock for the real Price List API client
+// for the duration of the calling test
+func useMockPricingClient(t *testing.T, mock *mockPricingClient) {
+	origNewPricingClient := newPricingClient
+	newPricingClient = func(awsCfg aws.Config) pricingApi {
+		return mock
+	}
+	t.Cleanup(func() {
+		newPricingClient = origNewPricingClient
+	})
+}
+
+type mockSsmClient struct {
+	getParameter func(*ssm.GetParameterInput) (*ssm.GetParameterOutput, error)
+}
+
+func (m *mockSsmClient) GetParameter(ctx context.Context,
+	params *ssm.GetParameterInput,
+	optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+
+	return m.getParameter(params)
+}
+
+// useMockSsmClient substitutes mock for the real SSM client for the duration
+// of the calling test
+func useMockSsmClient(t *testing.T, mock *mockSsmClient) {
+	origNewSsmClient := newSsmClient
+	newSsmClient = func(awsCfg aws.Config) ssmApi {
+		return mock
+	}
+	t.Cleanup(func() {
+		newSsmClient = origNewSsmClient
+	})
+}
+
+type mockCostExplorerClient struct {
+	getCostAndUsage func(*costexplorer.GetCostAndUsageInput) (*costexplorer.GetCostAndUsageOutput, error)
+}
+
+func (m *mockCostExplorerClient) GetCostAndUsage(ctx context.Context,
+	params *costexplorer.GetCostAndUsageInput,
+	optFns ...func(*costexplorer.Options)) (*costexplorer.GetCostAndUsageOutput, error) {
+
+	return m.getCostAndUsage(params)
+}
+
+// useMockCostExplorerClient substitutes mock for the real Cost Explorer
+// client for the duration of the calling test
+func useMockCostExplorerClient(t *testing.T, mock *mockCostExplorerClient) {
+	origNewCostExplorerClient := newCostExplorerClient
+	newCostExplorerClient = func(awsCfg aws.Config) costExplorerApi {
+		return mock
+	}
+	t.Cleanup(func() {
+		newCostExplorerClient = origNewCostExplorerClient
+	})
+}
+
+type mockCloudwatchClient struct {
+	getMetricData  func(*cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error)
+	putMetricAlarm func(*cloudwatch.PutMetricAlarmInput) (*cloudwatch.PutMetricAlarmOutput, error)
+	describeAlarms func(*cloudwatch.DescribeAlarmsInput) (*cloudwatch.DescribeAlarmsOutput, error)
+	deleteAlarms   func(*cloudwatch.DeleteAlarmsInput) (*cloudwatch.DeleteAlarmsOutput, error)
+}
+
+func (m *mockCloudwatchClient) PutMetricAlarm(ctx context.Context,
+	params *cloudwatch.PutMetricAlarmInput,
+	optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricAlarmOutput, error) {
+
+	return m.putMetricAlarm(params)
+}
+
+func (m *mockCloudwatchClient) DescribeAlarms(ctx context.Context,
+	params *cloudwatch.DescribeAlarmsInput,
+	optFns ...func(*cloudwatch.Options)) (*cloudwatch.DescribeAlarmsOutput, error) {
+
+	return m.describeAlarms(params)
+}
+
+func (m *mockCloudwatchClient) DeleteAlarms(ctx context.Context,
+	params *cloudwatch.DeleteAlarmsInput,
+	optFns ...func(*cloudwatch.Options)) (*cloudwatch.DeleteAlarmsOutput, error) {
+
+	return m.deleteAlarms(params)
+}
+
+func (m *mockCloudwatchClient) GetMetricData(ctx context.Context,
+	params *cloudwatch.GetMetricDataInput,
+	optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+
+	return m.getMetricData(params)
+}
+
+// useMockCloudwatchClient substitutes mock for the real CloudWatch client for
+// the duration of the calling test
+func useMockCloudwatchClient(t *testing.T, mock *mockCloudwatchClient) {
+	origNewCloudwatchClient := newCloudwatchClient
+	newCloudwatchClient = func(awsCfg aws.Config) cloudwatchApi {
+		return mock
+	}
+	t.Cleanup(func() {
+		newCloudwatchClient = origNewCloudwatchClient
+	})
+}
+
+func newMockEc2Client() *mockEc2Client {
+	return &mockEc2Client{
+		describeInstances: func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{}, nil
+		},
+		describeKeyPairs: func(*ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+			return &ec2.DescribeKeyPairsOutput{}, nil
+		},
+		describeSubnets: func(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+			return &ec2.DescribeSubnetsOutput{
+				Subnets: []types.Subnet{
+					{
+						SubnetId:         aws.String("subnet-a"),
+						AvailabilityZone: aws.String("us-east-2a"),
+					},
+				},
+			}, nil
+		},
+		describeSpotPrice: func(*ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error) {
+			return &ec2.DescribeSpotPriceHistoryOutput{}, nil
+		},
+	}
+}
+
+func TestLookupEc2SpotOneRegionMock(t *testing.T) {
+	mock := newMockEc2Client()
+	var describeInput *ec2.DescribeInstancesInput
+	mock.describeInstances = func(params *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+		describeInput = params
+		return &ec2.DescribeInstancesOutput{
+			Reservations: []types.Reservation{
+				{
+					Instances: []types.Instance{
+						{
+							InstanceId:        aws.String("i-0"),
+							InstanceType:      types.InstanceTypeC5Large,
+							InstanceLifecycle: types.InstanceLifecycleTypeSpot,
+							ImageId:           aws.String("ami-0"),
+							SubnetId:          aws.String("subnet-a"),
+							PublicIpAddress:   aws.String("192.0.2.1"),
+							Tags: []types.Tag{
+								{Key: aws.String("spotsh.user"), Value: aws.String("ec2-user")},
+								{Key: aws.String("spotsh.os"), Value: aws.String("amzn2023")},
+								{Key: aws.String("spotsh.maxprice"), Value: aws.String("0.08")},
+								{Key: aws.String("spotsh.version"), Value: aws.String("v0.4.1")},
+								{Key: aws.String("Name"), Value: aws.String("mybox")},
+							},
+						},
+						{
+							// no subnet, image, dns, or security groups
+							InstanceId:   aws.String("i-1"),
+							InstanceType: types.InstanceTypeC5Large,
+							Tags: []types.Tag{
+								{Key: aws.String("spotsh.user"), Value: aws.String("admin")},
+							},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+	mock.describeSpotPrice = func(params *ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error) {
+		return &ec2.DescribeSpotPriceHistoryOutput{
+			SpotPriceHistory: []types.SpotPrice{
+				{
+					AvailabilityZone: aws.String("us-east-2a"),
+					InstanceType:     types.InstanceTypeC5Large,
+					SpotPrice:        aws.String("0.03"),
+				},
+			},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+	useMockPricingClient(t, &mockPricingClient{
+		getProducts: func(*pricing.GetProductsInput) (*pricing.GetProductsOutput, error) {
+			return &pricing.GetProductsOutput{
+				PriceList: []string{testOnDemandPriceDoc},
+			}, nil
+		},
+	})
+
+	awsCfg := aws.Config{Region: "us-east-2"}
+	launchResults, err := lookupEc2SpotOneRegion(awsCfg, DefaultTagPrefix)
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+
+	foundTagFilter := false
+	for _, filter := range describeInput.Filters {
+		if *filter.Name == "tag-key" && filter.Values[0] == "spotsh.user" {
+			foundTagFilter = true
+		}
+	}
+	if !foundTagFilter {
+		t.Errorf("expected server side tag-key filter")
+	}
+
+	if len(launchResults) != 2 {
+		t.Fatalf("expected 2 results but got %v", len(launchResults))
+	}
+	lr := launchResults[0]
+	if lr.User != "ec2-user" || lr.Os != spotsh.AmazonLinux2023 {
+		t.Errorf("unexpected user/os %v/%v", lr.User, lr.Os)
+	}
+	if lr.Tags["Name"] != "mybox" {
+		t.Errorf("expected Name tag to be preserved; tags:%v", lr.Tags)
+	}
+	if lr.AzName != "us-east-2a" || lr.CurrentPrice != 0.03 {
+		t.Errorf("unexpected az/price %v/%v", lr.AzName, lr.CurrentPrice)
+	}
+	if lr.Lifecycle != MarketSpot {
+		t.Errorf("expected spot lifecycle but got %v", lr.Lifecycle)
+	}
+	if lr.MaxSpotPrice != "0.08" {
+		t.Errorf("expected max spot price 0.08 but got %v", lr.MaxSpotPrice)
+	}
+	if lr.SpotshVersion != "v0.4.1" {
+		t.Errorf("expected version v0.4.1 but got %v", lr.SpotshVersion)
+	}
+	lr = launchResults[1]
+	if lr.User != "admin" || lr.Os != spotsh.OsNone {
+		t.Errorf("unexpected user/os %v/%v", lr.User, lr.Os)
+	}
+	if lr.SgId != "" || lr.DnsName != "" || lr.AzName != "" ||
+		lr.MaxSpotPrice != "" || lr.SpotshVersion != "" {
+		t.Errorf("expected empty placeholders; got %+v", lr)
+	}
+	if lr.Lifecycle != MarketOnDemand || lr.CurrentPrice != 0.085 {
+		t.Errorf("expected on-demand lifecycle/price but got %v/%v",
+			lr.Lifecycle, lr.CurrentPrice)
+	}
+}
+
+func TestTerminateInstanceIdempotent(t *testing.T) {
+	testCases := []struct {
+		name            string
+		prevState       types.InstanceStateName
+		err             error
+		wantAlreadyGone bool
+		wantErr         bool
+	}{
+		{"running", types.InstanceStateNameRunning, nil, false, false},
+		{"terminated", types.InstanceStateNameTerminated, nil, true, false},
+		{"notfound", "", &smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound"},
+			true, false},
+		{"denied", "", &smithy.GenericAPIError{Code: "UnauthorizedOperation"},
+			false, true},
+	}
+
+	for _, tc := range testCases {
+		mock := newMockEc2Client()
+		mock.terminate = func(params *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			if tc.err != nil {
+				return nil, tc.err
+			}
+			return &ec2.TerminateInstancesOutput{
+				TerminatingInstances: []types.InstanceStateChange{
+					{
+						InstanceId:    aws.String(params.InstanceIds[0]),
+						PreviousState: &types.InstanceState{Name: tc.prevState},
+					},
+				},
+			}, nil
+		}
+		useMockEc2Client(t, mock)
+
+		alreadyGone, err := TerminateInstance(aws.Config{Region: "us-east-2"},
+			"i-0")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%v: unexpected err: %v", tc.name, err)
+		}
+		if alreadyGone != tc.wantAlreadyGone {
+			t.Errorf("%v: expected alreadyGone:%v but got %v", tc.name,
+				tc.wantAlreadyGone, alreadyGone)
+		}
+	}
+}
+
+func TestGetDefaultSecurityGroupIdNoDefaultVpc(t *testing.T) {
+	mock := newMockEc2Client()
+	vpcs := []types.Vpc{
+		{VpcId: aws.String("vpc-b"), IsDefault: aws.Bool(false)},
+	}
+	mock.describeVpcs = func(*ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+		return &ec2.DescribeVpcsOutput{Vpcs: vpcs}, nil
+	}
+	mock.describeSgs = func(*ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+		return &ec2.DescribeSecurityGroupsOutput{
+			SecurityGroups: []types.SecurityGroup{
+				{GroupId: aws.String("sg-a1"), GroupName: aws.String("default"),
+					VpcId: aws.String("vpc-a")},
+				{GroupId: aws.String("sg-b1"), GroupName: aws.String("default"),
+					VpcId: aws.String("vpc-b")},
+			},
+		}, nil
+	}
+	awsCfg := aws.Config{Region: "us-east-2"}
+
+	// a lone non-default vpc is used
+	sgId, err := getDefaultSecurityGroupId(awsCfg, mock)
+	if err != nil || sgId != "sg-b1" {
+		t.Errorf("expected sg-b1 but got %v err:%v", sgId, err)
+	}
+
+	// multiple non-default vpcs are ambiguous
+	vpcs = append(vpcs, types.Vpc{VpcId: aws.String("vpc-a"),
+		IsDefault: aws.Bool(false)})
+	_, err = getDefaultSecurityGroupId(awsCfg, mock)
+	if !errors.Is(err, ErrAmbiguousVpc) || !errors.Is(err, ErrNoDefaultVpc) {
+		t.Fatalf("expected ErrAmbiguousVpc but got %v", err)
+	}
+	if !strings.Contains(err.Error(), "vpc-a") ||
+		!strings.Contains(err.Error(), "sg-b1") {
+		t.Errorf("expected error to list vpcs & sgs: %v", err)
+	}
+}
+
+func TestIsUnauthorized(t *testing.T) {
+	unauthErr := &smithy.GenericAPIError{Code: "UnauthorizedOperation"}
+	if !IsUnauthorized(fmt.Errorf("wrapped: %w", unauthErr)) {
+		t.Errorf("expected wrapped UnauthorizedOperation to be unauthorized")
+	}
+	if IsUnauthorized(&smithy.GenericAPIError{Code: "InvalidGroup.NotFound"}) {
+		t.Errorf("expected InvalidGroup.NotFound to not be unauthorized")
+	}
+	if IsUnauthorized(errors.New("unauthorized")) {
+		t.Errorf("expected non-api error to not be unauthorized")
+	}
+}
+
+func TestIsExpiredCredentials(t *testing.T) {
+	expiredErr := &smithy.GenericAPIError{Code: "ExpiredToken"}
+	if !IsExpiredCredentials(fmt.Errorf("wrapped: %w", expiredErr)) {
+		t.Errorf("expected wrapped ExpiredToken to be expired")
+	}
+	ssoErr := &ssocreds.InvalidTokenError{Err: errors.New("token expired")}
+	if !IsExpiredCredentials(fmt.Errorf("failed to refresh cached credentials: %w",
+		ssoErr)) {
+		t.Errorf("expected wrapped sso InvalidTokenError to be expired")
+	}
+	if IsExpiredCredentials(&smithy.GenericAPIError{Code: "UnauthorizedOperation"}) {
+		t.Errorf("expected UnauthorizedOperation to not be expired")
+	}
+}
+
+func TestLookupInstanceHealth(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.instanceStatus = func(input *ec2.DescribeInstanceStatusInput) (*ec2.DescribeInstanceStatusOutput, error) {
+		if len(input.InstanceIds) != 2 {
+			t.Errorf("expected 2 instance ids but got %v", input.InstanceIds)
+		}
+		return &ec2.DescribeInstanceStatusOutput{
+			InstanceStatuses: []types.InstanceStatus{
+				{
+					InstanceId: aws.String("i-0"),
+					InstanceStatus: &types.InstanceStatusSummary{
+						Status: types.SummaryStatusImpaired,
+					},
+					SystemStatus: &types.InstanceStatusSummary{
+						Status: types.SummaryStatusOk,
+					},
+				},
+			},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+
+	launchResults := []LaunchEc2SpotResult{
+		{InstanceId: "i-0", Region: "us-east-2"},
+		{InstanceId: "i-1", Region: "us-east-2"},
+	}
+	err := LookupInstanceHealth(aws.Config{Region: "us-east-2"}, launchResults)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if launchResults[0].InstanceStatus != "impaired" ||
+		launchResults[0].SystemStatus != "ok" {
+		t.Errorf("expected impaired/ok but got %v/%v",
+			launchResults[0].InstanceStatus, launchResults[0].SystemStatus)
+	}
+	if launchResults[1].InstanceStatus != HealthUnknown ||
+		launchResults[1].SystemStatus != HealthUnknown {
+		t.Errorf("expected unchecked instance to be unknown but got %v/%v",
+			launchResults[1].InstanceStatus, launchResults[1].SystemStatus)
+	}
+}
+
+func TestKeepVolumes(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.describeInstances = func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+		return &ec2.DescribeInstancesOutput{
+			Reservations: []types.Reservation{{
+				Instances: []types.Instance{{
+					InstanceId:     aws.String("i-0"),
+					RootDeviceName: aws.String("/dev/xvda"),
+					BlockDeviceMappings: []types.InstanceBlockDeviceMapping{
+						{
+							DeviceName: aws.String("/dev/xvda"),
+							Ebs: &types.EbsInstanceBlockDevice{
+								VolumeId:            aws.String("vol-0"),
+								DeleteOnTermination: aws.Bool(true),
+							},
+						},
+						{
+							DeviceName: aws.String("/dev/sdb"),
+							Ebs: &types.EbsInstanceBlockDevice{
+								VolumeId:            aws.String("vol-1"),
+								DeleteOnTermination: aws.Bool(true),
+							},
+						},
+					},
+				}},
+			}},
+		}, nil
+	}
+	var modified []types.InstanceBlockDeviceMappingSpecification
+	mock.modifyAttribute = func(input *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+		modified = input.BlockDeviceMappings
+		return &ec2.ModifyInstanceAttributeOutput{}, nil
+	}
+	useMockEc2Client(t, mock)
+
+	volumeIds, err := KeepVolumes(aws.Config{Region: "us-east-2"}, "i-0",
+		false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(volumeIds, ",") != "vol-1" || len(modified) != 1 {
+		t.Errorf("expected only data volume vol-1 but got %v", volumeIds)
+	}
+
+	volumeIds, err = KeepVolumes(aws.Config{Region: "us-east-2"}, "i-0", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(volumeIds, ",") != "vol-0,vol-1" {
+		t.Errorf("expected vol-0,vol-1 but got %v", volumeIds)
+	}
+	if len(modified) != 2 {
+		t.Fatalf("expected 2 modified mappings but got %v", len(modified))
+	}
+	for _, mapping := range modified {
+		if mapping.Ebs == nil || *mapping.Ebs.DeleteOnTermination {
+			t.Errorf("expected DeleteOnTermination=false for %v",
+				*mapping.DeviceName)
+		}
+	}
+}
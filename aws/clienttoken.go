@@ -0,0 +1,66 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// maxClientTokenLen is the longest idempotency token EC2 accepts
+const maxClientTokenLen = 64
+
+// clientTokenTemplateTtl is how long the launch template of a caller supplied
+// ClientToken is kept for retries w/ that token
+const clientTokenTemplateTtl = 24 * time.Hour
+
+// clientTokenTemplateInfix separates a ClientToken launch template's name
+// from the hash of its token
+const clientTokenTemplateInfix = "-lt-token-"
+
+// newClientToken returns a unique idempotency token identifying a single
+// launch so that retries of its fleet request can't launch duplicates
+func newClientToken(tagPrefix string) (string, error) {
+	if tagPrefix == "" {
+		tagPrefix = DefaultTagPrefix
+	}
+	launchId := make([]byte, 16)
+	_, err := rand.Read(launchId)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate launch id: %w", err)
+	}
+
+	return tagPrefix + "-" + hex.EncodeToString(launchId), nil
+}
+
+// getClientTokenTemplateName returns the launch template name of a launch
+// w/ a caller supplied clientToken. A retry must reuse the very same template
+// since EC2 otherwise rejects the fleet request w/
+// IdempotentParameterMismatch; the token is hashed as it may contain
+// characters which aren't valid in a template name.
+func getClientTokenTemplateName(tagPrefix string, clientToken string) string {
+	tokenHash := sha256.Sum256([]byte(clientToken))
+
+	return tagPrefix + clientTokenTemplateInfix +
+		hex.EncodeToString(tokenHash[:8])
+}
+
+// checkClientToken verifies a caller supplied token is one EC2 accepts
+func checkClientToken(clientToken string) error {
+	if len(clientToken) > maxClientTokenLen {
+		return fmt.Errorf("ClientToken must be at most %v characters",
+			maxClientTokenLen)
+	}
+	for _, c := range clientToken {
+		if c < ' ' || c > '~' {
+			return fmt.Errorf("ClientToken must only contain printable ASCII characters")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewClientToken(t *testing.T) {
+	token1, err := newClientToken("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token2, err := newClientToken("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token1 == token2 {
+		t.Errorf("expected unique tokens but got %v twice", token1)
+	}
+	if !strings.HasPrefix(token1, DefaultTagPrefix+"-") {
+		t.Errorf("expected %v- prefix but got %v", DefaultTagPrefix, token1)
+	}
+	if err := checkClientToken(token1); err != nil {
+		t.Errorf("generated token %v is invalid: %v", token1, err)
+	}
+}
+
+func TestCheckClientToken(t *testing.T) {
+	if err := checkClientToken(""); err != nil {
+		t.Errorf("unexpected error w/ empty token: %v", err)
+	}
+	if err := checkClientToken(strings.Repeat("a", maxClientTokenLen+1)); err == nil {
+		t.Errorf("expected error w/ overlong token")
+	}
+	if err := checkClientToken("nightly\n"); err == nil {
+		t.Errorf("expected error w/ non-printable token")
+	}
+}
@@ -0,0 +1,134 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// cloudConfigHeader must be the first line of cloud-init's cloud-config
+const cloudConfigHeader = "#cloud-config"
+
+// checkCloudConfig verifies cloudConfig is a #cloud-config document whose
+// body parses as a yaml mapping
+func checkCloudConfig(cloudConfig string) error {
+	if !strings.HasPrefix(cloudConfig, cloudConfigHeader) {
+		return fmt.Errorf("Cloud config must begin w/ a '%v' line",
+			cloudConfigHeader)
+	}
+	var parsed map[string]interface{}
+	err := yaml.Unmarshal([]byte(cloudConfig), &parsed)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cloud config yaml: %w", err)
+	}
+
+	return nil
+}
+
+// getMultipartUserData returns a MIME multipart user data document which
+// cloud-init processes as cloudConfig followed by initCmd, if any. initCmd
+// is run as a bash script when it lacks its own #! line.
+func getMultipartUserData(cloudConfig string, initCmd string) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	err := writeUserDataPart(w, "text/cloud-config", "cloud-config.txt",
+		cloudConfig)
+	if err != nil {
+		return "", err
+	}
+	if initCmd != "" {
+		if !strings.HasPrefix(initCmd, "#!") {
+			initCmd = "#!/bin/bash\n" + initCmd
+		}
+		err = writeUserDataPart(w, "text/x-shellscript", "initcmd.sh",
+			initCmd)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+
+	header := fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%v\"\nMIME-Version: 1.0\n\n",
+		w.Boundary())
+
+	return header + body.String(), nil
+}
+
+func writeUserDataPart(w *multipart.Writer, contentType string,
+	fileName string, content string) error {
+
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Type", contentType+"; charset=\"us-ascii\"")
+	partHeader.Set("MIME-Version", "1.0")
+	partHeader.Set("Content-Transfer-Encoding", "7bit")
+	partHeader.Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%v\"", fileName))
+	part, err := w.CreatePart(partHeader)
+	if err != nil {
+		return fmt.Errorf("Failed to create %v user data part: %w",
+			contentType, err)
+	}
+	_, err = part.Write([]byte(content))
+	if err != nil {
+		return fmt.Errorf("Failed to write %v user data part: %w",
+			contentType, err)
+	}
+
+	return nil
+}
+
+// prependUserDataScript returns the shell script userData followed by
+// initCmd, if any. Both run within a single script so initCmd must itself be
+// a shell script; its own #! line, if any, is dropped.
+func prependUserDataScript(userData string, initCmd string) (string, error) {
+	if initCmd == "" {
+		return userData, nil
+	}
+	if !strings.HasPrefix(initCmd, "#!") {
+		if strings.HasPrefix(initCmd, "#cloud-") ||
+			strings.HasPrefix(initCmd, "#include") ||
+			strings.HasPrefix(initCmd, "#part-handler") ||
+			strings.HasPrefix(strings.ToLower(initCmd), "content-type:") {
+			return "", fmt.Errorf("Init command must be a shell script rather than a cloud-init directive; use CloudConfig for #cloud-config")
+		}
+		return userData + initCmd, nil
+	}
+	interpLine, body, _ := strings.Cut(initCmd, "\n")
+	if !isShellInterpreter(strings.TrimPrefix(interpLine, "#!")) {
+		return "", fmt.Errorf("Init command must be a shell script; %v is unsupported here",
+			interpLine)
+	}
+
+	return userData + body, nil
+}
+
+// isShellInterpreter returns true if the #! line interpLine runs a bourne
+// compatible shell; e.g. /bin/bash or /usr/bin/env sh
+func isShellInterpreter(interpLine string) bool {
+	fields := strings.Fields(interpLine)
+	if len(fields) == 0 {
+		return false
+	}
+	interp := path.Base(fields[0])
+	if interp == "env" && len(fields) > 1 {
+		interp = path.Base(fields[1])
+	}
+	switch interp {
+	case "sh", "bash", "dash", "ksh", "zsh":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,94 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestCheckCloudConfig(t *testing.T) {
+	err := checkCloudConfig("#cloud-config\npackages:\n  - htop\n")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = checkCloudConfig("packages:\n  - htop\n")
+	if err == nil {
+		t.Errorf("expected error w/o #cloud-config header")
+	}
+	err = checkCloudConfig("#cloud-config\npackages: [htop\n")
+	if err == nil {
+		t.Errorf("expected error for invalid yaml")
+	}
+}
+
+func TestGetMultipartUserData(t *testing.T) {
+	cloudConfig := "#cloud-config\npackages:\n  - htop\n"
+	userData, err := getMultipartUserData(cloudConfig, "echo hello\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(userData))
+	if err != nil {
+		t.Fatalf("failed to parse user data: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("expected multipart/mixed; got %v err:%v", mediaType, err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	expected := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/cloud-config", cloudConfig},
+		{"text/x-shellscript", "#!/bin/bash\necho hello\n"},
+	}
+	for _, exp := range expected {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("expected %v part: %v", exp.contentType, err)
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), exp.contentType) {
+			t.Errorf("expected %v part; got %v", exp.contentType,
+				part.Header.Get("Content-Type"))
+		}
+		content, _ := io.ReadAll(part)
+		if string(content) != exp.content {
+			t.Errorf("expected %v content %q; got %q", exp.contentType,
+				exp.content, content)
+		}
+	}
+	_, err = reader.NextPart()
+	if err != io.EOF {
+		t.Errorf("expected only 2 parts; got err:%v", err)
+	}
+}
+
+func TestPrependUserDataScript(t *testing.T) {
+	userData, err := prependUserDataScript("#!/bin/bash\nsetup\n",
+		"#!/usr/bin/env bash\necho hello\n")
+	if err != nil || userData != "#!/bin/bash\nsetup\necho hello\n" {
+		t.Errorf("unexpected user data %q err:%v", userData, err)
+	}
+	userData, err = prependUserDataScript("#!/bin/bash\nsetup\n",
+		"echo hello\n")
+	if err != nil || userData != "#!/bin/bash\nsetup\necho hello\n" {
+		t.Errorf("unexpected user data %q err:%v", userData, err)
+	}
+
+	for _, initCmd := range []string{"#!/usr/bin/python3\nprint(1)\n",
+		"#cloud-config\npackages: [git]\n"} {
+		_, err = prependUserDataScript("#!/bin/bash\nsetup\n", initCmd)
+		if err == nil {
+			t.Errorf("expected error for non shell init command %q", initCmd)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	cetypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+// the Cost Explorer API is only served from us-east-1
+const costExplorerApiRegion = "us-east-1"
+
+const costExplorerDateFmt = "2006-01-02"
+
+// CostEntry is the spend of a single instance type in a single region on a
+// single day
+type CostEntry struct {
+	Date         string // YYYY-MM-DD
+	InstanceType string
+	Region       string
+	Amount       float64
+	Unit         string // e.g. USD
+}
+
+// LookupSpotshCosts returns the daily spend, grouped by instance type &
+// region, of resources tagged by spotsh over the preceding days including
+// today. Cost Explorer only reports tags which have been activated as cost
+// allocation tags in the Billing console & only from the time of their
+// activation.
+func LookupSpotshCosts(awsCfg aws.Config, tagPrefix string,
+	days int) ([]CostEntry, error) {
+
+	if days <= 0 {
+		return nil, fmt.Errorf("Number of days %v must be positive", days)
+	}
+	if tagPrefix == "" {
+		tagPrefix = DefaultTagPrefix
+	}
+	ceClient := newCostExplorerClient(awsCfg)
+
+	// End is exclusive
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	costInput := &costexplorer.GetCostAndUsageInput{
+		Granularity: cetypes.GranularityDaily,
+		Metrics:     []string{"UnblendedCost"},
+		TimePeriod: &cetypes.DateInterval{
+			Start: aws.String(today.AddDate(0, 0, 1-days).Format(costExplorerDateFmt)),
+			End:   aws.String(today.AddDate(0, 0, 1).Format(costExplorerDateFmt)),
+		},
+		Filter: &cetypes.Expression{
+			Not: &cetypes.Expression{
+				Tags: &cetypes.TagValues{
+					Key:          aws.String(tagPrefix + "." + UserTagSuffix),
+					MatchOptions: []cetypes.MatchOption{cetypes.MatchOptionAbsent},
+				},
+			},
+		},
+		GroupBy: []cetypes.GroupDefinition{
+			{
+				Type: cetypes.GroupDefinitionTypeDimension,
+				Key:  aws.String(string(cetypes.DimensionInstanceType)),
+			},
+			{
+				Type: cetypes.GroupDefinitionTypeDimension,
+				Key:  aws.String(string(cetypes.DimensionRegion)),
+			},
+		},
+	}
+
+	entries := make([]CostEntry, 0)
+	for {
+		costOutput, err := ceClient.GetCostAndUsage(context.Background(),
+			costInput)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get cost & usage: %w", err)
+		}
+		for _, result := range costOutput.ResultsByTime {
+			date := ""
+			if result.TimePeriod != nil {
+				date = aws.ToString(result.TimePeriod.Start)
+			}
+			for _, group := range result.Groups {
+				metric, ok := group.Metrics["UnblendedCost"]
+				if !ok {
+					continue
+				}
+				amount, err := strconv.ParseFloat(aws.ToString(metric.Amount),
+					64)
+				if err != nil {
+					return nil, fmt.Errorf("Failed to parse float %v: %w",
+						aws.ToString(metric.Amount), err)
+				}
+				if amount == 0 {
+					continue
+				}
+				entry := CostEntry{
+					Date:   date,
+					Amount: amount,
+					Unit:   aws.ToString(metric.Unit),
+				}
+				if len(group.Keys) == 2 {
+					entry.InstanceType = group.Keys[0]
+					entry.Region = group.Keys[1]
+				}
+				entries = append(entries, entry)
+			}
+		}
+		if costOutput.NextPageToken == nil {
+			break
+		}
+		costInput.NextPageToken = costOutput.NextPageToken
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	cetypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+func TestLookupSpotshCosts(t *testing.T) {
+	useMockCostExplorerClient(t, &mockCostExplorerClient{
+		getCostAndUsage: func(input *costexplorer.GetCostAndUsageInput) (*costexplorer.GetCostAndUsageOutput, error) {
+			tagKey := aws.ToString(input.Filter.Not.Tags.Key)
+			if tagKey != "spotsh.user" {
+				t.Errorf("unexpected tag filter %v", tagKey)
+			}
+			output := &costexplorer.GetCostAndUsageOutput{
+				ResultsByTime: []cetypes.ResultByTime{{
+					TimePeriod: &cetypes.DateInterval{
+						Start: aws.String("2024-06-01"),
+					},
+					Groups: []cetypes.Group{
+						{
+							Keys: []string{"c7i.large", "us-east-2"},
+							Metrics: map[string]cetypes.MetricValue{
+								"UnblendedCost": {
+									Amount: aws.String("0.42"),
+									Unit:   aws.String("USD"),
+								},
+							},
+						},
+						{
+							Keys: []string{"NoInstanceType", "us-east-2"},
+							Metrics: map[string]cetypes.MetricValue{
+								"UnblendedCost": {
+									Amount: aws.String("0"),
+									Unit:   aws.String("USD"),
+								},
+							},
+						},
+					},
+				}},
+			}
+			if input.NextPageToken == nil {
+				output.NextPageToken = aws.String("page2")
+			}
+			return output, nil
+		},
+	})
+
+	entries, err := LookupSpotshCosts(aws.Config{}, "", 7)
+	if err != nil {
+		t.Fatalf("failed to lookup costs: %v", err)
+	}
+	if len(entries) != 2 || entries[0].InstanceType != "c7i.large" ||
+		entries[0].Region != "us-east-2" || entries[0].Amount != 0.42 ||
+		entries[0].Date != "2024-06-01" {
+		t.Errorf("unexpected cost entries %+v", entries)
+	}
+
+	_, err = LookupSpotshCosts(aws.Config{}, "", 0)
+	if err == nil {
+		t.Errorf("expected error w/ 0 days")
+	}
+}
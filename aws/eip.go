@@ -0,0 +1,151 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// associateEip associates an Elastic IP w/ launchResult's instance,
+// reusing an unassociated one previously allocated by spotsh when available.
+// The instance is tagged w/ the address's allocation id so that terminate
+// can release it.
+func associateEip(ctx context.Context, ec2Client ec2Api, tagPrefix string,
+	launchResult *LaunchEc2SpotResult) error {
+
+	allocId, publicIp, err := getSpotshEip(ctx, ec2Client, tagPrefix)
+	if err != nil {
+		return err
+	}
+	assocInput := &ec2.AssociateAddressInput{
+		AllocationId: aws.String(allocId),
+		InstanceId:   aws.String(launchResult.InstanceId),
+	}
+	_, err = ec2Client.AssociateAddress(ctx, assocInput)
+	if err != nil {
+		return fmt.Errorf("Failed to associate elastic ip %v w/ %v: %w",
+			publicIp, launchResult.InstanceId, err)
+	}
+	launchResult.PublicIp = publicIp
+
+	eipTagKey := tagPrefix + "." + EipTagSuffix
+	tagInput := &ec2.CreateTagsInput{
+		Resources: []string{launchResult.InstanceId},
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(eipTagKey),
+				Value: aws.String(allocId),
+			},
+		},
+	}
+	_, err = ec2Client.CreateTags(ctx, tagInput)
+	if err != nil {
+		return fmt.Errorf("Failed to tag %v w/ elastic ip %v: %w",
+			launchResult.InstanceId, allocId, err)
+	}
+	if launchResult.Tags == nil {
+		launchResult.Tags = make(map[string]string)
+	}
+	launchResult.Tags[eipTagKey] = allocId
+
+	return nil
+}
+
+// getSpotshEip returns the allocation id & public ip of an unassociated
+// Elastic IP tagged by spotsh, allocating a new one if none exist
+func getSpotshEip(ctx context.Context, ec2Client ec2Api,
+	tagPrefix string) (string, string, error) {
+
+	eipTagKey := tagPrefix + "." + EipTagSuffix
+	descInput := &ec2.DescribeAddressesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{eipTagKey},
+			},
+		},
+	}
+	descOutput, err := ec2Client.DescribeAddresses(ctx, descInput)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to describe elastic ips: %w", err)
+	}
+	for _, addr := range descOutput.Addresses {
+		if addr.AssociationId == nil && addr.AllocationId != nil {
+			return *addr.AllocationId, aws.ToString(addr.PublicIp), nil
+		}
+	}
+
+	allocInput := &ec2.AllocateAddressInput{
+		Domain: types.DomainTypeVpc,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeElasticIp,
+				Tags: []types.Tag{
+					{
+						Key:   aws.String(eipTagKey),
+						Value: aws.String("true"),
+					},
+				},
+			},
+		},
+	}
+	allocOutput, err := ec2Client.AllocateAddress(ctx, allocInput)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to allocate elastic ip: %w", err)
+	}
+
+	return aws.ToString(allocOutput.AllocationId),
+		aws.ToString(allocOutput.PublicIp), nil
+}
+
+// ReleaseEip disassociates & releases the Elastic IP w/ allocId; an already
+// released address is not an error
+func ReleaseEip(awsCfg aws.Config, allocId string) error {
+	ec2Client := newEc2Client(awsCfg)
+	ctx := context.Background()
+
+	descInput := &ec2.DescribeAddressesInput{
+		AllocationIds: []string{allocId},
+	}
+	descOutput, err := ec2Client.DescribeAddresses(ctx, descInput)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) &&
+			apiErr.ErrorCode() == "InvalidAllocationID.NotFound" {
+			return nil
+		}
+		return fmt.Errorf("Failed to describe elastic ip %v: %w", allocId,
+			err)
+	}
+	if len(descOutput.Addresses) == 0 {
+		return nil
+	}
+	if descOutput.Addresses[0].AssociationId != nil {
+		disassocInput := &ec2.DisassociateAddressInput{
+			AssociationId: descOutput.Addresses[0].AssociationId,
+		}
+		_, err = ec2Client.DisassociateAddress(ctx, disassocInput)
+		if err != nil {
+			return fmt.Errorf("Failed to disassociate elastic ip %v: %w",
+				allocId, err)
+		}
+	}
+	releaseInput := &ec2.ReleaseAddressInput{
+		AllocationId: aws.String(allocId),
+	}
+	_, err = ec2Client.ReleaseAddress(ctx, releaseInput)
+	if err != nil {
+		return fmt.Errorf("Failed to release elastic ip %v: %w", allocId, err)
+	}
+
+	return nil
+}
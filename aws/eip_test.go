@@ -0,0 +1,96 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func newMockEipClient(addrs []types.Address) (*mockEc2Client, *int) {
+	allocCount := 0
+	mock := newMockEc2Client()
+	mock.describeAddrs = func(*ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+		return &ec2.DescribeAddressesOutput{Addresses: addrs}, nil
+	}
+	mock.allocateAddr = func(*ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+		allocCount++
+		return &ec2.AllocateAddressOutput{
+			AllocationId: aws.String("eipalloc-new"),
+			PublicIp:     aws.String("198.51.100.2"),
+		}, nil
+	}
+	mock.associateAddr = func(*ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+		return &ec2.AssociateAddressOutput{}, nil
+	}
+	mock.createTags = func(*ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+		return &ec2.CreateTagsOutput{}, nil
+	}
+
+	return mock, &allocCount
+}
+
+func TestAssociateEipReuse(t *testing.T) {
+	mock, allocCount := newMockEipClient([]types.Address{
+		{
+			AllocationId:  aws.String("eipalloc-inuse"),
+			AssociationId: aws.String("eipassoc-0"),
+			PublicIp:      aws.String("198.51.100.0"),
+		},
+		{
+			AllocationId: aws.String("eipalloc-free"),
+			PublicIp:     aws.String("198.51.100.1"),
+		},
+	})
+
+	lr := &LaunchEc2SpotResult{InstanceId: "i-0", PublicIp: "192.0.2.1"}
+	err := associateEip(context.Background(), mock, DefaultTagPrefix, lr)
+	if err != nil {
+		t.Fatalf("failed to associate eip: %v", err)
+	}
+	if *allocCount != 0 || lr.PublicIp != "198.51.100.1" ||
+		lr.Tags[DefaultTagPrefix+"."+EipTagSuffix] != "eipalloc-free" {
+		t.Errorf("expected unassociated eip to be reused; got %+v", lr)
+	}
+}
+
+func TestAssociateEipAllocate(t *testing.T) {
+	mock, allocCount := newMockEipClient(nil)
+
+	lr := &LaunchEc2SpotResult{InstanceId: "i-0", PublicIp: "192.0.2.1"}
+	err := associateEip(context.Background(), mock, DefaultTagPrefix, lr)
+	if err != nil {
+		t.Fatalf("failed to associate eip: %v", err)
+	}
+	if *allocCount != 1 || lr.PublicIp != "198.51.100.2" {
+		t.Errorf("expected a new eip to be allocated; got %+v", lr)
+	}
+}
+
+func TestReleaseEip(t *testing.T) {
+	mock, _ := newMockEipClient([]types.Address{{
+		AllocationId:  aws.String("eipalloc-0"),
+		AssociationId: aws.String("eipassoc-0"),
+	}})
+	disassociated, released := false, false
+	mock.disassociateAddr = func(*ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+		disassociated = true
+		return &ec2.DisassociateAddressOutput{}, nil
+	}
+	mock.releaseAddr = func(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+		released = aws.ToString(input.AllocationId) == "eipalloc-0"
+		return &ec2.ReleaseAddressOutput{}, nil
+	}
+	useMockEc2Client(t, mock)
+
+	err := ReleaseEip(aws.Config{Region: "us-east-2"}, "eipalloc-0")
+	if err != nil || !disassociated || !released {
+		t.Errorf("expected eip disassociated & released; err:%v", err)
+	}
+}
@@ -0,0 +1,86 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// minEnclaveVCpus is the fewest vcpus an instance may have in order to
+// allocate some to an enclave while retaining some for the parent instance
+const minEnclaveVCpus = int32(4)
+
+// enclaveScriptFmt installs the Nitro Enclaves cli on Amazon Linux &
+// starts the allocator which reserves the enclave's cpus & memory; other
+// operating systems require the cli be installed manually
+const enclaveScriptFmt = `#!/bin/bash
+# spotsh: install & start the nitro enclaves allocator
+if command -v dnf >/dev/null 2>&1; then
+    dnf install -y aws-nitro-enclaves-cli aws-nitro-enclaves-cli-devel
+elif command -v amazon-linux-extras >/dev/null 2>&1; then
+    amazon-linux-extras install -y aws-nitro-enclaves-cli
+    yum install -y aws-nitro-enclaves-cli-devel
+fi
+if [ -f /etc/nitro_enclaves/allocator.yaml ]; then
+    usermod -aG ne %v
+    systemctl enable --now nitro-enclaves-allocator.service
+else
+    echo "spotsh: nitro enclaves cli is unavailable on this os; please install it manually" >&2
+fi
+`
+
+// checkEnclaveSupported verifies each of iTypes supports Nitro Enclaves &
+// has at least minEnclaveVCpus vcpus
+func checkEnclaveSupported(ctx context.Context, ec2Client ec2Api,
+	iTypes []types.InstanceType) error {
+
+	descInput := &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: iTypes,
+	}
+	supported := make(map[types.InstanceType]bool)
+	paginator := ec2.NewDescribeInstanceTypesPaginator(ec2Client, descInput)
+	for paginator.HasMorePages() {
+		descOutput, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to describe instance types %v: %w",
+				iTypes, err)
+		}
+		for _, iTypeInfo := range descOutput.InstanceTypes {
+			vCpus := int32(0)
+			if iTypeInfo.VCpuInfo != nil {
+				vCpus = aws.ToInt32(iTypeInfo.VCpuInfo.DefaultVCpus)
+			}
+			supported[iTypeInfo.InstanceType] = vCpus >= minEnclaveVCpus &&
+				iTypeInfo.NitroEnclavesSupport == types.NitroEnclavesSupportSupported
+		}
+	}
+
+	var unsupported []string
+	for _, iType := range iTypes {
+		if !supported[iType] {
+			unsupported = append(unsupported, string(iType))
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("Instance type(s) %v do not support Nitro Enclaves w/ at least %v vcpus; choose types such as c6i.xlarge or m6i.xlarge",
+			strings.Join(unsupported, ","), minEnclaveVCpus)
+	}
+
+	return nil
+}
+
+// getEnclaveUserData returns a user data script setting up the Nitro
+// Enclaves allocator for user followed by initCmd which, when set, must
+// itself be a shell script
+func getEnclaveUserData(user string, initCmd string) (string, error) {
+	return prependUserDataScript(fmt.Sprintf(enclaveScriptFmt,
+		shellQuote(user)), initCmd)
+}
@@ -0,0 +1,65 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestCheckEnclaveSupported(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.describeITypes = func(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+		vCpus := map[types.InstanceType]int32{
+			types.InstanceTypeC6iLarge:  2,
+			types.InstanceTypeC6iXlarge: 4,
+			types.InstanceTypeT3Xlarge:  4,
+		}
+		output := &ec2.DescribeInstanceTypesOutput{}
+		for _, iType := range input.InstanceTypes {
+			support := types.NitroEnclavesSupportSupported
+			if iType == types.InstanceTypeT3Xlarge {
+				support = types.NitroEnclavesSupportUnsupported
+			}
+			output.InstanceTypes = append(output.InstanceTypes,
+				types.InstanceTypeInfo{
+					InstanceType:         iType,
+					NitroEnclavesSupport: support,
+					VCpuInfo: &types.VCpuInfo{
+						DefaultVCpus: aws.Int32(vCpus[iType]),
+					},
+				})
+		}
+		return output, nil
+	}
+
+	err := checkEnclaveSupported(context.Background(), mock,
+		[]types.InstanceType{types.InstanceTypeC6iXlarge})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = checkEnclaveSupported(context.Background(), mock,
+		[]types.InstanceType{types.InstanceTypeC6iLarge,
+			types.InstanceTypeT3Xlarge})
+	if err == nil || !strings.Contains(err.Error(), "c6i.large,t3.xlarge") {
+		t.Errorf("expected error naming c6i.large & t3.xlarge but got %v", err)
+	}
+}
+
+func TestGetEnclaveUserData(t *testing.T) {
+	userData, err := getEnclaveUserData("ec2-user",
+		"#!/bin/bash\necho hello\n")
+	if err != nil || strings.Count(userData, "#!") != 1 ||
+		!strings.Contains(userData, "usermod -aG ne 'ec2-user'\n") ||
+		!strings.HasSuffix(userData, "fi\necho hello\n") {
+		t.Errorf("unexpected user data %v err:%v", userData, err)
+	}
+}
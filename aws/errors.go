@@ -0,0 +1,47 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned (possibly wrapped w/ additional detail) by this package so
+// that callers may distinguish failure causes via errors.Is()
+var (
+	// ErrNoDefaultVpc indicates the region has no default VPC from which to
+	// infer a security group
+	ErrNoDefaultVpc = errors.New("Could not find default VPC")
+
+	// ErrAmbiguousVpc indicates the region has no default VPC and more than
+	// one VPC, so a security group must be chosen explicitly; it wraps
+	// ErrNoDefaultVpc
+	ErrAmbiguousVpc = fmt.Errorf("%w among multiple VPCs", ErrNoDefaultVpc)
+
+	// ErrNoLocalKey indicates the private key for an instance's keypair is
+	// not present locally
+	ErrNoLocalKey = errors.New("Could not find local ssh key")
+
+	// ErrNoInstances indicates no spotsh instances are running
+	ErrNoInstances = errors.New("No spotsh instances running")
+
+	// ErrMultipleInstances indicates more than one spotsh instance is
+	// running and none was specified
+	ErrMultipleInstances = errors.New("Multiple spotsh instances found")
+
+	// ErrInstanceNotFound indicates the specified spotsh instance is not
+	// running
+	ErrInstanceNotFound = errors.New("Could not find spotsh instance")
+
+	// ErrInsufficientCapacity indicates EC2 could not fulfill a launch
+	// request for any of the requested instance types at the requested
+	// price
+	ErrInsufficientCapacity = errors.New("Unable to create instances at this price")
+
+	// ErrNoPublishedAmi indicates AWS does not publish an AMI for the
+	// operating system in the region (e.g. some opt-in & GovCloud regions)
+	ErrNoPublishedAmi = errors.New("No published AMI")
+)
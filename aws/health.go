@@ -0,0 +1,90 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"golang.org/x/sync/errgroup"
+)
+
+// LookupInstanceHealth populates InstanceStatus & SystemStatus of each entry
+// in launchResults w/ the result of EC2's reachability status checks. An
+// instance can be running yet still be initializing or failing these checks
+// (e.g. shortly after launch or due to an underlying hardware issue).
+func LookupInstanceHealth(awsCfg aws.Config,
+	launchResults []LaunchEc2SpotResult) error {
+
+	regionIdxs := make(map[string][]int)
+	for idx := range launchResults {
+		region := launchResults[idx].Region
+		regionIdxs[region] = append(regionIdxs[region], idx)
+	}
+
+	ctx := context.Background()
+	var wg errgroup.Group
+	for region, idxs := range regionIdxs {
+		regionCfg := awsCfg.Copy()
+		regionCfg.Region = region
+		idxs := idxs // https://golang.org/doc/faq#closures_and_goroutines
+		wg.Go(func() error {
+			// each goroutine only touches its own region's entries
+			return lookupInstanceHealthOneRegion(ctx, regionCfg, launchResults,
+				idxs)
+		})
+	}
+
+	return wg.Wait()
+}
+
+func lookupInstanceHealthOneRegion(ctx context.Context, awsCfg aws.Config,
+	launchResults []LaunchEc2SpotResult, idxs []int) error {
+
+	instanceIds := make([]string, 0, len(idxs))
+	for _, idx := range idxs {
+		instanceIds = append(instanceIds, launchResults[idx].InstanceId)
+	}
+
+	ec2Client := newEc2Client(awsCfg)
+	statusInput := &ec2.DescribeInstanceStatusInput{
+		InstanceIds: instanceIds,
+	}
+	statusOutput, err := ec2Client.DescribeInstanceStatus(ctx, statusInput)
+	if err != nil {
+		return fmt.Errorf("Failed to describe instance status in %v: %w",
+			awsCfg.Region, err)
+	}
+
+	statusById := make(map[string]int)
+	for sIdx, status := range statusOutput.InstanceStatuses {
+		if status.InstanceId != nil {
+			statusById[*status.InstanceId] = sIdx
+		}
+	}
+	for _, idx := range idxs {
+		launchResult := &launchResults[idx]
+		sIdx, ok := statusById[launchResult.InstanceId]
+		if !ok {
+			// ec2 omits status for instances it has not yet checked
+			launchResult.InstanceStatus = HealthUnknown
+			launchResult.SystemStatus = HealthUnknown
+			continue
+		}
+		status := statusOutput.InstanceStatuses[sIdx]
+		launchResult.InstanceStatus = HealthUnknown
+		if status.InstanceStatus != nil && status.InstanceStatus.Status != "" {
+			launchResult.InstanceStatus = string(status.InstanceStatus.Status)
+		}
+		launchResult.SystemStatus = HealthUnknown
+		if status.SystemStatus != nil && status.SystemStatus.Status != "" {
+			launchResult.SystemStatus = string(status.SystemStatus.Status)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// cloud-init prints an instance's ssh host public keys to its console
+// between these markers
+const (
+	hostKeysBeginMarker = "-----BEGIN SSH HOST KEY KEYS-----"
+	hostKeysEndMarker   = "-----END SSH HOST KEY KEYS-----"
+)
+
+// LookupHostKeys returns instanceId's ssh host public keys (e.g.
+// "ssh-ed25519 AAAA... root@host") as printed by cloud-init to its console.
+// EC2 only makes console output available a few minutes after boot so an
+// empty result w/o error means the keys aren't available yet.
+func LookupHostKeys(awsCfg aws.Config, instanceId string) ([]string, error) {
+	ec2Client := newEc2Client(awsCfg)
+
+	consoleInput := &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceId),
+		Latest:     aws.Bool(true),
+	}
+	consoleOutput, err := ec2Client.GetConsoleOutput(context.Background(),
+		consoleInput)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get console output of %v: %w",
+			instanceId, err)
+	}
+	if consoleOutput.Output == nil {
+		return nil, nil
+	}
+	console, err := base64.StdEncoding.DecodeString(*consoleOutput.Output)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode console output of %v: %w",
+			instanceId, err)
+	}
+
+	return parseHostKeys(string(console)), nil
+}
+
+func parseHostKeys(console string) []string {
+	_, afterBegin, found := strings.Cut(console, hostKeysBeginMarker)
+	if !found {
+		return nil
+	}
+	keysText, _, found := strings.Cut(afterBegin, hostKeysEndMarker)
+	if !found {
+		return nil
+	}
+
+	hostKeys := make([]string, 0)
+	for _, line := range strings.Split(keysText, "\n") {
+		line = strings.TrimSpace(line)
+		// console lines may be prefixed w/ kernel timestamps etc.; start at
+		// the key type
+		idx := strings.Index(line, "ssh-")
+		if idx < 0 {
+			idx = strings.Index(line, "ecdsa-")
+		}
+		if idx < 0 {
+			continue
+		}
+		hostKeys = append(hostKeys, line[idx:])
+	}
+
+	return hostKeys
+}
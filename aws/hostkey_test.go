@@ -0,0 +1,59 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+const testConsole = `[   12.345] cloud-init[812]: ci-info: no authorized ssh keys
+<14>Oct 15 12:00:00 cloud-init: #############################################################
+-----BEGIN SSH HOST KEY KEYS-----
+ecdsa-sha2-nistp256 AAAAE2VjZHNh root@ip-10-0-0-1
+ssh-ed25519 AAAAC3NzaC1lZDI1NTE5 root@ip-10-0-0-1
+-----END SSH HOST KEY KEYS-----
+`
+
+func TestParseHostKeys(t *testing.T) {
+	hostKeys := parseHostKeys(testConsole)
+	if len(hostKeys) != 2 {
+		t.Fatalf("expected 2 host keys; got %v", hostKeys)
+	}
+	if hostKeys[1] != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5 root@ip-10-0-0-1" {
+		t.Errorf("unexpected host key %v", hostKeys[1])
+	}
+
+	hostKeys = parseHostKeys("booting...\n")
+	if len(hostKeys) != 0 {
+		t.Errorf("expected no host keys before cloud-init; got %v", hostKeys)
+	}
+}
+
+func TestLookupHostKeys(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.consoleOutput = func(input *ec2.GetConsoleOutputInput) (*ec2.GetConsoleOutputOutput, error) {
+		if !aws.ToBool(input.Latest) {
+			t.Errorf("expected latest console output to be requested")
+		}
+		return &ec2.GetConsoleOutputOutput{
+			InstanceId: input.InstanceId,
+			Output: aws.String(base64.StdEncoding.EncodeToString(
+				[]byte(testConsole))),
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+
+	hostKeys, err := LookupHostKeys(aws.Config{Region: "us-east-2"}, "i-0")
+	if err != nil {
+		t.Fatalf("failed to lookup host keys: %v", err)
+	}
+	if len(hostKeys) != 2 {
+		t.Errorf("expected 2 host keys; got %v", hostKeys)
+	}
+}
@@ -6,11 +6,19 @@ package aws
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
 
 	"github.com/mikeb26/spotsh"
 )
@@ -22,7 +30,9 @@ type imageIdEntry struct {
 	user     string
 }
 
-var imageIdTab = []imageIdEntry{
+// imageIdTab is an array rather than a slice so that its length is a
+// constant which is checked against spotsh.OsInvalid below
+var imageIdTab = [...]imageIdEntry{
 	spotsh.OsNone: {},
 	spotsh.Ubuntu22_04: {
 		os:       spotsh.Ubuntu22_04,
@@ -62,6 +72,11 @@ var imageIdTab = []imageIdEntry{
 	},
 }
 
+// Adding a spotsh.OperatingSystem w/o a corresponding imageIdTab entry (or
+// vice versa) results in a negative array length and fails to compile
+var _ [len(imageIdTab) - int(spotsh.OsInvalid)]struct{}
+var _ [int(spotsh.OsInvalid) - len(imageIdTab)]struct{}
+
 func GetImageDesc(os spotsh.OperatingSystem) string {
 	idx := uint64(os)
 	if os == spotsh.OsNone || os >= spotsh.OsInvalid {
@@ -71,32 +86,79 @@ func GetImageDesc(os spotsh.OperatingSystem) string {
 	return imageIdTab[idx].desc
 }
 
+// getImageIdEntry returns os's imageIdTab entry or an error if os is not a
+// supported operating system; e.g. OsInvalid from an unrecognized --os or a
+// preferences file written by a newer spotsh
+func getImageIdEntry(os spotsh.OperatingSystem) (*imageIdEntry, error) {
+	if os == spotsh.OsNone {
+		return nil, fmt.Errorf("Must specify os type to determine latest ami")
+	}
+	if os >= spotsh.OsInvalid {
+		var sb strings.Builder
+		for _, tmpOs := range os.Values() {
+			if sb.Len() > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(tmpOs.String())
+		}
+		return nil, fmt.Errorf("Unsupported operating system (check --os or the Os set via 'spotsh config'); supported operating systems are: %v",
+			sb.String())
+	}
+
+	return &imageIdTab[os], nil
+}
+
 func getLatestAmiId(ctx context.Context, awsCfg aws.Config,
 	os spotsh.OperatingSystem) (string, error) {
 
-	if os == spotsh.OsNone {
-		return "", fmt.Errorf("Must specify os type to determine latest ami")
-	}
-	idx := uint64(os)
-	if idx >= uint64(spotsh.OsInvalid) {
-		return "", fmt.Errorf("No such os index %v", idx)
+	idEntry, err := getImageIdEntry(os)
+	if err != nil {
+		return "", err
 	}
-	idEntry := &imageIdTab[idx]
 
-	ssmClient := ssm.NewFromConfig(awsCfg)
+	ssmClient := newSsmClient(awsCfg)
 	getParamInput := &ssm.GetParameterInput{
 		Name: &idEntry.ssmParam,
 	}
 	getParamOutput, err := ssmClient.GetParameter(ctx, getParamInput)
-	if err != nil {
-		return "", err
+	var notFoundErr *ssmtypes.ParameterNotFound
+	if errors.As(err, &notFoundErr) {
+		return "", fmt.Errorf("%w: os %v has no published ami in region %v (ssm parameter %v does not exist there); please specify --ami-id or another --os",
+			ErrNoPublishedAmi, os, awsCfg.Region, idEntry.ssmParam)
+	} else if err != nil {
+		return "", fmt.Errorf("Failed to get latest %v ami from ssm parameter %v in %v: %w",
+			os, idEntry.ssmParam, awsCfg.Region, err)
 	}
 
 	return *getParamOutput.Parameter.Value, nil
 }
 
-func getRootVolName(ctx context.Context, ec2Client *ec2.Client,
-	amiId string) (string, error) {
+// LookupAmiOs returns the operating system & its default user when amiId is
+// the latest image of one of spotsh's supported operating systems in
+// awsCfg's region; otherwise OsNone & "" are returned
+func LookupAmiOs(awsCfg aws.Config, amiId string) (spotsh.OperatingSystem,
+	string, error) {
+
+	ctx := context.Background()
+	for _, os := range spotsh.OsNone.Values() {
+		latestAmiId, err := getLatestAmiId(ctx, awsCfg, os)
+		if errors.Is(err, ErrNoPublishedAmi) {
+			continue
+		} else if err != nil {
+			return spotsh.OsNone, "", err
+		}
+		if latestAmiId == amiId {
+			return os, imageIdTab[os].user, nil
+		}
+	}
+
+	return spotsh.OsNone, "", nil
+}
+
+// getRootVolInfo returns amiId's root device name along w/ the size of its
+// root snapshot in GiB, or 0 if unknown
+func getRootVolInfo(ctx context.Context, ec2Client ec2Api,
+	amiId string) (string, int32, error) {
 
 	dryRun := false
 	descInput := &ec2.DescribeImagesInput{
@@ -106,18 +168,61 @@ func getRootVolName(ctx context.Context, ec2Client *ec2.Client,
 
 	descOutput, err := ec2Client.DescribeImages(ctx, descInput)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	if len(descOutput.Images) != 1 {
-		return "", fmt.Errorf("Unexpected image count returned(%v) for %v description",
+		return "", 0, fmt.Errorf("Unexpected image count returned(%v) for %v description",
 			len(descOutput.Images), amiId)
 	}
 
-	return *descOutput.Images[0].RootDeviceName, nil
+	image := &descOutput.Images[0]
+	rootVolName := *image.RootDeviceName
+	snapshotSize := int32(0)
+	for _, blockDev := range image.BlockDeviceMappings {
+		if blockDev.DeviceName != nil && *blockDev.DeviceName == rootVolName &&
+			blockDev.Ebs != nil && blockDev.Ebs.VolumeSize != nil {
+			snapshotSize = *blockDev.Ebs.VolumeSize
+		}
+	}
+
+	return rootVolName, snapshotSize, nil
 }
 
-func getAmiIdFromName(awsCfg aws.Config, ec2Client *ec2.Client,
+// getRootVolSize returns the root volume size to launch w/ given the
+// requested size (0 for spotsh's default) & the AMI's root snapshot size,
+// or nil when the AMI's own size should be used. An explicitly requested
+// size smaller than the snapshot can't be satisfied by EC2; spotsh's
+// default is instead raised to fit.
+func getRootVolSize(amiId string, requestedSize int32, snapshotSize int32,
+	useAmiSize bool) (*int32, error) {
+
+	if useAmiSize {
+		return nil, nil
+	}
+	if requestedSize == 0 {
+		rootVolSize := DefaultRootVolSizeInGiB
+		if snapshotSize > rootVolSize {
+			rootVolSize = snapshotSize
+		}
+		return &rootVolSize, nil
+	}
+	if requestedSize < snapshotSize {
+		return nil, fmt.Errorf("Root volume size %v GiB is smaller than AMI %v's %v GiB root snapshot; please request at least %v GiB or use the AMI's default size",
+			requestedSize, amiId, snapshotSize, snapshotSize)
+	}
+
+	return &requestedSize, nil
+}
+
+// LookupAmiIdByName returns the id of the self-owned ami named amiName
+func LookupAmiIdByName(awsCfg aws.Config, amiName string) (string, error) {
+	ec2Client := newEc2Client(awsCfg)
+
+	return getAmiIdFromName(awsCfg, ec2Client, amiName)
+}
+
+func getAmiIdFromName(awsCfg aws.Config, ec2Client ec2Api,
 	amiName string) (string, error) {
 
 	lookupImagesResult, err := lookupImagesCommon(awsCfg, ec2Client)
@@ -134,10 +239,112 @@ func getAmiIdFromName(awsCfg aws.Config, ec2Client *ec2.Client,
 	return "", fmt.Errorf("Could not find ami id for %v", amiName)
 }
 
+func getAmiIdFromNamePrefix(awsCfg aws.Config, ec2Client ec2Api,
+	amiNamePrefix string, latest bool) (string, error) {
+
+	lookupImagesResult, err := lookupImagesCommon(awsCfg, ec2Client)
+	if err != nil {
+		return "", err
+	}
+
+	imgDesc, err := selectImageByNamePrefix(lookupImagesResult, amiNamePrefix,
+		latest)
+	if err != nil {
+		return "", err
+	}
+
+	return imgDesc.Id, nil
+}
+
+// amiNameMatches treats amiNamePattern as a glob when it contains glob
+// metacharacters and as a plain prefix otherwise
+func amiNameMatches(amiNamePattern string, amiName string) bool {
+	if strings.ContainsAny(amiNamePattern, "*?[") {
+		match, err := path.Match(amiNamePattern, amiName)
+		return err == nil && match
+	}
+
+	return strings.HasPrefix(amiName, amiNamePattern)
+}
+
+// selectImageByNamePrefix returns the single image matching amiNamePrefix or,
+// when latest is set, the most recently created of the matching images.
+func selectImageByNamePrefix(lookupImagesResult LookupImagesResult,
+	amiNamePrefix string, latest bool) (*LookupImageItem, error) {
+
+	matches := make([]*LookupImageItem, 0)
+	for _, imgDesc := range lookupImagesResult.Images {
+		if amiNameMatches(amiNamePrefix, imgDesc.Name) {
+			matches = append(matches, imgDesc)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("Could not find any ami matching %v",
+			amiNamePrefix)
+	}
+
+	// CreationDate is ISO 8601 so lexical order is chronological order
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].CreationDate != matches[j].CreationDate {
+			return matches[i].CreationDate > matches[j].CreationDate
+		}
+		return matches[i].Name < matches[j].Name
+	})
+
+	if len(matches) > 1 && !latest {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("Multiple amis match %v; please disambiguate or choose the newest w/ --latest:",
+			amiNamePrefix))
+		for _, imgDesc := range matches {
+			sb.WriteString(fmt.Sprintf("\n\t%v (%v) created %v", imgDesc.Name,
+				imgDesc.Id, imgDesc.CreationDate))
+		}
+		return nil, fmt.Errorf("%v", sb.String())
+	}
+
+	return matches[0], nil
+}
+
+const (
+	// images older than this are flagged as stale
+	ImageAgeWarnDays = 180
+	// images this close to their deprecation time are flagged
+	ImageDeprecationWarnDays = 30
+)
+
 type LookupImageItem struct {
-	Id        string
-	Name      string
-	Ownership string
+	Id              string
+	Name            string
+	Ownership       string
+	CreationDate    string
+	DeprecationTime string
+}
+
+// AgeInDays returns the number of whole days elapsed since the image was
+// created
+func (img *LookupImageItem) AgeInDays(now time.Time) (int, error) {
+	created, err := time.Parse(time.RFC3339, img.CreationDate)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse creation date of %v: %w", img.Id,
+			err)
+	}
+
+	return int(now.Sub(created).Hours() / 24), nil
+}
+
+// DaysUntilDeprecation returns the number of whole days remaining until the
+// image is deprecated; false is returned when the image has no (parseable)
+// deprecation time
+func (img *LookupImageItem) DaysUntilDeprecation(now time.Time) (int, bool) {
+	if img.DeprecationTime == "" {
+		return 0, false
+	}
+	deprecated, err := time.Parse(time.RFC3339, img.DeprecationTime)
+	if err != nil {
+		return 0, false
+	}
+
+	return int(deprecated.Sub(now).Hours() / 24), true
 }
 
 type LookupImagesResult struct {
@@ -145,13 +352,13 @@ type LookupImagesResult struct {
 }
 
 func LookupImages(awsCfg aws.Config) (LookupImagesResult, error) {
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	ec2Client := newEc2Client(awsCfg)
 
 	return lookupImagesCommon(awsCfg, ec2Client)
 }
 
 func lookupImagesCommon(awsCfg aws.Config,
-	ec2Client *ec2.Client) (LookupImagesResult, error) {
+	ec2Client ec2Api) (LookupImagesResult, error) {
 
 	lookupImagesResult := LookupImagesResult{
 		Images: make(map[string]*LookupImageItem),
@@ -175,6 +382,12 @@ func lookupImagesCommon(awsCfg aws.Config,
 			Id:        *imgDesc.ImageId,
 			Ownership: "self",
 		}
+		if imgDesc.CreationDate != nil {
+			lookupImageItem.CreationDate = *imgDesc.CreationDate
+		}
+		if imgDesc.DeprecationTime != nil {
+			lookupImageItem.DeprecationTime = *imgDesc.DeprecationTime
+		}
 
 		lookupImagesResult.Images[lookupImageItem.Id] = lookupImageItem
 	}
@@ -182,25 +395,133 @@ func lookupImagesCommon(awsCfg aws.Config,
 	return lookupImagesResult, nil
 }
 
+// LookupLatestOsImages resolves the latest AWS provided AMI for each
+// supported operating system
+func LookupLatestOsImages(awsCfg aws.Config) (map[spotsh.OperatingSystem]*LookupImageItem,
+	error) {
+
+	ctx := context.Background()
+	amiIds := make([]string, 0)
+	osByAmiId := make(map[string]spotsh.OperatingSystem)
+	for _, os := range spotsh.OsNone.Values() {
+		amiId, err := getLatestAmiId(ctx, awsCfg, os)
+		if errors.Is(err, ErrNoPublishedAmi) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		amiIds = append(amiIds, amiId)
+		osByAmiId[amiId] = os
+	}
+	result := make(map[spotsh.OperatingSystem]*LookupImageItem)
+	if len(amiIds) == 0 {
+		// DescribeImages w/o ImageIds would describe every public image
+		return result, nil
+	}
+
+	ec2Client := newEc2Client(awsCfg)
+	dryRun := false
+	descInput := &ec2.DescribeImagesInput{
+		DryRun:   &dryRun,
+		ImageIds: amiIds,
+	}
+	descOutput, err := ec2Client.DescribeImages(ctx, descInput)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, imgDesc := range descOutput.Images {
+		lookupImageItem := &LookupImageItem{
+			Id:        *imgDesc.ImageId,
+			Ownership: "aws",
+		}
+		if imgDesc.Name != nil {
+			lookupImageItem.Name = *imgDesc.Name
+		}
+		if imgDesc.CreationDate != nil {
+			lookupImageItem.CreationDate = *imgDesc.CreationDate
+		}
+		if imgDesc.DeprecationTime != nil {
+			lookupImageItem.DeprecationTime = *imgDesc.DeprecationTime
+		}
+		result[osByAmiId[lookupImageItem.Id]] = lookupImageItem
+	}
+
+	return result, nil
+}
+
+// CreateImage creates an AMI from instanceId returning the new AMI's id.
+// EC2's CreateImage accepts no ClientToken so the AMI's name, which must be
+// unique, instead keeps retries idempotent: one is generated when name is
+// unset, and a retry finding the name already taken by an image of
+// instanceId returns that image rather than failing.
 func CreateImage(awsCfg aws.Config, instanceId string, name string,
 	desc string) (string, error) {
 
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	ec2Client := newEc2Client(awsCfg)
+
+	return createImage(context.Background(), ec2Client, instanceId, name,
+		desc)
+}
+
+func createImage(ctx context.Context, ec2Client ec2Api, instanceId string,
+	name string, desc string) (string, error) {
 
+	if name == "" {
+		var err error
+		name, err = newClientToken(DefaultTagPrefix)
+		if err != nil {
+			return "", err
+		}
+	}
 	input := &ec2.CreateImageInput{
 		InstanceId: aws.String(instanceId),
-	}
-	if name != "" {
-		input.Name = aws.String(name)
+		Name:       aws.String(name),
 	}
 	if desc != "" {
 		input.Description = aws.String(desc)
 	}
 
-	result, err := ec2Client.CreateImage(context.Background(), input)
+	result, err := ec2Client.CreateImage(ctx, input)
 	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) &&
+			apiErr.ErrorCode() == "InvalidAMIName.Duplicate" {
+			amiId, lookupErr := getRetriedImageId(ctx, ec2Client, instanceId,
+				name)
+			if lookupErr == nil && amiId != "" {
+				return amiId, nil
+			}
+		}
 		return "", err
 	}
 
 	return *result.ImageId, nil
 }
+
+// getRetriedImageId returns the id of the self-owned AMI named name which an
+// earlier attempt created from instanceId, or "" if there is none
+func getRetriedImageId(ctx context.Context, ec2Client ec2Api,
+	instanceId string, name string) (string, error) {
+
+	descInput := &ec2.DescribeImagesInput{
+		Owners: []string{"self"},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("name"),
+				Values: []string{name},
+			},
+		},
+	}
+	descOutput, err := ec2Client.DescribeImages(ctx, descInput)
+	if err != nil {
+		return "", err
+	}
+	for _, img := range descOutput.Images {
+		if aws.ToString(img.SourceInstanceId) == instanceId {
+			return aws.ToString(img.ImageId), nil
+		}
+	}
+
+	return "", nil
+}
@@ -6,9 +6,21 @@ package aws
 
 import (
 	"context"
+	"errors"
+	"path"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/mikeb26/spotsh"
 )
 
 func TestLookupImages(t *testing.T) {
@@ -38,3 +50,258 @@ func TestLookupImages(t *testing.T) {
 			awsOwnedCount, 0)
 	}
 }
+
+func TestSelectImageByNamePrefix(t *testing.T) {
+	lookupImagesResult := LookupImagesResult{
+		Images: map[string]*LookupImageItem{
+			"ami-0": {
+				Id:           "ami-0",
+				Name:         "mybox-20240101",
+				CreationDate: "2024-01-01T00:00:00.000Z",
+			},
+			"ami-1": {
+				Id:           "ami-1",
+				Name:         "mybox-20240115",
+				CreationDate: "2024-01-15T00:00:00.000Z",
+			},
+			"ami-2": {
+				Id:           "ami-2",
+				Name:         "otherbox-20240201",
+				CreationDate: "2024-02-01T00:00:00.000Z",
+			},
+		},
+	}
+
+	_, err := selectImageByNamePrefix(lookupImagesResult, "mybox-", false)
+	if err == nil {
+		t.Fatalf("expected ambiguous prefix to fail w/o latest")
+	}
+
+	img, err := selectImageByNamePrefix(lookupImagesResult, "mybox-", true)
+	if err != nil {
+		t.Fatalf("failed to select latest image: %v", err)
+	}
+	if img.Id != "ami-1" {
+		t.Errorf("expected ami-1 to be latest but got %v", img.Id)
+	}
+
+	img, err = selectImageByNamePrefix(lookupImagesResult, "*box-202402*",
+		false)
+	if err != nil {
+		t.Fatalf("failed to select image by glob: %v", err)
+	}
+	if img.Id != "ami-2" {
+		t.Errorf("expected glob to match ami-2 but got %v", img.Id)
+	}
+
+	_, err = selectImageByNamePrefix(lookupImagesResult, "nobox-", true)
+	if err == nil {
+		t.Fatalf("expected unmatched prefix to fail")
+	}
+}
+
+func TestImageDates(t *testing.T) {
+	now := time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)
+	img := &LookupImageItem{
+		Id:              "ami-0",
+		CreationDate:    "2024-01-01T12:00:00.000Z",
+		DeprecationTime: "2024-07-11T00:00:00.000Z",
+	}
+
+	ageDays, err := img.AgeInDays(now)
+	if err != nil {
+		t.Fatalf("failed to compute age: %v", err)
+	}
+	if ageDays != 181 {
+		t.Errorf("expected age of 181 days but got %v", ageDays)
+	}
+	daysLeft, ok := img.DaysUntilDeprecation(now)
+	if !ok || daysLeft != 10 {
+		t.Errorf("expected 10 days until deprecation but got %v/%v", daysLeft,
+			ok)
+	}
+
+	img.DeprecationTime = ""
+	_, ok = img.DaysUntilDeprecation(now)
+	if ok {
+		t.Errorf("expected no deprecation time")
+	}
+	img.CreationDate = "garbage"
+	_, err = img.AgeInDays(now)
+	if err == nil {
+		t.Errorf("expected unparseable creation date to fail")
+	}
+}
+
+func TestGetImageIdEntry(t *testing.T) {
+	for _, os := range []spotsh.OperatingSystem{spotsh.OsNone,
+		spotsh.OsInvalid, spotsh.OsInvalid + 1} {
+
+		_, err := getImageIdEntry(os)
+		if err == nil {
+			t.Errorf("expected error for os %v", uint64(os))
+		}
+	}
+
+	entry, err := getImageIdEntry(spotsh.Debian12)
+	if err != nil || entry.os != spotsh.Debian12 {
+		t.Errorf("unexpected entry %v err:%v", entry, err)
+	}
+}
+
+func TestGetRootVolSize(t *testing.T) {
+	size, err := getRootVolSize("ami-0", 0, 8, false)
+	if err != nil || size == nil || *size != DefaultRootVolSizeInGiB {
+		t.Errorf("expected default size; got %v err:%v", size, err)
+	}
+
+	// spotsh's default grows to fit a larger snapshot
+	size, err = getRootVolSize("ami-0", 0, 100, false)
+	if err != nil || size == nil || *size != 100 {
+		t.Errorf("expected 100 GiB; got %v err:%v", size, err)
+	}
+
+	_, err = getRootVolSize("ami-0", 32, 100, false)
+	if err == nil {
+		t.Errorf("expected error for size smaller than snapshot")
+	}
+
+	size, err = getRootVolSize("ami-0", 32, 100, true)
+	if err != nil || size != nil {
+		t.Errorf("expected AMI's own size; got %v err:%v", size, err)
+	}
+}
+
+func TestGetLatestAmiIdNotPublished(t *testing.T) {
+	useMockSsmClient(t, &mockSsmClient{
+		getParameter: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+			if strings.Contains(*input.Name, "canonical") {
+				return nil, &ssmtypes.ParameterNotFound{}
+			}
+			return &ssm.GetParameterOutput{
+				Parameter: &ssmtypes.Parameter{
+					Value: aws.String("ami-" + path.Base(*input.Name)),
+				},
+			}, nil
+		},
+	})
+	awsCfg := aws.Config{Region: "ap-southeast-7"}
+
+	_, err := getLatestAmiId(context.Background(), awsCfg, spotsh.Ubuntu22_04)
+	if !errors.Is(err, ErrNoPublishedAmi) ||
+		!strings.Contains(err.Error(), "ap-southeast-7") {
+		t.Errorf("expected ErrNoPublishedAmi naming the region but got %v", err)
+	}
+
+	mock := newMockEc2Client()
+	mock.describeImages = func(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+		output := &ec2.DescribeImagesOutput{}
+		for _, amiId := range input.ImageIds {
+			output.Images = append(output.Images, types.Image{
+				ImageId: aws.String(amiId),
+			})
+		}
+		return output, nil
+	}
+	useMockEc2Client(t, mock)
+
+	images, err := LookupLatestOsImages(awsCfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := images[spotsh.Ubuntu22_04]; ok {
+		t.Errorf("expected unpublished ubuntu to be skipped")
+	}
+	if _, ok := images[spotsh.AmazonLinux2023]; !ok {
+		t.Errorf("expected amazon linux 2023 to be listed")
+	}
+}
+
+func TestLookupAmiOs(t *testing.T) {
+	useMockSsmClient(t, &mockSsmClient{
+		getParameter: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+			if strings.Contains(*input.Name, "debian") {
+				return nil, &ssmtypes.ParameterNotFound{}
+			}
+			return &ssm.GetParameterOutput{
+				Parameter: &ssmtypes.Parameter{
+					Value: aws.String("ami" + strings.ReplaceAll(*input.Name, "/", "-")),
+				},
+			}, nil
+		},
+	})
+	awsCfg := aws.Config{Region: "us-east-2"}
+
+	os, user, err := LookupAmiOs(awsCfg,
+		"ami"+strings.ReplaceAll(imageIdTab[spotsh.Ubuntu24_04].ssmParam, "/", "-"))
+	if err != nil || os != spotsh.Ubuntu24_04 || user != "ubuntu" {
+		t.Errorf("expected ubuntu24.04 & ubuntu but got %v %v err:%v", os,
+			user, err)
+	}
+
+	os, user, err = LookupAmiOs(awsCfg, "ami-custom")
+	if err != nil || os != spotsh.OsNone || user != "" {
+		t.Errorf("expected unknown ami but got %v %v err:%v", os, user, err)
+	}
+}
+
+func TestLookupAmiIdByName(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.describeImages = func(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+		return &ec2.DescribeImagesOutput{
+			Images: []types.Image{
+				{Name: aws.String("my-ami"), ImageId: aws.String("ami-mine")},
+			},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+	awsCfg := aws.Config{Region: "us-east-2"}
+
+	amiId, err := LookupAmiIdByName(awsCfg, "my-ami")
+	if err != nil || amiId != "ami-mine" {
+		t.Errorf("expected ami-mine but got %v err:%v", amiId, err)
+	}
+	_, err = LookupAmiIdByName(awsCfg, "other-ami")
+	if err == nil {
+		t.Errorf("expected an error for an unknown ami name")
+	}
+}
+
+func TestCreateImageRetry(t *testing.T) {
+	var names []string
+	mock := &mockEc2Client{
+		createImage: func(input *ec2.CreateImageInput) (*ec2.CreateImageOutput, error) {
+			names = append(names, aws.ToString(input.Name))
+			if len(names) == 1 {
+				return &ec2.CreateImageOutput{ImageId: aws.String("ami-0")}, nil
+			}
+			return nil, &smithy.GenericAPIError{Code: "InvalidAMIName.Duplicate"}
+		},
+		describeImages: func(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+			return &ec2.DescribeImagesOutput{
+				Images: []types.Image{{
+					ImageId:          aws.String("ami-0"),
+					SourceInstanceId: aws.String("i-0"),
+				}},
+			}, nil
+		},
+	}
+
+	ctx := context.Background()
+	amiId, err := createImage(ctx, mock, "i-0", "", "")
+	if err != nil || amiId != "ami-0" {
+		t.Fatalf("expected ami-0 but got %v err:%v", amiId, err)
+	}
+	if !strings.HasPrefix(names[0], DefaultTagPrefix+"-") {
+		t.Errorf("expected a generated name but got %v", names[0])
+	}
+	amiId, err = createImage(ctx, mock, "i-0", names[0], "")
+	if err != nil || amiId != "ami-0" {
+		t.Errorf("expected retry to return ami-0 but got %v err:%v", amiId,
+			err)
+	}
+	_, err = createImage(ctx, mock, "i-1", names[0], "")
+	if err == nil {
+		t.Errorf("expected duplicate name of another instance's image to fail")
+	}
+}
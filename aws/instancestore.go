@@ -0,0 +1,101 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// maxInstanceStoreVolumes is the number of ephemeral volumes mapped when
+// instance store is requested; mappings beyond the number an instance type
+// actually has are ignored by EC2
+const maxInstanceStoreVolumes = 4
+
+// instanceStoreScriptFmt formats & mounts each local NVMe instance store
+// volume; the first at the requested path & subsequent ones at path2,
+// path3, etc.
+const instanceStoreScriptFmt = `#!/bin/bash
+# spotsh: format & mount local NVMe instance store volumes
+MNT=%v
+IDX=1
+for DEV in $(lsblk -dpno NAME,MODEL | awk '/Instance Storage/ {print $1}')
+do
+    DIR="$MNT"
+    if [ $IDX -gt 1 ]; then DIR="$MNT$IDX"; fi
+    mkfs.xfs -f "$DEV" && mkdir -p "$DIR" && mount -o noatime "$DEV" "$DIR" && chmod 1777 "$DIR"
+    IDX=$((IDX+1))
+done
+`
+
+// checkInstanceStoreSupported verifies each of iTypes has local instance
+// storage which can be mounted via InstanceStoreMount
+func checkInstanceStoreSupported(ctx context.Context, ec2Client ec2Api,
+	iTypes []types.InstanceType) error {
+
+	descInput := &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: iTypes,
+	}
+	supported := make(map[types.InstanceType]bool)
+	paginator := ec2.NewDescribeInstanceTypesPaginator(ec2Client, descInput)
+	for paginator.HasMorePages() {
+		descOutput, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to describe instance types %v: %w",
+				iTypes, err)
+		}
+		for _, iTypeInfo := range descOutput.InstanceTypes {
+			supported[iTypeInfo.InstanceType] =
+				aws.ToBool(iTypeInfo.InstanceStorageSupported)
+		}
+	}
+
+	var unsupported []string
+	for _, iType := range iTypes {
+		if !supported[iType] {
+			unsupported = append(unsupported, string(iType))
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("Instance type(s) %v have no instance storage; choose types such as c6id.large or i4i.large",
+			strings.Join(unsupported, ","))
+	}
+
+	return nil
+}
+
+// getInstanceStoreBlockMaps returns block device mappings exposing the
+// instance's ephemeral volumes
+func getInstanceStoreBlockMaps() []types.LaunchTemplateBlockDeviceMappingRequest {
+	blockMaps := make([]types.LaunchTemplateBlockDeviceMappingRequest, 0)
+	for idx := 0; idx < maxInstanceStoreVolumes; idx++ {
+		blockMaps = append(blockMaps, types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName:  aws.String(fmt.Sprintf("/dev/sd%c", 'b'+idx)),
+			VirtualName: aws.String(fmt.Sprintf("ephemeral%v", idx)),
+		})
+	}
+
+	return blockMaps
+}
+
+// shellQuote quotes s as a single shell word w/o any expansion
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// getInstanceStoreUserData returns a user data script mounting instance
+// store at mountPath followed by initCmd which, when set, must itself be a
+// shell script
+func getInstanceStoreUserData(mountPath string,
+	initCmd string) (string, error) {
+
+	return prependUserDataScript(fmt.Sprintf(instanceStoreScriptFmt,
+		shellQuote(mountPath)), initCmd)
+}
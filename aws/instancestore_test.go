@@ -0,0 +1,65 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestCheckInstanceStoreSupported(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.describeITypes = func(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+		output := &ec2.DescribeInstanceTypesOutput{}
+		for _, iType := range input.InstanceTypes {
+			output.InstanceTypes = append(output.InstanceTypes,
+				types.InstanceTypeInfo{
+					InstanceType: iType,
+					InstanceStorageSupported: aws.Bool(iType ==
+						types.InstanceTypeC6idLarge),
+				})
+		}
+		return output, nil
+	}
+
+	err := checkInstanceStoreSupported(context.Background(), mock,
+		[]types.InstanceType{types.InstanceTypeC6idLarge})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = checkInstanceStoreSupported(context.Background(), mock,
+		[]types.InstanceType{types.InstanceTypeC6idLarge,
+			types.InstanceTypeC6iLarge})
+	if err == nil || !strings.Contains(err.Error(), "c6i.large") {
+		t.Errorf("expected error naming c6i.large but got %v", err)
+	}
+}
+
+func TestGetInstanceStoreUserData(t *testing.T) {
+	userData, err := getInstanceStoreUserData("/scratch", "")
+	if err != nil || !strings.HasPrefix(userData, "#!/bin/bash\n") ||
+		!strings.Contains(userData, "MNT='/scratch'\n") {
+		t.Errorf("unexpected user data %v", userData)
+	}
+
+	userData, err = getInstanceStoreUserData("/mnt/it's $(reboot)", "")
+	if err != nil || !strings.Contains(userData, `MNT='/mnt/it'\''s $(reboot)'`+"\n") {
+		t.Errorf("expected mount path to be shell quoted; got %v", userData)
+	}
+
+	userData, err = getInstanceStoreUserData("/scratch",
+		"#!/bin/bash\necho hello\n")
+	if err != nil || strings.Count(userData, "#!") != 1 ||
+		!strings.HasSuffix(userData, "done\necho hello\n") {
+		t.Errorf("expected initcmd to follow instance store setup; got %v",
+			userData)
+	}
+}
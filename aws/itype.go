@@ -0,0 +1,150 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// vcpu counts do not vary by region so once fetched they're cached for the
+// life of the process
+var vcpuCache = struct {
+	sync.Mutex
+	vcpus map[types.InstanceType]int32
+}{
+	vcpus: make(map[types.InstanceType]int32),
+}
+
+func LookupInstanceTypeVcpus(awsCfg aws.Config,
+	iTypes []types.InstanceType) (map[types.InstanceType]int32, error) {
+
+	vcpuCache.Lock()
+	defer vcpuCache.Unlock()
+
+	result := make(map[types.InstanceType]int32)
+	missing := make([]types.InstanceType, 0)
+	for _, iType := range iTypes {
+		vcpus, ok := vcpuCache.vcpus[iType]
+		if ok {
+			result[iType] = vcpus
+		} else {
+			missing = append(missing, iType)
+		}
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	ctx := context.Background()
+	if awsCfg.Region == "all" {
+		awsCfg = getGlobalQueryConfig(awsCfg)
+	}
+	ec2Client := newEc2Client(awsCfg)
+	descInput := &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: missing,
+	}
+	paginator := ec2.NewDescribeInstanceTypesPaginator(ec2Client, descInput)
+	for paginator.HasMorePages() {
+		descOutput, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to describe instance types %v: %w",
+				missing, err)
+		}
+		for _, iTypeInfo := range descOutput.InstanceTypes {
+			if iTypeInfo.VCpuInfo == nil ||
+				iTypeInfo.VCpuInfo.DefaultVCpus == nil {
+				continue
+			}
+			vcpus := *iTypeInfo.VCpuInfo.DefaultVCpus
+			vcpuCache.vcpus[iTypeInfo.InstanceType] = vcpus
+			result[iTypeInfo.InstanceType] = vcpus
+		}
+	}
+
+	return result, nil
+}
+
+// LookupUnofferedInstanceTypes returns those of iTypes which are not offered
+// in any of awsCfg's region(s) (every region when awsCfg.Region is "all");
+// typically these are misspelled instance types
+func LookupUnofferedInstanceTypes(awsCfg aws.Config,
+	iTypes []types.InstanceType) ([]types.InstanceType, error) {
+
+	var err error
+	var regionList []string
+	if awsCfg.Region == "all" {
+		regionList, err = getRegions(awsCfg)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		regionList = []string{awsCfg.Region}
+	}
+
+	iTypeStrs := make([]string, 0, len(iTypes))
+	for _, iType := range iTypes {
+		iTypeStrs = append(iTypeStrs, string(iType))
+	}
+
+	var offeredMutex sync.Mutex
+	offered := make(map[types.InstanceType]bool)
+	var wg errgroup.Group
+	for _, curReg := range regionList {
+		curReg := curReg // https://golang.org/doc/faq#closures_and_goroutines
+		wg.Go(func() error {
+			ctx := context.Background()
+			ec2Client := newEc2Client(getRegionConfig(awsCfg, curReg))
+			descInput := &ec2.DescribeInstanceTypeOfferingsInput{
+				LocationType: types.LocationTypeRegion,
+				Filters: []types.Filter{
+					{
+						Name:   aws.String("instance-type"),
+						Values: iTypeStrs,
+					},
+				},
+			}
+			paginator := ec2.NewDescribeInstanceTypeOfferingsPaginator(ec2Client,
+				descInput)
+			for paginator.HasMorePages() {
+				descOutput, err := paginator.NextPage(ctx)
+				if err != nil {
+					return fmt.Errorf("Failed to describe instance type offerings in %v: %w",
+						curReg, err)
+				}
+				offeredMutex.Lock()
+				for _, offering := range descOutput.InstanceTypeOfferings {
+					offered[offering.InstanceType] = true
+				}
+				offeredMutex.Unlock()
+			}
+
+			return nil
+		})
+	}
+	err = wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	unoffered := make([]types.InstanceType, 0)
+	for _, iType := range iTypes {
+		if !offered[iType] {
+			unoffered = append(unoffered, iType)
+		}
+	}
+	sort.Slice(unoffered, func(i, j int) bool {
+		return unoffered[i] < unoffered[j]
+	})
+
+	return unoffered, nil
+}
@@ -0,0 +1,63 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestLookupUnofferedInstanceTypes(t *testing.T) {
+	defer func() { DefaultRegions = nil }()
+	DefaultRegions = []string{"us-east-2", "us-west-2"}
+
+	var mutex sync.Mutex
+	numCalls := 0
+	mock := newMockEc2Client()
+	mock.iTypeOfferings = func(input *ec2.DescribeInstanceTypeOfferingsInput) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+		mutex.Lock()
+		numCalls++
+		// only 1 of the 2 regions offers c7g.large
+		offerC7g := numCalls == 1
+		mutex.Unlock()
+		if input.LocationType != types.LocationTypeRegion {
+			t.Errorf("expected region location type; got %v",
+				input.LocationType)
+		}
+		output := &ec2.DescribeInstanceTypeOfferingsOutput{}
+		for _, iType := range input.Filters[0].Values {
+			if iType == "c5.lrage" {
+				continue
+			}
+			if iType == "c7g.large" && !offerC7g {
+				continue
+			}
+			output.InstanceTypeOfferings = append(output.InstanceTypeOfferings,
+				types.InstanceTypeOffering{
+					InstanceType: types.InstanceType(iType),
+				})
+		}
+		return output, nil
+	}
+	useMockEc2Client(t, mock)
+
+	iTypes := []types.InstanceType{"c5.large", "c5.lrage", "c7g.large"}
+	unoffered, err := LookupUnofferedInstanceTypes(aws.Config{Region: "all"},
+		iTypes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(unoffered, []types.InstanceType{"c5.lrage"}) {
+		t.Errorf("expected only c5.lrage to be unoffered; got %v", unoffered)
+	}
+	if numCalls != 2 {
+		t.Errorf("expected each region to be checked; got %v calls", numCalls)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
@@ -45,7 +46,7 @@ func getSshRootDir() (string, error) {
 }
 
 func createDefaultKeyPair(ctx context.Context, awsCfg aws.Config,
-	ec2Client *ec2.Client) error {
+	ec2Client ec2Api) error {
 
 	sshRootDir, err := getSshRootDir()
 	if err != nil {
@@ -75,6 +76,10 @@ func createDefaultKeyPair(ctx context.Context, awsCfg aws.Config,
 		return err
 	}
 
+	// best effort; a stale index only means the key isn't found until the
+	// next sync
+	_ = addKeyIndexEntry(localKeyFile)
+
 	return nil
 }
 
@@ -122,7 +127,7 @@ func LookupKeys(awsCfg aws.Config) (LookupKeysResult, error) {
 		Keys: make(map[string]*LookupKeyItem),
 	}
 
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	ec2Client := newEc2Client(awsCfg)
 	dryRun := false
 	includePublic := true
 	descKeyInput := &ec2.DescribeKeyPairsInput{
@@ -135,6 +140,10 @@ func LookupKeys(awsCfg aws.Config) (LookupKeysResult, error) {
 	if err != nil {
 		return lookupKeysResult, err
 	}
+	keyIndex, err := loadKeyIndex()
+	if err != nil {
+		return lookupKeysResult, err
+	}
 	for _, keypair := range descKeyOutput.KeyPairs {
 		keyItem := &LookupKeyItem{
 			Id:          *keypair.KeyPairId,
@@ -142,7 +151,11 @@ func LookupKeys(awsCfg aws.Config) (LookupKeysResult, error) {
 			PublicKey:   *keypair.PublicKey,
 			Fingerprint: *keypair.KeyFingerprint,
 		}
-		keyItem.LocalKeyFile, err = findMatchingKeyFile(keyItem)
+		if keyIndex != nil {
+			keyItem.LocalKeyFile, err = lookupKeyIndex(keyIndex, keyItem)
+		} else {
+			keyItem.LocalKeyFile, err = findMatchingKeyFile(keyItem)
+		}
 
 		lookupKeysResult.Keys[keyItem.Id] = keyItem
 	}
@@ -225,3 +238,104 @@ func isKeypair(privKeyPemFile string, sshPubKey2Test ssh.PublicKey) (bool, error
 
 	return pubKey4PrivKey.Equal(pubKey2Test), nil
 }
+
+// DeleteKeyPair removes keyName from EC2 along with its local private key
+// file if one is present
+func DeleteKeyPair(awsCfg aws.Config, keyName string) error {
+	keysResult, err := LookupKeys(awsCfg)
+	if err != nil {
+		return err
+	}
+	var keyItem *LookupKeyItem
+	for _, tmpKeyItem := range keysResult.Keys {
+		if tmpKeyItem.Name == keyName {
+			keyItem = tmpKeyItem
+			break
+		}
+	}
+	if keyItem == nil {
+		return fmt.Errorf("Could not find keypair %v in %v", keyName,
+			awsCfg.Region)
+	}
+
+	ec2Client := newEc2Client(awsCfg)
+	dryRun := false
+	deleteKeyInput := &ec2.DeleteKeyPairInput{
+		DryRun:    &dryRun,
+		KeyPairId: &keyItem.Id,
+	}
+	_, err = ec2Client.DeleteKeyPair(context.Background(), deleteKeyInput)
+	if err != nil {
+		return err
+	}
+
+	if keyItem.LocalKeyFile != "" {
+		err = os.Remove(keyItem.LocalKeyFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Deleted keypair %v but failed to remove %v: %w",
+				keyName, keyItem.LocalKeyFile, err)
+		}
+	}
+
+	return nil
+}
+
+// RotateDefaultKeyPair replaces spotsh's default keypair with a newly
+// generated one & returns where the previous local key was backed up, if
+// any. Instances launched with the previous keypair continue to trust only
+// the previous key so it is kept rather than deleted. The backup is also
+// returned alongside an error when the previous keypair was deleted but its
+// replacement could not be created.
+func RotateDefaultKeyPair(awsCfg aws.Config) (string, error) {
+	ctx := context.Background()
+	haveDefaultKey, err := haveDefaultKeyPair(ctx, awsCfg)
+	if err != nil {
+		return "", err
+	}
+	localKeyFile, err := GetLocalDefaultKeyFile(awsCfg)
+	if err != nil {
+		return "", err
+	}
+
+	// move the previous local key aside before the new keypair is created;
+	// timestamped so that repeated rotations don't clobber older backups
+	localKeyFileBak := fmt.Sprintf("%v.%v.bak", localKeyFile,
+		time.Now().Unix())
+	if haveDefaultKey {
+		err = os.Rename(localKeyFile, localKeyFileBak)
+		if err != nil {
+			return "", fmt.Errorf("Could not backup %v: %w", localKeyFile, err)
+		}
+	}
+
+	ec2Client := newEc2Client(awsCfg)
+	keyName := GetDefaultKeyName(awsCfg)
+	dryRun := false
+	deleteKeyInput := &ec2.DeleteKeyPairInput{
+		DryRun:  &dryRun,
+		KeyName: &keyName,
+	}
+	_, err = ec2Client.DeleteKeyPair(ctx, deleteKeyInput)
+	if err != nil {
+		if haveDefaultKey {
+			_ = os.Rename(localKeyFileBak, localKeyFile)
+		}
+		return "", err
+	}
+	err = createDefaultKeyPair(ctx, awsCfg, ec2Client)
+	if err != nil {
+		// the previous keypair is already gone from EC2 so leave its local
+		// key backed up; the next launch or rotation then creates a new one
+		err = fmt.Errorf("Deleted keypair %v but failed to create its replacement; the next launch or rotation will create a new keypair: %w",
+			keyName, err)
+		if haveDefaultKey {
+			return localKeyFileBak, err
+		}
+		return "", err
+	}
+	if !haveDefaultKey {
+		return "", nil
+	}
+
+	return localKeyFileBak, nil
+}
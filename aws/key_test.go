@@ -6,9 +6,13 @@ package aws
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 )
@@ -58,3 +62,82 @@ func TestLookupKeys(t *testing.T) {
 		t.Errorf("Failed to find spotsh key")
 	}
 }
+
+func TestRotateDefaultKeyPairKeepsBackup(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	awsCfg := aws.Config{Region: "us-east-2"}
+	localKeyFile, err := GetLocalDefaultKeyFile(awsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Dir(localKeyFile), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(localKeyFile, []byte("old"), 0400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useMockEc2Client(t, &mockEc2Client{
+		deleteKeyPair: func(*ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+			return &ec2.DeleteKeyPairOutput{}, nil
+		},
+		createKeyPair: func(*ec2.CreateKeyPairInput) (*ec2.CreateKeyPairOutput, error) {
+			return &ec2.CreateKeyPairOutput{KeyMaterial: aws.String("new")}, nil
+		},
+	})
+
+	localKeyFileBak, err := RotateDefaultKeyPair(awsCfg)
+	if err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	bakData, err := os.ReadFile(localKeyFileBak)
+	if err != nil || string(bakData) != "old" {
+		t.Errorf("expected previous key in %v; got %q err:%v", localKeyFileBak,
+			bakData, err)
+	}
+	newData, err := os.ReadFile(localKeyFile)
+	if err != nil || string(newData) != "new" {
+		t.Errorf("expected new key in %v; got %q err:%v", localKeyFile, newData,
+			err)
+	}
+}
+
+func TestRotateDefaultKeyPairCreateFails(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	awsCfg := aws.Config{Region: "us-east-2"}
+	localKeyFile, err := GetLocalDefaultKeyFile(awsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Dir(localKeyFile), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(localKeyFile, []byte("old"), 0400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useMockEc2Client(t, &mockEc2Client{
+		deleteKeyPair: func(*ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+			return &ec2.DeleteKeyPairOutput{}, nil
+		},
+		createKeyPair: func(*ec2.CreateKeyPairInput) (*ec2.CreateKeyPairOutput, error) {
+			return nil, errors.New("throttled")
+		},
+	})
+
+	localKeyFileBak, err := RotateDefaultKeyPair(awsCfg)
+	if err == nil || !strings.Contains(err.Error(), "Deleted keypair") {
+		t.Fatalf("expected error explaining the deleted keypair; got %v", err)
+	}
+	bakData, err := os.ReadFile(localKeyFileBak)
+	if err != nil || string(bakData) != "old" {
+		t.Errorf("expected previous key in %v; got %q err:%v", localKeyFileBak,
+			bakData, err)
+	}
+	_, err = os.Stat(localKeyFile)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no local key w/o an EC2 keypair; got err:%v", err)
+	}
+}
@@ -0,0 +1,170 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyIndexPath, when set, is where SyncKeyIndex caches the local private key
+// file of each public key fingerprint. Once it exists LookupKeys consults it
+// rather than scanning every file in ~/.ssh.
+var KeyIndexPath string
+
+// KeySyncResult is the outcome of SyncKeyIndex
+type KeySyncResult struct {
+	Index   map[string]string // SHA256 public key fingerprint -> local private key file
+	Skipped map[string]error  // private key files which could not be parsed (e.g. w/ a passphrase)
+}
+
+// SyncKeyIndex rebuilds the index at KeyIndexPath from the private key files
+// in ~/.ssh
+func SyncKeyIndex() (KeySyncResult, error) {
+	syncResult := KeySyncResult{
+		Index:   make(map[string]string),
+		Skipped: make(map[string]error),
+	}
+	if KeyIndexPath == "" {
+		return syncResult, fmt.Errorf("No key index path is set")
+	}
+
+	sshRootDir, err := getSshRootDir()
+	if err != nil {
+		return syncResult, err
+	}
+	entries, err := ioutil.ReadDir(sshRootDir)
+	if err != nil {
+		return syncResult, err
+	}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		keyFile := filepath.Join(sshRootDir, entry.Name())
+		fingerprint, err := getKeyFileFingerprint(keyFile)
+		if err != nil {
+			syncResult.Skipped[keyFile] = err
+			continue
+		}
+		if fingerprint == "" {
+			continue
+		}
+		// entries are sorted so the first of any duplicates consistently wins
+		_, ok := syncResult.Index[fingerprint]
+		if !ok {
+			syncResult.Index[fingerprint] = keyFile
+		}
+	}
+
+	return syncResult, writeKeyIndex(syncResult.Index)
+}
+
+// getKeyFileFingerprint returns the public key fingerprint of the specified
+// private key file or an empty fingerprint if it isn't a private key at all
+func getKeyFileFingerprint(keyFile string) (string, error) {
+	keyData, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("Could not read %v: %w", keyFile, err)
+	}
+	if !bytes.Contains(keyData, []byte("PRIVATE KEY-----")) {
+		return "", nil
+	}
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse %v: %w", keyFile, err)
+	}
+
+	return ssh.FingerprintSHA256(signer.PublicKey()), nil
+}
+
+// loadKeyIndex returns the index at KeyIndexPath or nil if there is none
+func loadKeyIndex() (map[string]string, error) {
+	if KeyIndexPath == "" {
+		return nil, nil
+	}
+	indexData, err := ioutil.ReadFile(KeyIndexPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]string)
+	err = json.Unmarshal(indexData, &index)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse key index %v: %w",
+			KeyIndexPath, err)
+	}
+
+	return index, nil
+}
+
+func writeKeyIndex(index map[string]string) error {
+	indexData, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(KeyIndexPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(KeyIndexPath, indexData, 0600)
+}
+
+// addKeyIndexEntry records a newly created private key file in an existing
+// index so that it needn't be re-synced
+func addKeyIndexEntry(keyFile string) error {
+	index, err := loadKeyIndex()
+	if err != nil || index == nil {
+		return err
+	}
+	fingerprint, err := getKeyFileFingerprint(keyFile)
+	if err != nil || fingerprint == "" {
+		return err
+	}
+	index[fingerprint] = keyFile
+
+	return writeKeyIndex(index)
+}
+
+// lookupKeyIndex returns the indexed local private key file of keyItem after
+// verifying it still matches since the file may have since been replaced.
+// Keys missing from the index, e.g. those copied into ~/.ssh after the last
+// sync, fall back to a scan of ~/.ssh whose match is then added to the index.
+func lookupKeyIndex(index map[string]string,
+	keyItem *LookupKeyItem) (string, error) {
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(keyItem.PublicKey))
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse pub key for %v: %w",
+			keyItem.Name, err)
+	}
+	fingerprint := ssh.FingerprintSHA256(pubKey)
+	keyFile, ok := index[fingerprint]
+	if ok {
+		match, err := isKeypair(keyFile, pubKey)
+		if err == nil && match {
+			return keyFile, nil
+		}
+	}
+
+	keyFile, err = findMatchingKeyFile(keyItem)
+	if err != nil || keyFile == "" {
+		return "", err
+	}
+	index[fingerprint] = keyFile
+	// the index is only a cache so failing to update it is not fatal
+	_ = writeKeyIndex(index)
+
+	return keyFile, nil
+}
@@ -0,0 +1,187 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/mikeb26/spotsh"
+)
+
+// externalTemplateVersion is the version of a user specified launch
+// template which is launched from; spotsh's own template is always launched
+// from $Latest
+const externalTemplateVersion = "$Default"
+
+// checkExternalLaunchTemplateArgs verifies that none of launchArgs' options
+// which could only be honored by spotsh creating its own launch template
+// are combined w/ an existing LaunchTemplate
+func checkExternalLaunchTemplateArgs(launchArgs *LaunchEc2SpotArgs) error {
+	var conflicts []string
+	add := func(isSet bool, name string) {
+		if isSet {
+			conflicts = append(conflicts, name)
+		}
+	}
+	add(launchArgs.AmiId != "" || launchArgs.AmiName != "" ||
+		launchArgs.AmiNamePrefix != "", "ami")
+	add(launchArgs.Os != spotsh.OsNone, "os")
+	add(launchArgs.KeyPair != "", "key pair")
+	add(launchArgs.RootVolSizeInGiB != 0 || launchArgs.UseAmiRootVolSize,
+		"root volume size")
+	add(launchArgs.IdleCpuAlarmPct > 0, "idle cpu alarm")
+	add(launchArgs.AttachRoleName != "", "role")
+	add(launchArgs.InitCmd != "", "initcmd")
+	add(launchArgs.CloudConfig != "", "cloud config")
+	add(launchArgs.InstanceStoreMount != "", "instance store")
+	add(launchArgs.Enclave, "enclave")
+	add(launchArgs.Ipv6, "ipv6")
+	add(len(launchArgs.AuthorizedKeys) > 0, "authorized keys")
+	add(len(launchArgs.BlockDevices) > 0, "block devices")
+	add(launchArgs.Tenancy != "" && launchArgs.Tenancy != TenancyDefault,
+		"tenancy")
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Launch template %v may not be combined w/ %v; configure these in the launch template itself",
+			launchArgs.LaunchTemplate, strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// describeExternalLaunchTemplate returns the id & default version's data of
+// the existing launch template whose id or name is nameOrId
+func describeExternalLaunchTemplate(ctx context.Context, ec2Client ec2Api,
+	nameOrId string) (string, *types.ResponseLaunchTemplateData, error) {
+
+	descInput := &ec2.DescribeLaunchTemplateVersionsInput{
+		Versions: []string{externalTemplateVersion},
+	}
+	if strings.HasPrefix(nameOrId, "lt-") {
+		descInput.LaunchTemplateId = aws.String(nameOrId)
+	} else {
+		descInput.LaunchTemplateName = aws.String(nameOrId)
+	}
+	descOutput, err := ec2Client.DescribeLaunchTemplateVersions(ctx, descInput)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to describe launch template %v: %w",
+			nameOrId, err)
+	}
+	if len(descOutput.LaunchTemplateVersions) == 0 {
+		return "", nil, fmt.Errorf("Launch template %v not found", nameOrId)
+	}
+	version := &descOutput.LaunchTemplateVersions[0]
+	data := version.LaunchTemplateData
+	if data == nil {
+		data = &types.ResponseLaunchTemplateData{}
+	}
+
+	return aws.ToString(version.LaunchTemplateId), data, nil
+}
+
+// useExternalLaunchTemplate prepares to launch from the existing
+// launchArgs.LaunchTemplate rather than creating spotsh's own launch
+// template, returning the template's id. The template's settings are used
+// as is; only instance types & spot price are overridden by the fleet,
+// which also applies spotsh's instance tags (see getInstanceTags) so that
+// the instance can be managed by spotsh.
+func useExternalLaunchTemplate(ctx context.Context, awsCfg aws.Config,
+	ec2Client ec2Api, launchArgs *LaunchEc2SpotArgs,
+	launchResult *LaunchEc2SpotResult) (string, error) {
+
+	if launchArgs.TagPrefix == "" {
+		launchArgs.TagPrefix = DefaultTagPrefix
+	}
+	err := checkExternalLaunchTemplateArgs(launchArgs)
+	if err != nil {
+		return "", err
+	}
+	templateId, data, err := describeExternalLaunchTemplate(ctx, ec2Client,
+		launchArgs.LaunchTemplate)
+	if err != nil {
+		return "", err
+	}
+	if data.KeyName == nil {
+		return "", fmt.Errorf("Launch template %v specifies no keypair; spotsh requires one in order to ssh to the instance",
+			launchArgs.LaunchTemplate)
+	}
+
+	launchResult.LocalKeyFile, err = getLocalKeyFile(awsCfg, *data.KeyName)
+	if err != nil {
+		return "", err
+	}
+	if len(data.SecurityGroupIds) > 0 {
+		launchResult.SgId = data.SecurityGroupIds[0]
+	} else {
+		for _, netIf := range data.NetworkInterfaces {
+			if len(netIf.Groups) > 0 {
+				launchResult.SgId = netIf.Groups[0]
+				break
+			}
+		}
+	}
+
+	err = resolveExternalLaunchTemplateUser(awsCfg, data, launchArgs,
+		launchResult)
+	if err != nil {
+		return "", err
+	}
+
+	spotPrice := launchArgs.MaxSpotPrice
+	if spotPrice == "" {
+		spotPrice = DefaultMaxSpotPrice
+	}
+	launchResult.Tags = make(map[string]string)
+	for _, tag := range getInstanceTags(launchArgs, launchResult.User,
+		spotPrice) {
+		launchResult.Tags[*tag.Key] = *tag.Value
+	}
+	if len(launchArgs.InstanceTypes) == 0 {
+		// the fleet always overrides the instance type so absent any
+		// specified ones launch the template's own
+		if data.InstanceType != "" {
+			launchArgs.InstanceTypes = []types.InstanceType{data.InstanceType}
+		} else {
+			launchArgs.InstanceTypes = DefaultInstanceTypes
+		}
+	}
+
+	return templateId, nil
+}
+
+// resolveExternalLaunchTemplateUser determines the os & ssh user of the
+// instance from the launch template's image; when the image isn't the
+// latest of a known os the user must be specified via launchArgs.User
+func resolveExternalLaunchTemplateUser(awsCfg aws.Config,
+	data *types.ResponseLaunchTemplateData, launchArgs *LaunchEc2SpotArgs,
+	launchResult *LaunchEc2SpotResult) error {
+
+	amiOs := spotsh.OsNone
+	amiUser := ""
+	if data.ImageId != nil {
+		var err error
+		amiOs, amiUser, err = LookupAmiOs(awsCfg, *data.ImageId)
+		if err != nil {
+			return err
+		}
+	}
+	launchArgs.Os = amiOs
+	launchResult.Os = amiOs
+	launchResult.User = launchArgs.User
+	if launchResult.User == "" {
+		launchResult.User = amiUser
+	}
+	if launchResult.User == "" {
+		return fmt.Errorf("Unable to determine the ssh user of launch template %v's image; please specify the user",
+			launchArgs.LaunchTemplate)
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"github.com/mikeb26/spotsh"
+)
+
+func TestCheckExternalLaunchTemplateArgs(t *testing.T) {
+	launchArgs := &LaunchEc2SpotArgs{
+		LaunchTemplate: "org-lt",
+		User:           "ubuntu",
+		MaxSpotPrice:   "0.05",
+	}
+	err := checkExternalLaunchTemplateArgs(launchArgs)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	launchArgs.KeyPair = "mykey"
+	err = checkExternalLaunchTemplateArgs(launchArgs)
+	if err == nil {
+		t.Errorf("expected error w/ key pair")
+	}
+	launchArgs.KeyPair = ""
+	launchArgs.RootVolSizeInGiB = 128
+	err = checkExternalLaunchTemplateArgs(launchArgs)
+	if err == nil {
+		t.Errorf("expected error w/ root volume size")
+	}
+	launchArgs.RootVolSizeInGiB = 0
+	launchArgs.IdleCpuAlarmPct = 5
+	err = checkExternalLaunchTemplateArgs(launchArgs)
+	if err == nil {
+		t.Errorf("expected error w/ idle cpu alarm")
+	}
+	launchArgs.IdleCpuAlarmPct = 0
+
+	launchArgs.InitCmd = "echo hi"
+	launchArgs.Ipv6 = true
+	err = checkExternalLaunchTemplateArgs(launchArgs)
+	if err == nil {
+		t.Errorf("expected error w/ initcmd & ipv6")
+	}
+}
+
+func TestUseExternalLaunchTemplate(t *testing.T) {
+	mock := newMockEc2Client()
+	var descInput *ec2.DescribeLaunchTemplateVersionsInput
+	mock.ltVersions = func(input *ec2.DescribeLaunchTemplateVersionsInput) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+		descInput = input
+		return &ec2.DescribeLaunchTemplateVersionsOutput{
+			LaunchTemplateVersions: []types.LaunchTemplateVersion{
+				{
+					LaunchTemplateId: aws.String("lt-0123"),
+					LaunchTemplateData: &types.ResponseLaunchTemplateData{
+						ImageId:          aws.String("ami-ubuntu"),
+						KeyName:          aws.String("org-key"),
+						SecurityGroupIds: []string{"sg-0"},
+					},
+				},
+			},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+	useMockSsmClient(t, &mockSsmClient{
+		getParameter: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+			amiId := "ami-other"
+			if strings.Contains(*input.Name, "ubuntu/server/24.04") {
+				amiId = "ami-ubuntu"
+			}
+			return &ssm.GetParameterOutput{
+				Parameter: &ssmtypes.Parameter{Value: aws.String(amiId)},
+			}, nil
+		},
+	})
+
+	launchArgs := &LaunchEc2SpotArgs{
+		LaunchTemplate: "org-lt",
+		InstanceTypes:  []types.InstanceType{types.InstanceTypeC5Large},
+	}
+	var launchResult LaunchEc2SpotResult
+	templateId, err := useExternalLaunchTemplate(context.Background(),
+		aws.Config{Region: "us-east-2"}, mock, launchArgs, &launchResult)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if templateId != "lt-0123" {
+		t.Errorf("expected lt-0123 but got %v", templateId)
+	}
+	if aws.ToString(descInput.LaunchTemplateName) != "org-lt" ||
+		descInput.Versions[0] != externalTemplateVersion {
+		t.Errorf("expected default version of org-lt to be described; got %+v",
+			descInput)
+	}
+	if len(launchArgs.InstanceTypes) != 1 ||
+		launchArgs.InstanceTypes[0] != types.InstanceTypeC5Large {
+		t.Errorf("expected specified instance types to be kept; got %v",
+			launchArgs.InstanceTypes)
+	}
+	if launchResult.User != "ubuntu" || launchResult.Os != spotsh.Ubuntu24_04 ||
+		launchResult.SgId != "sg-0" {
+		t.Errorf("unexpected launch result %+v", launchResult)
+	}
+	if launchResult.Tags[DefaultTagPrefix+"."+UserTagSuffix] != "ubuntu" ||
+		launchResult.Tags[DefaultTagPrefix+"."+MaxPriceTagSuffix] != DefaultMaxSpotPrice {
+		t.Errorf("expected spotsh tags; got %v", launchResult.Tags)
+	}
+
+	configs := getLaunchTemplateConfigs(templateId, launchArgs, nil)
+	override := configs[0].Overrides[0]
+	if aws.ToString(configs[0].LaunchTemplateSpecification.Version) !=
+		externalTemplateVersion || aws.ToString(override.MaxPrice) !=
+		DefaultMaxSpotPrice {
+		t.Errorf("expected default version & max price override; got %+v",
+			configs[0])
+	}
+}
+
+func TestResolveExternalLaunchTemplateUser(t *testing.T) {
+	useMockSsmClient(t, &mockSsmClient{
+		getParameter: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+			return &ssm.GetParameterOutput{
+				Parameter: &ssmtypes.Parameter{Value: aws.String("ami-other")},
+			}, nil
+		},
+	})
+	data := &types.ResponseLaunchTemplateData{
+		ImageId: aws.String("ami-custom"),
+	}
+	launchArgs := &LaunchEc2SpotArgs{LaunchTemplate: "org-lt"}
+	var launchResult LaunchEc2SpotResult
+	err := resolveExternalLaunchTemplateUser(aws.Config{Region: "us-east-2"},
+		data, launchArgs, &launchResult)
+	if err == nil {
+		t.Errorf("expected error w/o a user for an unknown image")
+	}
+
+	launchArgs.User = "admin"
+	err = resolveExternalLaunchTemplateUser(aws.Config{Region: "us-east-2"},
+		data, launchArgs, &launchResult)
+	if err != nil || launchResult.User != "admin" ||
+		launchResult.Os != spotsh.OsNone {
+		t.Errorf("unexpected result %+v err:%v", launchResult, err)
+	}
+}
+
+func TestUseExternalLaunchTemplateInstanceType(t *testing.T) {
+	mock := newMockEc2Client()
+	var iType types.InstanceType
+	mock.ltVersions = func(input *ec2.DescribeLaunchTemplateVersionsInput) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+		return &ec2.DescribeLaunchTemplateVersionsOutput{
+			LaunchTemplateVersions: []types.LaunchTemplateVersion{
+				{
+					LaunchTemplateId: aws.String("lt-0123"),
+					LaunchTemplateData: &types.ResponseLaunchTemplateData{
+						InstanceType: iType,
+						KeyName:      aws.String("org-key"),
+					},
+				},
+			},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+
+	iType = types.InstanceTypeM7iLarge
+	launchArgs := &LaunchEc2SpotArgs{LaunchTemplate: "org-lt", User: "admin"}
+	var launchResult LaunchEc2SpotResult
+	_, err := useExternalLaunchTemplate(context.Background(),
+		aws.Config{Region: "us-east-2"}, mock, launchArgs, &launchResult)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(launchArgs.InstanceTypes) != 1 ||
+		launchArgs.InstanceTypes[0] != types.InstanceTypeM7iLarge {
+		t.Errorf("expected the template's instance type; got %v",
+			launchArgs.InstanceTypes)
+	}
+
+	iType = ""
+	launchArgs = &LaunchEc2SpotArgs{LaunchTemplate: "org-lt", User: "admin"}
+	_, err = useExternalLaunchTemplate(context.Background(),
+		aws.Config{Region: "us-east-2"}, mock, launchArgs, &launchResult)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(launchArgs.InstanceTypes, DefaultInstanceTypes) {
+		t.Errorf("expected default instance types; got %v",
+			launchArgs.InstanceTypes)
+	}
+}
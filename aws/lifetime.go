@@ -0,0 +1,33 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// lifetimeScriptFmt schedules a shutdown which, since spotsh launches w/
+// InstanceInitiatedShutdownBehavior terminate, terminates the instance
+const lifetimeScriptFmt = `#!/bin/bash
+# spotsh: terminate the instance once its lifetime elapses
+shutdown -h +%v
+`
+
+// getLifetimeUserData returns a user data script terminating the instance
+// at validUntil followed by initCmd which, when set, must itself be a shell
+// script
+func getLifetimeUserData(validUntil time.Time, now time.Time,
+	initCmd string) (string, error) {
+
+	lifetimeMins := int64(math.Ceil(validUntil.Sub(now).Minutes()))
+	if lifetimeMins < 1 {
+		return "", fmt.Errorf("ValidUntil %v is not in the future", validUntil)
+	}
+
+	return prependUserDataScript(fmt.Sprintf(lifetimeScriptFmt, lifetimeMins),
+		initCmd)
+}
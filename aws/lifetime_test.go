@@ -0,0 +1,27 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetLifetimeUserData(t *testing.T) {
+	now := time.Now()
+	userData, err := getLifetimeUserData(now.Add(90*time.Second), now,
+		"#!/bin/bash\necho hello\n")
+	if err != nil || strings.Count(userData, "#!") != 1 ||
+		!strings.Contains(userData, "shutdown -h +2\n") ||
+		!strings.HasSuffix(userData, "echo hello\n") {
+		t.Errorf("unexpected user data %v err:%v", userData, err)
+	}
+
+	_, err = getLifetimeUserData(now.Add(-time.Minute), now, "")
+	if err == nil {
+		t.Errorf("expected error w/ ValidUntil in the past")
+	}
+}
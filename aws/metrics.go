@@ -0,0 +1,106 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// InstanceMetric is a single CloudWatch metric's datapoints for an instance
+type InstanceMetric struct {
+	Name       string
+	Unit       string // e.g. Percent, Bytes
+	Timestamps []time.Time
+	Values     []float64 // oldest first
+}
+
+type instanceMetricDef struct {
+	name string
+	stat cwtypes.Statistic
+	unit string
+}
+
+// instanceMetricDefs are the AWS/EC2 metrics returned by
+// LookupInstanceMetrics; EBS* metrics cover nitro instances' EBS volumes
+var instanceMetricDefs = []instanceMetricDef{
+	{name: "CPUUtilization", stat: cwtypes.StatisticAverage, unit: "Percent"},
+	{name: "NetworkIn", stat: cwtypes.StatisticSum, unit: "Bytes"},
+	{name: "NetworkOut", stat: cwtypes.StatisticSum, unit: "Bytes"},
+	{name: "EBSReadBytes", stat: cwtypes.StatisticSum, unit: "Bytes"},
+	{name: "EBSWriteBytes", stat: cwtypes.StatisticSum, unit: "Bytes"},
+}
+
+// LookupInstanceMetrics returns instanceId's basic cpu, network, & disk
+// metrics over the since preceding now at a granularity of period. Instances
+// w/o detailed monitoring only publish datapoints every 5 minutes.
+func LookupInstanceMetrics(awsCfg aws.Config, instanceId string,
+	since time.Duration, period time.Duration) ([]InstanceMetric, error) {
+
+	if period < time.Minute {
+		return nil, fmt.Errorf("Metric period %v must be at least 1m", period)
+	}
+	cwClient := newCloudwatchClient(awsCfg)
+	endTime := time.Now()
+	metrics := make([]InstanceMetric, len(instanceMetricDefs))
+	queries := make([]cwtypes.MetricDataQuery, len(instanceMetricDefs))
+	for idx, def := range instanceMetricDefs {
+		metrics[idx] = InstanceMetric{Name: def.name, Unit: def.unit}
+		queries[idx] = cwtypes.MetricDataQuery{
+			Id: aws.String(fmt.Sprintf("m%v", idx)),
+			MetricStat: &cwtypes.MetricStat{
+				Metric: &cwtypes.Metric{
+					Namespace:  aws.String("AWS/EC2"),
+					MetricName: aws.String(def.name),
+					Dimensions: []cwtypes.Dimension{
+						{
+							Name:  aws.String("InstanceId"),
+							Value: aws.String(instanceId),
+						},
+					},
+				},
+				Period: aws.Int32(int32(period.Seconds())),
+				Stat:   aws.String(string(def.stat)),
+			},
+		}
+	}
+
+	metricInput := &cloudwatch.GetMetricDataInput{
+		StartTime:         aws.Time(endTime.Add(-since)),
+		EndTime:           aws.Time(endTime),
+		MetricDataQueries: queries,
+		ScanBy:            cwtypes.ScanByTimestampAscending,
+	}
+	for {
+		metricOutput, err := cwClient.GetMetricData(context.Background(),
+			metricInput)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get metrics of %v: %w",
+				instanceId, err)
+		}
+		for _, result := range metricOutput.MetricDataResults {
+			var idx int
+			_, err = fmt.Sscanf(aws.ToString(result.Id), "m%d", &idx)
+			if err != nil || idx < 0 || idx >= len(metrics) {
+				continue
+			}
+			metrics[idx].Timestamps = append(metrics[idx].Timestamps,
+				result.Timestamps...)
+			metrics[idx].Values = append(metrics[idx].Values,
+				result.Values...)
+		}
+		if metricOutput.NextToken == nil {
+			break
+		}
+		metricInput.NextToken = metricOutput.NextToken
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,58 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+func TestLookupInstanceMetrics(t *testing.T) {
+	calls := 0
+	useMockCloudwatchClient(t, &mockCloudwatchClient{
+		getMetricData: func(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+			calls++
+			if len(input.MetricDataQueries) != len(instanceMetricDefs) {
+				t.Errorf("expected %v queries but got %v",
+					len(instanceMetricDefs), len(input.MetricDataQueries))
+			}
+			output := &cloudwatch.GetMetricDataOutput{
+				MetricDataResults: []cwtypes.MetricDataResult{{
+					Id:         aws.String("m0"),
+					Timestamps: []time.Time{time.Now()},
+					Values:     []float64{float64(calls)},
+				}},
+			}
+			if input.NextToken == nil {
+				output.NextToken = aws.String("page2")
+			}
+			return output, nil
+		},
+	})
+
+	metrics, err := LookupInstanceMetrics(aws.Config{Region: "us-east-2"},
+		"i-0", time.Hour, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("failed to lookup metrics: %v", err)
+	}
+	if metrics[0].Name != "CPUUtilization" || len(metrics[0].Values) != 2 ||
+		metrics[0].Values[1] != 2 {
+		t.Errorf("expected paginated cpu datapoints but got %+v", metrics[0])
+	}
+	if len(metrics[1].Values) != 0 {
+		t.Errorf("expected no network datapoints but got %v",
+			metrics[1].Values)
+	}
+
+	_, err = LookupInstanceMetrics(aws.Config{Region: "us-east-2"}, "i-0",
+		time.Hour, time.Second)
+	if err == nil {
+		t.Errorf("expected error w/ sub-minute period")
+	}
+}
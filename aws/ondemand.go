@@ -0,0 +1,121 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	pricingTypes "github.com/aws/aws-sdk-go-v2/service/pricing/types"
+)
+
+// the AWS Price List API is only served from a handful of regions but
+// returns prices for all regions
+const pricingApiRegion = "us-east-1"
+
+// on-demand prices change infrequently so once fetched they're cached for
+// the life of the process
+var onDemandPriceCache = struct {
+	sync.Mutex
+	prices map[string]float64 // keyed by <region>/<instance type>
+}{
+	prices: make(map[string]float64),
+}
+
+// LookupOnDemandPrice returns the hourly USD on-demand price of a Linux
+// instance of type iType in awsCfg's region
+func LookupOnDemandPrice(awsCfg aws.Config,
+	iType types.InstanceType) (float64, error) {
+
+	cacheKey := awsCfg.Region + "/" + string(iType)
+	onDemandPriceCache.Lock()
+	defer onDemandPriceCache.Unlock()
+	price, ok := onDemandPriceCache.prices[cacheKey]
+	if ok {
+		return price, nil
+	}
+
+	pricingClient := newPricingClient(awsCfg)
+	getInput := &pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []pricingTypes.Filter{
+			newPricingFilter("instanceType", string(iType)),
+			newPricingFilter("regionCode", awsCfg.Region),
+			newPricingFilter("operatingSystem", "Linux"),
+			newPricingFilter("tenancy", "Shared"),
+			newPricingFilter("preInstalledSw", "NA"),
+			newPricingFilter("capacitystatus", "Used"),
+		},
+		MaxResults: aws.Int32(10),
+	}
+	getOutput, err := pricingClient.GetProducts(context.Background(), getInput)
+	if err != nil {
+		return 0.0, fmt.Errorf("Failed to get on-demand price of %v in %v: %w",
+			iType, awsCfg.Region, err)
+	}
+
+	for _, priceDoc := range getOutput.PriceList {
+		price, err = parseOnDemandPrice(priceDoc)
+		if err != nil {
+			return 0.0, fmt.Errorf("Failed to parse on-demand price of %v in %v: %w",
+				iType, awsCfg.Region, err)
+		}
+		if price > 0 {
+			onDemandPriceCache.prices[cacheKey] = price
+			return price, nil
+		}
+	}
+
+	return 0.0, fmt.Errorf("Could not find on-demand price of %v in %v", iType,
+		awsCfg.Region)
+}
+
+func newPricingFilter(field string, value string) pricingTypes.Filter {
+	return pricingTypes.Filter{
+		Field: aws.String(field),
+		Type:  pricingTypes.FilterTypeTermMatch,
+		Value: aws.String(value),
+	}
+}
+
+// parseOnDemandPrice extracts the hourly USD price from a single Price List
+// API product document; 0 is returned if the document has no such price
+func parseOnDemandPrice(priceDoc string) (float64, error) {
+	var product struct {
+		Terms struct {
+			OnDemand map[string]struct {
+				PriceDimensions map[string]struct {
+					Unit         string            `json:"unit"`
+					PricePerUnit map[string]string `json:"pricePerUnit"`
+				} `json:"priceDimensions"`
+			} `json:"OnDemand"`
+		} `json:"terms"`
+	}
+	err := json.Unmarshal([]byte(priceDoc), &product)
+	if err != nil {
+		return 0.0, err
+	}
+
+	for _, term := range product.Terms.OnDemand {
+		for _, dimension := range term.PriceDimensions {
+			if dimension.Unit != "Hrs" {
+				continue
+			}
+			usd, ok := dimension.PricePerUnit["USD"]
+			if !ok {
+				continue
+			}
+			return strconv.ParseFloat(usd, 64)
+		}
+	}
+
+	return 0.0, nil
+}
@@ -0,0 +1,80 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+)
+
+// testOnDemandPriceDoc is an abbreviated Price List API product document
+const testOnDemandPriceDoc = `{
+  "product": {"attributes": {"instanceType": "c5.large", "regionCode": "us-east-2"}},
+  "terms": {
+    "OnDemand": {
+      "ABC.JRTCKXETXF": {
+        "priceDimensions": {
+          "ABC.JRTCKXETXF.6YS6EN2CT7": {
+            "unit": "Hrs",
+            "pricePerUnit": {"USD": "0.0850000000"}
+          }
+        }
+      }
+    }
+  }
+}`
+
+func TestParseOnDemandPrice(t *testing.T) {
+	price, err := parseOnDemandPrice(testOnDemandPriceDoc)
+	if err != nil || price != 0.085 {
+		t.Errorf("expected 0.085 but got %v err:%v", price, err)
+	}
+
+	price, err = parseOnDemandPrice(`{"terms": {}}`)
+	if err != nil || price != 0 {
+		t.Errorf("expected no price but got %v err:%v", price, err)
+	}
+
+	_, err = parseOnDemandPrice(`not json`)
+	if err == nil {
+		t.Errorf("expected error parsing malformed document")
+	}
+}
+
+func TestGetMaxSpotPricesFromPct(t *testing.T) {
+	useMockPricingClient(t, &mockPricingClient{
+		getProducts: func(*pricing.GetProductsInput) (*pricing.GetProductsOutput, error) {
+			return &pricing.GetProductsOutput{
+				PriceList: []string{testOnDemandPriceDoc},
+			}, nil
+		},
+	})
+
+	launchArgs := &LaunchEc2SpotArgs{
+		InstanceTypes:   []types.InstanceType{"m9.test"},
+		MaxSpotPricePct: 50,
+	}
+	awsCfg := aws.Config{Region: "us-test-1"}
+	maxPrices, err := getMaxSpotPricesFromPct(awsCfg, launchArgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxPrices["m9.test"] != "0.04250" {
+		t.Errorf("expected 50%% of 0.085 but got %v", maxPrices["m9.test"])
+	}
+	if launchArgs.MaxSpotPrice != "0.04250" {
+		t.Errorf("expected MaxSpotPrice raised to 0.04250 but got %v",
+			launchArgs.MaxSpotPrice)
+	}
+
+	launchArgs.MaxSpotPricePct = 150
+	_, err = getMaxSpotPricesFromPct(awsCfg, launchArgs)
+	if err == nil {
+		t.Errorf("expected error for pct > 100")
+	}
+}
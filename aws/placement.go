@@ -0,0 +1,119 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"golang.org/x/sync/errgroup"
+)
+
+// LookupSpotPlacementScores annotates each az in result w/ EC2's spot
+// placement score (1-10) indicating how likely a request for a single
+// instance of that type is to be fulfilled right now. EC2 only scores the
+// top 10 azs per instance type; azs outside of those are left at 0.
+func LookupSpotPlacementScores(awsCfg aws.Config,
+	result *LookupEc2SpotPriceResult) error {
+
+	ctx := context.Background()
+	if awsCfg.Region == "all" {
+		awsCfg = getGlobalQueryConfig(awsCfg)
+	}
+
+	regionSet := make(map[string]struct{})
+	for _, lookupIType := range result.InstanceTypes {
+		for region := range lookupIType.Regions {
+			regionSet[region] = struct{}{}
+		}
+	}
+
+	// placement scores identify azs by id (e.g. use2-az1) while spot prices
+	// identify them by name (e.g. us-east-2a)
+	azIdToName := make(map[string]string)
+	var wg errgroup.Group
+	for region := range regionSet {
+		region := region // https://golang.org/doc/faq#closures_and_goroutines
+		wg.Go(func() error {
+			azIds, err := lookupAzIds(ctx, getRegionConfig(awsCfg, region))
+			if err != nil {
+				return err
+			}
+
+			result.mutex.Lock()
+			defer result.mutex.Unlock()
+			for azId, azName := range azIds {
+				azIdToName[azId] = azName
+			}
+			return nil
+		})
+	}
+	err := wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	regionNames := make([]string, 0, len(regionSet))
+	for region := range regionSet {
+		regionNames = append(regionNames, region)
+	}
+	ec2Client := newEc2Client(awsCfg)
+	for _, lookupIType := range result.InstanceTypes {
+		scoreInput := &ec2.GetSpotPlacementScoresInput{
+			TargetCapacity:         aws.Int32(1),
+			InstanceTypes:          []string{string(lookupIType.InstanceType)},
+			RegionNames:            regionNames,
+			SingleAvailabilityZone: aws.Bool(true),
+		}
+		scoreOutput, err := ec2Client.GetSpotPlacementScores(ctx, scoreInput)
+		if err != nil {
+			return fmt.Errorf("Failed to get spot placement scores for %v: %w",
+				lookupIType.InstanceType, err)
+		}
+
+		for _, score := range scoreOutput.SpotPlacementScores {
+			if score.Region == nil || score.AvailabilityZoneId == nil ||
+				score.Score == nil {
+				continue
+			}
+			lookupReg, ok := lookupIType.Regions[*score.Region]
+			if !ok {
+				continue
+			}
+			lookupAz, ok := lookupReg.Azs[azIdToName[*score.AvailabilityZoneId]]
+			if !ok {
+				continue
+			}
+			lookupAz.PlacementScore = *score.Score
+		}
+	}
+
+	return nil
+}
+
+func lookupAzIds(ctx context.Context,
+	awsCfg aws.Config) (map[string]string, error) {
+
+	region := awsCfg.Region
+	ec2Client := newEc2Client(awsCfg)
+	descOutput, err := ec2Client.DescribeAvailabilityZones(ctx,
+		&ec2.DescribeAvailabilityZonesInput{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe availability zones in %v: %w",
+			region, err)
+	}
+
+	azIds := make(map[string]string)
+	for _, az := range descOutput.AvailabilityZones {
+		if az.ZoneId == nil || az.ZoneName == nil {
+			continue
+		}
+		azIds[*az.ZoneId] = *az.ZoneName
+	}
+
+	return azIds, nil
+}
@@ -0,0 +1,70 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestLookupSpotPlacementScores(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.describeAzs = func(*ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+		return &ec2.DescribeAvailabilityZonesOutput{
+			AvailabilityZones: []types.AvailabilityZone{
+				{ZoneId: aws.String("use2-az1"), ZoneName: aws.String("us-east-2a")},
+				{ZoneId: aws.String("use2-az2"), ZoneName: aws.String("us-east-2b")},
+			},
+		}, nil
+	}
+	mock.placementScores = func(params *ec2.GetSpotPlacementScoresInput) (*ec2.GetSpotPlacementScoresOutput, error) {
+		if len(params.InstanceTypes) != 1 ||
+			params.InstanceTypes[0] != string(types.InstanceTypeC5Large) {
+			t.Errorf("unexpected instance types %v", params.InstanceTypes)
+		}
+		return &ec2.GetSpotPlacementScoresOutput{
+			SpotPlacementScores: []types.SpotPlacementScore{
+				{AvailabilityZoneId: aws.String("use2-az2"),
+					Region: aws.String("us-east-2"), Score: aws.Int32(9)},
+				{AvailabilityZoneId: aws.String("use1-az1"),
+					Region: aws.String("us-east-1"), Score: aws.Int32(7)},
+			},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+
+	azA := &LookupEc2SpotPriceAz{AzName: "us-east-2a", CurPrice: 0.03}
+	azB := &LookupEc2SpotPriceAz{AzName: "us-east-2b", CurPrice: 0.04}
+	result := &LookupEc2SpotPriceResult{
+		InstanceTypes: map[types.InstanceType]*LookupEc2SpotPriceIType{
+			types.InstanceTypeC5Large: {
+				InstanceType: types.InstanceTypeC5Large,
+				Regions: map[string]*LookupEc2SpotPriceRegion{
+					"us-east-2": {
+						Region: "us-east-2",
+						Azs: map[string]*LookupEc2SpotPriceAz{
+							azA.AzName: azA,
+							azB.AzName: azB,
+						},
+					},
+				},
+			},
+		},
+		mutex: &sync.Mutex{},
+	}
+
+	err := LookupSpotPlacementScores(aws.Config{Region: "us-east-2"}, result)
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if azA.PlacementScore != 0 || azB.PlacementScore != 9 {
+		t.Errorf("unexpected scores %v/%v", azA.PlacementScore,
+			azB.PlacementScore)
+	}
+}
@@ -0,0 +1,41 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"sync"
+	"time"
+)
+
+// RegionProgress, when set, is invoked each time a region of a multi-region
+// lookup (i.e. w/ region "all") completes w/ the number of regions completed
+// so far, the total number of regions, & the time elapsed since the lookup
+// began. Invocations are serialized.
+var RegionProgress func(done int, total int, elapsed time.Duration)
+
+type regionTracker struct {
+	mutex sync.Mutex
+	start time.Time
+	done  int
+	total int
+}
+
+func newRegionTracker(total int) *regionTracker {
+	return &regionTracker{
+		start: time.Now(),
+		total: total,
+	}
+}
+
+func (rt *regionTracker) regionDone() {
+	if RegionProgress == nil || rt.total <= 1 {
+		return
+	}
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.done++
+	RegionProgress(rt.done, rt.total, time.Since(rt.start))
+}
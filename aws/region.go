@@ -0,0 +1,50 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type InitRegionResult struct {
+	KeyName         string
+	KeyCreated      bool
+	SecurityGroupId string
+}
+
+// InitRegion performs the per-region setup which LaunchEc2Spot otherwise
+// does inline on the first launch in a region: it creates spotsh's default
+// keypair if it does not yet exist locally & validates that the default
+// VPC's default security group can be found.
+func InitRegion(awsCfg aws.Config) (InitRegionResult, error) {
+	ctx := context.Background()
+	result := InitRegionResult{
+		KeyName: GetDefaultKeyName(awsCfg),
+	}
+
+	haveDefaultKey, err := haveDefaultKeyPair(ctx, awsCfg)
+	if err != nil {
+		return result, err
+	}
+	if !haveDefaultKey {
+		err = createDefaultKeyPair(ctx, awsCfg, newEc2Client(awsCfg))
+		if err != nil {
+			return result, fmt.Errorf("Failed to create keypair %v: %w",
+				result.KeyName, err)
+		}
+		result.KeyCreated = true
+	}
+
+	result.SecurityGroupId, err = GetDefaultSecurityGroupId(awsCfg)
+	if err != nil {
+		return result, fmt.Errorf("Failed to find default security group: %w",
+			err)
+	}
+
+	return result, nil
+}
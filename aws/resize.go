@@ -0,0 +1,150 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const resizeWaitTimeout = 10 * time.Minute
+
+// ResizeInstanceType stops the specified instance, changes its instance type
+// to newType, & starts it again. The root volume & its data are retained
+// though the instance's public ip typically changes. Only on-demand
+// instances may be resized since EC2 does not allow one-time spot instances
+// to be stopped.
+func ResizeInstanceType(awsCfg aws.Config, instanceId string,
+	newType types.InstanceType) error {
+
+	ctx := context.Background()
+	ec2Client := newEc2Client(awsCfg)
+
+	describeInput := &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceId},
+	}
+	descOutput, err := ec2Client.DescribeInstances(ctx, describeInput)
+	if err != nil {
+		return fmt.Errorf("Failed to describe instance %v: %w", instanceId, err)
+	}
+	if len(descOutput.Reservations) != 1 ||
+		len(descOutput.Reservations[0].Instances) != 1 {
+		return fmt.Errorf("%w: %v", ErrInstanceNotFound, instanceId)
+	}
+	inst := &descOutput.Reservations[0].Instances[0]
+	if inst.InstanceLifecycle == types.InstanceLifecycleTypeSpot {
+		return fmt.Errorf("Instance %v is a spot instance which cannot be stopped; only instances launched w/ --market %v may be resized",
+			instanceId, MarketOnDemand)
+	}
+	if inst.InstanceType == newType {
+		return fmt.Errorf("Instance %v is already of type %v", instanceId,
+			newType)
+	}
+	err = checkInstanceTypeCompatible(ctx, ec2Client, inst, newType)
+	if err != nil {
+		return err
+	}
+
+	_, err = ec2Client.StopInstances(ctx, &ec2.StopInstancesInput{
+		InstanceIds: []string{instanceId},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to stop instance %v: %w", instanceId, err)
+	}
+	stoppedWaiter := ec2.NewInstanceStoppedWaiter(ec2Client)
+	err = stoppedWaiter.Wait(ctx, describeInput, resizeWaitTimeout)
+	if err != nil {
+		return fmt.Errorf("Failed waiting for instance %v to stop: %w",
+			instanceId, err)
+	}
+
+	modifyInput := &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(instanceId),
+		InstanceType: &types.AttributeValue{
+			Value: aws.String(string(newType)),
+		},
+	}
+	_, modifyErr := ec2Client.ModifyInstanceAttribute(ctx, modifyInput)
+	if modifyErr != nil {
+		modifyErr = fmt.Errorf("Failed to change instance %v's type to %v: %w",
+			instanceId, newType, modifyErr)
+		// fallthrough in order to restart the instance w/ its original type
+		// rather than leaving it stopped
+	}
+
+	_, err = ec2Client.StartInstances(ctx, &ec2.StartInstancesInput{
+		InstanceIds: []string{instanceId},
+	})
+	if err != nil {
+		if modifyErr != nil {
+			return fmt.Errorf("%w; also failed to restart instance: %v",
+				modifyErr, err)
+		}
+		return fmt.Errorf("Failed to start instance %v: %w", instanceId, err)
+	}
+	if modifyErr != nil {
+		return modifyErr
+	}
+	runningWaiter := ec2.NewInstanceRunningWaiter(ec2Client)
+	err = runningWaiter.Wait(ctx, describeInput, resizeWaitTimeout)
+	if err != nil {
+		return fmt.Errorf("Failed waiting for instance %v to start: %w",
+			instanceId, err)
+	}
+
+	return nil
+}
+
+// checkInstanceTypeCompatible verifies that newType supports inst's current
+// architecture & virtualization type so that its root volume can boot
+func checkInstanceTypeCompatible(ctx context.Context, ec2Client ec2Api,
+	inst *types.Instance, newType types.InstanceType) error {
+
+	descInput := &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{newType},
+	}
+	descOutput, err := ec2Client.DescribeInstanceTypes(ctx, descInput)
+	if err != nil {
+		return fmt.Errorf("Failed to describe instance type %v: %w", newType,
+			err)
+	}
+	if len(descOutput.InstanceTypes) != 1 {
+		return fmt.Errorf("Unknown instance type %v", newType)
+	}
+	iTypeInfo := &descOutput.InstanceTypes[0]
+
+	archOk := false
+	if iTypeInfo.ProcessorInfo != nil {
+		for _, arch := range iTypeInfo.ProcessorInfo.SupportedArchitectures {
+			if string(arch) == string(inst.Architecture) {
+				archOk = true
+				break
+			}
+		}
+	}
+	if !archOk {
+		return fmt.Errorf("Instance type %v does not support instance %v's %v architecture",
+			newType, *inst.InstanceId, inst.Architecture)
+	}
+
+	virtOk := false
+	for _, virt := range iTypeInfo.SupportedVirtualizationTypes {
+		if string(virt) == string(inst.VirtualizationType) {
+			virtOk = true
+			break
+		}
+	}
+	if !virtOk {
+		return fmt.Errorf("Instance type %v does not support instance %v's %v virtualization",
+			newType, *inst.InstanceId, inst.VirtualizationType)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestResizeInstanceTypeSpot(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.describeInstances = func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+		return &ec2.DescribeInstancesOutput{
+			Reservations: []types.Reservation{{
+				Instances: []types.Instance{{
+					InstanceId:        aws.String("i-0"),
+					InstanceType:      types.InstanceTypeC7iLarge,
+					InstanceLifecycle: types.InstanceLifecycleTypeSpot,
+				}},
+			}},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+
+	err := ResizeInstanceType(aws.Config{Region: "us-east-2"}, "i-0",
+		types.InstanceTypeC7iXlarge)
+	if err == nil || !strings.Contains(err.Error(), "cannot be stopped") {
+		t.Errorf("expected spot instance resize to be refused; got %v", err)
+	}
+}
+
+func TestCheckInstanceTypeCompatible(t *testing.T) {
+	mock := newMockEc2Client()
+	mock.describeITypes = func(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+		arch := types.ArchitectureTypeX8664
+		if input.InstanceTypes[0] == types.InstanceTypeC7gXlarge {
+			arch = types.ArchitectureTypeArm64
+		}
+		return &ec2.DescribeInstanceTypesOutput{
+			InstanceTypes: []types.InstanceTypeInfo{{
+				InstanceType: input.InstanceTypes[0],
+				ProcessorInfo: &types.ProcessorInfo{
+					SupportedArchitectures: []types.ArchitectureType{arch},
+				},
+				SupportedVirtualizationTypes: []types.VirtualizationType{
+					types.VirtualizationTypeHvm,
+				},
+			}},
+		}, nil
+	}
+
+	inst := &types.Instance{
+		InstanceId:         aws.String("i-0"),
+		Architecture:       types.ArchitectureValuesX8664,
+		VirtualizationType: types.VirtualizationTypeHvm,
+	}
+	ctx := context.Background()
+	err := checkInstanceTypeCompatible(ctx, mock, inst,
+		types.InstanceTypeC7iXlarge)
+	if err != nil {
+		t.Errorf("expected c7i.xlarge to be compatible; got %v", err)
+	}
+	err = checkInstanceTypeCompatible(ctx, mock, inst,
+		types.InstanceTypeC7gXlarge)
+	if err == nil || !strings.Contains(err.Error(), "architecture") {
+		t.Errorf("expected c7g.xlarge to be incompatible; got %v", err)
+	}
+}
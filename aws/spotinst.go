@@ -7,16 +7,19 @@ package aws
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 
 	"github.com/mikeb26/spotsh"
 )
@@ -26,10 +29,18 @@ const (
 	UserTagSuffix           = "user"
 	OsTagSuffix             = "os"
 	VpnTagSuffix            = "vpn"
+	MaxPriceTagSuffix       = "maxprice"
+	EipTagSuffix            = "eip"
+	VersionTagSuffix        = "version"
 	DefaultRootVolSizeInGiB = int32(64)
 	DefaultMaxSpotPrice     = "0.08"
 )
 
+// LaunchVersion, when set, is recorded in the VersionTagSuffix tag of each
+// launched instance so that instances launched by differing versions of
+// spotsh can be told apart
+var LaunchVersion string
+
 var DefaultInstanceTypes = []types.InstanceType{
 	types.InstanceTypeC5Large,
 	types.InstanceTypeC5aLarge,
@@ -42,33 +53,101 @@ var DefaultInstanceTypes = []types.InstanceType{
 
 const DefaultOperatingSystem = spotsh.AmazonLinux2023
 
+// purchasing options for LaunchEc2SpotArgs.Market & values of
+// LaunchEc2SpotResult.Lifecycle
+const (
+	MarketSpot     = "spot"
+	MarketOnDemand = "on-demand"
+)
+
+// tenancy options for LaunchEc2SpotArgs.Tenancy; EC2 only supports the
+// default (shared) tenancy for spot instances
+const (
+	TenancyDefault   = "default"
+	TenancyDedicated = "dedicated"
+	TenancyHost      = "host"
+)
+
+// HealthUnknown is reported by LookupInstanceHealth for instances which EC2
+// has not yet status checked
+const HealthUnknown = "unknown"
+
 type LaunchEc2SpotArgs struct {
-	Os               spotsh.OperatingSystem // optional; defaults to AmazonLinux2023
-	AmiId            string                 // optional; overrides Os; defaults to latest ami for specified Os
-	AmiName          string                 // optional; default is ignored in lieu of AmiId
-	KeyPair          string                 // optional; defaults to spotinst keypair
-	SecurityGroupId  string                 // optional; defaults to default VPC's default SG
-	AttachRoleName   string                 // optional; defaults to no attached role
-	InitCmd          string                 // optional; defaults to empty
-	InstanceTypes    []types.InstanceType   // optional; defaults to c5a.large
-	MaxSpotPrice     string                 // optional; defaults to "0.08" (USD$/hour)
-	User             string                 // optional; defaults to Os's default user
-	RootVolSizeInGiB int32                  // optional; defaults to 64GiB
-	TagPrefix        string                 // optional; defaults to 'spotsh'
+	Os                     spotsh.OperatingSystem         // optional; defaults to AmazonLinux2023
+	AmiId                  string                         // optional; overrides Os; defaults to latest ami for specified Os
+	AmiName                string                         // optional; default is ignored in lieu of AmiId
+	AmiNamePrefix          string                         // optional; prefix or glob matched against self-owned AMI names
+	AmiLatest              bool                           // optional; selects the newest AmiNamePrefix match rather than failing when ambiguous
+	KeyPair                string                         // optional; defaults to spotinst keypair
+	SecurityGroupId        string                         // optional; defaults to default VPC's default SG
+	AttachRoleName         string                         // optional; defaults to no attached role
+	InitCmd                string                         // optional; defaults to empty
+	InstanceTypes          []types.InstanceType           // optional; defaults to c5a.large
+	InstanceTypePriorities map[types.InstanceType]float64 // optional; lower values preferred; defaults to none (price-capacity-optimized)
+	MaxSpotPrice           string                         // optional; defaults to "0.08" (USD$/hour)
+	User                   string                         // optional; defaults to Os's default user
+	RootVolSizeInGiB       int32                          // optional; defaults to 64GiB or the AMI's root snapshot size if larger
+	UseAmiRootVolSize      bool                           // optional; overrides RootVolSizeInGiB w/ the AMI's own root volume size; defaults to false
+	TagPrefix              string                         // optional; defaults to 'spotsh'
+	IdleCpuAlarmPct        float64                        // optional; defaults to 0 (no idle alarm)
+	IdleCpuAlarmMinutes    int32                          // optional; defaults to 30 minutes
+	Tags                   map[string]string              // optional; additional non-reserved tags to apply to the instance
+	ValidUntil             time.Time                      // optional; time after which AWS stops fulfilling a scheduled fleet request or, w/o ValidFrom, at which the instance terminates itself; defaults to unbounded
+	Market                 string                         // optional; MarketSpot or MarketOnDemand; defaults to MarketSpot
+	MaxSpotPricePct        float64                        // optional; caps each type's spot price at this % of its on-demand price; defaults to 0 (MaxSpotPrice only)
+	Ipv6                   bool                           // optional; assigns a public IPv6 address; requires an IPv6-enabled subnet; defaults to false
+	NoDefaultKey           bool                           // optional; fail rather than create spotsh's default keypair when KeyPair is unset; defaults to false
+	InstanceStoreMount     string                         // optional; formats & mounts local NVMe instance store at this path; InitCmd must then be a shell script; defaults to none
+	AvailabilityZone       string                         // optional; restricts the launch to this az; defaults to any az in the region
+	Tenancy                string                         // optional; TenancyDefault, TenancyDedicated, or TenancyHost; non-default tenancy requires MarketOnDemand; defaults to TenancyDefault
+	Enclave                bool                           // optional; enables Nitro Enclaves & sets up the enclave allocator; InitCmd must then be a shell script; defaults to false
+	AssociateEip           bool                           // optional; associates a (reused or newly allocated) Elastic IP so the public ip persists; defaults to false
+	SpotInstancePools      int32                          // optional; selects the lowest-price allocation strategy across this many of the cheapest pools which, when > 1, may span azs; incompatible w/ InstanceTypePriorities & MarketOnDemand; defaults to 0 (price-capacity-optimized)
+	AuthorizedKeys         []string                       // optional; additional ssh public keys authorized for User; InitCmd must then be a shell script; defaults to none
+	LaunchTemplate         string                         // optional; id or name of an existing launch template to launch from rather than creating spotsh's own; only instance types & spot price are overridden; defaults to none
+	CloudConfig            string                         // optional; #cloud-config yaml combined w/ InitCmd into MIME multipart user data; defaults to none
+	BlockDevices           []BlockDevice                  // optional; additional EBS volumes deleted on termination; defaults to none
+	NoWait                 bool                           // optional; return once the fleet is created w/o waiting for a public ip; incompatible w/ AssociateEip; defaults to false
+	ValidFrom              time.Time                      // optional; schedules the launch for this time; only the fleet is created & its id returned; incompatible w/ AssociateEip, IdleCpuAlarmPct, NoWait, & LaunchTemplate; defaults to immediately
+	ClientToken            string                         // optional; idempotency token for the fleet request so retries can't launch duplicates; defaults to a newly generated launch id
 }
 
 type LaunchEc2SpotResult struct {
-	PublicIp     string
-	InstanceId   string
-	User         string
-	LocalKeyFile string
-	InstanceType types.InstanceType
-	ImageId      string
-	CurrentPrice float64
-	AzName       string
-	DnsName      string
-	Os           spotsh.OperatingSystem
-	SgId         string
+	PublicIp       string
+	PrivateIp      string
+	InstanceId     string
+	User           string
+	LocalKeyFile   string
+	InstanceType   types.InstanceType
+	ImageId        string
+	CurrentPrice   float64 // USD$/hour current market price of InstanceType in AzName; not necessarily the price paid (see LookupAccruedCost)
+	AzName         string
+	DnsName        string
+	Os             spotsh.OperatingSystem
+	SgId           string
+	Tags           map[string]string
+	Region         string
+	LaunchTime     time.Time
+	Lifecycle      string // MarketSpot or MarketOnDemand
+	InstanceStatus string // only set by LookupInstanceHealth; e.g. ok, impaired
+	SystemStatus   string // only set by LookupInstanceHealth; e.g. ok, impaired
+	Ipv6           string // empty unless launched w/ LaunchEc2SpotArgs.Ipv6
+	MaxSpotPrice   string // USD$/hour; empty for on-demand instances & those launched before spotsh recorded it
+	SpotshVersion  string // version of spotsh which launched the instance; empty for those launched before spotsh recorded it
+	FleetId        string // only set for launches scheduled via LaunchEc2SpotArgs.ValidFrom; InstanceId is then empty
+	ClientToken    string // only set by LaunchEc2Spot; idempotency token of the fleet request (e.g. for auditing via CloudTrail)
+	TagPrefix      string // prefix of the spotsh managed tags on the instance; e.g. spotsh
+}
+
+// IsReservedTag returns true for tags which are managed by spotsh or AWS
+// itself rather than the user
+func IsReservedTag(tagPrefix string, key string) bool {
+	if tagPrefix == "" {
+		tagPrefix = DefaultTagPrefix
+	}
+
+	return strings.HasPrefix(key, tagPrefix+".") ||
+		strings.HasPrefix(key, "aws:")
 }
 
 func LaunchEc2Spot(ctx context.Context, awsCfg aws.Config,
@@ -79,33 +158,270 @@ func LaunchEc2Spot(ctx context.Context, awsCfg aws.Config,
 	}
 
 	var launchResult LaunchEc2SpotResult
-	ec2Client := ec2.NewFromConfig(awsCfg)
-	templateId, err := createLaunchTemplate(ctx, awsCfg, ec2Client, launchArgs,
-		&launchResult)
+	if launchArgs.TagPrefix == "" {
+		launchArgs.TagPrefix = DefaultTagPrefix
+	}
+	launchResult.TagPrefix = launchArgs.TagPrefix
+	if launchArgs.Market == "" {
+		launchArgs.Market = MarketSpot
+	} else if launchArgs.Market != MarketSpot &&
+		launchArgs.Market != MarketOnDemand {
+		return launchResult, fmt.Errorf("Unknown market '%v'; must be %v or %v",
+			launchArgs.Market, MarketSpot, MarketOnDemand)
+	}
+	err := checkTenancy(launchArgs)
+	if err != nil {
+		return launchResult, err
+	}
+	err = checkSpotInstancePools(launchArgs)
+	if err != nil {
+		return launchResult, err
+	}
+	if launchArgs.NoWait && launchArgs.AssociateEip {
+		// an elastic ip can't be associated w/ a pending instance
+		return launchResult, fmt.Errorf("NoWait may not be combined w/ AssociateEip")
+	}
+	if launchArgs.CloudConfig != "" {
+		err = checkCloudConfig(launchArgs.CloudConfig)
+		if err != nil {
+			return launchResult, err
+		}
+	}
+	err = checkValidFrom(launchArgs, time.Now())
+	if err != nil {
+		return launchResult, err
+	}
+	err = checkClientToken(launchArgs.ClientToken)
 	if err != nil {
-		err = fmt.Errorf("failed to create launch template: %w\n", err)
 		return launchResult, err
 	}
+	maxPrices, err := getMaxSpotPricesFromPct(awsCfg, launchArgs)
+	if err != nil {
+		return launchResult, err
+	}
+	ec2Client := newEc2Client(awsCfg)
+	var templateId string
+	if launchArgs.LaunchTemplate != "" {
+		templateId, err = useExternalLaunchTemplate(ctx, awsCfg, ec2Client,
+			launchArgs, &launchResult)
+		if err != nil {
+			return launchResult, err
+		}
+	} else {
+		templateId, err = createLaunchTemplate(ctx, awsCfg, ec2Client,
+			launchArgs, &launchResult)
+		if err != nil {
+			err = fmt.Errorf("failed to create launch template: %w\n", err)
+			return launchResult, err
+		}
+	}
 
 	err = runInstance(ctx, awsCfg, ec2Client, templateId, launchArgs,
-		&launchResult)
+		maxPrices, &launchResult)
+	if err != nil {
+		return launchResult, err
+	}
+	if launchResult.InstanceId == "" {
+		// scheduled; the instance doesn't exist yet
+		return launchResult, nil
+	}
+
+	// the launch template is tagged w/ the overall max price; replace it w/
+	// the launched type's own max price when one was derived for it
+	maxPrice, ok := maxPrices[launchResult.InstanceType]
+	if ok {
+		maxPriceTagKey := launchArgs.TagPrefix + "." + MaxPriceTagSuffix
+		err = UpdateTag(awsCfg, launchResult.InstanceId, maxPriceTagKey,
+			maxPrice)
+		if err != nil {
+			return launchResult, fmt.Errorf("launched %v but failed to tag its max spot price: %w",
+				launchResult.InstanceId, err)
+		}
+		launchResult.Tags[maxPriceTagKey] = maxPrice
+	}
+	launchResult.MaxSpotPrice =
+		launchResult.Tags[launchArgs.TagPrefix+"."+MaxPriceTagSuffix]
+
+	if launchArgs.AssociateEip {
+		err = associateEip(ctx, ec2Client, launchArgs.TagPrefix,
+			&launchResult)
+		if err != nil {
+			return launchResult, fmt.Errorf("launched %v but failed to associate an elastic ip: %w",
+				launchResult.InstanceId, err)
+		}
+	}
+
+	if launchArgs.IdleCpuAlarmPct > 0 {
+		// best effort; alarms which terminated their own instance are
+		// otherwise never removed
+		_, _ = deleteStaleIdleCpuAlarms(ctx, newCloudwatchClient(awsCfg),
+			ec2Client, launchArgs.TagPrefix)
+		err = createIdleCpuAlarm(ctx, awsCfg, launchArgs.TagPrefix,
+			launchResult.InstanceId, launchArgs.IdleCpuAlarmPct,
+			launchArgs.IdleCpuAlarmMinutes)
+		if err != nil {
+			err = fmt.Errorf("launched %v but failed to create idle cpu alarm: %w",
+				launchResult.InstanceId, err)
+		}
+	}
 
 	return launchResult, err
 }
 
+// checkValidFrom verifies a scheduled launch is in the future & isn't
+// combined w/ options which require the instance to exist at launch time, and
+// that an instant launch's ValidUntil is in the future
+func checkValidFrom(launchArgs *LaunchEc2SpotArgs, now time.Time) error {
+	if launchArgs.ValidFrom.IsZero() {
+		if launchArgs.ValidUntil.IsZero() {
+			return nil
+		}
+		// EC2 ignores ValidUntil for instant fleets so the instance's user
+		// data bounds its lifetime instead
+		if !launchArgs.ValidUntil.After(now) {
+			return fmt.Errorf("ValidUntil %v is not in the future",
+				launchArgs.ValidUntil)
+		}
+		if launchArgs.LaunchTemplate != "" {
+			return fmt.Errorf("ValidUntil w/o ValidFrom may not be combined w/ LaunchTemplate")
+		}
+		return nil
+	}
+	if !launchArgs.ValidFrom.After(now) {
+		return fmt.Errorf("ValidFrom %v is not in the future",
+			launchArgs.ValidFrom)
+	}
+	if !launchArgs.ValidUntil.IsZero() &&
+		!launchArgs.ValidUntil.After(launchArgs.ValidFrom) {
+		return fmt.Errorf("ValidUntil %v must be after ValidFrom %v",
+			launchArgs.ValidUntil, launchArgs.ValidFrom)
+	}
+	if launchArgs.AssociateEip || launchArgs.IdleCpuAlarmPct > 0 ||
+		launchArgs.NoWait {
+		return fmt.Errorf("ValidFrom may not be combined w/ AssociateEip, IdleCpuAlarmPct, or NoWait")
+	}
+	if launchArgs.LaunchTemplate != "" {
+		// EC2 only applies fleet instance tag specifications to instant
+		// fleets so the instance wouldn't be tagged as spotsh's
+		return fmt.Errorf("ValidFrom may not be combined w/ LaunchTemplate")
+	}
+
+	return nil
+}
+
+func checkTenancy(launchArgs *LaunchEc2SpotArgs) error {
+	switch launchArgs.Tenancy {
+	case "", TenancyDefault:
+		return nil
+	case TenancyDedicated, TenancyHost:
+	default:
+		return fmt.Errorf("Unknown tenancy '%v'; must be %v, %v, or %v",
+			launchArgs.Tenancy, TenancyDefault, TenancyDedicated, TenancyHost)
+	}
+	if launchArgs.Market != MarketOnDemand {
+		return fmt.Errorf("Tenancy %v is not supported for spot instances; please use market %v",
+			launchArgs.Tenancy, MarketOnDemand)
+	}
+
+	return nil
+}
+
+// getMaxSpotPricesFromPct returns the maximum spot price of each of
+// launchArgs' instance types as MaxSpotPricePct percent of that type's
+// on-demand price, or nil when MaxSpotPricePct is unset. When MaxSpotPrice is
+// unset it is raised to the highest of these so that the fleet's total price
+// cap does not further restrict the per type caps.
+func getMaxSpotPricesFromPct(awsCfg aws.Config,
+	launchArgs *LaunchEc2SpotArgs) (map[types.InstanceType]string, error) {
+
+	if launchArgs.MaxSpotPricePct == 0 || launchArgs.Market == MarketOnDemand {
+		return nil, nil
+	}
+	if launchArgs.MaxSpotPricePct < 0 || launchArgs.MaxSpotPricePct > 100 {
+		return nil, fmt.Errorf("MaxSpotPricePct %v must be between 0 and 100",
+			launchArgs.MaxSpotPricePct)
+	}
+
+	iTypes := launchArgs.InstanceTypes
+	if len(iTypes) == 0 {
+		iTypes = DefaultInstanceTypes
+	}
+	maxPrices := make(map[types.InstanceType]string)
+	highestPrice := 0.0
+	for _, iType := range iTypes {
+		onDemandPrice, err := LookupOnDemandPrice(awsCfg, iType)
+		if err != nil {
+			return nil, err
+		}
+		maxPrice := onDemandPrice * launchArgs.MaxSpotPricePct / 100
+		maxPrices[iType] = strconv.FormatFloat(maxPrice, 'f', 5, 64)
+		if maxPrice > highestPrice {
+			highestPrice = maxPrice
+		}
+	}
+	if launchArgs.MaxSpotPrice == "" {
+		launchArgs.MaxSpotPrice = strconv.FormatFloat(highestPrice, 'f', 5, 64)
+	}
+
+	return maxPrices, nil
+}
+
+// getLaunchKeyName returns the keypair to launch w/; when none was
+// specified spotsh's default keypair is used, creating it if necessary
+// unless launchArgs.NoDefaultKey is set
+func getLaunchKeyName(ctx context.Context, awsCfg aws.Config,
+	ec2Client ec2Api, launchArgs *LaunchEc2SpotArgs) (string, error) {
+
+	if launchArgs.KeyPair != "" {
+		return launchArgs.KeyPair, nil
+	}
+
+	haveDefaultKey, err := haveDefaultKeyPair(ctx, awsCfg)
+	if err != nil {
+		return "", err
+	}
+	if !haveDefaultKey {
+		if launchArgs.NoDefaultKey {
+			return "", fmt.Errorf("No keypair specified and default keypair %v does not exist; specify one via --key",
+				GetDefaultKeyName(awsCfg))
+		}
+		err = createDefaultKeyPair(ctx, awsCfg, ec2Client)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return GetDefaultKeyName(awsCfg), nil
+}
+
 func createLaunchTemplate(ctx context.Context, awsCfg aws.Config,
-	ec2Client *ec2.Client, launchArgs *LaunchEc2SpotArgs,
+	ec2Client ec2Api, launchArgs *LaunchEc2SpotArgs,
 	launchResult *LaunchEc2SpotResult) (string, error) {
 
 	if launchArgs.TagPrefix == "" {
 		launchArgs.TagPrefix = DefaultTagPrefix
 	}
 	launchTemplateName := launchArgs.TagPrefix + "-lt"
+	if launchArgs.ClientToken != "" {
+		launchTemplateName = getClientTokenTemplateName(launchArgs.TagPrefix,
+			launchArgs.ClientToken)
+	} else if !launchArgs.ValidFrom.IsZero() {
+		// later launches replace the shared template whereas a scheduled
+		// fleet still needs its own once ValidFrom arrives
+		launchTemplateName += "-" +
+			strconv.FormatInt(launchArgs.ValidFrom.Unix(), 10)
+	}
 	descInput := &ec2.DescribeLaunchTemplatesInput{
 		LaunchTemplateNames: []string{launchTemplateName},
 	}
+	var existingTemplateId string
 	descOuput, err := ec2Client.DescribeLaunchTemplates(ctx, descInput)
-	if err == nil && len(descOuput.LaunchTemplates) > 0 {
+	if err == nil && len(descOuput.LaunchTemplates) > 0 &&
+		launchArgs.ClientToken != "" {
+		// a retry of an earlier launch w/ the same token
+		existingTemplateId =
+			aws.ToString(descOuput.LaunchTemplates[0].LaunchTemplateId)
+	} else if err == nil && len(descOuput.LaunchTemplates) > 0 {
 		deleteInput := &ec2.DeleteLaunchTemplateInput{
 			LaunchTemplateId: aws.String(*descOuput.LaunchTemplates[0].LaunchTemplateId),
 		}
@@ -128,6 +444,9 @@ func createLaunchTemplate(ctx context.Context, awsCfg aws.Config,
 		MarketType:  types.MarketTypeSpot,
 		SpotOptions: spotOpts,
 	}
+	if launchArgs.Market == MarketOnDemand {
+		marketOpts = nil
+	}
 
 	iamOpts := &types.LaunchTemplateIamInstanceProfileSpecificationRequest{}
 	if launchArgs.AttachRoleName != "" {
@@ -136,59 +455,28 @@ func createLaunchTemplate(ctx context.Context, awsCfg aws.Config,
 		iamOpts = nil
 	}
 
-	var keyName *string
-	if launchArgs.KeyPair != "" {
-		keyName = &launchArgs.KeyPair
-	} else {
-		haveDefaultKey, err := haveDefaultKeyPair(ctx, awsCfg)
-		if err != nil {
-			return "", err
-		}
-		if !haveDefaultKey {
-			err = createDefaultKeyPair(ctx, awsCfg, ec2Client)
-			if err != nil {
-				return "", err
-			}
-		}
-		keyPair := GetDefaultKeyName(awsCfg)
-		keyName = &keyPair
-	}
-	keysResult, err := LookupKeys(awsCfg)
+	keyPair, err := getLaunchKeyName(ctx, awsCfg, ec2Client, launchArgs)
 	if err != nil {
 		return "", err
 	}
-	launchResult.LocalKeyFile = ""
-	for _, keyItem := range keysResult.Keys {
-		if *keyName == keyItem.Name {
-			launchResult.LocalKeyFile = keyItem.LocalKeyFile
-			break
-		}
-	}
-	var initCmdEncoded *string
-	if launchArgs.InitCmd != "" {
-		initCmdEncodedActual :=
-			base64.StdEncoding.EncodeToString([]byte(launchArgs.InitCmd))
-		initCmdEncoded = &initCmdEncodedActual
-	} else {
-		initCmdEncoded = nil
+	keyName := &keyPair
+	launchResult.LocalKeyFile, err = getLocalKeyFile(awsCfg, keyPair)
+	if err != nil {
+		return "", err
 	}
-	amiId := launchArgs.AmiId
-	amiName := launchArgs.AmiName
-	if amiName != "" {
-		if amiId != "" {
-			return "", fmt.Errorf("Ami id and ami name are mutually exclusive; please specify one or the other")
-		}
-		amiId, err = getAmiIdFromName(awsCfg, ec2Client, amiName)
-		if err != nil {
-			return "", err
-		}
+	amiId, err := resolveAmiId(awsCfg, ec2Client, launchArgs)
+	if err != nil {
+		return "", err
 	}
 	if amiId == "" {
 		if launchArgs.Os == spotsh.OsNone {
 			launchArgs.Os = DefaultOperatingSystem
 		}
-		idx := int(launchArgs.Os)
-		launchResult.User = imageIdTab[idx].user
+		idEntry, err := getImageIdEntry(launchArgs.Os)
+		if err != nil {
+			return "", err
+		}
+		launchResult.User = idEntry.user
 		amiId, err = getLatestAmiId(ctx, awsCfg, launchArgs.Os)
 		if err != nil {
 			return "", err
@@ -198,6 +486,52 @@ func createLaunchTemplate(ctx context.Context, awsCfg aws.Config,
 	} else {
 		launchResult.User = launchArgs.User
 	}
+	initCmd := launchArgs.InitCmd
+	if launchArgs.InstanceStoreMount != "" {
+		initCmd, err = getInstanceStoreUserData(launchArgs.InstanceStoreMount,
+			initCmd)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(launchArgs.AuthorizedKeys) > 0 {
+		initCmd, err = getAuthorizedKeysUserData(launchResult.User,
+			launchArgs.AuthorizedKeys, initCmd)
+		if err != nil {
+			return "", err
+		}
+	}
+	if launchArgs.Enclave {
+		// prepended last so that the allocator reserves the enclave's
+		// memory before instance store setup or user commands run
+		initCmd, err = getEnclaveUserData(launchResult.User, initCmd)
+		if err != nil {
+			return "", err
+		}
+	}
+	if launchArgs.ValidFrom.IsZero() && !launchArgs.ValidUntil.IsZero() {
+		// prepended after the enclave allocator so that the shutdown is
+		// scheduled even if a later script fails
+		initCmd, err = getLifetimeUserData(launchArgs.ValidUntil, time.Now(),
+			initCmd)
+		if err != nil {
+			return "", err
+		}
+	}
+	if launchArgs.CloudConfig != "" {
+		initCmd, err = getMultipartUserData(launchArgs.CloudConfig, initCmd)
+		if err != nil {
+			return "", err
+		}
+	}
+	var initCmdEncoded *string
+	if initCmd != "" {
+		initCmdEncodedActual :=
+			base64.StdEncoding.EncodeToString([]byte(initCmd))
+		initCmdEncoded = &initCmdEncodedActual
+	} else {
+		initCmdEncoded = nil
+	}
 	sgId := launchArgs.SecurityGroupId
 	if sgId == "" {
 		sgId, err = getDefaultSecurityGroupId(awsCfg, ec2Client)
@@ -206,60 +540,115 @@ func createLaunchTemplate(ctx context.Context, awsCfg aws.Config,
 		}
 	}
 	launchResult.SgId = sgId
-	userTagKey := launchArgs.TagPrefix + "." + UserTagSuffix
-	userTagVal := launchResult.User
-	userTag := types.Tag{
-		Key:   &userTagKey,
-		Value: &userTagVal,
-	}
-	osTagKey := launchArgs.TagPrefix + "." + OsTagSuffix
-	osTagVal := launchArgs.Os.String()
 	launchResult.Os = launchArgs.Os
-	osTag := types.Tag{
-		Key:   &osTagKey,
-		Value: &osTagVal,
-	}
-	vpnTagKey := launchArgs.TagPrefix + "." + VpnTagSuffix
-	vpnTagVal := "false"
-	vpnTag := types.Tag{
-		Key:   &vpnTagKey,
-		Value: &vpnTagVal,
-	}
 	tagSpec := types.LaunchTemplateTagSpecificationRequest{
 		ResourceType: types.ResourceTypeInstance,
-		Tags:         []types.Tag{userTag, osTag, vpnTag},
+		Tags:         getInstanceTags(launchArgs, launchResult.User, spotPrice),
 	}
-	rootVolSize := launchArgs.RootVolSizeInGiB
-	rootVolName, err := getRootVolName(ctx, ec2Client, amiId)
+	launchResult.Tags = make(map[string]string)
+	for _, tag := range tagSpec.Tags {
+		launchResult.Tags[*tag.Key] = *tag.Value
+	}
+	rootVolName, snapshotSize, err := getRootVolInfo(ctx, ec2Client, amiId)
 	if err != nil {
 		return "", err
 	}
-	if rootVolSize == 0 {
-		rootVolSize = DefaultRootVolSizeInGiB
-	}
-	rootBlockMap := types.LaunchTemplateBlockDeviceMappingRequest{
-		DeviceName: &rootVolName,
-		Ebs: &types.LaunchTemplateEbsBlockDeviceRequest{
-			VolumeSize: &rootVolSize,
-		},
+	rootVolSize, err := getRootVolSize(amiId, launchArgs.RootVolSizeInGiB,
+		snapshotSize, launchArgs.UseAmiRootVolSize)
+	if err != nil {
+		return "", err
 	}
 	if len(launchArgs.InstanceTypes) == 0 {
 		launchArgs.InstanceTypes = DefaultInstanceTypes
 	}
+	blockMaps := make([]types.LaunchTemplateBlockDeviceMappingRequest, 0)
+	if rootVolSize != nil {
+		blockMaps = append(blockMaps, types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName: &rootVolName,
+			Ebs: &types.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeSize: rootVolSize,
+			},
+		})
+	}
+	if launchArgs.InstanceStoreMount != "" {
+		err = checkInstanceStoreSupported(ctx, ec2Client,
+			launchArgs.InstanceTypes)
+		if err != nil {
+			return "", err
+		}
+		blockMaps = append(blockMaps, getInstanceStoreBlockMaps()...)
+	}
+	if len(launchArgs.BlockDevices) > 0 {
+		usedNames := []string{rootVolName}
+		for _, blockMap := range blockMaps {
+			usedNames = append(usedNames, aws.ToString(blockMap.DeviceName))
+		}
+		extraMaps, err := getBlockDeviceMaps(launchArgs.BlockDevices, usedNames)
+		if err != nil {
+			return "", err
+		}
+		blockMaps = append(blockMaps, extraMaps...)
+	}
+	var enclaveOpts *types.LaunchTemplateEnclaveOptionsRequest
+	if launchArgs.Enclave {
+		err = checkEnclaveSupported(ctx, ec2Client, launchArgs.InstanceTypes)
+		if err != nil {
+			return "", err
+		}
+		enclaveOpts = &types.LaunchTemplateEnclaveOptionsRequest{
+			Enabled: aws.Bool(true),
+		}
+	}
+	var monitoringOpts *types.LaunchTemplatesMonitoringRequest
+	if launchArgs.IdleCpuAlarmPct > 0 {
+		// detailed monitoring provides the 1 minute datapoints the idle
+		// alarm evaluates
+		monitoringOpts = &types.LaunchTemplatesMonitoringRequest{
+			Enabled: aws.Bool(true),
+		}
+	}
+	var placementOpts *types.LaunchTemplatePlacementRequest
+	if launchArgs.Tenancy != "" && launchArgs.Tenancy != TenancyDefault {
+		placementOpts = &types.LaunchTemplatePlacementRequest{
+			Tenancy: types.Tenancy(launchArgs.Tenancy),
+		}
+	}
 	createInput := &ec2.CreateLaunchTemplateInput{
 		LaunchTemplateData: &types.RequestLaunchTemplateData{
-			BlockDeviceMappings:               []types.LaunchTemplateBlockDeviceMappingRequest{rootBlockMap},
+			BlockDeviceMappings:               blockMaps,
+			EnclaveOptions:                    enclaveOpts,
 			IamInstanceProfile:                iamOpts,
 			ImageId:                           aws.String(amiId),
 			InstanceInitiatedShutdownBehavior: types.ShutdownBehaviorTerminate,
 			InstanceMarketOptions:             marketOpts,
 			KeyName:                           keyName,
+			Monitoring:                        monitoringOpts,
+			Placement:                         placementOpts,
 			SecurityGroupIds:                  []string{sgId},
 			TagSpecifications:                 []types.LaunchTemplateTagSpecificationRequest{tagSpec},
 			UserData:                          initCmdEncoded,
 		},
 		LaunchTemplateName: aws.String(launchTemplateName),
 	}
+	if launchArgs.Ipv6 {
+		// security groups must be specified on the network interface rather
+		// than the instance once an interface is specified
+		createInput.LaunchTemplateData.SecurityGroupIds = nil
+		createInput.LaunchTemplateData.NetworkInterfaces = []types.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			{
+				AssociatePublicIpAddress: aws.Bool(true),
+				DeleteOnTermination:      aws.Bool(true),
+				DeviceIndex:              aws.Int32(0),
+				Groups:                   []string{sgId},
+				Ipv6AddressCount:         aws.Int32(1),
+			},
+		}
+	}
+	if existingTemplateId != "" {
+		// launchResult is still populated above as the retried fleet
+		// request returns the original launch's instance
+		return existingTemplateId, nil
+	}
 	createOutput, err := ec2Client.CreateLaunchTemplate(ctx, createInput)
 	if err != nil {
 		return "", err
@@ -268,19 +657,200 @@ func createLaunchTemplate(ctx context.Context, awsCfg aws.Config,
 	return *createOutput.LaunchTemplate.LaunchTemplateId, nil
 }
 
-func getLaunchTemplateConfigs(templateId string,
-	launchArgs *LaunchEc2SpotArgs) []types.FleetLaunchTemplateConfigRequest {
+// DeleteStaleScheduledLaunchTemplates deletes the launch templates created
+// for scheduled launches (i.e. <tagPrefix>-lt-<ValidFrom>) once no pending
+// fleet still needs them; i.e. their fleet has been fulfilled, has expired,
+// or was deleted. Those created for a caller supplied ClientToken (i.e.
+// <tagPrefix>-lt-token-<hash>) are likewise deleted once they are older than
+// clientTokenTemplateTtl. The names of the deleted templates are returned.
+func DeleteStaleScheduledLaunchTemplates(awsCfg aws.Config,
+	tagPrefix string) ([]string, error) {
+
+	ec2Client := newEc2Client(awsCfg)
+	ctx := context.Background()
+
+	return deleteStaleScheduledLaunchTemplates(ctx, ec2Client, tagPrefix,
+		time.Now())
+}
+
+func deleteStaleScheduledLaunchTemplates(ctx context.Context, ec2Client ec2Api,
+	tagPrefix string, now time.Time) ([]string, error) {
+
+	namePrefix := tagPrefix + "-lt-"
+	descInput := &ec2.DescribeLaunchTemplatesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("launch-template-name"),
+				Values: []string{namePrefix + "*"},
+			},
+		},
+	}
+	descOutput, err := ec2Client.DescribeLaunchTemplates(ctx, descInput)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe launch templates: %w", err)
+	}
+	if len(descOutput.LaunchTemplates) == 0 {
+		return nil, nil
+	}
+	fleetsInput := &ec2.DescribeFleetsInput{
+		Filters: []types.Filter{
+			{
+				Name: aws.String("fleet-state"),
+				Values: []string{string(types.FleetStateCodeSubmitted),
+					string(types.FleetStateCodeActive),
+					string(types.FleetStateCodeModifying)},
+			},
+		},
+	}
+	fleetsOutput, err := ec2Client.DescribeFleets(ctx, fleetsInput)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe fleets: %w", err)
+	}
+	inUse := make(map[string]bool)
+	for _, fleet := range fleetsOutput.Fleets {
+		if fleet.ActivityStatus == types.FleetActivityStatusFulfilled {
+			// a request fleet never replaces its instance
+			continue
+		}
+		for _, ltConfig := range fleet.LaunchTemplateConfigs {
+			if ltConfig.LaunchTemplateSpecification == nil {
+				continue
+			}
+			inUse[aws.ToString(ltConfig.LaunchTemplateSpecification.LaunchTemplateId)] = true
+		}
+	}
+
+	var deleted []string
+	for _, lt := range descOutput.LaunchTemplates {
+		name := aws.ToString(lt.LaunchTemplateName)
+		if strings.HasPrefix(name, tagPrefix+clientTokenTemplateInfix) {
+			if lt.CreateTime == nil ||
+				now.Sub(*lt.CreateTime) < clientTokenTemplateTtl {
+				// its launch may still be retried
+				continue
+			}
+		} else {
+			validFrom, err := strconv.ParseInt(strings.TrimPrefix(name,
+				namePrefix), 10, 64)
+			if err != nil || time.Unix(validFrom, 0).After(now) {
+				// not one of ours or its fleet may not be visible yet
+				continue
+			}
+		}
+		if inUse[aws.ToString(lt.LaunchTemplateId)] {
+			continue
+		}
+		deleteInput := &ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: lt.LaunchTemplateId,
+		}
+		_, err = ec2Client.DeleteLaunchTemplate(ctx, deleteInput)
+		if err != nil {
+			return deleted, fmt.Errorf("Failed to delete launch template %v: %w",
+				name, err)
+		}
+		deleted = append(deleted, name)
+	}
+
+	return deleted, nil
+}
+
+// getLocalKeyFile returns the path of keyName's local private key or "" if
+// it is not present locally
+func getLocalKeyFile(awsCfg aws.Config, keyName string) (string, error) {
+	keysResult, err := LookupKeys(awsCfg)
+	if err != nil {
+		return "", err
+	}
+	for _, keyItem := range keysResult.Keys {
+		if keyName == keyItem.Name {
+			return keyItem.LocalKeyFile, nil
+		}
+	}
+
+	return "", nil
+}
+
+// getInstanceTags returns the tags spotsh applies to each instance it
+// launches; these identify the instance as a spot shell & record details
+// (e.g. its ssh user) which spotsh can't otherwise determine
+func getInstanceTags(launchArgs *LaunchEc2SpotArgs, user string,
+	spotPrice string) []types.Tag {
+
+	tags := []types.Tag{
+		{
+			Key:   aws.String(launchArgs.TagPrefix + "." + UserTagSuffix),
+			Value: aws.String(user),
+		},
+		{
+			Key:   aws.String(launchArgs.TagPrefix + "." + OsTagSuffix),
+			Value: aws.String(launchArgs.Os.String()),
+		},
+		{
+			Key:   aws.String(launchArgs.TagPrefix + "." + VpnTagSuffix),
+			Value: aws.String("false"),
+		},
+	}
+	if launchArgs.Market != MarketOnDemand {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(launchArgs.TagPrefix + "." + MaxPriceTagSuffix),
+			Value: aws.String(spotPrice),
+		})
+	}
+	if LaunchVersion != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(launchArgs.TagPrefix + "." + VersionTagSuffix),
+			Value: aws.String(LaunchVersion),
+		})
+	}
+	for key, value := range launchArgs.Tags {
+		if IsReservedTag(launchArgs.TagPrefix, key) {
+			continue
+		}
+		tags = append(tags, types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+
+	return tags
+}
+
+func getLaunchTemplateConfigs(templateId string, launchArgs *LaunchEc2SpotArgs,
+	maxPrices map[types.InstanceType]string) []types.FleetLaunchTemplateConfigRequest {
+
+	spotPrice := launchArgs.MaxSpotPrice
+	if spotPrice == "" {
+		spotPrice = DefaultMaxSpotPrice
+	}
+	templateVersion := "$Latest"
+	if launchArgs.LaunchTemplate != "" {
+		templateVersion = externalTemplateVersion
+	}
 
 	configList := make([]types.FleetLaunchTemplateConfigRequest, 0)
 	for _, iType := range launchArgs.InstanceTypes {
+		override := types.FleetLaunchTemplateOverridesRequest{
+			InstanceType: iType,
+		}
+		if priority, ok := launchArgs.InstanceTypePriorities[iType]; ok {
+			override.Priority = aws.Float64(priority)
+		}
+		if maxPrice, ok := maxPrices[iType]; ok {
+			override.MaxPrice = aws.String(maxPrice)
+		} else if launchArgs.LaunchTemplate != "" &&
+			launchArgs.Market != MarketOnDemand {
+			// an external template may not specify a spot max price
+			override.MaxPrice = aws.String(spotPrice)
+		}
+		if launchArgs.AvailabilityZone != "" {
+			override.AvailabilityZone = aws.String(launchArgs.AvailabilityZone)
+		}
 		config := types.FleetLaunchTemplateConfigRequest{
 			LaunchTemplateSpecification: &types.FleetLaunchTemplateSpecificationRequest{
 				LaunchTemplateId: aws.String(templateId),
-				Version:          aws.String("$Latest"),
-			},
-			Overrides: []types.FleetLaunchTemplateOverridesRequest{
-				{InstanceType: iType},
+				Version:          aws.String(templateVersion),
 			},
+			Overrides: []types.FleetLaunchTemplateOverridesRequest{override},
 		}
 		configList = append(configList, config)
 	}
@@ -289,7 +859,8 @@ func getLaunchTemplateConfigs(templateId string,
 }
 
 func runInstance(ctx context.Context, awsCfg aws.Config,
-	ec2Client *ec2.Client, templateId string, launchArgs *LaunchEc2SpotArgs,
+	ec2Client ec2Api, templateId string, launchArgs *LaunchEc2SpotArgs,
+	maxPrices map[types.InstanceType]string,
 	launchResult *LaunchEc2SpotResult) error {
 
 	spotPrice := launchArgs.MaxSpotPrice
@@ -297,26 +868,78 @@ func runInstance(ctx context.Context, awsCfg aws.Config,
 		spotPrice = DefaultMaxSpotPrice
 	}
 	input := &ec2.CreateFleetInput{
-		LaunchTemplateConfigs: getLaunchTemplateConfigs(templateId, launchArgs),
+		LaunchTemplateConfigs: getLaunchTemplateConfigs(templateId, launchArgs,
+			maxPrices),
 		TargetCapacitySpecification: &types.TargetCapacitySpecificationRequest{
 			TotalTargetCapacity:       aws.Int32(1),
 			DefaultTargetCapacityType: types.DefaultTargetCapacityTypeSpot,
 			OnDemandTargetCapacity:    aws.Int32(0),
 			SpotTargetCapacity:        aws.Int32(1),
 		},
-		SpotOptions: &types.SpotOptionsRequest{
-			AllocationStrategy:     types.SpotAllocationStrategyPriceCapacityOptimized,
-			MaxTotalPrice:          aws.String(spotPrice),
+		SpotOptions: getSpotOptions(launchArgs, spotPrice),
+		Type:        types.FleetTypeInstant,
+	}
+	if launchArgs.Market == MarketOnDemand {
+		onDemandStrategy := types.FleetOnDemandAllocationStrategyLowestPrice
+		if len(launchArgs.InstanceTypePriorities) > 0 {
+			onDemandStrategy = types.FleetOnDemandAllocationStrategyPrioritized
+		}
+		input.TargetCapacitySpecification = &types.TargetCapacitySpecificationRequest{
+			TotalTargetCapacity:       aws.Int32(1),
+			DefaultTargetCapacityType: types.DefaultTargetCapacityTypeOnDemand,
+			OnDemandTargetCapacity:    aws.Int32(1),
+			SpotTargetCapacity:        aws.Int32(0),
+		}
+		input.SpotOptions = nil
+		input.OnDemandOptions = &types.OnDemandOptionsRequest{
+			AllocationStrategy:     onDemandStrategy,
 			MinTargetCapacity:      aws.Int32(1),
 			SingleAvailabilityZone: aws.Bool(true),
 			SingleInstanceType:     aws.Bool(false),
-		},
-		Type: types.FleetTypeInstant,
+		}
+	}
+	if !launchArgs.ValidFrom.IsZero() && !launchArgs.ValidUntil.IsZero() {
+		input.ValidUntil = aws.Time(launchArgs.ValidUntil.UTC())
+	}
+	// generated per fleet request rather than stored in launchArgs so that
+	// launching again w/ the same args isn't mistaken for a retry
+	clientToken := launchArgs.ClientToken
+	if clientToken == "" {
+		var err error
+		clientToken, err = newClientToken(launchArgs.TagPrefix)
+		if err != nil {
+			return err
+		}
+	}
+	input.ClientToken = aws.String(clientToken)
+	launchResult.ClientToken = clientToken
+	if !launchArgs.ValidFrom.IsZero() {
+		scheduleFleet(input, launchArgs.ValidFrom)
+	}
+	if launchArgs.LaunchTemplate != "" {
+		// spotsh's own template tags the instance; an external one won't
+		tagSpec := types.TagSpecification{
+			ResourceType: types.ResourceTypeInstance,
+		}
+		for key, value := range launchResult.Tags {
+			tagSpec.Tags = append(tagSpec.Tags, types.Tag{
+				Key:   aws.String(key),
+				Value: aws.String(value),
+			})
+		}
+		input.TagSpecifications = []types.TagSpecification{tagSpec}
 	}
 	runOutput, err := ec2Client.CreateFleet(ctx, input)
 	if err != nil {
 		return fmt.Errorf("unable to create EC2 fleet: %w", err)
 	}
+	if !launchArgs.ValidFrom.IsZero() {
+		// EC2 launches the instance asynchronously once ValidFrom arrives
+		launchResult.FleetId = aws.ToString(runOutput.FleetId)
+		launchResult.Region = awsCfg.Region
+		launchResult.Lifecycle = launchArgs.Market
+		return nil
+	}
 
 	if len(runOutput.Instances) != 1 {
 		deleteInput := &ec2.DeleteFleetsInput{
@@ -324,7 +947,7 @@ func runInstance(ctx context.Context, awsCfg aws.Config,
 			TerminateInstances: aws.Bool(true),
 		}
 		_, _ = ec2Client.DeleteFleets(ctx, deleteInput)
-		return fmt.Errorf("Unable to create instances at this price")
+		return newInsufficientCapacityError(runOutput.Errors)
 	}
 	if len(runOutput.Instances[0].InstanceIds) != 1 {
 		deleteInput := &ec2.DeleteFleetsInput{
@@ -332,20 +955,37 @@ func runInstance(ctx context.Context, awsCfg aws.Config,
 			TerminateInstances: aws.Bool(true),
 		}
 		_, _ = ec2Client.DeleteFleets(ctx, deleteInput)
-		return fmt.Errorf("Unable to create instances at this price")
+		return newInsufficientCapacityError(runOutput.Errors)
 	}
 
 	instanceId := runOutput.Instances[0].InstanceIds[0]
 	launchResult.InstanceId = instanceId
+	launchResult.Region = awsCfg.Region
 	launchResult.InstanceType = runOutput.Instances[0].InstanceType
+	launchResult.Lifecycle = launchArgs.Market
+	if launchArgs.NoWait {
+		// the public ip is left for a later lookup (e.g. spotsh info)
+		return nil
+	}
 
 	for {
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			// the caller abandoned the launch (e.g. via ctrl-c) after the
+			// instance was provisioned; don't leave it running & billing
+			cleanupInterruptedLaunch(ec2Client, *runOutput.FleetId, instanceId)
+			return fmt.Errorf("launch interrupted; terminated instance %v: %w",
+				instanceId, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
 
 		describeInput := &ec2.DescribeInstancesInput{
 			InstanceIds: []string{instanceId},
 		}
 		descOutput, err := ec2Client.DescribeInstances(ctx, describeInput)
+		if ctx.Err() != nil {
+			continue
+		}
 		if err != nil {
 			// launched succeeded but we couldn't determine the public ip;
 			// treat as success
@@ -360,9 +1000,18 @@ func runInstance(ctx context.Context, awsCfg aws.Config,
 			panic(fmt.Sprintf("Unexpected reservations' instances count: %v",
 				len(descOutput.Reservations[0].Instances)))
 		}
-		if descOutput.Reservations[0].Instances[0].PublicIpAddress != nil {
-			launchResult.PublicIp =
-				*descOutput.Reservations[0].Instances[0].PublicIpAddress
+		inst := &descOutput.Reservations[0].Instances[0]
+		if inst.LaunchTime != nil {
+			launchResult.LaunchTime = *inst.LaunchTime
+		}
+		if inst.PrivateIpAddress != nil {
+			launchResult.PrivateIp = *inst.PrivateIpAddress
+		}
+		if inst.Ipv6Address != nil {
+			launchResult.Ipv6 = *inst.Ipv6Address
+		}
+		if inst.PublicIpAddress != nil {
+			launchResult.PublicIp = *inst.PublicIpAddress
 			break
 		}
 	}
@@ -370,8 +1019,393 @@ func runInstance(ctx context.Context, awsCfg aws.Config,
 	return nil
 }
 
-func TerminateInstance(awsCfg aws.Config, instanceId string) error {
-	ec2Client := ec2.NewFromConfig(awsCfg)
+// scheduleFleet converts an instant fleet request into one which EC2
+// fulfills asynchronously at validFrom; the min capacity & single type/az
+// options are only supported by instant fleets
+func scheduleFleet(input *ec2.CreateFleetInput, validFrom time.Time) {
+	input.Type = types.FleetTypeRequest
+	input.ValidFrom = aws.Time(validFrom.UTC())
+	if input.SpotOptions != nil {
+		input.SpotOptions.MinTargetCapacity = nil
+		input.SpotOptions.SingleAvailabilityZone = nil
+		input.SpotOptions.SingleInstanceType = nil
+	}
+	if input.OnDemandOptions != nil {
+		input.OnDemandOptions.MinTargetCapacity = nil
+		input.OnDemandOptions.SingleAvailabilityZone = nil
+		input.OnDemandOptions.SingleInstanceType = nil
+	}
+}
+
+func getSpotOptions(launchArgs *LaunchEc2SpotArgs,
+	spotPrice string) *types.SpotOptionsRequest {
+
+	spotOpts := &types.SpotOptionsRequest{
+		AllocationStrategy:     types.SpotAllocationStrategyPriceCapacityOptimized,
+		MaxTotalPrice:          aws.String(spotPrice),
+		MinTargetCapacity:      aws.Int32(1),
+		SingleAvailabilityZone: aws.Bool(true),
+		SingleInstanceType:     aws.Bool(false),
+	}
+	if len(launchArgs.InstanceTypePriorities) > 0 {
+		spotOpts.AllocationStrategy =
+			types.SpotAllocationStrategyCapacityOptimizedPrioritized
+	} else if launchArgs.SpotInstancePools > 0 {
+		// EC2 only honors InstancePoolsToUseCount w/ lowest-price
+		spotOpts.AllocationStrategy = types.SpotAllocationStrategyLowestPrice
+		spotOpts.InstancePoolsToUseCount =
+			aws.Int32(launchArgs.SpotInstancePools)
+		if launchArgs.SpotInstancePools > 1 {
+			// a single az would confine the instance to that az's pools;
+			// EC2 only permits MinTargetCapacity along w/ a single az or
+			// instance type but w/ a capacity of 1 it's moot anyway
+			spotOpts.SingleAvailabilityZone = nil
+			spotOpts.MinTargetCapacity = nil
+		}
+	}
+
+	return spotOpts
+}
+
+// checkSpotInstancePools verifies SpotInstancePools is only combined w/ the
+// lowest-price allocation strategy it requires
+func checkSpotInstancePools(launchArgs *LaunchEc2SpotArgs) error {
+	if launchArgs.SpotInstancePools == 0 {
+		return nil
+	}
+	if launchArgs.SpotInstancePools < 0 {
+		return fmt.Errorf("SpotInstancePools must be positive")
+	}
+	if launchArgs.Market == MarketOnDemand {
+		return fmt.Errorf("SpotInstancePools may not be combined w/ market %v",
+			MarketOnDemand)
+	}
+	if len(launchArgs.InstanceTypePriorities) > 0 {
+		return fmt.Errorf("SpotInstancePools requires the lowest-price allocation strategy & may not be combined w/ instance type priorities")
+	}
+
+	return nil
+}
+
+// newInsufficientCapacityError wraps ErrInsufficientCapacity w/ the reasons,
+// if any, EC2 gave for failing to fulfill the fleet
+func newInsufficientCapacityError(fleetErrs []types.CreateFleetError) error {
+	reasons := make([]string, 0, len(fleetErrs))
+	seen := make(map[string]struct{})
+	for _, fleetErr := range fleetErrs {
+		if fleetErr.ErrorCode == nil {
+			continue
+		}
+		reason := *fleetErr.ErrorCode
+		if fleetErr.ErrorMessage != nil {
+			reason += " (" + *fleetErr.ErrorMessage + ")"
+		}
+		if _, ok := seen[reason]; ok {
+			continue
+		}
+		seen[reason] = struct{}{}
+		reasons = append(reasons, reason)
+	}
+	if len(reasons) == 0 {
+		return ErrInsufficientCapacity
+	}
+
+	return fmt.Errorf("%w: %v", ErrInsufficientCapacity,
+		strings.Join(reasons, "; "))
+}
+
+// SelectEc2Spot returns the member of launchResults w/ instanceId or, when
+// instanceId is empty, the sole member of launchResults. The returned error
+// wraps ErrNoInstances, ErrMultipleInstances, ErrInstanceNotFound, or
+// ErrNoLocalKey as appropriate.
+func SelectEc2Spot(launchResults []LaunchEc2SpotResult,
+	instanceId string) (*LaunchEc2SpotResult, error) {
+
+	if len(launchResults) == 0 {
+		return nil, ErrNoInstances
+	}
+	if len(launchResults) > 1 && instanceId == "" {
+		var sb strings.Builder
+		for _, lr := range launchResults {
+			sb.WriteString(fmt.Sprintf("\n\t%v:%v", lr.InstanceId, lr.PublicIp))
+		}
+		return nil, fmt.Errorf("%w:%v", ErrMultipleInstances, sb.String())
+	}
+
+	var selectedInstance *LaunchEc2SpotResult
+	for idx, lr := range launchResults {
+		if instanceId == "" || instanceId == lr.InstanceId {
+			selectedInstance = &launchResults[idx]
+			break
+		}
+	}
+	if selectedInstance == nil {
+		return nil, fmt.Errorf("%w w/ id %v", ErrInstanceNotFound, instanceId)
+	}
+	if selectedInstance.LocalKeyFile == "" {
+		return nil, fmt.Errorf("%w for instance w/ id %v", ErrNoLocalKey,
+			selectedInstance.InstanceId)
+	}
+
+	return selectedInstance, nil
+}
+
+// NewLaunchArgsFromInstance returns launch arguments which reproduce the
+// configuration of an existing instance: its operating system (or AMI when
+// launched by AMI), instance type, keypair, security group, role, root volume
+// size, and user tags.
+func NewLaunchArgsFromInstance(awsCfg aws.Config,
+	instanceId string) (*LaunchEc2SpotArgs, error) {
+
+	ec2Client := newEc2Client(awsCfg)
+	ctx := context.Background()
+	describeInput := &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceId},
+	}
+	descOutput, err := ec2Client.DescribeInstances(ctx, describeInput)
+	if err != nil {
+		return nil, err
+	}
+	if len(descOutput.Reservations) != 1 ||
+		len(descOutput.Reservations[0].Instances) != 1 {
+		return nil, fmt.Errorf("Could not find instance %v", instanceId)
+	}
+	inst := &descOutput.Reservations[0].Instances[0]
+
+	tags := make(map[string]string)
+	for _, tag := range inst.Tags {
+		if tag.Key == nil || tag.Value == nil {
+			continue
+		}
+		tags[*tag.Key] = *tag.Value
+	}
+	launchArgs := &LaunchEc2SpotArgs{
+		InstanceTypes: []types.InstanceType{inst.InstanceType},
+		Tags:          make(map[string]string),
+		TagPrefix:     DefaultTagPrefix,
+		Market:        MarketOnDemand,
+	}
+	if inst.InstanceLifecycle == types.InstanceLifecycleTypeSpot {
+		launchArgs.Market = MarketSpot
+	}
+	for key, value := range tags {
+		if !IsReservedTag(launchArgs.TagPrefix, key) {
+			launchArgs.Tags[key] = value
+		}
+	}
+
+	os := spotsh.OsFromString(tags[launchArgs.TagPrefix+"."+OsTagSuffix])
+	if os != spotsh.OsNone && os != spotsh.OsInvalid {
+		launchArgs.Os = os
+	} else {
+		if inst.ImageId == nil {
+			return nil, fmt.Errorf("Could not determine os or ami of %v",
+				instanceId)
+		}
+		launchArgs.AmiId = *inst.ImageId
+		launchArgs.User = tags[launchArgs.TagPrefix+"."+UserTagSuffix]
+	}
+	if inst.KeyName != nil {
+		launchArgs.KeyPair = *inst.KeyName
+	}
+	launchArgs.Ipv6 = inst.Ipv6Address != nil
+	if len(inst.SecurityGroups) > 0 && inst.SecurityGroups[0].GroupId != nil {
+		launchArgs.SecurityGroupId = *inst.SecurityGroups[0].GroupId
+	}
+	if inst.IamInstanceProfile != nil && inst.IamInstanceProfile.Arn != nil {
+		// arn:aws:iam::<account>:instance-profile/<name>
+		arn := *inst.IamInstanceProfile.Arn
+		launchArgs.AttachRoleName = arn[strings.LastIndex(arn, "/")+1:]
+	}
+
+	for _, blockDev := range inst.BlockDeviceMappings {
+		if blockDev.DeviceName == nil || inst.RootDeviceName == nil ||
+			*blockDev.DeviceName != *inst.RootDeviceName ||
+			blockDev.Ebs == nil || blockDev.Ebs.VolumeId == nil {
+			continue
+		}
+		volInput := &ec2.DescribeVolumesInput{
+			VolumeIds: []string{*blockDev.Ebs.VolumeId},
+		}
+		volOutput, err := ec2Client.DescribeVolumes(ctx, volInput)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to describe root volume of %v: %w",
+				instanceId, err)
+		}
+		if len(volOutput.Volumes) == 1 && volOutput.Volumes[0].Size != nil {
+			launchArgs.RootVolSizeInGiB = *volOutput.Volumes[0].Size
+		}
+		break
+	}
+
+	return launchArgs, nil
+}
+
+// cleanupInterruptedLaunch deletes the fleet & terminates the instance of a
+// launch that was interrupted. A fresh context is used since the launch's
+// context has already been cancelled.
+func cleanupInterruptedLaunch(ec2Client ec2Api, fleetId string,
+	instanceId string) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	deleteInput := &ec2.DeleteFleetsInput{
+		FleetIds:           []string{fleetId},
+		TerminateInstances: aws.Bool(true),
+	}
+	_, _ = ec2Client.DeleteFleets(ctx, deleteInput)
+
+	termInput := &ec2.TerminateInstancesInput{
+		InstanceIds: []string{instanceId},
+	}
+	_, _ = ec2Client.TerminateInstances(ctx, termInput)
+}
+
+// ResolveAmiId returns the ami id that launchArgs selects via its AmiId,
+// AmiName, or AmiNamePrefix; an empty id is returned when none are set.
+func ResolveAmiId(awsCfg aws.Config,
+	launchArgs *LaunchEc2SpotArgs) (string, error) {
+
+	ec2Client := newEc2Client(awsCfg)
+
+	return resolveAmiId(awsCfg, ec2Client, launchArgs)
+}
+
+func resolveAmiId(awsCfg aws.Config, ec2Client ec2Api,
+	launchArgs *LaunchEc2SpotArgs) (string, error) {
+
+	amiId := launchArgs.AmiId
+	if launchArgs.AmiName != "" {
+		if amiId != "" || launchArgs.AmiNamePrefix != "" {
+			return "", fmt.Errorf("Ami id, ami name, and ami name prefix are mutually exclusive; please specify only one")
+		}
+		return getAmiIdFromName(awsCfg, ec2Client, launchArgs.AmiName)
+	} else if launchArgs.AmiNamePrefix != "" {
+		if amiId != "" {
+			return "", fmt.Errorf("Ami id and ami name prefix are mutually exclusive; please specify one or the other")
+		}
+		return getAmiIdFromNamePrefix(awsCfg, ec2Client,
+			launchArgs.AmiNamePrefix, launchArgs.AmiLatest)
+	}
+
+	return amiId, nil
+}
+
+// FindMatchingEc2Spot returns the first of the given launch results whose
+// operating system (or AMI id) and instance type are consistent with
+// launchArgs, or nil if none match. An AmiName or AmiNamePrefix must first be
+// resolved into AmiId via ResolveAmiId; otherwise nothing matches.
+func FindMatchingEc2Spot(launchResults []LaunchEc2SpotResult,
+	launchArgs *LaunchEc2SpotArgs) *LaunchEc2SpotResult {
+
+	if launchArgs == nil {
+		launchArgs = &LaunchEc2SpotArgs{}
+	}
+	iTypes := launchArgs.InstanceTypes
+	if len(iTypes) == 0 {
+		iTypes = DefaultInstanceTypes
+	}
+	os := launchArgs.Os
+	if os == spotsh.OsNone {
+		os = DefaultOperatingSystem
+	}
+
+	for idx := range launchResults {
+		lr := &launchResults[idx]
+		if launchArgs.AmiId != "" {
+			if lr.ImageId != launchArgs.AmiId {
+				continue
+			}
+		} else if launchArgs.AmiName != "" || launchArgs.AmiNamePrefix != "" {
+			continue
+		} else if lr.Os != os {
+			continue
+		}
+		if launchArgs.User != "" && lr.User != launchArgs.User {
+			continue
+		}
+		if launchArgs.Market == MarketOnDemand && lr.Lifecycle != MarketOnDemand {
+			continue
+		}
+		for _, iType := range iTypes {
+			if lr.InstanceType == iType {
+				return lr
+			}
+		}
+	}
+
+	return nil
+}
+
+// KeepVolumes clears DeleteOnTermination on each of the specified
+// instance's EBS data volumes, and its root volume when keepRoot is set, so
+// that they survive the instance's termination. The ids of the retained
+// volumes are returned.
+func KeepVolumes(awsCfg aws.Config, instanceId string,
+	keepRoot bool) ([]string, error) {
+	ec2Client := newEc2Client(awsCfg)
+	ctx := context.Background()
+
+	describeInput := &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceId},
+	}
+	descOutput, err := ec2Client.DescribeInstances(ctx, describeInput)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe instance %v: %w", instanceId,
+			err)
+	}
+	if len(descOutput.Reservations) != 1 ||
+		len(descOutput.Reservations[0].Instances) != 1 {
+		return nil, fmt.Errorf("%w: %v", ErrInstanceNotFound, instanceId)
+	}
+	inst := &descOutput.Reservations[0].Instances[0]
+
+	var volumeIds []string
+	var mappings []types.InstanceBlockDeviceMappingSpecification
+	for _, bdm := range inst.BlockDeviceMappings {
+		if bdm.DeviceName == nil || bdm.Ebs == nil || bdm.Ebs.VolumeId == nil {
+			continue
+		}
+		if !keepRoot &&
+			aws.ToString(bdm.DeviceName) == aws.ToString(inst.RootDeviceName) {
+			continue
+		}
+		volumeIds = append(volumeIds, *bdm.Ebs.VolumeId)
+		mappings = append(mappings, types.InstanceBlockDeviceMappingSpecification{
+			DeviceName: bdm.DeviceName,
+			Ebs: &types.EbsInstanceBlockDeviceSpecification{
+				DeleteOnTermination: aws.Bool(false),
+				VolumeId:            bdm.Ebs.VolumeId,
+			},
+		})
+	}
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("Instance %v has no EBS volumes to keep",
+			instanceId)
+	}
+
+	modifyInput := &ec2.ModifyInstanceAttributeInput{
+		InstanceId:          aws.String(instanceId),
+		BlockDeviceMappings: mappings,
+	}
+	_, err = ec2Client.ModifyInstanceAttribute(ctx, modifyInput)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retain volumes of %v: %w", instanceId,
+			err)
+	}
+
+	return volumeIds, nil
+}
+
+// TerminateInstance terminates the specified instance. Terminating an
+// instance which no longer exists or which was already terminated (e.g. by
+// spot reclamation) is treated as success; in that case alreadyGone is
+// returned as true.
+func TerminateInstance(awsCfg aws.Config,
+	instanceId string) (alreadyGone bool, err error) {
+
+	ec2Client := newEc2Client(awsCfg)
 
 	dryRun := false
 	termInput := &ec2.TerminateInstancesInput{
@@ -379,18 +1413,30 @@ func TerminateInstance(awsCfg aws.Config, instanceId string) error {
 		DryRun:      &dryRun,
 	}
 	ctx := context.Background()
-	_, err := ec2Client.TerminateInstances(ctx, termInput)
+	termOutput, err := ec2Client.TerminateInstances(ctx, termInput)
 	if err != nil {
-		return err
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) &&
+			apiErr.ErrorCode() == "InvalidInstanceID.NotFound" {
+			return true, nil
+		}
+		return false, err
 	}
 
-	return nil
+	for _, stateChange := range termOutput.TerminatingInstances {
+		if stateChange.PreviousState != nil &&
+			stateChange.PreviousState.Name == types.InstanceStateNameTerminated {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
 func UpdateTag(awsCfg aws.Config, instanceId string, key string,
 	value string) error {
 
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	ec2Client := newEc2Client(awsCfg)
 
 	tagInput := &ec2.CreateTagsInput{
 		Resources: []string{instanceId},
@@ -410,10 +1456,30 @@ func UpdateTag(awsCfg aws.Config, instanceId string, key string,
 	return nil
 }
 
+func DeleteTag(awsCfg aws.Config, instanceId string, key string) error {
+	ec2Client := newEc2Client(awsCfg)
+
+	tagInput := &ec2.DeleteTagsInput{
+		Resources: []string{instanceId},
+		Tags: []types.Tag{
+			{
+				Key: &key,
+			},
+		},
+	}
+
+	_, err := ec2Client.DeleteTags(context.Background(), tagInput)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func GetTagValue(awsCfg aws.Config, instanceId string,
 	key string) (string, error) {
 
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	ec2Client := newEc2Client(awsCfg)
 
 	resourceId := "resource-id"
 	keyName := "key"
@@ -442,6 +1508,24 @@ func GetTagValue(awsCfg aws.Config, instanceId string,
 	return *tagOutput.Tags[0].Value, nil
 }
 
+// getCommonSpotAz returns the az shared by all of launchResults' spot
+// instances so that their prices can be looked up in only that az, or ""
+// if they span more than one
+func getCommonSpotAz(launchResults []LaunchEc2SpotResult) string {
+	azName := ""
+	for _, launchResult := range launchResults {
+		if launchResult.Lifecycle == MarketOnDemand {
+			continue
+		}
+		if azName != "" && azName != launchResult.AzName {
+			return ""
+		}
+		azName = launchResult.AzName
+	}
+
+	return azName
+}
+
 func LookupEc2Spot(ctx context.Context,
 	awsCfgIn aws.Config, tagPrefix string) ([]LaunchEc2SpotResult, error) {
 
@@ -453,7 +1537,7 @@ func LookupEc2Spot(ctx context.Context,
 	resultsAllRegions := make([]LaunchEc2SpotResult, 0)
 
 	if awsCfgIn.Region == "all" {
-		regionList, err = getRegions()
+		regionList, err = getRegions(awsCfgIn)
 		if err != nil {
 			return nil, err
 		}
@@ -463,16 +1547,14 @@ func LookupEc2Spot(ctx context.Context,
 
 	var wg errgroup.Group
 	var resultLock sync.Mutex
+	tracker := newRegionTracker(len(regionList))
 
 	for _, curReg := range regionList {
 		curReg := curReg // https://golang.org/doc/faq#closures_and_goroutines
 		wg.Go(func() error {
-			awsCfgTmp, err := config.LoadDefaultConfig(ctx,
-				config.WithRegion(curReg))
-			if err != nil {
-				return err
-			}
-			resultsOneRegion, err := lookupEc2SpotOneRegion(awsCfgTmp, tagPrefix)
+			defer tracker.regionDone()
+			regCfg := getRegionConfig(awsCfgIn, curReg)
+			resultsOneRegion, err := lookupEc2SpotOneRegion(regCfg, tagPrefix)
 			if err != nil {
 				return err
 			}
@@ -497,12 +1579,29 @@ func lookupEc2SpotOneRegion(awsCfg aws.Config,
 
 	launchResults := make([]LaunchEc2SpotResult, 0)
 
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	userTagKey := tagPrefix + "." + UserTagSuffix
+	osTagKey := tagPrefix + "." + OsTagSuffix
+	maxPriceTagKey := tagPrefix + "." + MaxPriceTagSuffix
+	versionTagKey := tagPrefix + "." + VersionTagSuffix
+
+	ec2Client := newEc2Client(awsCfg)
 	dryRun := false
 	maxResults := int32(1000)
 	describeInput := &ec2.DescribeInstancesInput{
 		DryRun:     &dryRun,
 		MaxResults: &maxResults,
+		// only ask for running instances launched by spotsh rather than
+		// filtering the entire account's instances client side
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{userTagKey},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{string(types.InstanceStateNameRunning)},
+			},
+		},
 	}
 	ctx := context.Background()
 	descOutput, err := ec2Client.DescribeInstances(ctx, describeInput)
@@ -517,25 +1616,16 @@ func lookupEc2SpotOneRegion(awsCfg aws.Config,
 	azMap := make(map[string]string)
 	var iTypes []types.InstanceType
 
-	var foundSpotShTag bool
-	var user string
-	var os string
-	userTagKey := tagPrefix + "." + UserTagSuffix
-	osTagKey := tagPrefix + "." + OsTagSuffix
 	for _, resv := range descOutput.Reservations {
 		for _, inst := range resv.Instances {
-			if inst.State.Name != types.InstanceStateNameRunning {
-				continue
-			}
-			foundSpotShTag = false
+			tags := make(map[string]string)
 			for _, tag := range inst.Tags {
-				if *tag.Key == userTagKey {
-					foundSpotShTag = true
-					user = *tag.Value
-				} else if *tag.Key == osTagKey {
-					os = *tag.Value
+				if tag.Key == nil || tag.Value == nil {
+					continue
 				}
+				tags[*tag.Key] = *tag.Value
 			}
+			user, foundSpotShTag := tags[userTagKey]
 			if !foundSpotShTag {
 				continue
 			}
@@ -548,28 +1638,78 @@ func lookupEc2SpotOneRegion(awsCfg aws.Config,
 				}
 			}
 
-			azName, err := getAzNameFromSubnetId(ec2Client, azMap,
-				*inst.SubnetId)
-			if err != nil {
-				return launchResults, err
+			// instances in unusual states may lack any of the following so
+			// substitute empty values rather than failing the entire lookup
+			azName := ""
+			if inst.SubnetId != nil {
+				azName, err = getAzNameFromSubnetId(ec2Client, azMap,
+					*inst.SubnetId)
+				if err != nil {
+					return launchResults, err
+				}
+			}
+			if azName == "" && inst.Placement != nil &&
+				inst.Placement.AvailabilityZone != nil {
+				azName = *inst.Placement.AvailabilityZone
 			}
 			iTypes = append(iTypes, inst.InstanceType)
 			publicIp := ""
 			if inst.PublicIpAddress != nil {
 				publicIp = *inst.PublicIpAddress
 			}
+			privateIp := ""
+			if inst.PrivateIpAddress != nil {
+				privateIp = *inst.PrivateIpAddress
+			}
+			imageId := ""
+			if inst.ImageId != nil {
+				imageId = *inst.ImageId
+			}
+			ipv6 := ""
+			if inst.Ipv6Address != nil {
+				ipv6 = *inst.Ipv6Address
+			}
+			dnsName := ""
+			if inst.PublicDnsName != nil {
+				dnsName = *inst.PublicDnsName
+			}
+			launchTime := time.Time{}
+			if inst.LaunchTime != nil {
+				launchTime = *inst.LaunchTime
+			}
+			// on-demand instances have no lifecycle
+			lifecycle := MarketOnDemand
+			if inst.InstanceLifecycle == types.InstanceLifecycleTypeSpot {
+				lifecycle = MarketSpot
+			}
+			sgId := ""
+			if len(inst.SecurityGroups) > 0 &&
+				inst.SecurityGroups[0].GroupId != nil {
+				sgId = *inst.SecurityGroups[0].GroupId
+			}
 			launchResult := LaunchEc2SpotResult{
 				InstanceId:   *inst.InstanceId,
 				PublicIp:     publicIp,
+				PrivateIp:    privateIp,
 				User:         user,
 				LocalKeyFile: localKeyFile,
 				InstanceType: inst.InstanceType,
-				ImageId:      *inst.ImageId,
+				ImageId:      imageId,
 				AzName:       azName,
 				CurrentPrice: 0.00,
-				DnsName:      *inst.PublicDnsName,
-				Os:           spotsh.OsFromString(os),
-				SgId:         *inst.SecurityGroups[0].GroupId,
+				DnsName:      dnsName,
+				Os:           spotsh.OsFromString(tags[osTagKey]),
+				SgId:         sgId,
+				Tags:         tags,
+				Region:       awsCfg.Region,
+				LaunchTime:   launchTime,
+				Lifecycle:    lifecycle,
+				Ipv6:         ipv6,
+				MaxSpotPrice: tags[maxPriceTagKey],
+				// instances launched by older versions of spotsh may lack
+				// any of the non-user tags; missing ones default to empty
+				SpotshVersion: tags[versionTagKey],
+				TagPrefix:     tagPrefix,
 			}
 
 			launchResults = append(launchResults, launchResult)
@@ -580,7 +1720,8 @@ func lookupEc2SpotOneRegion(awsCfg aws.Config,
 		return launchResults, nil
 	}
 
-	spotPriceResult, err := LookupEc2SpotPrices(awsCfg, iTypes)
+	spotPriceResult, err := lookupEc2SpotPrices(awsCfg, iTypes,
+		getCommonSpotAz(launchResults))
 	if err != nil {
 		return launchResults, err
 	}
@@ -588,11 +1729,20 @@ func lookupEc2SpotOneRegion(awsCfg aws.Config,
 	for idx := range launchResults {
 		launchResult := &launchResults[idx]
 		iType := launchResult.InstanceType
+		if launchResult.Lifecycle == MarketOnDemand {
+			// best effort; leave price unknown if the price list api is
+			// unavailable
+			launchResult.CurrentPrice, _ = LookupOnDemandPrice(awsCfg, iType)
+			continue
+		}
 		reg := awsCfg.Region
 		azName := launchResult.AzName
-		curPrice :=
-			spotPriceResult.InstanceTypes[iType].Regions[reg].Azs[azName].CurPrice
-		launchResult.CurrentPrice = curPrice
+		lookupAz, ok :=
+			spotPriceResult.InstanceTypes[iType].Regions[reg].Azs[azName]
+		if !ok {
+			continue
+		}
+		launchResult.CurrentPrice = lookupAz.CurPrice
 	}
 
 	return launchResults, nil
@@ -6,10 +6,20 @@ package aws
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 
 	"github.com/mikeb26/spotsh"
 )
@@ -29,6 +39,25 @@ func TestInstanceIdTab(t *testing.T) {
 	}
 }
 
+func TestImageIdTabEntries(t *testing.T) {
+	for idx := 1; idx < int(spotsh.OsInvalid); idx++ {
+		entry := &imageIdTab[idx]
+
+		if entry.desc == "" {
+			t.Errorf("imageIdTab entry for %v is missing desc", entry.os)
+		}
+		if entry.user == "" {
+			t.Errorf("imageIdTab entry for %v is missing user", entry.os)
+		}
+		if !strings.HasPrefix(entry.ssmParam, "/aws/service/") ||
+			strings.HasSuffix(entry.ssmParam, "/") ||
+			strings.ContainsAny(entry.ssmParam, " \t\n") {
+			t.Errorf("imageIdTab entry for %v has malformed ssmParam '%v'",
+				entry.os, entry.ssmParam)
+		}
+	}
+}
+
 func TestSsmParam(t *testing.T) {
 	ctx := context.Background()
 
@@ -78,3 +107,584 @@ func TestLaunch(t *testing.T) {
 			launchResult.User)
 	}
 }
+
+func TestFindMatchingEc2Spot(t *testing.T) {
+	launchResults := []LaunchEc2SpotResult{
+		{
+			InstanceId:   "i-0",
+			InstanceType: types.InstanceTypeC5Large,
+			Os:           spotsh.Ubuntu22_04,
+			ImageId:      "ami-0",
+			User:         "ubuntu",
+		},
+		{
+			InstanceId:   "i-1",
+			InstanceType: types.InstanceTypeC6iLarge,
+			Os:           DefaultOperatingSystem,
+			ImageId:      "ami-1",
+			User:         "ec2-user",
+		},
+	}
+
+	lr := FindMatchingEc2Spot(launchResults, nil)
+	if lr == nil || lr.InstanceId != "i-1" {
+		t.Fatalf("default args matched unexpected instance: %v", lr)
+	}
+
+	lr = FindMatchingEc2Spot(launchResults, &LaunchEc2SpotArgs{
+		Os:            spotsh.Ubuntu22_04,
+		InstanceTypes: []types.InstanceType{types.InstanceTypeC5Large},
+	})
+	if lr == nil || lr.InstanceId != "i-0" {
+		t.Fatalf("os args matched unexpected instance: %v", lr)
+	}
+
+	lr = FindMatchingEc2Spot(launchResults, &LaunchEc2SpotArgs{
+		Os:            spotsh.Ubuntu22_04,
+		InstanceTypes: []types.InstanceType{types.InstanceTypeC6iLarge},
+	})
+	if lr != nil {
+		t.Fatalf("mismatched type unexpectedly matched %v", lr.InstanceId)
+	}
+
+	lr = FindMatchingEc2Spot(launchResults, &LaunchEc2SpotArgs{
+		AmiId: "ami-0",
+		User:  "admin",
+	})
+	if lr != nil {
+		t.Fatalf("mismatched user unexpectedly matched %v", lr.InstanceId)
+	}
+	lr = FindMatchingEc2Spot(launchResults, &LaunchEc2SpotArgs{
+		AmiId:         "ami-0",
+		User:          "ubuntu",
+		InstanceTypes: []types.InstanceType{types.InstanceTypeC5Large},
+	})
+	if lr == nil || lr.InstanceId != "i-0" {
+		t.Fatalf("ami args matched unexpected instance: %v", lr)
+	}
+
+	lr = FindMatchingEc2Spot(launchResults, &LaunchEc2SpotArgs{
+		AmiName:       "my-ami",
+		User:          "ubuntu",
+		InstanceTypes: []types.InstanceType{types.InstanceTypeC5Large},
+	})
+	if lr != nil {
+		t.Fatalf("unresolved ami name unexpectedly matched %v", lr.InstanceId)
+	}
+	lr = FindMatchingEc2Spot(launchResults, &LaunchEc2SpotArgs{
+		AmiName:       "my-ami",
+		AmiId:         "ami-1",
+		User:          "ubuntu",
+		InstanceTypes: []types.InstanceType{types.InstanceTypeC5Large},
+	})
+	if lr != nil {
+		t.Fatalf("mismatched resolved ami unexpectedly matched %v", lr.InstanceId)
+	}
+	lr = FindMatchingEc2Spot(launchResults, &LaunchEc2SpotArgs{
+		AmiNamePrefix: "my-",
+		AmiId:         "ami-0",
+		User:          "ubuntu",
+		InstanceTypes: []types.InstanceType{types.InstanceTypeC5Large},
+	})
+	if lr == nil || lr.InstanceId != "i-0" {
+		t.Fatalf("resolved ami prefix matched unexpected instance: %v", lr)
+	}
+}
+
+func TestIsReservedTag(t *testing.T) {
+	reserved := []string{"spotsh.user", "spotsh.os", "aws:ec2launchtemplate:id"}
+	for _, key := range reserved {
+		if !IsReservedTag("", key) {
+			t.Errorf("expected %v to be reserved", key)
+		}
+	}
+
+	unreserved := []string{"Name", "owner", "spotshfoo"}
+	for _, key := range unreserved {
+		if IsReservedTag("", key) {
+			t.Errorf("expected %v to not be reserved", key)
+		}
+	}
+}
+
+func TestSelectEc2Spot(t *testing.T) {
+	launchResults := []LaunchEc2SpotResult{
+		{InstanceId: "i-0", LocalKeyFile: "/tmp/key0"},
+		{InstanceId: "i-1"},
+	}
+
+	_, err := SelectEc2Spot(nil, "")
+	if !errors.Is(err, ErrNoInstances) {
+		t.Errorf("expected ErrNoInstances but got %v", err)
+	}
+	_, err = SelectEc2Spot(launchResults, "")
+	if !errors.Is(err, ErrMultipleInstances) ||
+		!strings.Contains(err.Error(), "i-1") {
+		t.Errorf("expected ErrMultipleInstances but got %v", err)
+	}
+	_, err = SelectEc2Spot(launchResults, "i-2")
+	if !errors.Is(err, ErrInstanceNotFound) {
+		t.Errorf("expected ErrInstanceNotFound but got %v", err)
+	}
+	_, err = SelectEc2Spot(launchResults, "i-1")
+	if !errors.Is(err, ErrNoLocalKey) {
+		t.Errorf("expected ErrNoLocalKey but got %v", err)
+	}
+	lr, err := SelectEc2Spot(launchResults, "i-0")
+	if err != nil || lr.InstanceId != "i-0" {
+		t.Errorf("expected i-0 but got %v err:%v", lr, err)
+	}
+	lr, err = SelectEc2Spot(launchResults[:1], "")
+	if err != nil || lr.InstanceId != "i-0" {
+		t.Errorf("expected sole instance i-0 but got %v err:%v", lr, err)
+	}
+}
+
+func TestInsufficientCapacityError(t *testing.T) {
+	err := newInsufficientCapacityError(nil)
+	if err != ErrInsufficientCapacity {
+		t.Errorf("expected bare ErrInsufficientCapacity but got %v", err)
+	}
+
+	fleetErr := types.CreateFleetError{
+		ErrorCode:    aws.String("InsufficientInstanceCapacity"),
+		ErrorMessage: aws.String("no capacity"),
+	}
+	err = newInsufficientCapacityError([]types.CreateFleetError{fleetErr,
+		fleetErr})
+	if !errors.Is(err, ErrInsufficientCapacity) {
+		t.Errorf("expected ErrInsufficientCapacity but got %v", err)
+	}
+	if strings.Count(err.Error(), "InsufficientInstanceCapacity") != 1 {
+		t.Errorf("expected deduplicated reason but got %v", err)
+	}
+}
+
+func TestGetLaunchKeyNameNoDefaultKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	awsCfg := aws.Config{Region: "us-east-2"}
+	// CreateKeyPair is not mocked so any attempt to create a key panics
+	mock := newMockEc2Client()
+
+	launchArgs := &LaunchEc2SpotArgs{NoDefaultKey: true}
+	_, err := getLaunchKeyName(context.Background(), awsCfg, mock, launchArgs)
+	if err == nil {
+		t.Errorf("expected error w/o a default keypair")
+	}
+
+	launchArgs.KeyPair = "mykey"
+	keyName, err := getLaunchKeyName(context.Background(), awsCfg, mock,
+		launchArgs)
+	if err != nil || keyName != "mykey" {
+		t.Errorf("expected mykey but got %v err:%v", keyName, err)
+	}
+}
+
+func TestGetLaunchTemplateConfigsAz(t *testing.T) {
+	launchArgs := &LaunchEc2SpotArgs{
+		InstanceTypes: []types.InstanceType{types.InstanceTypeC5Large},
+	}
+	configs := getLaunchTemplateConfigs("lt-0", launchArgs, nil)
+	if configs[0].Overrides[0].AvailabilityZone != nil {
+		t.Errorf("unexpected az override w/o AvailabilityZone")
+	}
+
+	launchArgs.AvailabilityZone = "us-east-2b"
+	configs = getLaunchTemplateConfigs("lt-0", launchArgs, nil)
+	az := configs[0].Overrides[0].AvailabilityZone
+	if az == nil || *az != "us-east-2b" {
+		t.Errorf("expected az override us-east-2b but got %v", az)
+	}
+}
+
+func TestCheckTenancy(t *testing.T) {
+	launchArgs := &LaunchEc2SpotArgs{Market: MarketSpot}
+	if err := checkTenancy(launchArgs); err != nil {
+		t.Errorf("unexpected error w/ default tenancy: %v", err)
+	}
+
+	launchArgs.Tenancy = TenancyDedicated
+	if err := checkTenancy(launchArgs); err == nil {
+		t.Errorf("expected error w/ dedicated spot instance")
+	}
+
+	launchArgs.Market = MarketOnDemand
+	if err := checkTenancy(launchArgs); err != nil {
+		t.Errorf("unexpected error w/ dedicated on-demand instance: %v", err)
+	}
+
+	launchArgs.Tenancy = "shared"
+	if err := checkTenancy(launchArgs); err == nil {
+		t.Errorf("expected error w/ unknown tenancy")
+	}
+}
+
+func TestGetSpotOptionsPools(t *testing.T) {
+	launchArgs := &LaunchEc2SpotArgs{}
+	spotOpts := getSpotOptions(launchArgs, "0.08")
+	if spotOpts.AllocationStrategy !=
+		types.SpotAllocationStrategyPriceCapacityOptimized ||
+		spotOpts.InstancePoolsToUseCount != nil {
+		t.Errorf("unexpected default spot options %+v", spotOpts)
+	}
+
+	launchArgs.SpotInstancePools = 3
+	spotOpts = getSpotOptions(launchArgs, "0.08")
+	if spotOpts.AllocationStrategy != types.SpotAllocationStrategyLowestPrice ||
+		aws.ToInt32(spotOpts.InstancePoolsToUseCount) != 3 {
+		t.Errorf("expected lowest-price across 3 pools but got %+v", spotOpts)
+	}
+	if spotOpts.SingleAvailabilityZone != nil ||
+		spotOpts.MinTargetCapacity != nil {
+		t.Errorf("expected pools to span azs w/o a min capacity but got %+v",
+			spotOpts)
+	}
+	launchArgs.SpotInstancePools = 1
+	spotOpts = getSpotOptions(launchArgs, "0.08")
+	if !aws.ToBool(spotOpts.SingleAvailabilityZone) ||
+		aws.ToInt32(spotOpts.MinTargetCapacity) != 1 {
+		t.Errorf("expected a single pool to remain in a single az but got %+v",
+			spotOpts)
+	}
+	launchArgs.SpotInstancePools = 3
+	if err := checkSpotInstancePools(launchArgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	launchArgs.InstanceTypePriorities = map[types.InstanceType]float64{
+		types.InstanceTypeC5Large: 1,
+	}
+	if err := checkSpotInstancePools(launchArgs); err == nil {
+		t.Errorf("expected error w/ instance type priorities")
+	}
+	launchArgs.InstanceTypePriorities = nil
+	launchArgs.Market = MarketOnDemand
+	if err := checkSpotInstancePools(launchArgs); err == nil {
+		t.Errorf("expected error w/ on-demand market")
+	}
+}
+
+func TestRunInstanceNoWait(t *testing.T) {
+	// describeInstances is intentionally unset; NoWait must not poll for ip
+	mock := &mockEc2Client{
+		createFleet: func(*ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error) {
+			return &ec2.CreateFleetOutput{
+				FleetId: aws.String("fleet-0"),
+				Instances: []types.CreateFleetInstance{
+					{
+						InstanceIds:  []string{"i-0"},
+						InstanceType: types.InstanceTypeC5aLarge,
+					},
+				},
+			}, nil
+		},
+	}
+	launchArgs := &LaunchEc2SpotArgs{NoWait: true, ClientToken: "nightly-1"}
+	var launchResult LaunchEc2SpotResult
+	err := runInstance(context.Background(), aws.Config{Region: "us-east-1"},
+		mock, "lt-0", launchArgs, nil, &launchResult)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if launchResult.ClientToken != "nightly-1" {
+		t.Errorf("expected client token nightly-1 but got %v",
+			launchResult.ClientToken)
+	}
+	if launchResult.InstanceId != "i-0" || launchResult.PublicIp != "" ||
+		launchResult.Region != "us-east-1" {
+		t.Errorf("unexpected launch result %+v", launchResult)
+	}
+}
+
+func TestGetInstanceTagsVersion(t *testing.T) {
+	launchArgs := &LaunchEc2SpotArgs{TagPrefix: DefaultTagPrefix}
+	versionTagKey := DefaultTagPrefix + "." + VersionTagSuffix
+
+	getVersionTag := func(tags []types.Tag) string {
+		for _, tag := range tags {
+			if aws.ToString(tag.Key) == versionTagKey {
+				return aws.ToString(tag.Value)
+			}
+		}
+		return ""
+	}
+
+	prevVersion := LaunchVersion
+	defer func() { LaunchVersion = prevVersion }()
+
+	LaunchVersion = ""
+	if ver := getVersionTag(getInstanceTags(launchArgs, "ec2-user", "0.08")); ver != "" {
+		t.Errorf("expected no version tag but got %v", ver)
+	}
+	LaunchVersion = "v0.4.1"
+	if ver := getVersionTag(getInstanceTags(launchArgs, "ec2-user", "0.08")); ver != "v0.4.1" {
+		t.Errorf("expected version tag v0.4.1 but got %v", ver)
+	}
+}
+
+func TestCheckValidFrom(t *testing.T) {
+	now := time.Now()
+	launchArgs := &LaunchEc2SpotArgs{}
+	if err := checkValidFrom(launchArgs, now); err != nil {
+		t.Errorf("unexpected error w/o ValidFrom: %v", err)
+	}
+	launchArgs.ValidUntil = now.Add(time.Hour)
+	if err := checkValidFrom(launchArgs, now); err != nil {
+		t.Errorf("unexpected error w/ ValidUntil on an instant launch: %v", err)
+	}
+	launchArgs.ValidUntil = now.Add(-time.Minute)
+	if err := checkValidFrom(launchArgs, now); err == nil {
+		t.Errorf("expected error w/ ValidUntil in the past")
+	}
+	launchArgs.ValidUntil = time.Time{}
+
+	launchArgs.ValidFrom = now.Add(-time.Minute)
+	if err := checkValidFrom(launchArgs, now); err == nil {
+		t.Errorf("expected error w/ ValidFrom in the past")
+	}
+	launchArgs.ValidFrom = now.Add(time.Hour)
+	if err := checkValidFrom(launchArgs, now); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	launchArgs.ValidUntil = now.Add(time.Minute)
+	if err := checkValidFrom(launchArgs, now); err == nil {
+		t.Errorf("expected error w/ ValidUntil before ValidFrom")
+	}
+	launchArgs.ValidUntil = time.Time{}
+	launchArgs.AssociateEip = true
+	if err := checkValidFrom(launchArgs, now); err == nil {
+		t.Errorf("expected error w/ AssociateEip")
+	}
+}
+
+func TestRunInstanceScheduled(t *testing.T) {
+	validFrom := time.Now().Add(time.Hour)
+	var fleetInput *ec2.CreateFleetInput
+	mock := &mockEc2Client{
+		createFleet: func(params *ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error) {
+			fleetInput = params
+			return &ec2.CreateFleetOutput{FleetId: aws.String("fleet-0")}, nil
+		},
+	}
+	validUntil := validFrom.Add(time.Hour)
+	launchArgs := &LaunchEc2SpotArgs{ValidFrom: validFrom,
+		ValidUntil: validUntil}
+	var launchResult LaunchEc2SpotResult
+	err := runInstance(context.Background(), aws.Config{Region: "us-east-1"},
+		mock, "lt-0", launchArgs, nil, &launchResult)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fleetInput.Type != types.FleetTypeRequest ||
+		!aws.ToTime(fleetInput.ValidFrom).Equal(validFrom) {
+		t.Errorf("expected request fleet valid from %v; got %v/%v", validFrom,
+			fleetInput.Type, fleetInput.ValidFrom)
+	}
+	if !aws.ToTime(fleetInput.ValidUntil).Equal(validUntil) {
+		t.Errorf("expected request fleet valid until %v; got %v", validUntil,
+			fleetInput.ValidUntil)
+	}
+	if fleetInput.SpotOptions.MinTargetCapacity != nil ||
+		fleetInput.SpotOptions.SingleAvailabilityZone != nil {
+		t.Errorf("expected instant only spot options to be cleared")
+	}
+	if aws.ToString(fleetInput.ClientToken) == "" {
+		t.Errorf("expected a generated client token")
+	}
+	if launchResult.FleetId != "fleet-0" || launchResult.InstanceId != "" {
+		t.Errorf("unexpected launch result %+v", launchResult)
+	}
+}
+
+func TestDeleteStaleScheduledLaunchTemplates(t *testing.T) {
+	now := time.Now()
+	pastName := "spotsh-lt-" + strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)
+	mock := &mockEc2Client{
+		describeLts: func(params *ec2.DescribeLaunchTemplatesInput) (*ec2.DescribeLaunchTemplatesOutput, error) {
+			return &ec2.DescribeLaunchTemplatesOutput{
+				LaunchTemplates: []types.LaunchTemplate{
+					{LaunchTemplateId: aws.String("lt-done"),
+						LaunchTemplateName: aws.String(pastName)},
+					{LaunchTemplateId: aws.String("lt-pending"),
+						LaunchTemplateName: aws.String("spotsh-lt-" +
+							strconv.FormatInt(now.Add(-2*time.Hour).Unix(), 10))},
+					{LaunchTemplateId: aws.String("lt-future"),
+						LaunchTemplateName: aws.String("spotsh-lt-" +
+							strconv.FormatInt(now.Add(time.Hour).Unix(), 10))},
+					{LaunchTemplateId: aws.String("lt-other"),
+						LaunchTemplateName: aws.String("spotsh-lt-mine")},
+					{LaunchTemplateId: aws.String("lt-token-old"),
+						LaunchTemplateName: aws.String(getClientTokenTemplateName("spotsh", "old")),
+						CreateTime:         aws.Time(now.Add(-25 * time.Hour))},
+					{LaunchTemplateId: aws.String("lt-token-new"),
+						LaunchTemplateName: aws.String(getClientTokenTemplateName("spotsh", "new")),
+						CreateTime:         aws.Time(now.Add(-time.Hour))},
+				},
+			}, nil
+		},
+		describeFleets: func(params *ec2.DescribeFleetsInput) (*ec2.DescribeFleetsOutput, error) {
+			ltSpec := func(ltId string) []types.FleetLaunchTemplateConfig {
+				return []types.FleetLaunchTemplateConfig{{
+					LaunchTemplateSpecification: &types.FleetLaunchTemplateSpecification{
+						LaunchTemplateId: aws.String(ltId),
+					},
+				}}
+			}
+			return &ec2.DescribeFleetsOutput{
+				Fleets: []types.FleetData{
+					{ActivityStatus: types.FleetActivityStatusFulfilled,
+						LaunchTemplateConfigs: ltSpec("lt-done")},
+					{ActivityStatus: types.FleetActivityStatusPendingFulfillment,
+						LaunchTemplateConfigs: ltSpec("lt-pending")},
+				},
+			}, nil
+		},
+	}
+	var deletedIds []string
+	mock.deleteLt = func(params *ec2.DeleteLaunchTemplateInput) (*ec2.DeleteLaunchTemplateOutput, error) {
+		deletedIds = append(deletedIds, aws.ToString(params.LaunchTemplateId))
+		return &ec2.DeleteLaunchTemplateOutput{}, nil
+	}
+
+	deleted, err := deleteStaleScheduledLaunchTemplates(context.Background(),
+		mock, "spotsh", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(deletedIds, []string{"lt-done", "lt-token-old"}) ||
+		len(deleted) != 2 {
+		t.Errorf("expected only lt-done & lt-token-old to be deleted; got %v",
+			deletedIds)
+	}
+}
+
+func TestLaunchClientTokenRetry(t *testing.T) {
+	mock := newMockEc2Client()
+	templates := make(map[string]string)
+	mock.describeLts = func(params *ec2.DescribeLaunchTemplatesInput) (*ec2.DescribeLaunchTemplatesOutput, error) {
+		output := &ec2.DescribeLaunchTemplatesOutput{}
+		for _, name := range params.LaunchTemplateNames {
+			if ltId, ok := templates[name]; ok {
+				output.LaunchTemplates = append(output.LaunchTemplates,
+					types.LaunchTemplate{
+						LaunchTemplateId:   aws.String(ltId),
+						LaunchTemplateName: aws.String(name),
+					})
+			}
+		}
+		return output, nil
+	}
+	mock.deleteLt = func(params *ec2.DeleteLaunchTemplateInput) (*ec2.DeleteLaunchTemplateOutput, error) {
+		for name, ltId := range templates {
+			if ltId == aws.ToString(params.LaunchTemplateId) {
+				delete(templates, name)
+			}
+		}
+		return &ec2.DeleteLaunchTemplateOutput{}, nil
+	}
+	created := 0
+	mock.createLt = func(params *ec2.CreateLaunchTemplateInput) (*ec2.CreateLaunchTemplateOutput, error) {
+		ltId := "lt-" + strconv.Itoa(created)
+		created++
+		templates[aws.ToString(params.LaunchTemplateName)] = ltId
+		return &ec2.CreateLaunchTemplateOutput{
+			LaunchTemplate: &types.LaunchTemplate{
+				LaunchTemplateId: aws.String(ltId),
+			},
+		}, nil
+	}
+	mock.describeImages = func(params *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+		return &ec2.DescribeImagesOutput{
+			Images: []types.Image{{RootDeviceName: aws.String("/dev/xvda")}},
+		}, nil
+	}
+	// EC2 rejects a retried token whose request differs from the original
+	fleetTemplates := make(map[string]string)
+	mock.createFleet = func(params *ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error) {
+		ltId := aws.ToString(params.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateId)
+		prevLtId, ok := fleetTemplates[aws.ToString(params.ClientToken)]
+		if ok && prevLtId != ltId {
+			return nil, fmt.Errorf("IdempotentParameterMismatch")
+		}
+		fleetTemplates[aws.ToString(params.ClientToken)] = ltId
+		return &ec2.CreateFleetOutput{
+			FleetId: aws.String("fleet-0"),
+			Instances: []types.CreateFleetInstance{
+				{InstanceIds: []string{"i-0"}},
+			},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+	awsCfg := aws.Config{Region: "us-east-1"}
+
+	for i := 0; i < 2; i++ {
+		launchArgs := &LaunchEc2SpotArgs{
+			AmiId:           "ami-0",
+			User:            "ec2-user",
+			KeyPair:         "mykey",
+			SecurityGroupId: "sg-0",
+			ClientToken:     "nightly-1",
+			NoWait:          true,
+		}
+		var launchResult LaunchEc2SpotResult
+		templateId, err := createLaunchTemplate(context.Background(), awsCfg,
+			mock, launchArgs, &launchResult)
+		if err != nil {
+			t.Fatalf("launch %v: failed to create template: %v", i, err)
+		}
+		err = runInstance(context.Background(), awsCfg, mock, templateId,
+			launchArgs, nil, &launchResult)
+		if err != nil {
+			t.Fatalf("launch %v: unexpected error: %v", i, err)
+		}
+		if launchResult.InstanceId != "i-0" {
+			t.Errorf("launch %v: expected i-0 but got %v", i,
+				launchResult.InstanceId)
+		}
+	}
+	if created != 1 {
+		t.Errorf("expected a single launch template but got %v", created)
+	}
+}
+
+func TestLookupEc2SpotAllRegionsKeepsProfile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer func() { DefaultRegions = nil }()
+	DefaultRegions = []string{"us-east-2", "us-west-2"}
+
+	// stands in for the credentials of the --profile main() loaded
+	profileCreds := credentials.NewStaticCredentialsProvider("prod-key",
+		"prod-secret", "")
+	mock := &mockEc2Client{
+		describeInstances: func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{}, nil
+		},
+		describeKeyPairs: func(*ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+			return &ec2.DescribeKeyPairsOutput{}, nil
+		},
+	}
+	var cfgLock sync.Mutex
+	regions := make(map[string]bool)
+	origNewEc2Client := newEc2Client
+	newEc2Client = func(awsCfg aws.Config) ec2Api {
+		creds, err := awsCfg.Credentials.Retrieve(context.Background())
+		if err != nil || creds.AccessKeyID != "prod-key" {
+			t.Errorf("expected profile credentials in %v; got %v err:%v",
+				awsCfg.Region, creds.AccessKeyID, err)
+		}
+		cfgLock.Lock()
+		regions[awsCfg.Region] = true
+		cfgLock.Unlock()
+		return mock
+	}
+	defer func() { newEc2Client = origNewEc2Client }()
+
+	_, err := LookupEc2Spot(context.Background(),
+		aws.Config{Region: "all", Credentials: profileCreds}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !regions["us-east-2"] || !regions["us-west-2"] {
+		t.Errorf("expected lookups in each region; got %v", regions)
+	}
+}
@@ -7,22 +7,28 @@ package aws
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"golang.org/x/sync/errgroup"
 )
 
 type LookupEc2SpotPriceAz struct {
-	AzName   string
-	CurPrice float64
+	AzName         string
+	CurPrice       float64
+	PlacementScore int32 // 1-10; 0 if unknown. see LookupSpotPlacementScores()
+
+	timestamp time.Time
 }
 
+// spotPriceHistoryPageSize is the maximum DescribeSpotPriceHistory permits
+const spotPriceHistoryPageSize = int32(1000)
+
 type LookupEc2SpotPriceRegion struct {
 	Region     string
 	Azs        map[string]*LookupEc2SpotPriceAz
@@ -46,6 +52,14 @@ type LookupEc2SpotPriceResult struct {
 func LookupEc2SpotPrices(awsCfg aws.Config,
 	iTypes []types.InstanceType) (*LookupEc2SpotPriceResult, error) {
 
+	return lookupEc2SpotPrices(awsCfg, iTypes, "")
+}
+
+// lookupEc2SpotPrices is LookupEc2SpotPrices restricted to a single az when
+// azName is non-empty
+func lookupEc2SpotPrices(awsCfg aws.Config, iTypes []types.InstanceType,
+	azName string) (*LookupEc2SpotPriceResult, error) {
+
 	var err error
 	var regionList []string
 
@@ -54,7 +68,7 @@ func LookupEc2SpotPrices(awsCfg aws.Config,
 	}
 
 	if awsCfg.Region == "all" {
-		regionList, err = getRegions()
+		regionList, err = getRegions(awsCfg)
 		if err != nil {
 			return nil, err
 		}
@@ -82,10 +96,13 @@ func LookupEc2SpotPrices(awsCfg aws.Config,
 	}
 
 	var wg errgroup.Group
+	tracker := newRegionTracker(len(regionList))
 	for _, curReg := range regionList {
 		curReg := curReg // https://golang.org/doc/faq#closures_and_goroutines
 		wg.Go(func() error {
-			return lookupEc2SpotPricesOneRegion(curReg, iTypes, result)
+			defer tracker.regionDone()
+			return lookupEc2SpotPricesOneRegion(getRegionConfig(awsCfg,
+				curReg), iTypes, azName, result)
 		})
 	}
 
@@ -93,6 +110,7 @@ func LookupEc2SpotPrices(awsCfg aws.Config,
 	if err != nil {
 		return nil, err
 	}
+	setCheapest(result)
 	if result.numAzs == 0 {
 		return nil, fmt.Errorf("None of %v appear to be available in region %v",
 			iTypes, awsCfg.Region)
@@ -101,96 +119,164 @@ func LookupEc2SpotPrices(awsCfg aws.Config,
 	return result, err
 }
 
-func lookupEc2SpotPricesOneRegion(curReg string, iTypes []types.InstanceType,
+func lookupEc2SpotPricesOneRegion(awsCfg aws.Config,
+	iTypes []types.InstanceType, azName string,
 	result *LookupEc2SpotPriceResult) error {
 
 	ctx := context.Background()
-	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(curReg))
-	if err != nil {
-		return err
-	}
-
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	curReg := awsCfg.Region
+	ec2Client := newEc2Client(awsCfg)
 	dryRun := false
+	// a start time in the future returns only each type & az's current price
 	startTime := time.Date(2199, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxResults := spotPriceHistoryPageSize
 	descInput := &ec2.DescribeSpotPriceHistoryInput{
 		DryRun:              &dryRun,
 		InstanceTypes:       iTypes,
 		ProductDescriptions: []string{"Linux/UNIX"},
 		StartTime:           &startTime,
+		MaxResults:          &maxResults,
 	}
-
-	descOutput, err := ec2Client.DescribeSpotPriceHistory(ctx, descInput)
-	if err != nil {
-		return err
+	if azName != "" {
+		descInput.AvailabilityZone = &azName
 	}
 
-	for _, entry := range descOutput.SpotPriceHistory {
-		iType := entry.InstanceType
-		azName := *entry.AvailabilityZone
-		curPrice, err := strconv.ParseFloat(*entry.SpotPrice, 64)
+	paginator := ec2.NewDescribeSpotPriceHistoryPaginator(ec2Client, descInput)
+	for paginator.HasMorePages() {
+		descOutput, err := paginator.NextPage(ctx)
 		if err != nil {
-			return fmt.Errorf("Failed to parse float %v for %v:%v:%v: %w",
-				entry.SpotPrice, iType, curReg, azName, err)
+			return err
 		}
-		lookupAz := &LookupEc2SpotPriceAz{
-			AzName:   azName,
-			CurPrice: curPrice,
+
+		for _, entry := range descOutput.SpotPriceHistory {
+			err = addSpotPriceEntry(curReg, entry, result)
+			if err != nil {
+				return err
+			}
 		}
+	}
 
-		result.mutex.Lock()
+	return nil
+}
 
-		result.numAzs++
-		result.InstanceTypes[iType].Regions[curReg].Azs[azName] = lookupAz
-		setCheapest(result, iType, curReg, azName, lookupAz)
+// addSpotPriceEntry records entry's price in result unless a more recent
+// price for the same instance type & az has already been recorded
+func addSpotPriceEntry(curReg string, entry types.SpotPrice,
+	result *LookupEc2SpotPriceResult) error {
+
+	iType := entry.InstanceType
+	azName := *entry.AvailabilityZone
+	curPrice, err := strconv.ParseFloat(*entry.SpotPrice, 64)
+	if err != nil {
+		return fmt.Errorf("Failed to parse float %v for %v:%v:%v: %w",
+			entry.SpotPrice, iType, curReg, azName, err)
+	}
+	lookupAz := &LookupEc2SpotPriceAz{
+		AzName:   azName,
+		CurPrice: curPrice,
+	}
+	if entry.Timestamp != nil {
+		lookupAz.timestamp = *entry.Timestamp
+	}
 
-		result.mutex.Unlock()
+	result.mutex.Lock()
+	defer result.mutex.Unlock()
+
+	lookupIType, ok := result.InstanceTypes[iType]
+	if !ok {
+		return nil
+	}
+	azs := lookupIType.Regions[curReg].Azs
+	prevAz, ok := azs[azName]
+	if ok && prevAz.timestamp.After(lookupAz.timestamp) {
+		return nil
 	}
+	if !ok {
+		result.numAzs++
+	}
+	azs[azName] = lookupAz
 
 	return nil
 }
 
-func setCheapest(result *LookupEc2SpotPriceResult, iType types.InstanceType,
-	reg string, azName string, lookupAz *LookupEc2SpotPriceAz) {
-
-	// set cheapest az in region for this iType
-	lookupReg := result.InstanceTypes[iType].Regions[reg]
-	if lookupReg.CheapestAz == nil {
-		lookupReg.CheapestAz = lookupAz
-	} else {
-		if lookupAz.CurPrice < lookupReg.CheapestAz.CurPrice {
-			lookupReg.CheapestAz = lookupAz
+// setCheapest determines the cheapest az per region, region per instance
+// type, and overall instance type. It is invoked once all prices have been
+// collected so that the result does not depend upon the order in which
+// concurrent per-region lookups complete; ties are broken by name so that
+// the selection is deterministic.
+func setCheapest(result *LookupEc2SpotPriceResult) {
+	result.CheapestIType = nil
+
+	for _, lookupIType := range result.InstanceTypes {
+		lookupIType.CheapestRegion = nil
+
+		for _, lookupReg := range lookupIType.Regions {
+			lookupReg.CheapestAz = nil
+
+			for _, lookupAz := range lookupReg.Azs {
+				if lookupReg.CheapestAz == nil ||
+					lookupAz.CurPrice < lookupReg.CheapestAz.CurPrice ||
+					(lookupAz.CurPrice == lookupReg.CheapestAz.CurPrice &&
+						lookupAz.AzName < lookupReg.CheapestAz.AzName) {
+					lookupReg.CheapestAz = lookupAz
+				}
+			}
+			if lookupReg.CheapestAz == nil {
+				continue
+			}
+
+			cheapestReg := lookupIType.CheapestRegion
+			if cheapestReg == nil ||
+				lookupReg.CheapestAz.CurPrice < cheapestReg.CheapestAz.CurPrice ||
+				(lookupReg.CheapestAz.CurPrice == cheapestReg.CheapestAz.CurPrice &&
+					lookupReg.Region < cheapestReg.Region) {
+				lookupIType.CheapestRegion = lookupReg
+			}
 		}
-	}
-
-	// set cheapest region for this iType
-	lookupIType := result.InstanceTypes[iType]
-	if lookupIType.CheapestRegion == nil {
-		lookupIType.CheapestRegion = lookupReg
-	} else {
-		if lookupAz.CurPrice < lookupIType.CheapestRegion.CheapestAz.CurPrice {
-			lookupIType.CheapestRegion = lookupReg
+		if lookupIType.CheapestRegion == nil {
+			continue
 		}
-	}
 
-	// set cheapest iType
-	if result.CheapestIType == nil {
-		result.CheapestIType = lookupIType
-	} else {
-		if lookupAz.CurPrice < result.CheapestIType.CheapestRegion.CheapestAz.CurPrice {
+		cheapestIType := result.CheapestIType
+		curPrice := lookupIType.CheapestRegion.CheapestAz.CurPrice
+		if cheapestIType == nil ||
+			curPrice < cheapestIType.CheapestRegion.CheapestAz.CurPrice ||
+			(curPrice == cheapestIType.CheapestRegion.CheapestAz.CurPrice &&
+				lookupIType.InstanceType < cheapestIType.InstanceType) {
 			result.CheapestIType = lookupIType
 		}
 	}
 }
 
-func getRegions() ([]string, error) {
-	ctx := context.Background()
-	awsCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-2"))
-	if err != nil {
-		return nil, err
+// DefaultRegions, when non-empty, is the subset of regions which region "all"
+// expands to in place of every enabled region
+var DefaultRegions []string
+
+// globalQueryRegion is where region independent lookups (e.g. the enabled
+// regions or instance type specs) are made when no single region applies
+const globalQueryRegion = "us-east-2"
+
+// getRegionConfig returns a copy of awsCfg targeting region; the copy
+// retains awsCfg's credentials (e.g. those of a --profile) which reloading
+// the default config would discard
+func getRegionConfig(awsCfg aws.Config, region string) aws.Config {
+	regCfg := awsCfg.Copy()
+	regCfg.Region = region
+
+	return regCfg
+}
+
+func getGlobalQueryConfig(awsCfg aws.Config) aws.Config {
+	return getRegionConfig(awsCfg, globalQueryRegion)
+}
+
+func getRegions(awsCfg aws.Config) ([]string, error) {
+	if len(DefaultRegions) > 0 {
+		return append([]string{}, DefaultRegions...), nil
 	}
 
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	ctx := context.Background()
+	ec2Client := newEc2Client(getGlobalQueryConfig(awsCfg))
 
 	dryRun := false
 	// only include regions that are not disabled
@@ -212,3 +298,94 @@ func getRegions() ([]string, error) {
 
 	return regList, nil
 }
+
+// LookupAccruedCost returns the approximate USD$ cost lr has accrued since
+// it launched. Spot instances are billed per second at the spot price in
+// effect for their type & az so their cost is derived from the spot price
+// history over the instance's lifetime rather than the current market
+// price. The result excludes EBS, data transfer, & the like.
+func LookupAccruedCost(awsCfg aws.Config,
+	lr *LaunchEc2SpotResult) (float64, error) {
+
+	if lr.Region != "" {
+		awsCfg = awsCfg.Copy()
+		awsCfg.Region = lr.Region
+	}
+	endTime := time.Now()
+	if lr.LaunchTime.IsZero() || !lr.LaunchTime.Before(endTime) {
+		return 0, nil
+	}
+	if lr.Lifecycle == MarketOnDemand {
+		price, err := LookupOnDemandPrice(awsCfg, lr.InstanceType)
+		if err != nil {
+			return 0, err
+		}
+		return price * endTime.Sub(lr.LaunchTime).Hours(), nil
+	}
+
+	ctx := context.Background()
+	ec2Client := newEc2Client(awsCfg)
+	maxResults := spotPriceHistoryPageSize
+	descInput := &ec2.DescribeSpotPriceHistoryInput{
+		AvailabilityZone:    aws.String(lr.AzName),
+		InstanceTypes:       []types.InstanceType{lr.InstanceType},
+		ProductDescriptions: []string{"Linux/UNIX"},
+		StartTime:           aws.Time(lr.LaunchTime),
+		EndTime:             aws.Time(endTime),
+		MaxResults:          &maxResults,
+	}
+	history := make([]types.SpotPrice, 0)
+	paginator := ec2.NewDescribeSpotPriceHistoryPaginator(ec2Client, descInput)
+	for paginator.HasMorePages() {
+		descOutput, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to get spot price history of %v: %w",
+				lr.InstanceId, err)
+		}
+		history = append(history, descOutput.SpotPriceHistory...)
+	}
+	if len(history) == 0 {
+		return 0, fmt.Errorf("No spot price history found for %v in %v",
+			lr.InstanceType, lr.AzName)
+	}
+
+	return integrateSpotPrices(history, lr.LaunchTime, endTime)
+}
+
+// integrateSpotPrices sums the cost of running from startTime to endTime
+// given the spot price changes in history. Each price applies from its
+// timestamp until the next change; the earliest price is assumed to also
+// apply prior to its timestamp.
+func integrateSpotPrices(history []types.SpotPrice, startTime time.Time,
+	endTime time.Time) (float64, error) {
+
+	sort.Slice(history, func(i, j int) bool {
+		return aws.ToTime(history[i].Timestamp).Before(
+			aws.ToTime(history[j].Timestamp))
+	})
+
+	cost := 0.0
+	for idx, entry := range history {
+		price, err := strconv.ParseFloat(aws.ToString(entry.SpotPrice), 64)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to parse float %v: %w",
+				aws.ToString(entry.SpotPrice), err)
+		}
+		segStart := aws.ToTime(entry.Timestamp)
+		if idx == 0 || segStart.Before(startTime) {
+			segStart = startTime
+		}
+		segEnd := endTime
+		if idx+1 < len(history) {
+			segEnd = aws.ToTime(history[idx+1].Timestamp)
+		}
+		if segEnd.After(endTime) {
+			segEnd = endTime
+		}
+		if segEnd.After(segStart) {
+			cost += price * segEnd.Sub(segStart).Hours()
+		}
+	}
+
+	return cost, nil
+}
@@ -0,0 +1,207 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package aws
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type testSpotPriceEntry struct {
+	iType  types.InstanceType
+	region string
+	azName string
+	price  float64
+}
+
+func newTestSpotPriceResult(entries []testSpotPriceEntry) *LookupEc2SpotPriceResult {
+	result := &LookupEc2SpotPriceResult{
+		InstanceTypes: make(map[types.InstanceType]*LookupEc2SpotPriceIType),
+		mutex:         &sync.Mutex{},
+	}
+	for _, entry := range entries {
+		lookupIType, ok := result.InstanceTypes[entry.iType]
+		if !ok {
+			lookupIType = &LookupEc2SpotPriceIType{
+				InstanceType: entry.iType,
+				Regions:      make(map[string]*LookupEc2SpotPriceRegion),
+			}
+			result.InstanceTypes[entry.iType] = lookupIType
+		}
+		lookupReg, ok := lookupIType.Regions[entry.region]
+		if !ok {
+			lookupReg = &LookupEc2SpotPriceRegion{
+				Region: entry.region,
+				Azs:    make(map[string]*LookupEc2SpotPriceAz),
+			}
+			lookupIType.Regions[entry.region] = lookupReg
+		}
+		lookupReg.Azs[entry.azName] = &LookupEc2SpotPriceAz{
+			AzName:   entry.azName,
+			CurPrice: entry.price,
+		}
+		result.numAzs++
+	}
+
+	return result
+}
+
+func TestSetCheapestOutOfOrder(t *testing.T) {
+	entries := []testSpotPriceEntry{
+		{types.InstanceTypeC5Large, "us-east-2", "us-east-2a", 0.05},
+		{types.InstanceTypeC5Large, "us-west-2", "us-west-2a", 0.04},
+		{types.InstanceTypeC5Large, "us-east-2", "us-east-2b", 0.02},
+		{types.InstanceTypeC6iLarge, "us-east-2", "us-east-2a", 0.03},
+		{types.InstanceTypeC6iLarge, "us-west-2", "us-west-2b", 0.025},
+		{types.InstanceTypeC5Large, "us-west-2", "us-west-2c", 0.06},
+	}
+
+	// feed the same entries in several different orders; the cheapest
+	// selections must not depend upon arrival order
+	for rotation := 0; rotation < len(entries); rotation++ {
+		feed := append(append([]testSpotPriceEntry{}, entries[rotation:]...),
+			entries[:rotation]...)
+		for reverse := 0; reverse < 2; reverse++ {
+			if reverse == 1 {
+				for i, j := 0, len(feed)-1; i < j; i, j = i+1, j-1 {
+					feed[i], feed[j] = feed[j], feed[i]
+				}
+			}
+
+			result := newTestSpotPriceResult(feed)
+			setCheapest(result)
+
+			if result.CheapestIType == nil ||
+				result.CheapestIType.InstanceType != types.InstanceTypeC5Large {
+				t.Fatalf("feed %v: unexpected cheapest itype %v", feed,
+					result.CheapestIType)
+			}
+			c5 := result.InstanceTypes[types.InstanceTypeC5Large]
+			if c5.CheapestRegion.Region != "us-east-2" ||
+				c5.CheapestRegion.CheapestAz.AzName != "us-east-2b" {
+				t.Fatalf("feed %v: unexpected c5 cheapest %v:%v", feed,
+					c5.CheapestRegion.Region, c5.CheapestRegion.CheapestAz.AzName)
+			}
+			if c5.Regions["us-west-2"].CheapestAz.AzName != "us-west-2a" {
+				t.Fatalf("feed %v: unexpected us-west-2 cheapest az %v", feed,
+					c5.Regions["us-west-2"].CheapestAz.AzName)
+			}
+			c6i := result.InstanceTypes[types.InstanceTypeC6iLarge]
+			if c6i.CheapestRegion.Region != "us-west-2" {
+				t.Fatalf("feed %v: unexpected c6i cheapest region %v", feed,
+					c6i.CheapestRegion.Region)
+			}
+		}
+	}
+}
+
+func TestGetRegionsDefaultRegions(t *testing.T) {
+	defer func() { DefaultRegions = nil }()
+	DefaultRegions = []string{"us-east-2", "us-west-2"}
+
+	regionList, err := getRegions(aws.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(regionList) != 2 || regionList[0] != "us-east-2" ||
+		regionList[1] != "us-west-2" {
+		t.Errorf("expected us-east-2,us-west-2 but got %v", regionList)
+	}
+}
+
+func TestLookupEc2SpotPricesPaginates(t *testing.T) {
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+	mock := newMockEc2Client()
+	var inputs []*ec2.DescribeSpotPriceHistoryInput
+	mock.describeSpotPrice = func(params *ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error) {
+		inputs = append(inputs, params)
+		if params.NextToken == nil {
+			return &ec2.DescribeSpotPriceHistoryOutput{
+				NextToken: aws.String("page2"),
+				SpotPriceHistory: []types.SpotPrice{
+					{
+						AvailabilityZone: aws.String("us-east-2a"),
+						InstanceType:     types.InstanceTypeC5Large,
+						SpotPrice:        aws.String("0.03"),
+						Timestamp:        &newer,
+					},
+				},
+			}, nil
+		}
+		return &ec2.DescribeSpotPriceHistoryOutput{
+			SpotPriceHistory: []types.SpotPrice{
+				{
+					AvailabilityZone: aws.String("us-east-2a"),
+					InstanceType:     types.InstanceTypeC5Large,
+					SpotPrice:        aws.String("0.09"),
+					Timestamp:        &older,
+				},
+				{
+					AvailabilityZone: aws.String("us-east-2b"),
+					InstanceType:     types.InstanceTypeC5Large,
+					SpotPrice:        aws.String("0.02"),
+					Timestamp:        &older,
+				},
+			},
+		}, nil
+	}
+	useMockEc2Client(t, mock)
+
+	result, err := lookupEc2SpotPrices(aws.Config{Region: "us-east-2"},
+		[]types.InstanceType{types.InstanceTypeC5Large}, "us-east-2a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("expected 2 pages to be fetched but got %v", len(inputs))
+	}
+	if inputs[0].AvailabilityZone == nil ||
+		*inputs[0].AvailabilityZone != "us-east-2a" {
+		t.Errorf("expected az filter us-east-2a")
+	}
+	if inputs[0].MaxResults == nil ||
+		*inputs[0].MaxResults != spotPriceHistoryPageSize {
+		t.Errorf("expected MaxResults %v", spotPriceHistoryPageSize)
+	}
+
+	azs := result.InstanceTypes[types.InstanceTypeC5Large].Regions["us-east-2"].Azs
+	if azs["us-east-2a"].CurPrice != 0.03 {
+		t.Errorf("expected latest price 0.03 but got %v",
+			azs["us-east-2a"].CurPrice)
+	}
+	if azs["us-east-2b"].CurPrice != 0.02 {
+		t.Errorf("expected 0.02 from 2nd page but got %v",
+			azs["us-east-2b"].CurPrice)
+	}
+}
+
+func TestIntegrateSpotPrices(t *testing.T) {
+	start := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	history := []types.SpotPrice{
+		{SpotPrice: aws.String("0.20"), Timestamp: aws.Time(start.Add(time.Hour))},
+		{SpotPrice: aws.String("0.10"), Timestamp: aws.Time(start.Add(-time.Hour))},
+	}
+
+	// 1hr @ 0.10 followed by 2hrs @ 0.20
+	cost, err := integrateSpotPrices(history, start, start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("failed to integrate: %v", err)
+	}
+	if cost < 0.4999 || cost > 0.5001 {
+		t.Errorf("expected cost 0.50 but got %v", cost)
+	}
+
+	history[0].SpotPrice = aws.String("bogus")
+	_, err = integrateSpotPrices(history, start, start.Add(time.Hour))
+	if err == nil {
+		t.Errorf("expected error w/ unparseable price")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -18,13 +19,19 @@ import (
 )
 
 func GetDefaultSecurityGroupId(awsCfg aws.Config) (string, error) {
-	ec2Client := ec2.NewFromConfig(awsCfg)
+	ec2Client := newEc2Client(awsCfg)
 
 	return getDefaultSecurityGroupId(awsCfg, ec2Client)
 }
 
-func getExternalIP() (string, error) {
-	resp, err := http.Get("https://api.ipify.org?format=text")
+// external address lookup services; the ipv6 variant only answers over ipv6
+const (
+	externalIpv4Url = "https://api.ipify.org?format=text"
+	externalIpv6Url = "https://api6.ipify.org?format=text"
+)
+
+func getExternalIP(url string) (string, error) {
+	resp, err := http.Get(url)
 	if err != nil {
 		return "", err
 	}
@@ -42,39 +49,72 @@ func getExternalIP() (string, error) {
 	return string(ip), nil
 }
 
-func addSshIngressRule(ctx context.Context, host string, ec2Client *ec2.Client,
-	sgId string) error {
+// DefaultSshPort is the port on which sshd listens unless an AMI moved it
+const DefaultSshPort = int32(22)
 
-	myIp, err := getExternalIP()
-	if err != nil {
-		return err
-	}
-	cidrBlock := fmt.Sprintf("%v/32", myIp)
-	permissions := []types.IpPermission{
-		{
-			IpProtocol: aws.String("tcp"),
-			FromPort:   aws.Int32(22),
-			ToPort:     aws.Int32(22),
-			IpRanges: []types.IpRange{
-				{
-					CidrIp:      aws.String(cidrBlock),
-					Description: aws.String(fmt.Sprintf("allow ssh from %v (added by spotsh)", host)),
-				},
+func newSshIngressPermission(host string, myIp string, ipv6 bool,
+	port int32) types.IpPermission {
+
+	description := aws.String(fmt.Sprintf("allow ssh from %v (added by spotsh)",
+		host))
+	if port != DefaultSshPort {
+		description = aws.String(fmt.Sprintf("allow ssh port %v from %v (added by spotsh)",
+			port, host))
+	}
+	permission := types.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int32(port),
+		ToPort:     aws.Int32(port),
+	}
+	if ipv6 {
+		permission.Ipv6Ranges = []types.Ipv6Range{
+			{
+				CidrIpv6:    aws.String(fmt.Sprintf("%v/128", myIp)),
+				Description: description,
 			},
-		},
+		}
+	} else {
+		permission.IpRanges = []types.IpRange{
+			{
+				CidrIp:      aws.String(fmt.Sprintf("%v/32", myIp)),
+				Description: description,
+			},
+		}
 	}
 
+	return permission
+}
+
+func addSshIngressRule(ctx context.Context, host string, ec2Client ec2Api,
+	sgId string, myIp string, ipv6 bool, port int32) error {
+
 	input := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId:       aws.String(sgId),
-		IpPermissions: permissions,
+		GroupId: aws.String(sgId),
+		IpPermissions: []types.IpPermission{
+			newSshIngressPermission(host, myIp, ipv6, port),
+		},
 	}
 
-	_, err = ec2Client.AuthorizeSecurityGroupIngress(ctx, input)
+	_, err := ec2Client.AuthorizeSecurityGroupIngress(ctx, input)
 	return err
 }
 
-func hasSshIngressRule(ctx context.Context, host string, ec2Client *ec2.Client,
-	sgId string) bool {
+// permissionAllowsPort returns true if perm applies to the specified tcp
+// port
+func permissionAllowsPort(perm types.IpPermission, port int32) bool {
+	if aws.ToString(perm.IpProtocol) == "-1" {
+		// all traffic
+		return true
+	}
+	if perm.FromPort == nil || perm.ToPort == nil {
+		return true
+	}
+
+	return *perm.FromPort <= port && port <= *perm.ToPort
+}
+
+func hasSshIngressRule(ctx context.Context, host string, ec2Client ec2Api,
+	sgId string, ipv6 bool, port int32) bool {
 
 	input := &ec2.DescribeSecurityGroupsInput{
 		GroupIds: []string{sgId},
@@ -88,15 +128,23 @@ func hasSshIngressRule(ctx context.Context, host string, ec2Client *ec2.Client,
 
 	for _, sg := range resp.SecurityGroups {
 		for _, perm := range sg.IpPermissions {
-			for _, descr := range perm.IpRanges {
-				if strings.Contains(*descr.Description, "ssh") &&
-					strings.Contains(*descr.Description, host) {
-					return true
+			if !permissionAllowsPort(perm, port) {
+				continue
+			}
+			if !ipv6 {
+				for _, descr := range perm.IpRanges {
+					if descr.Description != nil &&
+						strings.Contains(*descr.Description, "ssh") &&
+						strings.Contains(*descr.Description, host) {
+						return true
+					}
 				}
+				continue
 			}
 
 			for _, descr := range perm.Ipv6Ranges {
-				if strings.Contains(*descr.Description, "ssh") &&
+				if descr.Description != nil &&
+					strings.Contains(*descr.Description, "ssh") &&
 					strings.Contains(*descr.Description, host) {
 					return true
 				}
@@ -107,8 +155,13 @@ func hasSshIngressRule(ctx context.Context, host string, ec2Client *ec2.Client,
 	return false
 }
 
-func CheckOrAddSshIngressRule(awsCfg aws.Config, sgId string) error {
-	ec2Client := ec2.NewFromConfig(awsCfg)
+// CheckOrAddSshIngressRule ensures sgId permits ssh on the specified port
+// from this host's public ipv4 address and, when ipv6 is set & this host has
+// ipv6 connectivity, from its public ipv6 address as well
+func CheckOrAddSshIngressRule(awsCfg aws.Config, sgId string, ipv6 bool,
+	port int32) error {
+
+	ec2Client := newEc2Client(awsCfg)
 	host, err := os.Hostname()
 	if err != nil {
 		host = "localhost"
@@ -116,15 +169,30 @@ func CheckOrAddSshIngressRule(awsCfg aws.Config, sgId string) error {
 
 	ctx := context.Background()
 
-	if hasSshIngressRule(ctx, host, ec2Client, sgId) {
+	if !hasSshIngressRule(ctx, host, ec2Client, sgId, false, port) {
+		myIp, err := getExternalIP(externalIpv4Url)
+		if err != nil {
+			return err
+		}
+		err = addSshIngressRule(ctx, host, ec2Client, sgId, myIp, false, port)
+		if err != nil {
+			return err
+		}
+	}
+	if !ipv6 || hasSshIngressRule(ctx, host, ec2Client, sgId, true, port) {
+		return nil
+	}
+	myIpv6, err := getExternalIP(externalIpv6Url)
+	if err != nil {
+		// this host lacks ipv6 connectivity so there's nothing to allow
 		return nil
 	}
 
-	return addSshIngressRule(context.Background(), host, ec2Client, sgId)
+	return addSshIngressRule(ctx, host, ec2Client, sgId, myIpv6, true, port)
 }
 
 func getDefaultSecurityGroupId(awsCfg aws.Config,
-	ec2Client *ec2.Client) (string, error) {
+	ec2Client ec2Api) (string, error) {
 
 	dryRun := false
 	maxResults := int32(1000)
@@ -147,8 +215,12 @@ func getDefaultSecurityGroupId(awsCfg aws.Config,
 		break
 	}
 	if vpcId == "" {
-		if len(descVpcsOutput.Vpcs) != 1 {
-			return "", fmt.Errorf("Could not find default VPC")
+		if len(descVpcsOutput.Vpcs) == 0 {
+			return "", fmt.Errorf("%w: no VPCs exist in %v", ErrNoDefaultVpc,
+				awsCfg.Region)
+		}
+		if len(descVpcsOutput.Vpcs) > 1 {
+			return "", newAmbiguousVpcError(ctx, awsCfg, ec2Client)
 		}
 		// if there's only 1 VPC then it's the only reasonable choice even
 		// if it is not EC2's notion of 'default VPC'
@@ -201,21 +273,70 @@ type LookupVpcSgsResult struct {
 	Vpcs map[string]*LookupVpcSgsVpc
 }
 
+func newAmbiguousVpcError(ctx context.Context, awsCfg aws.Config,
+	ec2Client ec2Api) error {
+
+	lookupVpcSgsResult, err := lookupVpcSecurityGroups(ctx, ec2Client)
+	if err != nil {
+		return fmt.Errorf("%w in %v and failed to list VPCs: %w",
+			ErrAmbiguousVpc, awsCfg.Region, err)
+	}
+
+	return fmt.Errorf("%w in %v (%v VPCs); please specify a security group via --sgid or 'spotsh config'. Available security groups:\n%v",
+		ErrAmbiguousVpc, awsCfg.Region, len(lookupVpcSgsResult.Vpcs),
+		lookupVpcSgsResult.String())
+}
+
 func LookupVpcSecurityGroups(awsCfg aws.Config) (LookupVpcSgsResult, error) {
+	ec2Client := newEc2Client(awsCfg)
+
+	return lookupVpcSecurityGroups(context.Background(), ec2Client)
+}
+
+// String returns an indented listing of each VPC and its security groups
+// sorted by id
+func (lookupVpcSgsResult LookupVpcSgsResult) String() string {
+	var sb strings.Builder
+
+	vpcIds := make([]string, 0, len(lookupVpcSgsResult.Vpcs))
+	for vpcId := range lookupVpcSgsResult.Vpcs {
+		vpcIds = append(vpcIds, vpcId)
+	}
+	sort.Strings(vpcIds)
+	for _, vpcId := range vpcIds {
+		vpc := lookupVpcSgsResult.Vpcs[vpcId]
+		if vpc.Default {
+			sb.WriteString(fmt.Sprintf("    Vpc %v (default):\n", vpc.Id))
+		} else {
+			sb.WriteString(fmt.Sprintf("    Vpc %v:\n", vpc.Id))
+		}
+		sgIds := make([]string, 0, len(vpc.Sgs))
+		for sgId := range vpc.Sgs {
+			sgIds = append(sgIds, sgId)
+		}
+		sort.Strings(sgIds)
+		for _, sgId := range sgIds {
+			sb.WriteString(fmt.Sprintf("      %v (%v)\n", sgId,
+				vpc.Sgs[sgId].Name))
+		}
+	}
+
+	return sb.String()
+}
+
+func lookupVpcSecurityGroups(ctx context.Context,
+	ec2Client ec2Api) (LookupVpcSgsResult, error) {
 
 	lookupVpcSgsResult := LookupVpcSgsResult{
 		Vpcs: make(map[string]*LookupVpcSgsVpc),
 	}
 
-	ec2Client := ec2.NewFromConfig(awsCfg)
-
 	dryRun := false
 	maxResults := int32(1000)
 	descVpcsInput := &ec2.DescribeVpcsInput{
 		DryRun:     &dryRun,
 		MaxResults: &maxResults,
 	}
-	ctx := context.Background()
 	descVpcsOutput, err := ec2Client.DescribeVpcs(ctx, descVpcsInput)
 	if err != nil {
 		return lookupVpcSgsResult, err
@@ -257,7 +378,7 @@ func LookupVpcSecurityGroups(awsCfg aws.Config) (LookupVpcSgsResult, error) {
 	return lookupVpcSgsResult, nil
 }
 
-func getAzNameFromSubnetId(ec2Client *ec2.Client, azMap map[string]string,
+func getAzNameFromSubnetId(ec2Client ec2Api, azMap map[string]string,
 	subnetId string) (string, error) {
 
 	azName, ok := azMap[subnetId]
@@ -282,7 +403,7 @@ func getAzNameFromSubnetId(ec2Client *ec2.Client, azMap map[string]string,
 	return azMap[subnetId], nil
 }
 
-func getSubnetIdFromAzName(ec2Client *ec2.Client, azName string) (string, error) {
+func getSubnetIdFromAzName(ec2Client ec2Api, azName string) (string, error) {
 	dryRun := false
 	descIn := &ec2.DescribeSubnetsInput{
 		DryRun: &dryRun,
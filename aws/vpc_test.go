@@ -9,8 +9,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
 
 func TestGetDefaultSecurityGroupId(t *testing.T) {
@@ -59,3 +61,61 @@ func TestLookupVpcSecurityGroups(t *testing.T) {
 		}
 	}
 }
+
+func TestSshIngressRuleIpv6(t *testing.T) {
+	v4Perm := newSshIngressPermission("myhost", "198.51.100.1", false,
+		DefaultSshPort)
+	if len(v4Perm.IpRanges) != 1 || len(v4Perm.Ipv6Ranges) != 0 ||
+		*v4Perm.IpRanges[0].CidrIp != "198.51.100.1/32" {
+		t.Errorf("unexpected ipv4 permission %+v", v4Perm)
+	}
+	v6Perm := newSshIngressPermission("myhost", "2001:db8::1", true,
+		DefaultSshPort)
+	if len(v6Perm.Ipv6Ranges) != 1 || len(v6Perm.IpRanges) != 0 ||
+		*v6Perm.Ipv6Ranges[0].CidrIpv6 != "2001:db8::1/128" {
+		t.Errorf("unexpected ipv6 permission %+v", v6Perm)
+	}
+
+	mock := newMockEc2Client()
+	mock.describeSgs = func(*ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+		return &ec2.DescribeSecurityGroupsOutput{
+			SecurityGroups: []types.SecurityGroup{{
+				IpPermissions: []types.IpPermission{v4Perm},
+			}},
+		}, nil
+	}
+	ctx := context.Background()
+	if !hasSshIngressRule(ctx, "myhost", mock, "sg-0", false, DefaultSshPort) {
+		t.Errorf("expected ipv4 ssh rule to be found")
+	}
+	if hasSshIngressRule(ctx, "myhost", mock, "sg-0", true, DefaultSshPort) {
+		t.Errorf("expected ipv4 ssh rule to not satisfy ipv6")
+	}
+}
+
+func TestSshIngressRulePort(t *testing.T) {
+	perm := newSshIngressPermission("myhost", "198.51.100.1", false, 2222)
+	if aws.ToInt32(perm.FromPort) != 2222 || aws.ToInt32(perm.ToPort) != 2222 ||
+		!strings.Contains(*perm.IpRanges[0].Description, "2222") {
+		t.Errorf("unexpected port 2222 permission %+v", perm)
+	}
+
+	mock := newMockEc2Client()
+	mock.describeSgs = func(*ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+		return &ec2.DescribeSecurityGroupsOutput{
+			SecurityGroups: []types.SecurityGroup{{
+				IpPermissions: []types.IpPermission{
+					newSshIngressPermission("myhost", "198.51.100.1", false,
+						DefaultSshPort),
+				},
+			}},
+		}, nil
+	}
+	ctx := context.Background()
+	if hasSshIngressRule(ctx, "myhost", mock, "sg-0", false, 2222) {
+		t.Errorf("expected port 22 rule to not satisfy port 2222")
+	}
+	if !hasSshIngressRule(ctx, "myhost", mock, "sg-0", false, DefaultSshPort) {
+		t.Errorf("expected port 22 rule to be found")
+	}
+}
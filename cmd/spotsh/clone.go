@@ -0,0 +1,50 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func cloneMain(awsCfg aws.Config, args []string) error {
+	selectedInstance, _, err := selectOrLaunchWithArgs(&awsCfg, "spotsh clone",
+		false, &args, nil)
+	if err != nil {
+		return err
+	}
+
+	prefsLaunchArgs, err := newLaunchArgsFromPrefs(awsCfg)
+	if err != nil {
+		return err
+	}
+	launchArgs, err := iaws.NewLaunchArgsFromInstance(awsCfg,
+		selectedInstance.InstanceId)
+	if err != nil {
+		return fmt.Errorf("Failed to describe instance %v: %w",
+			selectedInstance.InstanceId, err)
+	}
+	launchArgs.MaxSpotPrice = prefsLaunchArgs.MaxSpotPrice
+
+	fmt.Fprintf(os.Stderr, "Cloning %v (%v)...\n", selectedInstance.InstanceId,
+		selectedInstance.InstanceType)
+
+	ctx, cancel := newLaunchContext()
+	defer cancel()
+	launchResult, err := iaws.LaunchEc2Spot(ctx, awsCfg, launchArgs)
+	if err != nil {
+		return err
+	}
+	err = runPostLaunchHook(awsCfg, &launchResult, false)
+	if err != nil {
+		return err
+	}
+
+	return printLaunchResult("Launched", &launchResult, "")
+}
@@ -0,0 +1,118 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+const (
+	PrefSourceFile    = "file"
+	PrefSourceDefault = "default"
+)
+
+type effectivePref struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func configShowMain(awsCfg aws.Config, out io.Writer, asJson bool) error {
+	configFilePath, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	prefs := newPrefs()
+	err = loadConfigPrefs(awsCfg, configFilePath, prefs)
+	if err != nil {
+		return err
+	}
+
+	effPrefs := getEffectivePrefs(awsCfg, prefs)
+	if asJson {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(effPrefs)
+	}
+
+	fmt.Fprintf(out, "Preferences file: %v\n", configFilePath)
+	for _, pref := range effPrefs {
+		fmt.Fprintf(out, "  %-20v %v (%v)\n", pref.Name+":", pref.Value,
+			pref.Source)
+	}
+
+	return nil
+}
+
+// getEffectivePrefs merges prefs w/ the defaults spotsh would otherwise use
+// in awsCfg's region, noting which of the two each value came from
+func getEffectivePrefs(awsCfg aws.Config, prefs *Prefs) []effectivePref {
+	effPrefs := make([]effectivePref, 0)
+	add := func(name string, fileValue string, defaultValue string) {
+		pref := effectivePref{
+			Name:   name,
+			Value:  fileValue,
+			Source: PrefSourceFile,
+		}
+		if fileValue == "" {
+			pref.Value = defaultValue
+			pref.Source = PrefSourceDefault
+		}
+		effPrefs = append(effPrefs, pref)
+	}
+	boolStr := func(value bool) string {
+		if !value {
+			return ""
+		}
+		return "true"
+	}
+
+	add("os", prefs.Os, iaws.DefaultOperatingSystem.String())
+	add("instance types", strings.Join(prefs.InstanceTypes, ","),
+		iTypeSlice2String(iaws.DefaultInstanceTypes))
+	add("keypair", prefs.keyPair, iaws.GetDefaultKeyName(awsCfg))
+	add("security group", prefs.securityGroup,
+		"<default VPC's default security group>")
+	add("max spot price", prefs.MaxSpotPrice, iaws.DefaultMaxSpotPrice)
+	rootVolSize := ""
+	if prefs.RootVolSizeInGiB != 0 {
+		rootVolSize = fmt.Sprintf("%v GiB", prefs.RootVolSizeInGiB)
+	}
+	add("root vol size", rootVolSize,
+		fmt.Sprintf("%v GiB", iaws.DefaultRootVolSizeInGiB))
+	add("forward agent", boolStr(prefs.ForwardAgent), "false")
+	sshPortStr := ""
+	if prefs.SshPort != 0 {
+		sshPortStr = fmt.Sprintf("%v", prefs.SshPort)
+	}
+	add("ssh port", sshPortStr, fmt.Sprintf("%v", iaws.DefaultSshPort))
+	add("no default key", boolStr(prefs.NoDefaultKey), "false")
+	add("reads default to --region all",
+		boolStr(prefs.DefaultAllRegionsForReads), "false")
+	add("--region all", strings.Join(prefs.Regions, ","),
+		"<all enabled regions>")
+	profileRegions := make([]string, 0, len(prefs.ProfileRegions))
+	for profile, region := range prefs.ProfileRegions {
+		profileRegions = append(profileRegions, profile+"="+region)
+	}
+	sort.Strings(profileRegions)
+	add("profile regions", strings.Join(profileRegions, ","), "<none>")
+	profileNames := make([]string, 0, len(prefs.Profiles))
+	for name := range prefs.Profiles {
+		profileNames = append(profileNames, name)
+	}
+	sort.Strings(profileNames)
+	add("profiles", strings.Join(profileNames, ","), "<none>")
+
+	return effPrefs
+}
@@ -0,0 +1,43 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestGetEffectivePrefs(t *testing.T) {
+	prefs := newPrefs()
+	prefs.Os = "ubuntu22.04"
+	prefs.RootVolSizeInGiB = 128
+	prefs.keyPair = "mykey"
+	prefs.ProfileRegions = map[string]string{
+		"prod": "eu-west-1",
+		"dev":  "us-east-1",
+	}
+
+	effPrefs := getEffectivePrefs(aws.Config{Region: "us-east-2"}, prefs)
+	byName := make(map[string]effectivePref)
+	for _, pref := range effPrefs {
+		byName[pref.Name] = pref
+	}
+
+	expected := map[string]effectivePref{
+		"os":             {"os", "ubuntu22.04", PrefSourceFile},
+		"root vol size":  {"root vol size", "128 GiB", PrefSourceFile},
+		"keypair":        {"keypair", "mykey", PrefSourceFile},
+		"max spot price": {"max spot price", "0.08", PrefSourceDefault},
+		"forward agent":  {"forward agent", "false", PrefSourceDefault},
+		"profile regions": {"profile regions", "dev=us-east-1,prod=eu-west-1",
+			PrefSourceFile},
+	}
+	for name, pref := range expected {
+		if byName[name] != pref {
+			t.Errorf("expected %v but got %v", pref, byName[name])
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func costMain(awsCfg aws.Config, args []string) error {
+	var days int
+	f := flag.NewFlagSet("spotsh cost", flag.ContinueOnError)
+	f.IntVar(&days, "days", 7, "Number of days, including today, to report")
+	err := parseWithRegionFlags(&awsCfg, f, args)
+	if err != nil {
+		return err
+	}
+	if days <= 0 {
+		return fmt.Errorf("--days must be positive")
+	}
+
+	entries, err := iaws.LookupSpotshCosts(awsCfg, iaws.DefaultTagPrefix,
+		days)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No spotsh spend found over the last %v day(s)\n", days)
+		fmt.Printf("Note that %v.%v must be activated as a cost allocation tag in the AWS Billing console for Cost Explorer to attribute spend to spotsh\n",
+			iaws.DefaultTagPrefix, iaws.UserTagSuffix)
+		return nil
+	}
+
+	return printCosts(os.Stdout, entries)
+}
+
+func printCosts(out io.Writer, entries []iaws.CostEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Date != entries[j].Date {
+			return entries[i].Date < entries[j].Date
+		}
+		if entries[i].Region != entries[j].Region {
+			return entries[i].Region < entries[j].Region
+		}
+		return entries[i].InstanceType < entries[j].InstanceType
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DATE\tTYPE\tREGION\tCOST\n")
+	total := 0.0
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%v\t%v\t%v\t$%.2f\n", entry.Date, entry.InstanceType,
+			entry.Region, entry.Amount)
+		total += entry.Amount
+	}
+	fmt.Fprintf(w, "TOTAL\t\t\t$%.2f\n", total)
+
+	return w.Flush()
+}
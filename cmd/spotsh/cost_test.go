@@ -0,0 +1,33 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func TestPrintCosts(t *testing.T) {
+	entries := []iaws.CostEntry{
+		{Date: "2024-06-02", InstanceType: "c7i.large", Region: "us-east-2",
+			Amount: 1.5},
+		{Date: "2024-06-01", InstanceType: "c5a.large", Region: "us-west-2",
+			Amount: 0.25},
+	}
+
+	var out bytes.Buffer
+	err := printCosts(&out, entries)
+	if err != nil {
+		t.Fatalf("failed to print costs: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "2024-06-01") ||
+		!strings.HasSuffix(lines[3], "$1.75") {
+		t.Errorf("unexpected output:\n%v", out.String())
+	}
+}
@@ -0,0 +1,186 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+type instanceField struct {
+	header string
+	value  func(lr *iaws.LaunchEc2SpotResult) string
+}
+
+// instanceFieldTab maps each name accepted by info --fields to its column
+var instanceFieldTab = map[string]instanceField{
+	"id": {"ID", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.InstanceId
+	}},
+	"ip": {"PUBLICIP", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.PublicIp
+	}},
+	"privateip": {"PRIVATEIP", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.PrivateIp
+	}},
+	"ipv6": {"IPV6", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.Ipv6
+	}},
+	"user": {"USER", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.User
+	}},
+	"type": {"TYPE", func(lr *iaws.LaunchEc2SpotResult) string {
+		return string(lr.InstanceType)
+	}},
+	"image": {"IMAGEID", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.ImageId
+	}},
+	"key": {"LOCALKEYFILE", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.LocalKeyFile
+	}},
+	"price": {"MARKETPRICE", func(lr *iaws.LaunchEc2SpotResult) string {
+		return fmt.Sprintf("$%v/hr", lr.CurrentPrice)
+	}},
+	"maxprice": {"MAXPRICE", func(lr *iaws.LaunchEc2SpotResult) string {
+		if lr.MaxSpotPrice == "" {
+			return ""
+		}
+		return fmt.Sprintf("$%v/hr", lr.MaxSpotPrice)
+	}},
+	"az": {"AZ", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.AzName
+	}},
+	"dns": {"DNSNAME", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.DnsName
+	}},
+	"os": {"OS", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.Os.String()
+	}},
+	"region": {"REGION", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.Region
+	}},
+	"launchtime": {"LAUNCHTIME", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.LaunchTime.String()
+	}},
+	"sg": {"SGID", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.SgId
+	}},
+	"lifecycle": {"LIFECYCLE", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.Lifecycle
+	}},
+	"version": {"VERSION", func(lr *iaws.LaunchEc2SpotResult) string {
+		return lr.SpotshVersion
+	}},
+}
+
+// instanceFieldOrder is the order in which field names are listed in error
+// messages
+var instanceFieldOrder = []string{"id", "ip", "privateip", "ipv6", "user",
+	"type", "image", "key", "price", "maxprice", "az", "dns", "os", "region", "launchtime",
+	"sg", "lifecycle", "version"}
+
+func parseInstanceFields(fieldList string) ([]instanceField, error) {
+	var fields []instanceField
+
+	for _, name := range strings.Split(fieldList, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		field, ok := instanceFieldTab[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown field '%v'; valid fields are: %v",
+				name, strings.Join(instanceFieldOrder, ","))
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("--fields requires at least one field")
+	}
+
+	return fields, nil
+}
+
+// printInstanceFields writes one column aligned row per instance containing
+// only the specified fields
+func printInstanceFields(out io.Writer, launchResults []iaws.LaunchEc2SpotResult,
+	fields []instanceField) error {
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	headers := make([]string, 0, len(fields))
+	for _, field := range fields {
+		headers = append(headers, field.header)
+	}
+	fmt.Fprintf(w, "%v\n", strings.Join(headers, "\t"))
+
+	for idx := range launchResults {
+		values := make([]string, 0, len(fields))
+		for _, field := range fields {
+			values = append(values, field.value(&launchResults[idx]))
+		}
+		fmt.Fprintf(w, "%v\n", strings.Join(values, "\t"))
+	}
+
+	return w.Flush()
+}
+
+// printInstancesCsv emits one row per instance sorted by region & then
+// instance id
+func printInstancesCsv(out io.Writer,
+	launchResults []iaws.LaunchEc2SpotResult) error {
+
+	sorted := make([]*iaws.LaunchEc2SpotResult, 0, len(launchResults))
+	for idx := range launchResults {
+		sorted = append(sorted, &launchResults[idx])
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Region != sorted[j].Region {
+			return sorted[i].Region < sorted[j].Region
+		}
+		return sorted[i].InstanceId < sorted[j].InstanceId
+	})
+
+	w := csv.NewWriter(out)
+	err := w.Write([]string{"instanceId", "publicIp", "user", "instanceType",
+		"os", "az", "region", "price", "launchTime"})
+	if err != nil {
+		return err
+	}
+	for _, lr := range sorted {
+		launchTime := ""
+		if !lr.LaunchTime.IsZero() {
+			launchTime = lr.LaunchTime.UTC().Format(time.RFC3339)
+		}
+		err = w.Write([]string{
+			lr.InstanceId,
+			lr.PublicIp,
+			lr.User,
+			string(lr.InstanceType),
+			lr.Os.String(),
+			lr.AzName,
+			lr.Region,
+			strconv.FormatFloat(lr.CurrentPrice, 'f', -1, 64),
+			launchTime,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	err = w.Error()
+	if err != nil {
+		return fmt.Errorf("Failed to write csv: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+const (
+	PostLaunchHookName   = "post-launch"
+	PreTerminateHookName = "pre-terminate"
+	DefaultDrainTimeout  = 5 * time.Minute
+)
+
+// runHook runs the user's hookCmd from their preferences via the shell w/
+// details of lr passed as SPOTSH_* environment variables. hookCmd's stdout
+// is redirected to stderr so that it can't interfere w/ spotsh's own
+// output (e.g. launch --print). Failures are only returned when strict is
+// set; otherwise they are reported as warnings.
+func runHook(hookName string, hookCmd string, lr *iaws.LaunchEc2SpotResult,
+	strict bool) error {
+
+	if hookCmd == "" {
+		return nil
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", hookCmd)
+	cmd.Env = append(os.Environ(), getHookEnv(hookName, lr)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("%v hook '%v' failed for %v: %w", hookName, hookCmd,
+		lr.InstanceId, err)
+	if strict {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+
+	return nil
+}
+
+// drainInstance runs drainCmd on lr ahead of terminating it, waiting at most
+// timeout for it to complete so that a hung drain can't block cleanup. A
+// partially run drain may not be safe to repeat so ssh failures aren't
+// retried. As w/ runHook, failures are only returned when strict is set;
+// otherwise they are reported as warnings and the terminate proceeds.
+func drainInstance(lr *iaws.LaunchEc2SpotResult, port int32,
+	drainCmd string, timeout time.Duration, strict bool) error {
+
+	if drainCmd == "" {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Draining %v...\n", lr.InstanceId)
+	output, err := runRemoteNoRetry(lr, port, []string{drainCmd}, timeout)
+	if output != "" {
+		fmt.Fprint(os.Stderr, output)
+	}
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("drain '%v' failed for %v: %w", drainCmd, lr.InstanceId,
+		err)
+	if strict {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+
+	return nil
+}
+
+func getHookEnv(hookName string, lr *iaws.LaunchEc2SpotResult) []string {
+	return []string{
+		"SPOTSH_HOOK=" + hookName,
+		"SPOTSH_INSTANCE_ID=" + lr.InstanceId,
+		"SPOTSH_INSTANCE_TYPE=" + string(lr.InstanceType),
+		"SPOTSH_PUBLIC_IP=" + lr.PublicIp,
+		"SPOTSH_PRIVATE_IP=" + lr.PrivateIp,
+		"SPOTSH_IPV6=" + lr.Ipv6,
+		"SPOTSH_DNS_NAME=" + lr.DnsName,
+		"SPOTSH_USER=" + lr.User,
+		"SPOTSH_KEY_FILE=" + lr.LocalKeyFile,
+		"SPOTSH_OS=" + lr.Os.String(),
+		"SPOTSH_REGION=" + lr.Region,
+		"SPOTSH_AZ=" + lr.AzName,
+		"SPOTSH_IMAGE_ID=" + lr.ImageId,
+		"SPOTSH_SECURITY_GROUP_ID=" + lr.SgId,
+	}
+}
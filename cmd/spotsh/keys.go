@@ -0,0 +1,116 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func keysMain(awsCfg aws.Config, args []string) error {
+	f := flag.NewFlagSet("spotsh keys", flag.ContinueOnError)
+	err := parseWithRegionFlags(&awsCfg, f, args)
+	if err != nil {
+		return err
+	}
+	args = f.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("spotsh keys <rotate|rm [<keypair_name>]|sync> must be specified")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "rotate":
+		if len(args) != 1 {
+			return fmt.Errorf("spotsh keys rotate does not accept arguments")
+		}
+		return keysRotate(awsCfg)
+	case "sync":
+		if len(args) != 1 {
+			return fmt.Errorf("spotsh keys sync does not accept arguments")
+		}
+		return keysSync()
+	case "rm":
+		keyName := iaws.GetDefaultKeyName(awsCfg)
+		if len(args) == 2 {
+			keyName = args[1]
+		} else if len(args) > 2 {
+			return fmt.Errorf("spotsh keys rm accepts at most 1 keypair name")
+		}
+		err = iaws.DeleteKeyPair(awsCfg, keyName)
+		if err != nil {
+			return fmt.Errorf("Failed to remove keypair %v: %w", keyName, err)
+		}
+		fmt.Printf("Removed keypair %v from %v\n", keyName, awsCfg.Region)
+		return nil
+	}
+
+	return fmt.Errorf("spotsh keys <rotate|rm [<keypair_name>]|sync> must be specified")
+}
+
+func keysRotate(awsCfg aws.Config) error {
+	localKeyFile, err := iaws.GetLocalDefaultKeyFile(awsCfg)
+	if err != nil {
+		return err
+	}
+	launchResults, err := iaws.LookupEc2Spot(context.Background(), awsCfg,
+		iaws.DefaultTagPrefix)
+	if err != nil {
+		return fmt.Errorf("Failed to lookup instance: %w", err)
+	}
+
+	localKeyFileBak, err := iaws.RotateDefaultKeyPair(awsCfg)
+	if err != nil {
+		if localKeyFileBak != "" {
+			fmt.Fprintf(os.Stderr, "Previous private key kept at %v\n",
+				localKeyFileBak)
+		}
+		return fmt.Errorf("Failed to rotate keypair %v: %w",
+			iaws.GetDefaultKeyName(awsCfg), err)
+	}
+	fmt.Printf("Rotated keypair %v (%v)\n", iaws.GetDefaultKeyName(awsCfg),
+		localKeyFile)
+	if localKeyFileBak != "" {
+		fmt.Printf("Previous private key kept at %v\n", localKeyFileBak)
+	}
+
+	for _, lr := range launchResults {
+		if lr.LocalKeyFile != localKeyFile {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Warning: running instance %v still trusts only the previous key; reach it via ssh -i %v %v@%v\n",
+			lr.InstanceId, localKeyFileBak, lr.User, lr.PublicIp)
+	}
+
+	return nil
+}
+
+func keysSync() error {
+	syncResult, err := iaws.SyncKeyIndex()
+	if err != nil {
+		return fmt.Errorf("Failed to sync key index: %w", err)
+	}
+
+	skippedFiles := make([]string, 0, len(syncResult.Skipped))
+	for keyFile := range syncResult.Skipped {
+		skippedFiles = append(skippedFiles, keyFile)
+	}
+	sort.Strings(skippedFiles)
+	for _, keyFile := range skippedFiles {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %v: %v\n", keyFile,
+			syncResult.Skipped[keyFile])
+	}
+	fmt.Printf("Indexed %v local private keys in %v\n", len(syncResult.Index),
+		iaws.KeyIndexPath)
+
+	return nil
+}
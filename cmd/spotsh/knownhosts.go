@@ -0,0 +1,127 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+// KnownHostsFile holds host keys exported via info --export-ssh-known-hosts.
+// Entries are keyed by instance id (via ssh's HostKeyAlias) rather than ip
+// since public ips are recycled across instances.
+const KnownHostsFile = "known_hosts"
+
+func getKnownHostsPath() (string, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, KnownHostsFile), nil
+}
+
+// exportKnownHosts records the ssh host keys of each of launchResults so
+// that subsequent connections to them verify the host
+func exportKnownHosts(awsCfg aws.Config,
+	launchResults []iaws.LaunchEc2SpotResult) error {
+
+	knownHostsPath, err := getKnownHostsPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(knownHostsPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	for idx := range launchResults {
+		lr := &launchResults[idx]
+		regionCfg := awsCfg.Copy()
+		regionCfg.Region = lr.Region
+		hostKeys, err := iaws.LookupHostKeys(regionCfg, lr.InstanceId)
+		if err != nil {
+			return err
+		}
+		if len(hostKeys) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: host keys of %v are not yet available in its console output; please retry in a few minutes\n",
+				lr.InstanceId)
+			continue
+		}
+		err = updateKnownHosts(knownHostsPath, lr.InstanceId, hostKeys)
+		if err != nil {
+			return fmt.Errorf("Failed to update %v: %w", knownHostsPath, err)
+		}
+		fmt.Printf("Exported %v host key(s) of %v to %v\n", len(hostKeys),
+			lr.InstanceId, knownHostsPath)
+	}
+
+	return nil
+}
+
+// updateKnownHosts replaces any existing entries for instanceId in
+// knownHostsPath w/ hostKeys
+func updateKnownHosts(knownHostsPath string, instanceId string,
+	hostKeys []string) error {
+
+	content, err := os.ReadFile(knownHostsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var sb strings.Builder
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" || strings.HasPrefix(line, instanceId+" ") {
+			continue
+		}
+		sb.WriteString(line + "\n")
+	}
+	for _, hostKey := range hostKeys {
+		// drop the trailing comment (e.g. root@ip-10-0-0-1)
+		fields := strings.Fields(hostKey)
+		if len(fields) < 2 {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%v %v %v\n", instanceId, fields[0],
+			fields[1]))
+	}
+
+	return os.WriteFile(knownHostsPath, []byte(sb.String()), 0600)
+}
+
+func hasKnownHost(knownHostsPath string, instanceId string) bool {
+	content, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, instanceId+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getHostKeyArgs returns the ssh options governing host key verification
+// of selectedInstance. Verification is only enforced once its host keys
+// have been exported since otherwise there's nothing to verify against.
+func getHostKeyArgs(selectedInstance *iaws.LaunchEc2SpotResult) []string {
+	knownHostsPath, err := getKnownHostsPath()
+	if err == nil && hasKnownHost(knownHostsPath, selectedInstance.InstanceId) {
+		return []string{"-o", "StrictHostKeyChecking=yes", "-o",
+			"UserKnownHostsFile=" + knownHostsPath, "-o",
+			"HostKeyAlias=" + selectedInstance.InstanceId}
+	}
+
+	return []string{"-o", "StrictHostKeyChecking=no", "-o",
+		"UserKnownHostsFile=/dev/null"}
+}
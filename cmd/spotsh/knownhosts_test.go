@@ -0,0 +1,76 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateKnownHosts(t *testing.T) {
+	knownHostsPath := filepath.Join(t.TempDir(), KnownHostsFile)
+
+	err := updateKnownHosts(knownHostsPath, "i-0",
+		[]string{"ssh-ed25519 AAAAold root@old"})
+	if err != nil {
+		t.Fatalf("failed to update known hosts: %v", err)
+	}
+	err = updateKnownHosts(knownHostsPath, "i-1",
+		[]string{"ssh-ed25519 AAAAother root@other"})
+	if err != nil {
+		t.Fatalf("failed to update known hosts: %v", err)
+	}
+	err = updateKnownHosts(knownHostsPath, "i-0",
+		[]string{"ssh-ed25519 AAAAnew root@new"})
+	if err != nil {
+		t.Fatalf("failed to update known hosts: %v", err)
+	}
+
+	content, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatalf("failed to read known hosts: %v", err)
+	}
+	expected := "i-1 ssh-ed25519 AAAAother\ni-0 ssh-ed25519 AAAAnew\n"
+	if string(content) != expected {
+		t.Errorf("expected %q; got %q", expected, string(content))
+	}
+	if !hasKnownHost(knownHostsPath, "i-0") ||
+		hasKnownHost(knownHostsPath, "i-2") {
+		t.Errorf("unexpected known host lookup result")
+	}
+}
+
+func TestGetHostKeyArgs(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	instance := newTestInstance()
+
+	args := strings.Join(getHostKeyArgs(instance), " ")
+	if !strings.Contains(args, "StrictHostKeyChecking=no") {
+		t.Errorf("expected no host key checking w/o exported keys; got %v",
+			args)
+	}
+
+	knownHostsPath, err := getKnownHostsPath()
+	if err != nil {
+		t.Fatalf("failed to get known hosts path: %v", err)
+	}
+	err = os.MkdirAll(filepath.Dir(knownHostsPath), 0700)
+	if err != nil {
+		t.Fatalf("failed to create config dir: %v", err)
+	}
+	err = updateKnownHosts(knownHostsPath, instance.InstanceId,
+		[]string{"ssh-ed25519 AAAA root@host"})
+	if err != nil {
+		t.Fatalf("failed to update known hosts: %v", err)
+	}
+
+	args = strings.Join(getHostKeyArgs(instance), " ")
+	if !strings.Contains(args, "StrictHostKeyChecking=yes") ||
+		!strings.Contains(args, "HostKeyAlias="+instance.InstanceId) {
+		t.Errorf("expected host key checking w/ exported keys; got %v", args)
+	}
+}
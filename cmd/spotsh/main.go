@@ -5,19 +5,27 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -29,31 +37,73 @@ import (
 )
 
 type Prefs struct {
-	Os               string            `json:",omitempty"`
-	InstanceTypes    []string          `json:",omitempty"`
-	KeyPairs         map[string]string `json:",omitempty"`
-	SecurityGroups   map[string]string `json:",omitempty"`
-	MaxSpotPrice     string            `json:",omitempty"`
-	RootVolSizeInGiB int32             `json:",omitempty"`
+	Os                        string              `json:",omitempty"`
+	InstanceTypes             []string            `json:",omitempty"`
+	KeyPairs                  map[string]string   `json:",omitempty"`
+	SecurityGroups            map[string]string   `json:",omitempty"`
+	MaxSpotPrice              string              `json:",omitempty"`
+	RootVolSizeInGiB          int32               `json:",omitempty"`
+	ForwardAgent              bool                `json:",omitempty"`
+	Regions                   []string            `json:",omitempty"`
+	NoDefaultKey              bool                `json:",omitempty"`
+	PostLaunchHook            string              `json:",omitempty"`
+	PreTerminateHook          string              `json:",omitempty"`
+	PreTerminateRemoteCmd     string              `json:",omitempty"`
+	DefaultAllRegionsForReads bool                `json:",omitempty"`
+	ProfileRegions            map[string]string   `json:",omitempty"`
+	SshPort                   int32               `json:",omitempty"`
+	Profiles                  map[string]*Profile `json:",omitempty"`
 
 	keyPair       string
 	securityGroup string
 }
 
+// Profile is a named set of launch preferences selected via
+// 'launch --from-config <name>'; fields which are set override the
+// corresponding top level Prefs
+type Profile struct {
+	Os               string   `json:",omitempty"`
+	InstanceTypes    []string `json:",omitempty"`
+	MaxSpotPrice     string   `json:",omitempty"`
+	RootVolSizeInGiB int32    `json:",omitempty"`
+	Role             string   `json:",omitempty"`
+	InitCmd          string   `json:",omitempty"`
+}
+
 var subCommandTab = map[string]func(awsCfg aws.Config, args []string) error{
-	"help":      helpMain,
-	"info":      infoMain,
-	"ls":        infoMain, // alias for info
-	"launch":    launchMain,
-	"scp":       scpMain,
-	"image":     imageMain,
-	"ssh":       sshMain,
-	"vpn":       vpnMain,
-	"terminate": terminateMain,
-	"version":   versionMain,
-	"upgrade":   upgradeMain,
-	"config":    configMain,
-	"price":     priceMain,
+	"clone":       cloneMain,
+	"help":        helpMain,
+	"info":        infoMain,
+	"ls":          infoMain, // alias for info
+	"keys":        keysMain,
+	"key":         keysMain, // alias for keys
+	"launch":      launchMain,
+	"metrics":     metricsMain,
+	"mount":       mountMain,
+	"scp":         scpMain,
+	"image":       imageMain,
+	"ssh":         sshMain,
+	"tag":         tagMain,
+	"vpn":         vpnMain,
+	"terminate":   terminateMain,
+	"version":     versionMain,
+	"upgrade":     upgradeMain,
+	"config":      configMain,
+	"cost":        costMain,
+	"price":       priceMain,
+	"resize-type": resizeTypeMain,
+	"rsync":       rsyncMain,
+	"selftest":    selftestMain,
+	"umount":      umountMain,
+}
+
+// readOnlySubCommands only look up state so, unlike those which launch or
+// modify instances, may default to every region; see
+// Prefs.DefaultAllRegionsForReads
+var readOnlySubCommands = map[string]bool{
+	"info":  true,
+	"ls":    true,
+	"price": true,
 }
 
 //go:embed help.txt
@@ -78,19 +128,80 @@ func versionMain(awsCfg aws.Config, args []string) error {
 
 func infoMain(awsCfg aws.Config, args []string) error {
 
-	var instances, vpcs, images, keys, all bool
+	var instances, vpcs, images, keys, all, health, stale, watchPrice bool
+	var exportKnown, actualCost, sshTest, csvOutput bool
+	var format, fieldList string
+	var watchInterval time.Duration
+	var warnPct float64
 	f := flag.NewFlagSet("spotsh info", flag.ContinueOnError)
 	f.BoolVar(&instances, "instances", true, "Display spot shell instances")
+	f.BoolVar(&health, "health", false,
+		"Display EC2 status check results of spot shell instances")
+	f.BoolVar(&stale, "stale", false,
+		"Only display spot shell instances which spotsh can no longer fully manage")
 	f.BoolVar(&vpcs, "vpcs", false, "Display VPCs")
 	f.BoolVar(&images, "images", false, "Display AMIs")
 	f.BoolVar(&keys, "keys", false, "Display keys")
 	f.BoolVar(&all, "all", false, "Display all")
-
-	err := f.Parse(args)
+	f.StringVar(&format, "format", "",
+		"Go text/template applied to each spot shell instance")
+	f.StringVar(&fieldList, "fields", "",
+		"Comma separated list of instance fields to display; e.g. id,ip")
+	f.BoolVar(&csvOutput, "csv", false,
+		"Display spot shell instances in csv format")
+	f.BoolVar(&actualCost, "actual-cost", false,
+		"Also output each instance's cost accrued since launch")
+	f.BoolVar(&sshTest, "ssh-test", false,
+		"Test & display whether each instance's ssh port is reachable")
+	f.BoolVar(&exportKnown, "export-ssh-known-hosts", false,
+		"Record each instance's ssh host keys so that later connections verify them")
+	f.BoolVar(&watchPrice, "watch-price", false,
+		"Periodically warn when an instance's spot price nears its max price")
+	f.DurationVar(&watchInterval, "watch-interval", 5*time.Minute,
+		"Interval between spot price checks w/ --watch-price")
+	f.Float64Var(&warnPct, "warn-pct", DefaultPriceWarnPct,
+		"Warn when the spot price is within this percent of the max price")
+
+	err := parseWithRegionFlags(&awsCfg, f, args)
 	if err != nil {
 		return err
 	}
 
+	if watchPrice {
+		if watchInterval <= 0 {
+			return fmt.Errorf("--watch-interval must be positive")
+		}
+		if warnPct <= 0 || warnPct >= 100 {
+			return fmt.Errorf("--warn-pct must be between 0 and 100")
+		}
+		return watchInstancePrices(awsCfg, watchInterval, warnPct)
+	}
+
+	if format != "" && fieldList != "" {
+		return fmt.Errorf("--format and --fields are mutually exclusive; choose only one")
+	}
+	if csvOutput && (format != "" || fieldList != "" || sshTest) {
+		return fmt.Errorf("--csv may not be combined w/ --format, --fields, or --ssh-test")
+	}
+	if sshTest && format != "" {
+		return fmt.Errorf("--ssh-test may not be combined w/ --format")
+	}
+	var fields []instanceField
+	if fieldList != "" {
+		fields, err = parseInstanceFields(fieldList)
+		if err != nil {
+			return err
+		}
+	}
+
+	var formatTmpl *template.Template
+	if format != "" {
+		formatTmpl, err = template.New("format").Parse(format)
+		if err != nil {
+			return fmt.Errorf("Failed to parse --format template: %w", err)
+		}
+	}
+
 	if all {
 		instances = true
 		vpcs = true
@@ -104,8 +215,58 @@ func infoMain(awsCfg aws.Config, args []string) error {
 		if err != nil {
 			return fmt.Errorf("Failed to lookup instance: %w", err)
 		}
+		if stale {
+			launchResults = filterStaleInstances(launchResults)
+		}
+		if health {
+			err = iaws.LookupInstanceHealth(awsCfg, launchResults)
+			if err != nil {
+				return err
+			}
+		}
+		if exportKnown {
+			return exportKnownHosts(awsCfg, launchResults)
+		}
+		var sshStatus map[string]string
+		if sshTest {
+			port, err := loadSshPort(awsCfg)
+			if err != nil {
+				return err
+			}
+			sshStatus = testSshReachability(launchResults,
+				func(lr *iaws.LaunchEc2SpotResult) error {
+					return testSshOnce(lr, port)
+				})
+			if fields != nil {
+				fields = append(fields, instanceField{"SSH",
+					func(lr *iaws.LaunchEc2SpotResult) string {
+						return sshStatus[lr.InstanceId]
+					}})
+			}
+		}
 
-		if len(launchResults) == 0 {
+		if csvOutput {
+			err = printInstancesCsv(os.Stdout, launchResults)
+			if err != nil {
+				return err
+			}
+		} else if formatTmpl != nil {
+			for idx := range launchResults {
+				err = formatTmpl.Execute(os.Stdout, &launchResults[idx])
+				if err != nil {
+					return fmt.Errorf("Failed to apply --format template: %w",
+						err)
+				}
+				fmt.Printf("\n")
+			}
+		} else if fields != nil {
+			err = printInstanceFields(os.Stdout, launchResults, fields)
+			if err != nil {
+				return err
+			}
+		} else if len(launchResults) == 0 && stale {
+			fmt.Printf("No stale spot shell instances\n")
+		} else if len(launchResults) == 0 {
 			fmt.Printf("No spot shell instances running\n")
 		} else {
 			fmt.Printf("Spot shell instances:\n")
@@ -113,16 +274,49 @@ func infoMain(awsCfg aws.Config, args []string) error {
 				fmt.Printf("\tInstance[%v]:\n", idx)
 				fmt.Printf("\t\tId: %v\n\t\tPublicIp: %v\n\t\tUser: %v\n",
 					lr.InstanceId, lr.PublicIp, lr.User)
+				fmt.Printf("\t\tPrivateIp: %v\n", lr.PrivateIp)
+				if lr.Ipv6 != "" {
+					fmt.Printf("\t\tIpv6: %v\n", lr.Ipv6)
+				}
 				if lr.LocalKeyFile == "" {
 					lr.LocalKeyFile = "<not present>"
 				}
 				fmt.Printf("\t\tType: %v\n", lr.InstanceType)
 				fmt.Printf("\t\tImageId: %v\n", lr.ImageId)
 				fmt.Printf("\t\tLocalKeyFile: %v\n", lr.LocalKeyFile)
-				fmt.Printf("\t\tCurrentPrice: $%v/hr\n", lr.CurrentPrice)
+				fmt.Printf("\t\tCurrentMarketPrice: $%v/hr\n", lr.CurrentPrice)
+				if actualCost {
+					cost, err := iaws.LookupAccruedCost(awsCfg, &lr)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: failed to get accrued cost of %v: %v\n",
+							lr.InstanceId, err)
+					} else {
+						fmt.Printf("\t\tAccruedCost: $%.4f\n", cost)
+					}
+				}
+				if lr.MaxSpotPrice != "" {
+					fmt.Printf("\t\tMaxSpotPrice: $%v/hr\n", lr.MaxSpotPrice)
+				}
+				fmt.Printf("\t\tLifecycle: %v\n", lr.Lifecycle)
 				fmt.Printf("\t\tAZName: %v\n", lr.AzName)
 				fmt.Printf("\t\tDNSName: %v\n", lr.DnsName)
 				fmt.Printf("\t\tOs: %v\n", lr.Os.String())
+				fmt.Printf("\t\tRegion: %v\n", lr.Region)
+				fmt.Printf("\t\tLaunchTime: %v\n", lr.LaunchTime)
+				fmt.Printf("\t\tSpotshVersion: %v\n",
+					getLaunchVersionDesc(&lr))
+				if stale {
+					fmt.Printf("\t\tStale: %v\n",
+						strings.Join(getStaleReasons(&lr), "; "))
+				}
+				if health {
+					fmt.Printf("\t\tInstanceStatus: %v\n", lr.InstanceStatus)
+					fmt.Printf("\t\tSystemStatus: %v\n", lr.SystemStatus)
+				}
+				if sshTest {
+					fmt.Printf("\t\tSsh: %v\n", sshStatus[lr.InstanceId])
+				}
+				printUserTags(lr.Tags)
 			}
 		}
 	}
@@ -180,51 +374,441 @@ func infoMain(awsCfg aws.Config, args []string) error {
 			fmt.Printf("\t\tId: %v\n", imageId)
 			fmt.Printf("\t\tName: %v\n", image.Name)
 			fmt.Printf("\t\tOwnership: %v\n", image.Ownership)
+			printImageDates(image)
 			idx++
 		}
+
+		latestImages, err := iaws.LookupLatestOsImages(awsCfg)
+		if err != nil {
+			return fmt.Errorf("Failed to lookup latest os images: %w", err)
+		}
+		fmt.Printf("Latest OS Images:\n")
+		for _, tmpOs := range spotsh.OsNone.Values() {
+			image, ok := latestImages[tmpOs]
+			if !ok {
+				continue
+			}
+			fmt.Printf("\t%v:\n", tmpOs)
+			fmt.Printf("\t\tId: %v\n", image.Id)
+			fmt.Printf("\t\tName: %v\n", image.Name)
+			printImageDates(image)
+		}
 	}
 
 	return nil
 }
 
+func printImageDates(image *iaws.LookupImageItem) {
+	now := time.Now()
+
+	ageDays, err := image.AgeInDays(now)
+	if err != nil {
+		fmt.Printf("\t\tCreationDate: %v\n", image.CreationDate)
+	} else if ageDays > iaws.ImageAgeWarnDays {
+		fmt.Printf("\t\tCreationDate: %v (%v days old; consider refreshing)\n",
+			image.CreationDate, ageDays)
+	} else {
+		fmt.Printf("\t\tCreationDate: %v (%v days old)\n", image.CreationDate,
+			ageDays)
+	}
+
+	daysLeft, ok := image.DaysUntilDeprecation(now)
+	if !ok {
+		return
+	}
+	if daysLeft < 0 {
+		fmt.Printf("\t\tDeprecationTime: %v (*WARN*: deprecated)\n",
+			image.DeprecationTime)
+	} else if daysLeft <= iaws.ImageDeprecationWarnDays {
+		fmt.Printf("\t\tDeprecationTime: %v (*WARN*: deprecated in %v days)\n",
+			image.DeprecationTime, daysLeft)
+	} else {
+		fmt.Printf("\t\tDeprecationTime: %v\n", image.DeprecationTime)
+	}
+}
+
+// getLaunchVersionDesc describes the version of spotsh which launched the
+// specified instance noting when it differs from this version
+func getLaunchVersionDesc(lr *iaws.LaunchEc2SpotResult) string {
+	if lr.SpotshVersion == "" {
+		return "<unknown>"
+	}
+	if lr.SpotshVersion != versionText {
+		return fmt.Sprintf("%v (differs from this spotsh %v)",
+			lr.SpotshVersion, versionText)
+	}
+
+	return lr.SpotshVersion
+}
+
+// getStaleReasons returns why spotsh can no longer fully manage the
+// specified instance, if at all
+func getStaleReasons(lr *iaws.LaunchEc2SpotResult) []string {
+	var reasons []string
+
+	if lr.LocalKeyFile == "" || lr.LocalKeyFile == "<not present>" {
+		reasons = append(reasons, "local private key not found")
+	}
+	if lr.Os == spotsh.OsInvalid {
+		reasons = append(reasons, fmt.Sprintf("%v.%v tag '%v' is unknown to this version of spotsh",
+			iaws.DefaultTagPrefix, iaws.OsTagSuffix,
+			lr.Tags[iaws.DefaultTagPrefix+"."+iaws.OsTagSuffix]))
+	}
+
+	return reasons
+}
+
+func filterStaleInstances(
+	launchResults []iaws.LaunchEc2SpotResult) []iaws.LaunchEc2SpotResult {
+
+	staleResults := make([]iaws.LaunchEc2SpotResult, 0)
+	for idx := range launchResults {
+		if len(getStaleReasons(&launchResults[idx])) > 0 {
+			staleResults = append(staleResults, launchResults[idx])
+		}
+	}
+
+	return staleResults
+}
+
+func printUserTags(tags map[string]string) {
+	keys := make([]string, 0)
+	for key := range tags {
+		if iaws.IsReservedTag(iaws.DefaultTagPrefix, key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("\t\tTags:\n")
+	for _, key := range keys {
+		fmt.Printf("\t\t\t%v: %v\n", key, tags[key])
+	}
+}
+
 func launchMain(awsCfg aws.Config, args []string) error {
-	launchArgs, err := newLaunchArgsFromPrefs(awsCfg)
+	// the selected profile determines the defaults of the remaining flags
+	profileName, args, err := extractStringArg(args, "from-config")
+	if err != nil {
+		return err
+	}
+	launchArgs, err := newLaunchArgsFromProfile(awsCfg, profileName)
 	if err != nil {
 		return err
 	}
 
 	var os string
+	var reuse, quiet, useInstanceStore, typeFromPrice, strictHooks bool
+	var waitSsh bool
+	var printField, instanceStorePath, cloudConfigPath, validFrom string
+	var initCmdPath string
+	var waitForPrice float64
+	var waitInterval, waitTimeout, validUntil time.Duration
 
 	f := flag.NewFlagSet("spotsh launch", flag.ContinueOnError)
+	// already extracted above; defined here so that it appears in usage
+	f.String("from-config", profileName,
+		"Named profile from spotsh preferences to launch w/")
 	f.StringVar(&os, "os", "", "Operating System; e.g. amzn2")
 	f.StringVar(&launchArgs.AmiId, "ami", launchArgs.AmiId,
 		"Amazon Machine Image id")
 	f.StringVar(&launchArgs.AmiName, "ami-name", launchArgs.AmiName,
 		"Name of an Amazon Machine Image")
+	f.StringVar(&launchArgs.AmiNamePrefix, "ami-name-prefix",
+		launchArgs.AmiNamePrefix,
+		"Name prefix or glob of a self-owned Amazon Machine Image")
+	f.BoolVar(&launchArgs.AmiLatest, "latest", launchArgs.AmiLatest,
+		"Select the newest AMI matching --ami-name-prefix")
 	f.StringVar(&launchArgs.User, "user", launchArgs.User, "username to ssh as")
 	f.StringVar(&launchArgs.KeyPair, "key", launchArgs.KeyPair, "EC2 keypair")
+	f.BoolVar(&launchArgs.NoDefaultKey, "no-default-key",
+		launchArgs.NoDefaultKey,
+		"Fail rather than create a default keypair when --key is not set")
 	f.StringVar(&launchArgs.SecurityGroupId, "sgid", launchArgs.SecurityGroupId,
 		"Security Group Id")
 	f.StringVar(&launchArgs.AttachRoleName, "role", launchArgs.AttachRoleName,
 		"IAM Role to attach to instance")
 	f.StringVar(&launchArgs.InitCmd, "initcmd", launchArgs.InitCmd,
 		"Initial command to run in the instance")
-	iTypeList := iTypeSlice2String(launchArgs.InstanceTypes)
-	f.StringVar(&iTypeList, "types", iTypeList, "Instance types")
+	f.StringVar(&initCmdPath, "initcmd-file", "",
+		"File containing the initial command or shell script to run in the instance")
+	f.StringVar(&launchArgs.LaunchTemplate, "launch-template", "",
+		"Existing launch template (name or id) to launch from")
+	f.StringVar(&cloudConfigPath, "cloud-config", "",
+		"cloud-init #cloud-config yaml file applied along w/ --initcmd")
+	iTypeList := iTypePriorities2String(launchArgs.InstanceTypes,
+		launchArgs.InstanceTypePriorities)
+	f.StringVar(&iTypeList, "types", iTypeList,
+		"Instance types; optionally <type>=<priority>")
+	var typeFamily, sizeList, typesFrom string
+	f.StringVar(&typeFamily, "type-family", "",
+		"Instance type family to launch from; e.g. c7i")
+	f.StringVar(&typesFrom, "types-from", "",
+		"File (or - for stdin) containing price --json output to launch from")
+	f.StringVar(&sizeList, "size", "",
+		"Comma separated sizes within --type-family; e.g. large,xlarge")
 	f.StringVar(&launchArgs.MaxSpotPrice, "spotprice", launchArgs.MaxSpotPrice,
 		"Maximum spot price to pay")
-	err = f.Parse(args)
+	rootVolSize := int(launchArgs.RootVolSizeInGiB)
+	f.IntVar(&rootVolSize, "root-vol-size", rootVolSize,
+		"Root volume size in GiB; 0 for the AMI's own size")
+	f.BoolVar(&launchArgs.UseAmiRootVolSize, "no-root-resize", false,
+		"Use the AMI's own root volume size")
+	f.BoolVar(&typeFromPrice, "instance-type-from-price", false,
+		"Launch only the single cheapest of the instance types in its cheapest az")
+	var sshImportIds string
+	f.StringVar(&sshImportIds, "ssh-import-id", "",
+		"Also authorize the public keys of these users; e.g. gh:alice,lp:bob")
+	spotPools := 0
+	f.IntVar(&spotPools, "spot-pools", 0,
+		"Choose the instance from this many of the cheapest spot pools")
+	f.Float64Var(&launchArgs.MaxSpotPricePct, "spotprice-pct", 0,
+		"Cap each type's spot price at this percent of its on-demand price")
+	f.BoolVar(&launchArgs.Ipv6, "ipv6", false,
+		"Assign a public IPv6 address; requires an IPv6-enabled subnet")
+	f.BoolVar(&launchArgs.AssociateEip, "associate-eip", false,
+		"Associate a persistent Elastic IP w/ the instance")
+	f.BoolVar(&launchArgs.AssociateEip, "eip", false,
+		"Associate a persistent Elastic IP w/ the instance")
+	f.BoolVar(&launchArgs.Enclave, "enclave", false,
+		"Enable Nitro Enclaves & set up the enclave allocator")
+	f.Func("block-device",
+		"Attach an additional EBS volume; <device>:<sizeGiB>[:<type>]; may be repeated",
+		func(spec string) error {
+			blockDev, err := iaws.ParseBlockDevice(spec)
+			if err != nil {
+				return err
+			}
+			launchArgs.BlockDevices = append(launchArgs.BlockDevices, blockDev)
+			return nil
+		})
+	f.BoolVar(&useInstanceStore, "use-instance-store", false,
+		"Format & mount the instance type's local NVMe instance store")
+	f.StringVar(&instanceStorePath, "instance-store-path", "/scratch",
+		"Path at which --use-instance-store mounts instance store")
+	f.StringVar(&launchArgs.Market, "market", iaws.MarketSpot,
+		"Purchasing option; spot or on-demand")
+	f.StringVar(&launchArgs.Tenancy, "tenancy", iaws.TenancyDefault,
+		"Instance tenancy; default, dedicated, or host")
+	f.StringVar(&launchArgs.ClientToken, "client-token", "",
+		"Idempotency token of the fleet request; defaults to a new launch id")
+	f.BoolVar(&waitSsh, "wait-ssh", false,
+		"Return only once the instance accepts ssh connections")
+	f.BoolVar(&launchArgs.NoWait, "no-wait", false,
+		"Return once launched w/o waiting for the instance's public ip")
+	f.BoolVar(&launchArgs.NoWait, "detach", false,
+		"Return once launched w/o waiting for the instance's public ip")
+	f.BoolVar(&reuse, "reuse", false,
+		"Reuse an existing matching instance rather than launching a new one")
+	f.BoolVar(&strictHooks, "strict-hooks", false,
+		"Fail rather than warn when the post-launch hook fails")
+	f.Float64Var(&launchArgs.IdleCpuAlarmPct, "alarm-idle-cpu",
+		launchArgs.IdleCpuAlarmPct,
+		"Terminate the instance once average cpu % stays below this threshold")
+	f.BoolVar(&quiet, "q", false, "Only print the public ip on stdout")
+	f.StringVar(&printField, "print", "",
+		"Only print the specified field on stdout; one of id|ip|user@ip")
+	f.Float64Var(&waitForPrice, "wait-for-price", 0,
+		"Wait until the cheapest spot price is at or below this price")
+	f.DurationVar(&waitInterval, "wait-interval", 5*time.Minute,
+		"Interval between spot price checks w/ --wait-for-price")
+	f.DurationVar(&waitTimeout, "wait-timeout", 24*time.Hour,
+		"Maximum time to wait w/ --wait-for-price")
+	f.DurationVar(&validUntil, "valid-until", 0,
+		"Duration after --valid-from which AWS stops trying to fulfill the fleet request, or w/o --valid-from after which the instance terminates itself")
+	f.StringVar(&validFrom, "valid-from", "",
+		"Time at which AWS launches the instance; e.g. 2am or RFC3339")
+	f.StringVar(&validFrom, "at", "",
+		"Time at which AWS launches the instance; e.g. 2am or RFC3339")
+	idleMins := int(iaws.DefaultIdleCpuAlarmMinutes)
+	f.IntVar(&idleMins, "alarm-idle-minutes", idleMins,
+		"Minutes cpu must remain below --alarm-idle-cpu before terminating")
+	prevRegion := awsCfg.Region
+	err = parseWithRegionFlags(&awsCfg, f, args)
 	if err != nil {
 		return err
 	}
+	if awsCfg.Region != prevRegion {
+		// keypair & security group preferences are per region
+		regionLaunchArgs, err := newLaunchArgsFromProfile(awsCfg, profileName)
+		if err != nil {
+			return err
+		}
+		if !flagWasSet(f, "key") {
+			launchArgs.KeyPair = regionLaunchArgs.KeyPair
+		}
+		if !flagWasSet(f, "sgid") {
+			launchArgs.SecurityGroupId = regionLaunchArgs.SecurityGroupId
+		}
+	}
 
+	if quiet && printField == "" {
+		printField = "ip"
+	}
+	if printField != "" {
+		iaws.RegionProgress = nil
+	}
+	if printField != "" && printField != "id" && printField != "ip" &&
+		printField != "user@ip" {
+		return fmt.Errorf("--print must be one of id, ip, or user@ip")
+	}
+	if launchArgs.NoWait && printField != "" && printField != "id" {
+		return fmt.Errorf("--no-wait may only be combined w/ --print id")
+	}
+	if launchArgs.NoWait && launchArgs.AssociateEip {
+		return fmt.Errorf("--no-wait may not be combined w/ --eip")
+	}
+	if waitSsh && launchArgs.NoWait {
+		return fmt.Errorf("--wait-ssh may not be combined w/ --no-wait")
+	}
+	if idleMins <= 0 {
+		return fmt.Errorf("--alarm-idle-minutes must be positive")
+	}
+	if idleMins > int(iaws.MaxIdleCpuAlarmMinutes) {
+		return fmt.Errorf("--alarm-idle-minutes must be at most %v (1 day)",
+			iaws.MaxIdleCpuAlarmMinutes)
+	}
+	if useInstanceStore {
+		if !filepath.IsAbs(instanceStorePath) {
+			return fmt.Errorf("--instance-store-path must be an absolute path")
+		}
+		launchArgs.InstanceStoreMount = instanceStorePath
+	} else if flagWasSet(f, "instance-store-path") {
+		return fmt.Errorf("--instance-store-path requires --use-instance-store")
+	}
+	launchArgs.IdleCpuAlarmMinutes = int32(idleMins)
+	if validUntil < 0 {
+		return fmt.Errorf("--valid-until must be positive")
+	}
+	if validFrom != "" {
+		launchArgs.ValidFrom, err = parseValidFrom(validFrom, time.Now())
+		if err != nil {
+			return err
+		}
+		if printField != "" {
+			return fmt.Errorf("--valid-from may not be combined w/ --print or -q")
+		}
+		if waitSsh {
+			return fmt.Errorf("--valid-from may not be combined w/ --wait-ssh")
+		}
+	}
+	if launchArgs.MaxSpotPricePct < 0 || launchArgs.MaxSpotPricePct > 100 {
+		return fmt.Errorf("--spotprice-pct must be between 0 and 100")
+	}
+	launchArgs.Market, err = getTenancyMarket(launchArgs.Tenancy,
+		launchArgs.Market, flagWasSet(f, "market"))
+	if err != nil {
+		return err
+	}
+	if launchArgs.MaxSpotPricePct != 0 && launchArgs.Market == iaws.MarketOnDemand {
+		return fmt.Errorf("--spotprice-pct may not be combined w/ --market %v",
+			iaws.MarketOnDemand)
+	}
+	if flagWasSet(f, "root-vol-size") {
+		if rootVolSize < 0 {
+			return fmt.Errorf("--root-vol-size must not be negative")
+		}
+		launchArgs.RootVolSizeInGiB = int32(rootVolSize)
+		if rootVolSize == 0 {
+			launchArgs.UseAmiRootVolSize = true
+		} else if launchArgs.UseAmiRootVolSize {
+			return fmt.Errorf("--root-vol-size may not be combined w/ --no-root-resize")
+		}
+	}
+	if typeFromPrice && launchArgs.Market == iaws.MarketOnDemand {
+		return fmt.Errorf("--instance-type-from-price may not be combined w/ --market %v",
+			iaws.MarketOnDemand)
+	}
+	if launchArgs.MaxSpotPricePct != 0 && !flagWasSet(f, "spotprice") {
+		// let the per type caps rather than the preferences' absolute price
+		// bound the fleet
+		launchArgs.MaxSpotPrice = ""
+	}
+	iTypeList, err = applyTypeFamily(f, iTypeList, typeFamily, sizeList)
+	if err != nil {
+		return err
+	}
+	if typesFrom != "" {
+		if typeFamily != "" || flagWasSet(f, "types") {
+			return fmt.Errorf("--types-from may not be combined w/ --types or --type-family")
+		}
+		iTypeList, err = readTypesFrom(typesFrom)
+		if err != nil {
+			return err
+		}
+	}
 	launchArgs.InstanceTypes = string2iTypeSlice(iTypeList)
-	if launchArgs.AmiId != "" || launchArgs.AmiName != "" {
-		if launchArgs.AmiId != "" && launchArgs.AmiName != "" {
-			return fmt.Errorf("--ami and --ami-name are mutually exclusive; choose one but not both flags simultaneously")
+	launchArgs.InstanceTypePriorities, err = string2iTypePriorities(iTypeList)
+	if err != nil {
+		return err
+	}
+	if launchArgs.User != "" {
+		warnAmiUserMismatch(awsCfg, launchArgs.AmiId, launchArgs.AmiName,
+			launchArgs.User)
+	}
+	if initCmdPath != "" {
+		if flagWasSet(f, "initcmd") {
+			return fmt.Errorf("--initcmd and --initcmd-file are mutually exclusive")
+		}
+		launchArgs.InitCmd, err = readUserDataFile("init command",
+			initCmdPath)
+		if err != nil {
+			return err
+		}
+	}
+	if cloudConfigPath != "" {
+		launchArgs.CloudConfig, err = readUserDataFile("cloud config",
+			cloudConfigPath)
+		if err != nil {
+			return err
+		}
+	}
+	if sshImportIds != "" {
+		launchArgs.AuthorizedKeys, err = fetchSshImportIds(sshImportIds)
+		if err != nil {
+			return err
+		}
+	}
+	if launchArgs.LaunchTemplate != "" {
+		// the template itself determines these so preference defaults are
+		// dropped; explicitly specified ones are rejected by LaunchEc2Spot
+		if !flagWasSet(f, "os") {
+			launchArgs.Os = spotsh.OsNone
+		}
+		if !flagWasSet(f, "types") && typeFamily == "" && typesFrom == "" {
+			// launch the template's own instance type
+			launchArgs.InstanceTypes = nil
+			launchArgs.InstanceTypePriorities = nil
+		}
+		if !flagWasSet(f, "key") {
+			launchArgs.KeyPair = ""
+		}
+		if !flagWasSet(f, "root-vol-size") {
+			launchArgs.RootVolSizeInGiB = 0
+		}
+	}
+	// validated along w/ the other launch args by iaws.LaunchEc2Spot
+	launchArgs.SpotInstancePools = int32(spotPools)
+	if launchArgs.AmiLatest && launchArgs.AmiNamePrefix == "" {
+		return fmt.Errorf("--latest may only be specified along with --ami-name-prefix")
+	}
+	numAmiFlags := 0
+	for _, amiFlag := range []string{launchArgs.AmiId, launchArgs.AmiName,
+		launchArgs.AmiNamePrefix} {
+		if amiFlag != "" {
+			numAmiFlags++
+		}
+	}
+	if numAmiFlags > 0 {
+		if numAmiFlags > 1 {
+			return fmt.Errorf("--ami, --ami-name, and --ami-name-prefix are mutually exclusive; choose only one")
 		}
 		if os != "" {
-			return fmt.Errorf("--os is mutually exclusive with --ami or --ami-name; choose one only")
+			return fmt.Errorf("--os is mutually exclusive with --ami, --ami-name, or --ami-name-prefix; choose one only")
 		}
 		if launchArgs.User == "" {
 			return fmt.Errorf("--user must be specified when launching by AMI id or AMI name so that spotsh knows which user to ssh as in the future")
@@ -241,129 +825,789 @@ func launchMain(awsCfg aws.Config, args []string) error {
 				return fmt.Errorf(sb.String())
 			}
 		}
-		if launchArgs.User != "" {
+		if launchArgs.User != "" && launchArgs.LaunchTemplate == "" {
 			return fmt.Errorf("--user is automatically determined by default or when --os is specified")
 		}
 	}
 
 	ctx := context.Background()
-	launchResult, err := iaws.LaunchEc2Spot(ctx, awsCfg, launchArgs)
-	if err != nil {
-		return err
+	if reuse {
+		launchResults, err := iaws.LookupEc2Spot(ctx, awsCfg,
+			iaws.DefaultTagPrefix)
+		if err != nil {
+			return fmt.Errorf("Failed to lookup instance: %w", err)
+		}
+		matchArgs := *launchArgs
+		matchArgs.AmiId, err = iaws.ResolveAmiId(awsCfg, launchArgs)
+		if err != nil {
+			return err
+		}
+		existing := iaws.FindMatchingEc2Spot(launchResults, &matchArgs)
+		if existing != nil {
+			return printLaunchResult("Reusing", existing, printField)
+		}
 	}
-	fmt.Printf("Launched %v (%v@%v)\n", launchResult.InstanceId,
-		launchResult.User, launchResult.PublicIp)
 
-	return nil
-}
-
-func iTypeSlice2String(iTypes []types.InstanceType) string {
-	var iTypeList string
-
-	for _, iType := range iTypes {
-		if iTypeList == "" {
-			iTypeList = string(iType)
-		} else {
-			iTypeList += "," + string(iType)
+	if waitForPrice > 0 {
+		if waitInterval <= 0 {
+			return fmt.Errorf("--wait-interval must be positive")
+		}
+		iTypes := launchArgs.InstanceTypes
+		if len(iTypes) == 0 {
+			iTypes = iaws.DefaultInstanceTypes
 		}
+		err = waitForSpotPrice(awsCfg, iTypes, waitForPrice, waitInterval,
+			waitTimeout)
+		if err != nil {
+			return err
+		}
+		launchArgs.MaxSpotPrice = capSpotPrice(launchArgs.MaxSpotPrice,
+			waitForPrice)
 	}
 
-	return iTypeList
-}
-
-func string2iTypeSlice(iTypeList string) []types.InstanceType {
-	iTypes := make([]types.InstanceType, 0)
+	if typeFromPrice {
+		err = restrictToCheapestType(awsCfg, launchArgs)
+		if err != nil {
+			return err
+		}
+	}
 
-	for _, iType := range strings.Split(iTypeList, ",") {
-		if iType == "" {
-			continue
+	if validUntil > 0 && launchArgs.ValidFrom.IsZero() {
+		launchArgs.ValidUntil = time.Now().Add(validUntil)
+	} else if validUntil > 0 {
+		launchArgs.ValidUntil = launchArgs.ValidFrom.Add(validUntil)
+	}
+	launchStart := time.Now()
+	launchCtx, cancel := newLaunchContext()
+	defer cancel()
+	launchResult, err := iaws.LaunchEc2Spot(launchCtx, awsCfg, launchArgs)
+	if err != nil {
+		return err
+	}
+	if launchResult.FleetId != "" {
+		// the post-launch hook has no instance to act on yet
+		fmt.Printf("Scheduled fleet %v to launch at %v; see info once launched\n",
+			launchResult.FleetId, launchArgs.ValidFrom.Local())
+		return nil
+	}
+	if waitSsh {
+		err = waitForLaunchedSsh(awsCfg, &launchResult, launchStart)
+		if err != nil {
+			return err
 		}
-		iTypes = append(iTypes, types.InstanceType(iType))
+	}
+	err = runPostLaunchHook(awsCfg, &launchResult, strictHooks)
+	if err != nil {
+		return err
 	}
 
-	return iTypes
+	return printLaunchResult("Launched", &launchResult, printField)
 }
 
-func stringSlice2iTypeSlice(iTypesStr []string) []types.InstanceType {
-	iTypes := make([]types.InstanceType, 0)
+// waitForLaunchedSsh waits until a newly launched instance accepts ssh &
+// reports the time since launchStart
+func waitForLaunchedSsh(awsCfg aws.Config,
+	launchResult *iaws.LaunchEc2SpotResult, launchStart time.Time) error {
 
-	for _, iType := range iTypesStr {
-		iTypes = append(iTypes, types.InstanceType(iType))
+	port, err := loadSshPort(awsCfg)
+	if err != nil {
+		return err
+	}
+	err = waitForSsh(awsCfg, launchResult, port)
+	if err != nil {
+		return fmt.Errorf("Launched %v but ssh never became ready: %w",
+			launchResult.InstanceId, err)
 	}
+	fmt.Fprintf(os.Stderr, "Ssh ready on %v after %v\n",
+		launchResult.InstanceId, time.Since(launchStart).Round(time.Second))
 
-	return iTypes
+	return nil
 }
 
-func terminateMain(awsCfg aws.Config, args []string) error {
-	selectedInstance, err := selectOrLaunchWithArgs(awsCfg, "spotsh terminate",
-		false, &args)
+func runPostLaunchHook(awsCfg aws.Config, launchResult *iaws.LaunchEc2SpotResult,
+	strict bool) error {
+
+	prefs, err := loadPrefs(awsCfg)
 	if err != nil {
 		return err
 	}
 
-	needVpnTeardown, err := iaws.GetTagValue(awsCfg, selectedInstance.InstanceId,
-		iaws.DefaultTagPrefix+"."+iaws.VpnTagSuffix)
-	if err != nil {
-		return fmt.Errorf("Failed to get vpn tag value: %w", err)
+	return runHook(PostLaunchHookName, prefs.PostLaunchHook, launchResult,
+		strict)
+}
+
+// validFromClockLayouts are the time of day forms accepted by --valid-from
+// in addition to RFC3339
+var validFromClockLayouts = []string{"15:04", "3PM", "3:04PM"}
+
+// parseValidFrom parses either an RFC3339 time or a local time of day
+// (e.g. 2am or 14:30) which refers to its next occurrence after now
+func parseValidFrom(spec string, now time.Time) (time.Time, error) {
+	validFrom, err := time.Parse(time.RFC3339, spec)
+	if err == nil {
+		if !validFrom.After(now) {
+			return time.Time{}, fmt.Errorf("--valid-from %v is not in the future",
+				spec)
+		}
+		return validFrom, nil
 	}
-	if needVpnTeardown == "true" {
-		err = stopVpnClient(awsCfg, selectedInstance)
+
+	clockSpec := strings.ToUpper(strings.ReplaceAll(spec, " ", ""))
+	for _, layout := range validFromClockLayouts {
+		clock, err := time.Parse(layout, clockSpec)
 		if err != nil {
-			return err
+			continue
+		}
+		validFrom = time.Date(now.Year(), now.Month(), now.Day(),
+			clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if !validFrom.After(now) {
+			validFrom = validFrom.AddDate(0, 0, 1)
 		}
+		return validFrom, nil
 	}
 
-	return iaws.TerminateInstance(awsCfg, selectedInstance.InstanceId)
+	return time.Time{}, fmt.Errorf("Unable to parse --valid-from %v; expected a time of day (e.g. 2am or 14:30) or RFC3339 time",
+		spec)
 }
 
-func sshMain(awsCfg aws.Config, args []string) error {
-	return sshCommon(awsCfg, false, args)
-}
+// getTenancyMarket returns the market to launch w/ given the requested
+// tenancy. EC2 does not offer spot instances w/ dedicated or host tenancy so
+// these switch to on-demand unless spot was explicitly requested.
+func getTenancyMarket(tenancy string, market string,
+	marketSet bool) (string, error) {
 
-func getCommonSshArgs(cmd string,
-	selectedInstance *iaws.LaunchEc2SpotResult) []string {
+	if tenancy == iaws.TenancyDefault || market == iaws.MarketOnDemand {
+		return market, nil
+	}
+	if marketSet {
+		return "", fmt.Errorf("--tenancy %v may not be combined w/ --market %v",
+			tenancy, market)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: spot instances do not support %v tenancy; launching an %v instance instead\n",
+		tenancy, iaws.MarketOnDemand)
 
-	return []string{cmd, "-i", selectedInstance.LocalKeyFile, "-o",
-		"StrictHostKeyChecking=no", "-o", "ConnectTimeout=5", "-o",
-		"UserKnownHostsFile=/dev/null"}
+	return iaws.MarketOnDemand, nil
 }
 
-func scpMain(awsCfg aws.Config, args []string) error {
-	const SpotHostVar = "{s}"
+// restrictToCheapestType narrows launchArgs to only the cheapest of its
+// instance types in that type's cheapest az so that the fleet can't select
+// any other type & the resulting price is predictable
+func restrictToCheapestType(awsCfg aws.Config,
+	launchArgs *iaws.LaunchEc2SpotArgs) error {
 
-	selectedInstance, err := selectOrLaunchWithArgs(awsCfg, "spotsh scp", false, &args)
-	if err != nil {
-		return err
+	iTypes := launchArgs.InstanceTypes
+	if len(iTypes) == 0 {
+		iTypes = iaws.DefaultInstanceTypes
 	}
-
-	// replace all instances of {s} in remaining args with user@ip
-	userAtPublicIp := selectedInstance.User + "@" + selectedInstance.PublicIp
-	for idx := range args {
-		args[idx] = strings.ReplaceAll(args[idx], SpotHostVar, userAtPublicIp)
+	lookupResult, err := iaws.LookupEc2SpotPrices(awsCfg, iTypes)
+	if err != nil {
+		return fmt.Errorf("Failed to lookup spot prices: %w", err)
 	}
-
-	scpArgs := getCommonSshArgs("scp", selectedInstance)
-	if len(args) > 0 {
-		scpArgs = append(scpArgs, args...)
+	cheapest := lookupResult.CheapestIType
+	if cheapest == nil || cheapest.CheapestRegion == nil ||
+		cheapest.CheapestRegion.CheapestAz == nil {
+		return fmt.Errorf("No spot prices currently available for %v",
+			iTypeSlice2String(iTypes))
 	}
-	fmt.Printf("exec %v\n", scpArgs)
 
-	err = syscall.Exec("/usr/bin/scp", scpArgs, nil)
-	if err != nil {
-		return fmt.Errorf("Failed to scp: %w\n", err)
-	}
+	fmt.Fprintf(os.Stderr, "Selected %v in %v at $%v/hr\n",
+		cheapest.InstanceType, cheapest.CheapestRegion.CheapestAz.AzName,
+		cheapest.CheapestRegion.CheapestAz.CurPrice)
+	launchArgs.InstanceTypes = []types.InstanceType{cheapest.InstanceType}
+	launchArgs.InstanceTypePriorities = nil
+	launchArgs.AvailabilityZone = cheapest.CheapestRegion.CheapestAz.AzName
 
 	return nil
 }
 
-func imageMain(awsCfg aws.Config, args []string) error {
-	var name, desc, instanceId string
+// printLaunchResult displays a human readable summary of launchResult on
+// stdout or, when printField is set, only the requested field on stdout w/
+// the summary redirected to stderr
+func printLaunchResult(verb string, launchResult *iaws.LaunchEc2SpotResult,
+	printField string) error {
+
+	summary := fmt.Sprintf("%v %v (%v@%v)\n", verb, launchResult.InstanceId,
+		launchResult.User, launchResult.PublicIp)
+	if printField == "" {
+		fmt.Printf("%v", summary)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%v", summary)
+	switch printField {
+	case "id":
+		fmt.Printf("%v\n", launchResult.InstanceId)
+	case "ip":
+		fmt.Printf("%v\n", launchResult.PublicIp)
+	case "user@ip":
+		fmt.Printf("%v@%v\n", launchResult.User, launchResult.PublicIp)
+	default:
+		return fmt.Errorf("Unknown --print field %v", printField)
+	}
+
+	return nil
+}
+
+// newLaunchContext returns a context which is cancelled upon SIGINT or
+// SIGTERM so that an interrupted launch can cleanup any instance it has
+// already provisioned
+func newLaunchContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+}
+
+func iTypeSlice2String(iTypes []types.InstanceType) string {
+	var iTypeList string
+
+	for _, iType := range iTypes {
+		if iTypeList == "" {
+			iTypeList = string(iType)
+		} else {
+			iTypeList += "," + string(iType)
+		}
+	}
+
+	return iTypeList
+}
+
+func iTypePriorities2String(iTypes []types.InstanceType,
+	priorities map[types.InstanceType]float64) string {
+
+	var iTypeList string
+
+	for _, iType := range iTypes {
+		iTypeStr := string(iType)
+		if priority, ok := priorities[iType]; ok {
+			iTypeStr = fmt.Sprintf("%v=%v", iType, priority)
+		}
+		if iTypeList == "" {
+			iTypeList = iTypeStr
+		} else {
+			iTypeList += "," + iTypeStr
+		}
+	}
+
+	return iTypeList
+}
+
+// string2iTypeSlice parses a comma separated list of instance types. Each
+// entry may carry an optional =<priority> suffix which is ignored here; see
+// string2iTypePriorities.
+func string2iTypeSlice(iTypeList string) []types.InstanceType {
+	iTypes := make([]types.InstanceType, 0)
+
+	for _, iType := range strings.Split(iTypeList, ",") {
+		iType = strings.Split(iType, "=")[0]
+		if iType == "" {
+			continue
+		}
+		iTypes = append(iTypes, types.InstanceType(iType))
+	}
+
+	return iTypes
+}
+
+// string2iTypePriorities parses the optional =<priority> suffixes from a
+// comma separated list of instance types. nil is returned when no entry
+// specifies a priority.
+func string2iTypePriorities(iTypeList string) (map[types.InstanceType]float64,
+	error) {
+
+	var priorities map[types.InstanceType]float64
+
+	for _, iType := range strings.Split(iTypeList, ",") {
+		iTypeAndPriority := strings.SplitN(iType, "=", 2)
+		if len(iTypeAndPriority) != 2 {
+			continue
+		}
+		priority, err := strconv.ParseFloat(iTypeAndPriority[1], 64)
+		if err != nil || priority < 0 {
+			return nil, fmt.Errorf("Invalid priority '%v' for instance type %v",
+				iTypeAndPriority[1], iTypeAndPriority[0])
+		}
+		if priorities == nil {
+			priorities = make(map[types.InstanceType]float64)
+		}
+		priorities[types.InstanceType(iTypeAndPriority[0])] = priority
+	}
+
+	return priorities, nil
+}
+
+func stringSlice2iTypeSlice(iTypesStr []string) []types.InstanceType {
+	return string2iTypeSlice(strings.Join(iTypesStr, ","))
+}
+
+// expandTypeFamily returns the instance types within the specified family
+// (e.g. c7i) as a comma separated list suitable for string2iTypeSlice. When
+// sizeList is non-empty only the specified comma separated sizes (e.g.
+// large,xlarge) are included.
+func expandTypeFamily(family string, sizeList string) (string, error) {
+	family = strings.ToLower(strings.TrimSpace(family))
+	if family == "" || strings.Contains(family, ".") {
+		return "", fmt.Errorf("Invalid instance type family '%v'; e.g. c7i",
+			family)
+	}
+
+	var familyTypes []string
+	familySizes := make(map[string]bool)
+	for _, iType := range types.InstanceType("").Values() {
+		iTypeStr := string(iType)
+		if !strings.HasPrefix(iTypeStr, family+".") {
+			continue
+		}
+		familyTypes = append(familyTypes, iTypeStr)
+		familySizes[strings.TrimPrefix(iTypeStr, family+".")] = true
+	}
+	if len(familyTypes) == 0 {
+		return "", fmt.Errorf("Unknown instance type family '%v'", family)
+	}
+	if sizeList == "" {
+		return strings.Join(familyTypes, ","), nil
+	}
+
+	var iTypes []string
+	for _, size := range strings.Split(sizeList, ",") {
+		size = strings.ToLower(strings.TrimSpace(size))
+		if size == "" {
+			continue
+		}
+		if !familySizes[size] {
+			return "", fmt.Errorf("Instance type family %v has no size '%v'",
+				family, size)
+		}
+		iTypes = append(iTypes, family+"."+size)
+	}
+	if len(iTypes) == 0 {
+		return "", fmt.Errorf("--size requires at least one size")
+	}
+
+	return strings.Join(iTypes, ","), nil
+}
+
+// applyTypeFamily replaces iTypeList w/ the expansion of --type-family &
+// --size when specified
+func applyTypeFamily(f *flag.FlagSet, iTypeList string, family string,
+	sizeList string) (string, error) {
+
+	if family == "" {
+		if sizeList != "" {
+			return "", fmt.Errorf("--size may only be specified along with --type-family")
+		}
+		return iTypeList, nil
+	}
+	if flagWasSet(f, "types") {
+		return "", fmt.Errorf("--types and --type-family are mutually exclusive")
+	}
+
+	return expandTypeFamily(family, sizeList)
+}
+
+// flagWasSet returns true if the named flag was explicitly specified rather
+// than left at its default
+func flagWasSet(f *flag.FlagSet, name string) bool {
+	wasSet := false
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			wasSet = true
+		}
+	})
+
+	return wasSet
+}
+
+func terminateMain(awsCfg aws.Config, args []string) error {
+	var termOpts terminateOpts
+	var osName, iType string
+	var assumeYes bool
+	opts, err := parseSshArgs(&awsCfg, "spotsh terminate", &args,
+		func(f *flag.FlagSet) {
+			f.BoolVar(&termOpts.keepVolume, "keep-volume", false,
+				"Retain the instance's EBS volumes")
+			f.BoolVar(&termOpts.keepRootVolume, "keep-root-volume", false,
+				"Retain the instance's root EBS volume")
+			f.BoolVar(&termOpts.keepEip, "keep-eip", false,
+				"Retain the instance's Elastic IP")
+			f.BoolVar(&termOpts.strictHooks, "strict-hooks", false,
+				"Fail if the pre-terminate hook fails")
+			f.StringVar(&termOpts.drainCmd, "drain", "",
+				"Remote command to run before terminating")
+			f.DurationVar(&termOpts.drainTimeout, "drain-timeout",
+				DefaultDrainTimeout, "Maximum time to wait for --drain")
+			f.StringVar(&osName, "os", "",
+				"Terminate every instance running this Operating System")
+			f.StringVar(&iType, "type", "",
+				"Terminate every instance of this instance type")
+			f.BoolVar(&assumeYes, "yes", false,
+				"Don't prompt for confirmation w/ --os/--type")
+		})
+	if err != nil {
+		return err
+	}
+	if termOpts.drainTimeout <= 0 {
+		return fmt.Errorf("Invalid --drain-timeout %v; must be a positive duration such as 90s",
+			termOpts.drainTimeout)
+	}
+	if osName != "" || iType != "" {
+		if opts.instanceId != "" || len(args) != 0 {
+			return fmt.Errorf("--os/--type may not be combined w/ --instance-id or other arguments")
+		}
+		return terminateBySelector(awsCfg, osName, iType, assumeYes,
+			&termOpts)
+	}
+
+	selectedInstance, err := selectOrLaunch(awsCfg, false, opts.instanceId)
+	if err != nil {
+		return err
+	}
+
+	prefs, err := loadPrefs(awsCfg)
+	if err != nil {
+		return err
+	}
+
+	return terminateInstance(awsCfg, prefs, selectedInstance, &termOpts)
+}
+
+// terminateBySelector terminates every spot shell instance whose os and/or
+// instance type match those specified after confirming w/ the user
+func terminateBySelector(awsCfg aws.Config, osName string, iType string,
+	assumeYes bool, termOpts *terminateOpts) error {
+
+	if osName != "" && spotsh.OsFromString(osName) == spotsh.OsInvalid {
+		return fmt.Errorf("No such os \"%v\" supported", osName)
+	}
+	launchResults, err := iaws.LookupEc2Spot(context.Background(), awsCfg,
+		iaws.DefaultTagPrefix)
+	if err != nil {
+		return fmt.Errorf("Failed to lookup instances: %w", err)
+	}
+	matches := selectInstances(launchResults, osName, iType)
+	if len(matches) == 0 {
+		return fmt.Errorf("No spot shell instances match the specified --os/--type")
+	}
+
+	fmt.Printf("The following instance(s) will be terminated:\n")
+	for _, lr := range matches {
+		fmt.Printf("  %v %v %v %v\n", lr.InstanceId, lr.Os, lr.InstanceType,
+			lr.PublicIp)
+	}
+	if !assumeYes {
+		fmt.Printf("Terminate %v instance(s)? (Y/N) [N]: ", len(matches))
+		shouldTerminate := "N"
+		fmt.Scanf("%s", &shouldTerminate)
+		shouldTerminate = strings.ToUpper(strings.TrimSpace(shouldTerminate))
+		if shouldTerminate == "" || shouldTerminate[0] != 'Y' {
+			return nil
+		}
+	}
+
+	prefs, err := loadPrefs(awsCfg)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, lr := range matches {
+		err = terminateInstance(awsCfg, prefs, lr, termOpts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to terminate %v: %w",
+				lr.InstanceId, err))
+			continue
+		}
+		fmt.Printf("Terminated %v\n", lr.InstanceId)
+	}
+
+	return errors.Join(errs...)
+}
+
+// selectInstances returns those of launchResults matching osName and iType;
+// an empty osName or iType matches any
+func selectInstances(launchResults []iaws.LaunchEc2SpotResult, osName string,
+	iType string) []*iaws.LaunchEc2SpotResult {
+
+	matches := make([]*iaws.LaunchEc2SpotResult, 0)
+	for idx := range launchResults {
+		lr := &launchResults[idx]
+		if osName != "" && lr.Os.String() != osName {
+			continue
+		}
+		if iType != "" && string(lr.InstanceType) != iType {
+			continue
+		}
+		matches = append(matches, lr)
+	}
+
+	return matches
+}
+
+type terminateOpts struct {
+	keepVolume     bool
+	keepRootVolume bool
+	keepEip        bool
+	strictHooks    bool
+	drainCmd       string
+	drainTimeout   time.Duration
+}
+
+func terminateInstance(awsCfg aws.Config, prefs *Prefs,
+	selectedInstance *iaws.LaunchEc2SpotResult, termOpts *terminateOpts) error {
+
+	err := runHook(PreTerminateHookName, prefs.PreTerminateHook,
+		selectedInstance, termOpts.strictHooks)
+	if err != nil {
+		return err
+	}
+	drainCmd := termOpts.drainCmd
+	if drainCmd == "" {
+		drainCmd = prefs.PreTerminateRemoteCmd
+	}
+	port, err := getSshPortPref(prefs)
+	if err != nil {
+		return err
+	}
+	err = drainInstance(selectedInstance, port, drainCmd,
+		termOpts.drainTimeout, termOpts.strictHooks)
+	if err != nil {
+		return err
+	}
+
+	var keptVolumeIds []string
+	if termOpts.keepVolume || termOpts.keepRootVolume {
+		keptVolumeIds, err = iaws.KeepVolumes(awsCfg,
+			selectedInstance.InstanceId, termOpts.keepRootVolume)
+		if err != nil {
+			return err
+		}
+	}
+
+	needVpnTeardown, err := iaws.GetTagValue(awsCfg, selectedInstance.InstanceId,
+		iaws.DefaultTagPrefix+"."+iaws.VpnTagSuffix)
+	if err != nil {
+		return fmt.Errorf("Failed to get vpn tag value: %w", err)
+	}
+	if needVpnTeardown == "true" {
+		err = stopVpnClient(awsCfg, selectedInstance)
+		if err != nil {
+			return err
+		}
+	}
+
+	alreadyGone, err := iaws.TerminateInstance(awsCfg, selectedInstance.InstanceId)
+	if err != nil {
+		return err
+	}
+	if alreadyGone {
+		fmt.Printf("Instance %v is already gone\n", selectedInstance.InstanceId)
+	}
+	if len(keptVolumeIds) > 0 {
+		fmt.Printf("Retained volume(s) %v of instance %v\n",
+			strings.Join(keptVolumeIds, ","), selectedInstance.InstanceId)
+	}
+	eipAllocId := selectedInstance.Tags[iaws.DefaultTagPrefix+"."+iaws.EipTagSuffix]
+	if eipAllocId != "" {
+		if termOpts.keepEip {
+			fmt.Printf("Retained elastic ip %v of instance %v\n", eipAllocId,
+				selectedInstance.InstanceId)
+		} else {
+			err = iaws.ReleaseEip(awsCfg, eipAllocId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = iaws.DeleteIdleCpuAlarm(awsCfg, selectedInstance.TagPrefix,
+		selectedInstance.InstanceId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to delete idle cpu alarm for %v: %v\n",
+			selectedInstance.InstanceId, err)
+	}
+	// an alarm which terminated its own instance outlives it; this is the
+	// next opportunity to clean it up
+	_, err = iaws.DeleteStaleIdleCpuAlarms(awsCfg, selectedInstance.TagPrefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to delete stale idle cpu alarms: %v\n",
+			err)
+	}
+	// a scheduled launch's template outlives its launch; this is the next
+	// opportunity to clean it up
+	_, err = iaws.DeleteStaleScheduledLaunchTemplates(awsCfg,
+		iaws.DefaultTagPrefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to delete stale scheduled launch templates: %v\n",
+			err)
+	}
+
+	return nil
+}
+
+func sshMain(awsCfg aws.Config, args []string) error {
+	return sshCommon(awsCfg, false, args)
+}
+
+func getCommonSshArgs(cmd string, selectedInstance *iaws.LaunchEc2SpotResult,
+	opts *sshOpts) []string {
+
+	sshArgs := []string{cmd, "-i", selectedInstance.LocalKeyFile, "-o",
+		"ConnectTimeout=5"}
+	sshArgs = append(sshArgs, getHostKeyArgs(selectedInstance)...)
+	sshArgs = append(sshArgs, getSshPortArgs(opts.port)...)
+	if opts.jumpHost != "" {
+		sshArgs = append(sshArgs, "-o", "ProxyJump="+opts.jumpHost)
+	}
+	if opts.forwardAgent {
+		sshArgs = append(sshArgs, "-o", "ForwardAgent=yes")
+	}
+	if opts.compress {
+		sshArgs = append(sshArgs, "-C")
+	}
+
+	return sshArgs
+}
+
+// getSshHost returns the address spotsh should connect to. When reaching
+// the instance through a jump host the private ip is preferred since the
+// bastion is typically inside the same VPC.
+func getSshHost(selectedInstance *iaws.LaunchEc2SpotResult,
+	opts *sshOpts) string {
+
+	if opts.jumpHost != "" && selectedInstance.PrivateIp != "" {
+		return selectedInstance.PrivateIp
+	}
+
+	return selectedInstance.PublicIp
+}
+
+func checkSshHost(selectedInstance *iaws.LaunchEc2SpotResult,
+	opts *sshOpts) error {
+
+	if getSshHost(selectedInstance, opts) == "" {
+		return fmt.Errorf("Instance %v has no public ip address; use --jump to connect via its private ip",
+			selectedInstance.InstanceId)
+	}
+
+	return nil
+}
+
+func scpMain(awsCfg aws.Config, args []string) error {
+	const SpotHostVar = "{s}"
+
+	selectedInstance, opts, err := selectOrLaunchWithArgs(&awsCfg, "spotsh scp",
+		false, &args, nil)
+	if err != nil {
+		return err
+	}
+	err = checkSshHost(selectedInstance, opts)
+	if err != nil {
+		return err
+	}
+
+	// replace all instances of {s} in remaining args with user@ip
+	userAtIp := selectedInstance.User + "@" + getSshHost(selectedInstance, opts)
+	for idx := range args {
+		args[idx] = strings.ReplaceAll(args[idx], SpotHostVar, userAtIp)
+	}
+
+	if opts.jumpHost == "" && !opts.noFirewall {
+		err = waitForSsh(awsCfg, selectedInstance, opts.port)
+		if err != nil {
+			return err
+		}
+	}
+
+	scpArgs := getCommonSshArgs("scp", selectedInstance, opts)
+	if len(args) > 0 {
+		scpArgs = append(scpArgs, args...)
+	}
+	fmt.Fprintf(os.Stderr, "exec %v\n", scpArgs)
+
+	err = retryTransientSsh(func() (string, error) {
+		return runScp(scpArgs[1:])
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to scp: %w\n", err)
+	}
+
+	return nil
+}
+
+// scpPath is the scp client which spotsh runs; tests may substitute it
+var scpPath = "/usr/bin/scp"
+
+// runScp runs scp w/ args returning its stderr output, which is also
+// passed through to spotsh's own stderr
+func runScp(args []string) (string, error) {
+	var stderrBuf bytes.Buffer
+	cmd := exec.Command(scpPath, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderrBuf)
+	err := cmd.Run()
+
+	return stderrBuf.String(), err
+}
+
+// rsyncQuote quotes arg, when necessary, such that rsync's splitting of its
+// -e command on whitespace keeps arg as a single word; rsync honors single &
+// double quotes but not backslashes so an embedded single quote is instead
+// double quoted
+func rsyncQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\") {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'"'"'`) + "'"
+}
+
+func rsyncMain(awsCfg aws.Config, args []string) error {
+	const SpotHostVar = "{s}"
+
+	selectedInstance, opts, err := selectOrLaunchWithArgs(&awsCfg,
+		"spotsh rsync", false, &args, nil)
+	if err != nil {
+		return err
+	}
+	err = checkSshHost(selectedInstance, opts)
+	if err != nil {
+		return err
+	}
+
+	// replace all instances of {s} in remaining args with user@ip
+	userAtIp := selectedInstance.User + "@" + getSshHost(selectedInstance, opts)
+	for idx := range args {
+		args[idx] = strings.ReplaceAll(args[idx], SpotHostVar, userAtIp)
+	}
+
+	// rsync compresses w/ -z itself; don't also compress the ssh transport
+	transportOpts := *opts
+	transportOpts.compress = false
+	sshArgs := getCommonSshArgs("ssh", selectedInstance, &transportOpts)
+	for idx := range sshArgs {
+		sshArgs[idx] = rsyncQuote(sshArgs[idx])
+	}
+	rsyncArgs := []string{"rsync", "-e", strings.Join(sshArgs, " ")}
+	if opts.compress {
+		rsyncArgs = append(rsyncArgs, "-z")
+	}
+	rsyncArgs = append(rsyncArgs, args...)
+	fmt.Printf("exec %v\n", rsyncArgs)
+
+	err = syscall.Exec("/usr/bin/rsync", rsyncArgs, os.Environ())
+	if err != nil {
+		return fmt.Errorf("Failed to rsync: %w\n", err)
+	}
+
+	return nil
+}
+
+func imageMain(awsCfg aws.Config, args []string) error {
+	var name, desc, instanceId string
 	f := flag.NewFlagSet("spotsh image", flag.ContinueOnError)
 	f.StringVar(&name, "name", "", "The name of the AMI to be created")
 	f.StringVar(&desc, "desc", "", "The description of the AMI to be created")
 	f.StringVar(&instanceId, "instance-id", "", "EC2 instance id")
 
-	err := f.Parse(args)
+	err := parseWithRegionFlags(&awsCfg, f, args)
 	if err != nil {
 		return err
 	}
@@ -373,7 +1617,8 @@ func imageMain(awsCfg aws.Config, args []string) error {
 		return err
 	}
 
-	amiId, err := iaws.CreateImage(awsCfg, instanceId, name, desc)
+	amiId, err := iaws.CreateImage(awsCfg, selectedInstance.InstanceId, name,
+		desc)
 	if err != nil {
 		return fmt.Errorf("Failed to create AMI: %w", err)
 	}
@@ -384,22 +1629,172 @@ func imageMain(awsCfg aws.Config, args []string) error {
 	return nil
 }
 
-func selectOrLaunchWithArgs(awsCfg aws.Config, cmdName string, canLaunch bool,
-	args *[]string) (*iaws.LaunchEc2SpotResult, error) {
+func resizeTypeMain(awsCfg aws.Config, args []string) error {
+	var newType, instanceId string
+	f := flag.NewFlagSet("spotsh resize-type", flag.ContinueOnError)
+	f.StringVar(&newType, "type", "", "The instance type to change to")
+	f.StringVar(&instanceId, "instance-id", "", "EC2 instance id")
 
-	sshOpts := struct {
-		instanceId string
-	}{}
+	err := parseWithRegionFlags(&awsCfg, f, args)
+	if err != nil {
+		return err
+	}
+	if newType == "" {
+		return fmt.Errorf("--type must be specified")
+	}
 
-	f := flag.NewFlagSet(cmdName, flag.ContinueOnError)
-	f.StringVar(&sshOpts.instanceId, "instance-id", "", "EC2 instance id")
-	err := f.Parse(*args)
+	selectedInstance, err := selectOrLaunch(awsCfg, false, instanceId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Stopping %v to change its type from %v to %v...\n",
+		selectedInstance.InstanceId, selectedInstance.InstanceType, newType)
+	err = iaws.ResizeInstanceType(awsCfg, selectedInstance.InstanceId,
+		types.InstanceType(newType))
+	if err != nil {
+		return err
+	}
+
+	// the public ip changes across a stop/start
+	resizedInstance, err := selectOrLaunch(awsCfg, false,
+		selectedInstance.InstanceId)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Instance %v is now type %v w/ public ip %v\n",
+		resizedInstance.InstanceId, resizedInstance.InstanceType,
+		resizedInstance.PublicIp)
+
+	return nil
+}
+
+type sshOpts struct {
+	instanceId   string
+	jumpHost     string
+	forwardAgent bool
+	noFirewall   bool
+	compress     bool
+	tty          int // number of -t passed to ssh; >1 forces a tty
+	sudo         bool
+	reconnect    bool
+	port         int32 // port on which the instance's sshd listens
+}
+
+// ttyCount counts occurrences of ssh's -t flag; each -tt counts twice
+type ttyCount struct {
+	count *int
+	inc   int
+}
+
+func (t ttyCount) String() string {
+	if t.count == nil {
+		return "0"
+	}
+	return strconv.Itoa(*t.count)
+}
+
+func (t ttyCount) Set(value string) error {
+	set, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if set {
+		*t.count += t.inc
+	}
+	return nil
+}
+
+func (t ttyCount) IsBoolFlag() bool {
+	return true
+}
+
+// addSshFlags defines the flags common to each subcommand which selects an
+// instance & connects to it via ssh
+func addSshFlags(f *flag.FlagSet, cmdName string, opts *sshOpts, port *int) {
+	f.StringVar(&opts.instanceId, "instance-id", "", "EC2 instance id")
+	f.StringVar(&opts.jumpHost, "jump", "",
+		"Jump host to connect through; e.g. user@bastion")
+	f.BoolVar(&opts.forwardAgent, "A", opts.forwardAgent,
+		"Forward the local ssh agent to the instance")
+	f.BoolVar(&opts.forwardAgent, "forward-agent", opts.forwardAgent,
+		"Forward the local ssh agent to the instance")
+	f.BoolVar(&opts.noFirewall, "no-firewall", false,
+		"Never modify the instance's security group to allow ssh")
+	f.BoolVar(&opts.compress, "C", false,
+		"Compress data transferred to/from the instance")
+	f.BoolVar(&opts.compress, "compress", false,
+		"Compress data transferred to/from the instance")
+	f.IntVar(port, "ssh-port", *port, "Port on which the instance's sshd listens")
+	f.Var(ttyCount{&opts.tty, 1}, "t",
+		"Force pseudo-terminal allocation; repeat to force even w/o a local tty")
+	f.Var(ttyCount{&opts.tty, 1}, "tty",
+		"Force pseudo-terminal allocation; repeat to force even w/o a local tty")
+	f.Var(ttyCount{&opts.tty, 2}, "tt",
+		"Force pseudo-terminal allocation even w/o a local tty")
+	if cmdName == "spotsh ssh" {
+		// only meaningful for ssh sessions & remote commands
+		f.BoolVar(&opts.sudo, "sudo", false,
+			"Run the remote command or login shell as root via sudo")
+		f.BoolVar(&opts.reconnect, "reconnect", false,
+			"Reconnect once the instance is reachable again if the session is lost")
+	}
+}
+
+// selectOrLaunchWithArgs parses the ssh flags from args, along w/ any extra
+// flags defined on the flag set by addFlags, then selects or launches the
+// instance to operate on
+func selectOrLaunchWithArgs(awsCfg *aws.Config, cmdName string, canLaunch bool,
+	args *[]string, addFlags func(f *flag.FlagSet)) (*iaws.LaunchEc2SpotResult,
+	*sshOpts, error) {
+
+	opts, err := parseSshArgs(awsCfg, cmdName, args, addFlags)
+	if err != nil {
+		return nil, nil, err
+	}
+	selectedInstance, err := selectOrLaunch(*awsCfg, canLaunch, opts.instanceId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return selectedInstance, opts, nil
+}
+
+// parseSshArgs parses the ssh & region flags along w/ any defined by addFlags
+// from args leaving only the remaining positional args
+func parseSshArgs(awsCfg *aws.Config, cmdName string, args *[]string,
+	addFlags func(f *flag.FlagSet)) (*sshOpts, error) {
+
+	prefs, err := loadPrefs(*awsCfg)
 	if err != nil {
 		return nil, err
 	}
+	prefsPort, err := getSshPortPref(prefs)
+	if err != nil {
+		return nil, err
+	}
+	opts := &sshOpts{
+		forwardAgent: prefs.ForwardAgent,
+		port:         prefsPort,
+	}
 
+	f := flag.NewFlagSet(cmdName, flag.ContinueOnError)
+	port := int(opts.port)
+	addSshFlags(f, cmdName, opts, &port)
+	if addFlags != nil {
+		addFlags(f)
+	}
+	err = parseWithRegionFlags(awsCfg, f, *args)
+	if err != nil {
+		return nil, err
+	}
+	if !isValidSshPort(port) {
+		return nil, fmt.Errorf("--ssh-port must be between 1 and 65535")
+	}
+	opts.port = int32(port)
 	*args = f.Args()
-	return selectOrLaunch(awsCfg, canLaunch, sshOpts.instanceId)
+
+	return opts, nil
 }
 
 func selectOrLaunch(awsCfg aws.Config, canLaunch bool,
@@ -407,106 +1802,243 @@ func selectOrLaunch(awsCfg aws.Config, canLaunch bool,
 
 	launchResults, err := iaws.LookupEc2Spot(context.Background(), awsCfg,
 		iaws.DefaultTagPrefix)
-	if err == nil && len(launchResults) == 0 {
-		if canLaunch {
-			launchArgs, err := newLaunchArgsFromPrefs(awsCfg)
-			if err != nil {
-				return nil, err
-			}
-			var newLaunchResult iaws.LaunchEc2SpotResult
+	if err == nil && len(launchResults) == 0 && canLaunch {
+		var launchArgs *iaws.LaunchEc2SpotArgs
+		launchArgs, err = newLaunchArgsFromPrefs(awsCfg)
+		if err != nil {
+			return nil, err
+		}
+		var newLaunchResult iaws.LaunchEc2SpotResult
 
-			fmt.Fprintf(os.Stderr, "Launching new spot instance in %v...\n",
-				awsCfg.Region)
+		fmt.Fprintf(os.Stderr, "Launching new spot instance in %v...\n",
+			awsCfg.Region)
 
-			ctx := context.Background()
-			newLaunchResult, err = iaws.LaunchEc2Spot(ctx, awsCfg, launchArgs)
-			launchResults = append(launchResults, newLaunchResult)
-		} else {
-			err = fmt.Errorf("No spotsh instances running")
-		}
+		ctx, cancel := newLaunchContext()
+		newLaunchResult, err = iaws.LaunchEc2Spot(ctx, awsCfg, launchArgs)
+		cancel()
+		launchResults = append(launchResults, newLaunchResult)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("Failed to lookup/launch instance: %w", err)
 	}
 
-	if len(launchResults) > 1 && instanceId == "" {
-		errStr := "Multiple spotsh instances found; please disambiguate w/ --instance-id:"
-		for _, lr := range launchResults {
-			errStr = fmt.Sprintf("%v\n\t%v:%v", errStr, lr.InstanceId,
-				lr.PublicIp)
+	selectedInstance, err := iaws.SelectEc2Spot(launchResults, instanceId)
+	if errors.Is(err, iaws.ErrMultipleInstances) {
+		return nil, fmt.Errorf("%w\nPlease disambiguate w/ --instance-id", err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return selectedInstance, nil
+}
+
+func sshCommon(awsCfg aws.Config, canLaunch bool, args []string) error {
+	selectedInstance, opts, err := selectOrLaunchWithArgs(&awsCfg, "spotsh ssh",
+		canLaunch, &args, nil)
+	if err != nil {
+		return err
+	}
+	err = checkSshHost(selectedInstance, opts)
+	if err != nil {
+		return err
+	}
+	if opts.reconnect {
+		err = checkReconnectArgs(args, isTerminal(os.Stdin))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the instance may not be directly reachable from here or the user's
+	// credentials may not permit modifying security groups w/ --jump or
+	// --no-firewall; leave connectivity testing to ssh itself
+	if opts.jumpHost == "" && !opts.noFirewall {
+		err = waitForSsh(awsCfg, selectedInstance, opts.port)
+		if err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("%v", errStr)
+	}
+	if opts.reconnect {
+		return runSshReconnecting(awsCfg, selectedInstance, opts, args)
 	}
 
-	var selectedInstance *iaws.LaunchEc2SpotResult
-	for idx, lr := range launchResults {
-		if instanceId == "" || instanceId == lr.InstanceId {
-			selectedInstance = &launchResults[idx]
-			break
+	return execSsh(selectedInstance, opts, args)
+}
+
+// warnAmiUserMismatch warns when amiId, or the id amiName resolves to, is one
+// of spotsh's known os images whose default user differs from user since ssh
+// would then fail later w/ "Permission denied"
+func warnAmiUserMismatch(awsCfg aws.Config, amiId string, amiName string,
+	user string) {
+
+	if amiId == "" && amiName != "" {
+		var err error
+		amiId, err = iaws.LookupAmiIdByName(awsCfg, amiName)
+		if err != nil {
+			// launch reports an unresolvable --ami-name itself
+			return
 		}
 	}
+	if amiId == "" {
+		return
+	}
+	amiOs, amiUser, err := iaws.LookupAmiOs(awsCfg, amiId)
+	if err != nil || amiOs == spotsh.OsNone || amiUser == user {
+		// best effort only; unknown amis can't be checked
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: ami %v is %v whose default user is %v rather than --user %v; ssh will likely fail w/ Permission denied\n",
+		amiId, iaws.GetImageDesc(amiOs), amiUser, user)
+}
 
-	if selectedInstance == nil {
-		return nil, fmt.Errorf("Could not find spotsh instance w/ id %v",
-			instanceId)
+func readUserDataFile(what string, path string) (string, error) {
+	path, err := expandPath(path)
+	if err != nil {
+		return "", err
 	}
-	if selectedInstance.LocalKeyFile == "" {
-		return nil, fmt.Errorf("Could not find local ssh key for instance w/ id %v",
-			selectedInstance.InstanceId)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %v %v: %w", what, path, err)
 	}
 
-	return selectedInstance, nil
+	return string(content), nil
 }
 
-func sshCommon(awsCfg aws.Config, canLaunch bool, args []string) error {
-	selectedInstance, err := selectOrLaunchWithArgs(awsCfg, "spotsh ssh", canLaunch,
-		&args)
+// waitForSsh waits for selectedInstance's ssh port to become reachable,
+// adding an ingress rule for this host to its security group if necessary
+func waitForSsh(awsCfg aws.Config,
+	selectedInstance *iaws.LaunchEc2SpotResult, port int32) error {
+
+	var checkFirewall bool
+
+	err := testSsh(selectedInstance, port, &checkFirewall)
+	if err != nil {
+		if checkFirewall {
+			fmt.Fprintf(os.Stderr, "Checking or adding ssh ingress rule for security group id %v...\n",
+				selectedInstance.SgId)
+			ferr := iaws.CheckOrAddSshIngressRule(awsCfg, selectedInstance.SgId,
+				selectedInstance.Ipv6 != "", port)
+			if ferr != nil && iaws.IsUnauthorized(ferr) {
+				return fmt.Errorf("Failed to ssh err:%w ingress_add_err:%v\nThe current AWS credentials are not permitted to modify security group %v; if ssh is otherwise reachable retry w/ --no-firewall",
+					err, ferr, selectedInstance.SgId)
+			} else if ferr != nil {
+				return fmt.Errorf("Failed to ssh err:%w ingress_add_err:%v",
+					err, ferr)
+			}
+			err = testSsh(selectedInstance, port, &checkFirewall)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to open ssh port: %w\n", err)
+		}
+	}
+
+	return nil
+}
+
+// sshPath is the ssh client which spotsh execs; tests may substitute it
+var sshPath = "/usr/bin/ssh"
+
+func isValidSshPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// getSshPortPref returns the SshPort preference or sshd's default port when
+// it is unset
+func getSshPortPref(prefs *Prefs) (int32, error) {
+	if prefs.SshPort == 0 {
+		return iaws.DefaultSshPort, nil
+	}
+	if !isValidSshPort(int(prefs.SshPort)) {
+		return 0, fmt.Errorf("SshPort preference %v must be between 1 and 65535",
+			prefs.SshPort)
+	}
+
+	return prefs.SshPort, nil
+}
+
+// loadSshPort returns the SshPort preference for commands w/o --ssh-port
+func loadSshPort(awsCfg aws.Config) (int32, error) {
+	prefs, err := loadPrefs(awsCfg)
+	if err != nil {
+		return 0, err
+	}
+
+	return getSshPortPref(prefs)
+}
+
+// getSshPortArgs returns the ssh option selecting port, if any; -o Port is
+// used rather than -p since scp & sshfs spell that flag differently
+func getSshPortArgs(port int32) []string {
+	if port == 0 || port == iaws.DefaultSshPort {
+		return nil
+	}
+
+	return []string{"-o", fmt.Sprintf("Port=%v", port)}
+}
+
+func execSsh(selectedInstance *iaws.LaunchEc2SpotResult, opts *sshOpts,
+	args []string) error {
+
+	sshArgs := getSshExecArgs(selectedInstance, opts, args,
+		isTerminal(os.Stdin))
+
+	fmt.Fprintf(os.Stderr, "exec %v\n", sshArgs)
+
+	// spotsh's stdin, stdout, & stderr are inherited across exec so e.g.
+	// 'cat build.sh | spotsh ssh bash' streams build.sh to the remote bash
+	err := syscall.Exec(sshPath, sshArgs, os.Environ())
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to exec ssh: %w\n", err)
 	}
 
-	var checkFirewall bool
+	return nil
+}
 
-	err = testSsh(selectedInstance, &checkFirewall)
-	if err != nil {
-		if checkFirewall {
-			fmt.Fprintf(os.Stderr, "Checking or adding ssh ingress rule for security group id %v...\n",
-				selectedInstance.SgId)
-			ferr := iaws.CheckOrAddSshIngressRule(awsCfg, selectedInstance.SgId)
-			if ferr != nil {
-				return fmt.Errorf("Failed to ssh err:%w ingress_add_err:%v",
-					err, ferr)
-			}
-			err = testSsh(selectedInstance, &checkFirewall)
-		}
+func getSshExecArgs(selectedInstance *iaws.LaunchEc2SpotResult,
+	opts *sshOpts, args []string, stdinIsTerminal bool) []string {
 
-		if err != nil {
-			return fmt.Errorf("Failed to open ssh port: %w\n", err)
+	sshArgs := getCommonSshArgs("ssh", selectedInstance, opts)
+	tty := opts.tty
+	if opts.sudo {
+		if len(args) == 0 {
+			// an interactive root login shell requires a tty
+			args = []string{"sudo", "-i"}
+			tty = max(tty, 1)
+		} else {
+			args = append([]string{"sudo"}, args...)
 		}
 	}
-
-	return execSsh(selectedInstance, args)
-}
-
-func execSsh(selectedInstance *iaws.LaunchEc2SpotResult, args []string) error {
-	sshArgs := getCommonSshArgs("ssh", selectedInstance)
-	sshArgs = append(sshArgs, selectedInstance.User+"@"+selectedInstance.PublicIp)
+	if tty > 0 {
+		for ii := 0; ii < tty; ii++ {
+			sshArgs = append(sshArgs, "-t")
+		}
+	} else if !stdinIsTerminal {
+		// input is being piped in; don't request a pseudo-terminal which
+		// would otherwise echo & mangle the input stream
+		sshArgs = append(sshArgs, "-T")
+	}
+	sshArgs = append(sshArgs,
+		selectedInstance.User+"@"+getSshHost(selectedInstance, opts))
 
 	if len(args) > 0 {
 		sshArgs = append(sshArgs, args...)
 	}
 
-	fmt.Fprintf(os.Stderr, "exec %v\n", sshArgs)
+	return sshArgs
+}
 
-	err := syscall.Exec("/usr/bin/ssh", sshArgs, os.Environ())
+func isTerminal(f *os.File) bool {
+	fileInfo, err := f.Stat()
 	if err != nil {
-		return fmt.Errorf("Failed to exec ssh: %w\n", err)
+		return false
 	}
 
-	return nil
+	return fileInfo.Mode()&os.ModeCharDevice != 0
 }
 
-func testSsh(selectedInstance *iaws.LaunchEc2SpotResult,
+func testSsh(selectedInstance *iaws.LaunchEc2SpotResult, port int32,
 	checkFirewallOut *bool) error {
 
 	var err error
@@ -519,7 +2051,7 @@ func testSsh(selectedInstance *iaws.LaunchEc2SpotResult,
 		fmt.Fprintf(os.Stderr, ".")
 
 		checkFirewall = false
-		err = testSshOnce(selectedInstance)
+		err = testSshOnce(selectedInstance, port)
 		if err == nil {
 			break
 		}
@@ -548,9 +2080,12 @@ func testSsh(selectedInstance *iaws.LaunchEc2SpotResult,
 	return err
 }
 
-func testSshOnce(selectedInstance *iaws.LaunchEc2SpotResult) error {
+func testSshOnce(selectedInstance *iaws.LaunchEc2SpotResult,
+	port int32) error {
+
 	conn, err := net.DialTimeout("tcp",
-		net.JoinHostPort(selectedInstance.PublicIp, "22"), 5*time.Second)
+		net.JoinHostPort(selectedInstance.PublicIp,
+			strconv.Itoa(int(port))), 5*time.Second)
 	if err != nil {
 		return err
 	}
@@ -559,23 +2094,80 @@ func testSshOnce(selectedInstance *iaws.LaunchEc2SpotResult) error {
 	return nil
 }
 
+// testSshReachability concurrently runs testFn against each of
+// launchResults returning a map from instance id to "reachable" or
+// "unreachable"
+func testSshReachability(launchResults []iaws.LaunchEc2SpotResult,
+	testFn func(*iaws.LaunchEc2SpotResult) error) map[string]string {
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	status := make(map[string]string)
+	for idx := range launchResults {
+		lr := &launchResults[idx]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "reachable"
+			if lr.PublicIp == "" || testFn(lr) != nil {
+				result = "unreachable"
+			}
+			mutex.Lock()
+			status[lr.InstanceId] = result
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return status
+}
+
 func upgradeMain(awsCfg aws.Config, args []string) error {
+	var rollback bool
+	var toVer string
+	f := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	f.BoolVar(&rollback, "rollback", false,
+		"Restore the spotsh binary replaced by the most recent upgrade")
+	f.StringVar(&toVer, "to", "", "Install a specific release; e.g. v0.1.0")
+	err := f.Parse(args)
+	if err != nil {
+		return err
+	}
+	if rollback && toVer != "" {
+		return fmt.Errorf("--rollback and --to are mutually exclusive; choose only one")
+	}
+	if rollback {
+		return rollbackUpgrade()
+	}
+
 	if versionText == DevVersionText {
 		fmt.Fprintf(os.Stderr, "Skipping spotsh upgrade on development version\n")
 		return nil
 	}
-	latestVer, err := getLatestVersion()
-	if err != nil {
-		return err
+	targetVer := toVer
+	if targetVer == "" {
+		targetVer, err = getLatestVersion()
+		if err != nil {
+			return err
+		}
 	}
-	if latestVer == versionText {
-		fmt.Printf("spotsh %v is already the latest version\n",
-			versionText)
+	if targetVer == versionText {
+		if toVer == "" {
+			fmt.Printf("spotsh %v is already the latest version\n",
+				versionText)
+		} else {
+			fmt.Printf("spotsh %v is already installed\n", versionText)
+		}
 		return nil
 	}
 
-	fmt.Printf("A new version of spotsh is available (%v). Upgrade? (Y/N) [Y]: ",
-		latestVer)
+	if toVer == "" {
+		fmt.Printf("A new version of spotsh is available (%v). Upgrade? (Y/N) [Y]: ",
+			targetVer)
+	} else {
+		fmt.Printf("Replace spotsh %v with %v? (Y/N) [Y]: ", versionText,
+			targetVer)
+	}
 	shouldUpgrade := "Y"
 	fmt.Scanf("%s", &shouldUpgrade)
 	shouldUpgrade = strings.ToUpper(strings.TrimSpace(shouldUpgrade))
@@ -585,9 +2177,9 @@ func upgradeMain(awsCfg aws.Config, args []string) error {
 	}
 
 	fmt.Printf("Upgrading spotsh from %v to %v...\n", versionText,
-		latestVer)
+		targetVer)
 
-	return upgradeViaGithub(latestVer)
+	return upgradeViaGithub(targetVer)
 }
 
 func getLatestVersion() (string, error) {
@@ -629,8 +2221,12 @@ func upgradeViaGithub(latestVer string) error {
 
 	resp, err := client.Get(fmt.Sprintf(LatestDownloadFmt, latestVer))
 	if err != nil {
-		return fmt.Errorf("Failed to download version %v: %w", versionText, err)
-
+		return fmt.Errorf("Failed to download version %v: %w", latestVer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to download version %v: %v", latestVer,
+			resp.Status)
 	}
 
 	tmpFile, err := os.CreateTemp("", "spotsh-*")
@@ -639,29 +2235,27 @@ func upgradeViaGithub(latestVer string) error {
 	}
 	binaryContent, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("Failed to download version %v: %w", versionText, err)
+		return fmt.Errorf("Failed to download version %v: %w", latestVer, err)
 	}
 	_, err = tmpFile.Write(binaryContent)
 	if err != nil {
-		return fmt.Errorf("Failed to download version %v: %w", versionText, err)
+		return fmt.Errorf("Failed to download version %v: %w", latestVer, err)
 	}
 	err = tmpFile.Chmod(0755)
 	if err != nil {
-		return fmt.Errorf("Failed to download version %v: %w", versionText, err)
+		return fmt.Errorf("Failed to download version %v: %w", latestVer, err)
 	}
 	err = tmpFile.Close()
 	if err != nil {
-		return fmt.Errorf("Failed to download version %v: %w", versionText, err)
+		return fmt.Errorf("Failed to download version %v: %w", latestVer, err)
 	}
-	myBinaryPath, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("Could not determine path to spotsh: %w", err)
-	}
-	myBinaryPath, err = filepath.EvalSymlinks(myBinaryPath)
+	myBinaryPath, err := getMyBinaryPath()
 	if err != nil {
-		return fmt.Errorf("Could not determine path to spotsh: %w", err)
+		return err
 	}
 
+	// the replaced binary is retained so that 'upgrade --rollback' can
+	// restore it
 	myBinaryPathBak := myBinaryPath + ".bak"
 	err = os.Rename(myBinaryPath, myBinaryPathBak)
 	if err != nil {
@@ -681,13 +2275,70 @@ func upgradeViaGithub(latestVer string) error {
 		_ = os.Rename(myBinaryPathBak, myBinaryPath)
 		return err
 	}
-	_ = os.Remove(myBinaryPathBak)
 
-	fmt.Printf("Upgrade %v to %v complete\n", myBinaryPath, latestVer)
+	fmt.Printf("Upgrade %v to %v complete; 'spotsh upgrade --rollback' restores %v\n",
+		myBinaryPath, latestVer, versionText)
+
+	return nil
+}
+
+func getMyBinaryPath() (string, error) {
+	myBinaryPath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("Could not determine path to spotsh: %w", err)
+	}
+	myBinaryPath, err = filepath.EvalSymlinks(myBinaryPath)
+	if err != nil {
+		return "", fmt.Errorf("Could not determine path to spotsh: %w", err)
+	}
+
+	return myBinaryPath, nil
+}
+
+func rollbackUpgrade() error {
+	myBinaryPath, err := getMyBinaryPath()
+	if err != nil {
+		return err
+	}
+	err = swapBinaryWithBackup(myBinaryPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rolled back %v to its previous version; 'spotsh upgrade --rollback' again restores %v\n",
+		myBinaryPath, versionText)
 
 	return nil
 }
 
+// swapBinaryWithBackup restores binaryPath's .bak left by a previous upgrade
+// while retaining the current binary as the new .bak so that a rollback can
+// itself be undone
+func swapBinaryWithBackup(binaryPath string) error {
+	binaryPathBak := binaryPath + ".bak"
+	_, err := os.Stat(binaryPathBak)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("No previous version of spotsh found at %v",
+			binaryPathBak)
+	} else if err != nil {
+		return err
+	}
+
+	binaryPathTmp := binaryPath + ".rollback"
+	err = os.Rename(binaryPath, binaryPathTmp)
+	if err != nil {
+		return fmt.Errorf("Could not replace existing %v; do you need to be root?: %w",
+			binaryPath, err)
+	}
+	err = os.Rename(binaryPathBak, binaryPath)
+	if err != nil {
+		_ = os.Rename(binaryPathTmp, binaryPath)
+		return fmt.Errorf("Could not restore %v: %w", binaryPathBak, err)
+	}
+
+	return os.Rename(binaryPathTmp, binaryPathBak)
+}
+
 func checkAndPrintUpgradeWarning() bool {
 	if versionText == DevVersionText {
 		return false
@@ -707,6 +2358,15 @@ func checkAndPrintUpgradeWarning() bool {
 }
 
 func getConfigDir() (string, error) {
+	// per the XDG base directory spec relative paths are ignored
+	xdgConfigHome, err := expandPath(os.Getenv("XDG_CONFIG_HOME"))
+	if err != nil {
+		return "", err
+	}
+	if xdgConfigHome != "" && filepath.IsAbs(xdgConfigHome) {
+		return filepath.Join(xdgConfigHome, "spotsh"), nil
+	}
+
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("Could not find user home directory: %w", err)
@@ -715,7 +2375,32 @@ func getConfigDir() (string, error) {
 	return filepath.Join(homeDir, ".config", "spotsh"), nil
 }
 
+// expandPath expands $VAR/${VAR} references & a leading ~ (the current
+// user's home directory) in a user supplied path since, unlike the shell,
+// spotsh otherwise treats them literally (e.g. in --config=~/prefs.json)
+func expandPath(path string) (string, error) {
+	path = os.ExpandEnv(path)
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("Could not find user home directory to expand %v: %w",
+			path, err)
+	}
+
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~")), nil
+}
+
+// configPathOverride is set via the global --config flag or $SPOTSH_CONFIG
+// and when non-empty replaces the default prefs.json location
+var configPathOverride string
+
 func getConfigPath() (string, error) {
+	if configPathOverride != "" {
+		return expandPath(configPathOverride)
+	}
 	configDir, err := getConfigDir()
 	if err != nil {
 		return "", err
@@ -746,6 +2431,11 @@ func storeConfigPrefs(configFilePath string, prefs *Prefs) error {
 	if err != nil {
 		return err
 	}
+	err = os.MkdirAll(filepath.Dir(configFilePath), 0700)
+	if err != nil {
+		return fmt.Errorf("Could not create config directory for %v: %w",
+			configFilePath, err)
+	}
 
 	return ioutil.WriteFile(configFilePath, configContent, 0600)
 }
@@ -760,7 +2450,7 @@ func newPrefs() *Prefs {
 	return ret
 }
 
-func newLaunchArgsFromPrefs(awsCfg aws.Config) (*iaws.LaunchEc2SpotArgs, error) {
+func loadPrefs(awsCfg aws.Config) (*Prefs, error) {
 	configFilePath, err := getConfigPath()
 	if err != nil {
 		return nil, err
@@ -772,19 +2462,101 @@ func newLaunchArgsFromPrefs(awsCfg aws.Config) (*iaws.LaunchEc2SpotArgs, error)
 		return nil, err
 	}
 
+	return prefs, nil
+}
+
+func newLaunchArgsFromPrefs(awsCfg aws.Config) (*iaws.LaunchEc2SpotArgs, error) {
+	return newLaunchArgsFromProfile(awsCfg, "")
+}
+
+// newLaunchArgsFromProfile returns launch arguments derived from spotsh's
+// preferences w/ the named profile, if any, merged over them
+func newLaunchArgsFromProfile(awsCfg aws.Config,
+	profileName string) (*iaws.LaunchEc2SpotArgs, error) {
+
+	prefs, err := loadPrefs(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	var profile *Profile
+	if profileName != "" {
+		var ok bool
+		profile, ok = prefs.Profiles[profileName]
+		if !ok || profile == nil {
+			names := make([]string, 0, len(prefs.Profiles))
+			for name := range prefs.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("No such profile '%v' in spotsh preferences; available profiles: %v",
+				profileName, strings.Join(names, ","))
+		}
+		mergeProfile(prefs, profile)
+	}
+
+	iTypePriorities, err :=
+		string2iTypePriorities(strings.Join(prefs.InstanceTypes, ","))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse instance type preferences: %w",
+			err)
+	}
+
 	launchArgs := &iaws.LaunchEc2SpotArgs{
-		Os:               spotsh.OsFromString(prefs.Os),
-		KeyPair:          prefs.keyPair,
-		SecurityGroupId:  prefs.securityGroup,
-		InstanceTypes:    stringSlice2iTypeSlice(prefs.InstanceTypes),
-		MaxSpotPrice:     prefs.MaxSpotPrice,
-		RootVolSizeInGiB: prefs.RootVolSizeInGiB,
+		Os:                     spotsh.OsFromString(prefs.Os),
+		KeyPair:                prefs.keyPair,
+		SecurityGroupId:        prefs.securityGroup,
+		InstanceTypes:          stringSlice2iTypeSlice(prefs.InstanceTypes),
+		InstanceTypePriorities: iTypePriorities,
+		MaxSpotPrice:           prefs.MaxSpotPrice,
+		RootVolSizeInGiB:       prefs.RootVolSizeInGiB,
+		NoDefaultKey:           prefs.NoDefaultKey,
+	}
+	if profile != nil {
+		launchArgs.AttachRoleName = profile.Role
+		launchArgs.InitCmd = profile.InitCmd
 	}
 
 	return launchArgs, nil
 }
 
+func mergeProfile(prefs *Prefs, profile *Profile) {
+	if profile.Os != "" {
+		prefs.Os = profile.Os
+	}
+	if len(profile.InstanceTypes) > 0 {
+		prefs.InstanceTypes = profile.InstanceTypes
+	}
+	if profile.MaxSpotPrice != "" {
+		prefs.MaxSpotPrice = profile.MaxSpotPrice
+	}
+	if profile.RootVolSizeInGiB != 0 {
+		prefs.RootVolSizeInGiB = profile.RootVolSizeInGiB
+	}
+}
+
 func configMain(awsCfg aws.Config, args []string) error {
+	var regionInitList string
+	var show, asJson bool
+	f := flag.NewFlagSet("spotsh config", flag.ContinueOnError)
+	f.StringVar(&regionInitList, "region-init", "",
+		"Comma separated regions in which to pre-create keys & validate security groups")
+	f.BoolVar(&show, "show", false,
+		"Display the effective preferences rather than changing them")
+	f.BoolVar(&asJson, "json", false, "Display --show output as json")
+	err := parseWithRegionFlags(&awsCfg, f, args)
+	if err != nil {
+		return err
+	}
+	if asJson && !show {
+		return fmt.Errorf("--json is only supported w/ spotsh config --show")
+	}
+	if show {
+		return configShowMain(awsCfg, os.Stdout, asJson)
+	}
+	if regionInitList != "" {
+		return regionInitMain(awsCfg, regionInitList)
+	}
+
 	configDir, err := getConfigDir()
 	if err != nil {
 		return err
@@ -808,6 +2580,39 @@ func configMain(awsCfg aws.Config, args []string) error {
 	return err
 }
 
+// regionInitMain warms each listed region so that later launches there
+// needn't create keys or discover security groups inline
+func regionInitMain(awsCfg aws.Config, regionInitList string) error {
+	var failedRegions []string
+
+	for _, region := range strings.Split(regionInitList, ",") {
+		region = strings.TrimSpace(region)
+		if region == "" {
+			continue
+		}
+		regionCfg := awsCfg.Copy()
+		regionCfg.Region = region
+		initResult, err := iaws.InitRegion(regionCfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", region, err)
+			failedRegions = append(failedRegions, region)
+			continue
+		}
+		keyStatus := "exists"
+		if initResult.KeyCreated {
+			keyStatus = "created"
+		}
+		fmt.Printf("%v: keypair %v %v; security group %v\n", region,
+			initResult.KeyName, keyStatus, initResult.SecurityGroupId)
+	}
+	if len(failedRegions) > 0 {
+		return fmt.Errorf("Failed to initialize region(s): %v",
+			strings.Join(failedRegions, ","))
+	}
+
+	return nil
+}
+
 func prefsMain(awsCfg aws.Config, args []string) error {
 	configFilePath, err := getConfigPath()
 	if err != nil {
@@ -853,7 +2658,7 @@ func prefsMain(awsCfg aws.Config, args []string) error {
 	// set itype pref
 	iTypeList := iTypeSlice2String(iaws.DefaultInstanceTypes)
 	if len(prefs.InstanceTypes) > 0 {
-		iTypeList = iTypeSlice2String(stringSlice2iTypeSlice(prefs.InstanceTypes))
+		iTypeList = strings.Join(prefs.InstanceTypes, ",")
 	}
 	fmt.Printf("Default instance types: %v Change? (Y/N) [N]: ", iTypeList)
 	changePref = "N"
@@ -902,32 +2707,33 @@ func prefsMain(awsCfg aws.Config, args []string) error {
 
 	// set security group pref
 	sgId, err := iaws.GetDefaultSecurityGroupId(awsCfg)
+	ambiguousVpc := errors.Is(err, iaws.ErrAmbiguousVpc)
 	if err != nil {
 		sgId = "<none>"
 	}
 	if prefs.SecurityGroups[awsCfg.Region] != "" {
 		sgId = prefs.SecurityGroups[awsCfg.Region]
+		ambiguousVpc = false
+	}
+	if ambiguousVpc {
+		// spotsh can't pick a security group on its own so launch would
+		// fail; require the user to choose one
+		fmt.Printf("No default VPC found in %v and more than one VPC exists; please choose a default security group\n",
+			awsCfg.Region)
+		changePref = "Y"
+	} else {
+		fmt.Printf("Default security group id: %v Change? (Y/N) [N]: ", sgId)
+		changePref = "N"
+		fmt.Scanf("%s", &changePref)
+		changePref = strings.ToUpper(strings.TrimSpace(changePref))
 	}
-	fmt.Printf("Default security group id: %v Change? (Y/N) [N]: ", sgId)
-	changePref = "N"
-	fmt.Scanf("%s", &changePref)
-	changePref = strings.ToUpper(strings.TrimSpace(changePref))
 	if changePref[0] == 'Y' {
 		existingSgs, err := iaws.LookupVpcSecurityGroups(awsCfg)
 		if err != nil {
 			return err
 		}
 		fmt.Printf("  Available Security Groups: \n")
-		for _, vpc := range existingSgs.Vpcs {
-			if vpc.Default {
-				fmt.Printf("    Vpc %v (default):\n", vpc.Id)
-			} else {
-				fmt.Printf("    Vpc %v:\n", vpc.Id)
-			}
-			for _, sg := range vpc.Sgs {
-				fmt.Printf("      %v\n", sg.Id)
-			}
-		}
+		fmt.Printf("%v", existingSgs.String())
 		fmt.Printf("  Enter preferred default security group: ")
 		newSgId := ""
 		fmt.Scanf("%s", &newSgId)
@@ -975,50 +2781,197 @@ func prefsMain(awsCfg aws.Config, args []string) error {
 		prefs.RootVolSizeInGiB = newRootVolSize
 	}
 
-	return storeConfigPrefs(configFilePath, prefs)
-}
+	// set ssh agent forwarding pref
+	fmt.Printf("Forward ssh agent by default: %v Change? (Y/N) [N]: ",
+		prefs.ForwardAgent)
+	changePref = "N"
+	fmt.Scanf("%s", &changePref)
+	changePref = strings.ToUpper(strings.TrimSpace(changePref))
+	if changePref[0] == 'Y' {
+		prefs.ForwardAgent = !prefs.ForwardAgent
+	}
 
-func priceMain(awsCfg aws.Config, args []string) error {
-	launchArgs, err := newLaunchArgsFromPrefs(awsCfg)
-	if err != nil {
-		return err
+	// set no default key pref
+	fmt.Printf("Never create a default keypair: %v Change? (Y/N) [N]: ",
+		prefs.NoDefaultKey)
+	changePref = "N"
+	fmt.Scanf("%s", &changePref)
+	changePref = strings.ToUpper(strings.TrimSpace(changePref))
+	if changePref[0] == 'Y' {
+		prefs.NoDefaultKey = !prefs.NoDefaultKey
 	}
 
-	f := flag.NewFlagSet("spotsh price", flag.ContinueOnError)
-	iTypeList := iTypeSlice2String(iaws.DefaultInstanceTypes)
-	if len(launchArgs.InstanceTypes) > 0 {
-		iTypeList = iTypeSlice2String(launchArgs.InstanceTypes)
+	// set read-only commands default to --region all pref
+	fmt.Printf("Default info & price to --region all: %v Change? (Y/N) [N]: ",
+		prefs.DefaultAllRegionsForReads)
+	changePref = "N"
+	fmt.Scanf("%s", &changePref)
+	changePref = strings.ToUpper(strings.TrimSpace(changePref))
+	if changePref[0] == 'Y' {
+		prefs.DefaultAllRegionsForReads = !prefs.DefaultAllRegionsForReads
 	}
-	f.StringVar(&iTypeList, "types", iTypeList, "Instance types")
-	err = f.Parse(args)
-	if err != nil {
-		return err
+
+	// set --region all pref
+	regionList := "<all enabled regions>"
+	if len(prefs.Regions) > 0 {
+		regionList = strings.Join(prefs.Regions, ",")
+	}
+	fmt.Printf("Regions for --region all: %v Change? (Y/N) [N]: ", regionList)
+	changePref = "N"
+	fmt.Scanf("%s", &changePref)
+	changePref = strings.ToUpper(strings.TrimSpace(changePref))
+	if changePref[0] == 'Y' {
+		fmt.Printf("  Enter preferred regions (comma separated; 'all' for every enabled region): ")
+		newRegionList := ""
+		fmt.Scanf("%s", &newRegionList)
+		newRegionList = strings.TrimSpace(newRegionList)
+		newRegionList = strings.Split(newRegionList, " ")[0]
+		prefs.Regions = nil
+		if newRegionList != "all" && newRegionList != "" {
+			prefs.Regions = strings.Split(newRegionList, ",")
+		}
 	}
 
-	iTypes := string2iTypeSlice(iTypeList)
-	lookupResult, err := iaws.LookupEc2SpotPrices(awsCfg, iTypes)
+	return storeConfigPrefs(configFilePath, prefs)
+}
+
+// extractStringArg removes any --<name> flag from args returning its value
+// along w/ the remaining args. This allows flags whose value determines
+// other flags' defaults to be processed first. Arguments following a "--"
+// separator are passed through untouched.
+func extractStringArg(args []string, flagName string) (string, []string,
+	error) {
+
+	value := ""
+	remaining := make([]string, 0, len(args))
+
+	for ii := 0; ii < len(args); ii++ {
+		arg := args[ii]
+		if arg == "--" {
+			remaining = append(remaining, args[ii:]...)
+			break
+		}
+		name, argValue, hasValue := strings.Cut(arg, "=")
+		if name != "--"+flagName && name != "-"+flagName {
+			remaining = append(remaining, arg)
+			continue
+		}
+		if !hasValue {
+			if ii+1 >= len(args) {
+				return "", nil, fmt.Errorf("flag needs an argument: %v", arg)
+			}
+			ii++
+			argValue = args[ii]
+		}
+		if argValue == "" {
+			return "", nil, fmt.Errorf("Invalid empty value for %v", name)
+		}
+		value = argValue
+	}
+
+	return value, remaining, nil
+}
+
+// regionFlags select the AWS profile & region(s) to operate on; they may be
+// given either before the subcommand name or after it
+type regionFlags struct {
+	region     string
+	profile    string
+	allRegions bool
+	regionSet  bool // region was explicitly specified
+	readOnly   bool // the subcommand is one of readOnlySubCommands
+}
+
+// globalRegionFlags are the region flags given before the subcommand name;
+// they serve as the defaults of those given after it
+var globalRegionFlags regionFlags
+
+// addRegionFlags defines the region flags on f w/ rf's current values as
+// their defaults
+func addRegionFlags(f *flag.FlagSet, rf *regionFlags) {
+	f.StringVar(&rf.region, "region", rf.region, "AWS region; e.g. us-east-2")
+	f.StringVar(&rf.profile, "profile", rf.profile, "AWS shared config profile")
+	f.BoolVar(&rf.allRegions, "all-regions", rf.allRegions,
+		"Operate on every enabled region ignoring the Regions preference")
+}
+
+// parseWithRegionFlags parses args w/ the subcommand's flag set f after
+// adding the region flags to it; awsCfg is reloaded when any of them were
+// specified
+func parseWithRegionFlags(awsCfg *aws.Config, f *flag.FlagSet,
+	args []string) error {
+
+	rf := globalRegionFlags
+	addRegionFlags(f, &rf)
+	err := f.Parse(args)
 	if err != nil {
 		return err
 	}
+	if !flagWasSet(f, "region") && !flagWasSet(f, "profile") &&
+		!flagWasSet(f, "all-regions") {
+		return nil
+	}
+	rf.regionSet = rf.regionSet || flagWasSet(f, "region")
+	*awsCfg, err = loadAwsConfig(context.Background(), rf)
 
-	for _, lookupInst := range lookupResult.InstanceTypes {
-		for _, lookupReg := range lookupInst.Regions {
-			if lookupReg.CheapestAz == nil {
-				continue
-			}
+	return err
+}
 
-			lookupAz := lookupReg.CheapestAz
-			if lookupReg == lookupInst.CheapestRegion &&
-				lookupInst == lookupResult.CheapestIType {
-				fmt.Printf(" ** ")
-			}
+// loadAwsConfig loads the AWS config of the profile & region selected by rf
+func loadAwsConfig(ctx context.Context, rf regionFlags) (aws.Config, error) {
+	var cfgOpts []func(*config.LoadOptions) error
+	if rf.profile != "" {
+		cfgOpts = append(cfgOpts, config.WithSharedConfigProfile(rf.profile))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, cfgOpts...)
+	if err != nil {
+		return awsCfg, err
+	}
+	region := rf.region
+	if !rf.regionSet {
+		region = awsCfg.Region
+	}
+	if !rf.regionSet && rf.profile != "" {
+		prefs, err := loadPrefs(awsCfg)
+		if err != nil {
+			return awsCfg, err
+		}
+		profileRegion := prefs.ProfileRegions[rf.profile]
+		if profileRegion != "" {
+			// a profile's mapped region is treated as if explicitly given
+			region = profileRegion
+			rf.regionSet = true
+		}
+	}
+	if rf.allRegions {
+		region = "all"
+	} else if !rf.regionSet && rf.readOnly {
+		prefs, err := loadPrefs(awsCfg)
+		if err != nil {
+			return awsCfg, err
+		}
+		if prefs.DefaultAllRegionsForReads {
+			region = "all"
+		}
+	}
 
-			fmt.Printf("%v - %v - %v - $%v/hr\n", lookupInst.InstanceType,
-				lookupReg.Region, lookupAz.AzName, lookupAz.CurPrice)
+	if region != awsCfg.Region {
+		cfgOpts = append(cfgOpts, config.WithRegion(region))
+		awsCfg, err = config.LoadDefaultConfig(ctx, cfgOpts...)
+		if err != nil {
+			return awsCfg, err
+		}
+	}
+	iaws.DefaultRegions = nil
+	if region == "all" && !rf.allRegions {
+		prefs, err := loadPrefs(awsCfg)
+		if err != nil {
+			return awsCfg, err
 		}
+		iaws.DefaultRegions = prefs.Regions
 	}
 
-	return nil
+	return awsCfg, nil
 }
 
 func main() {
@@ -1029,9 +2982,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	var region string
+	rf := regionFlags{
+		region:  awsCfg.Region,
+		profile: os.Getenv("AWS_PROFILE"),
+	}
 	f := flag.NewFlagSet("spotsh", flag.ContinueOnError)
-	f.StringVar(&region, "region", awsCfg.Region, "AWS region; e.g. us-east-2")
+	addRegionFlags(f, &rf)
+	f.StringVar(&configPathOverride, "config", os.Getenv("SPOTSH_CONFIG"),
+		"Path to spotsh preferences file")
 
 	var args []string
 	if len(os.Args) > 1 {
@@ -1043,13 +3001,23 @@ func main() {
 		os.Exit(1)
 	}
 	args = f.Args()
-
-	if region != awsCfg.Region {
-		awsCfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(region))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
-		}
+	rf.regionSet = flagWasSet(f, "region")
+	rf.readOnly = len(args) > 0 && readOnlySubCommands[args[0]]
+	globalRegionFlags = rf
+	awsCfg, err = loadAwsConfig(ctx, rf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	iaws.LaunchVersion = versionText
+	configDir, err := getConfigDir()
+	if err == nil {
+		iaws.KeyIndexPath = filepath.Join(configDir, "keyindex.json")
+	}
+	// only show multi-region progress to interactive users so that piped
+	// output & scripts are unaffected
+	if isTerminal(os.Stdout) && isTerminal(os.Stderr) {
+		iaws.RegionProgress = newRegionProgress(os.Stderr)
 	}
 	subCommandName := ""
 	if len(args) > 0 {
@@ -1057,7 +3025,9 @@ func main() {
 	}
 	exitStatus := 0
 
-	if len(args) > 1 {
+	if len(args) > 0 {
+		// subcommands receive only their own arguments; e.g. a bare
+		// 'spotsh ssh' must not pass "ssh" along as the remote command
 		args = args[1:]
 	}
 
@@ -1076,7 +3046,12 @@ func main() {
 	}
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if iaws.IsExpiredCredentials(err) {
+			fmt.Fprintf(os.Stderr, "AWS credentials expired; run 'aws sso login' or otherwise refresh them & retry\n  (%v)\n",
+				err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
 		exitStatus = 1
 	}
 
@@ -0,0 +1,662 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/mikeb26/spotsh"
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func newTestInstance() *iaws.LaunchEc2SpotResult {
+	return &iaws.LaunchEc2SpotResult{
+		InstanceId:   "i-0",
+		PublicIp:     "192.0.2.1",
+		User:         "ec2-user",
+		LocalKeyFile: "/tmp/key.pem",
+	}
+}
+
+func TestGetSshExecArgsPipedStdin(t *testing.T) {
+	args := getSshExecArgs(newTestInstance(), &sshOpts{},
+		[]string{"bash", "-s"}, false)
+	argStr := strings.Join(args, " ")
+	if !strings.Contains(argStr, " -T ec2-user@192.0.2.1 bash -s") {
+		t.Errorf("expected -T & remote command w/ piped stdin; got %v", args)
+	}
+
+	args = getSshExecArgs(newTestInstance(), &sshOpts{}, nil, true)
+	for _, arg := range args {
+		if arg == "-T" {
+			t.Errorf("unexpected -T w/ terminal stdin; got %v", args)
+		}
+	}
+	if args[len(args)-1] != "ec2-user@192.0.2.1" {
+		t.Errorf("expected no remote command; got %v", args)
+	}
+}
+
+func TestGetSshExecArgsTty(t *testing.T) {
+	opts := &sshOpts{}
+	f := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.Var(ttyCount{&opts.tty, 1}, "t", "")
+	f.Var(ttyCount{&opts.tty, 2}, "tt", "")
+	err := f.Parse([]string{"-t", "-tt", "top"})
+	if err != nil || opts.tty != 3 || f.Arg(0) != "top" {
+		t.Fatalf("expected tty count 3 but got %v err:%v", opts.tty, err)
+	}
+
+	opts.tty = 1
+	args := getSshExecArgs(newTestInstance(), opts, []string{"top"}, false)
+	argStr := strings.Join(args, " ")
+	if !strings.Contains(argStr, " -t ec2-user@192.0.2.1 top") ||
+		strings.Contains(argStr, " -T ") {
+		t.Errorf("expected -t rather than -T; got %v", args)
+	}
+
+	opts.tty = 2
+	args = getSshExecArgs(newTestInstance(), opts, []string{"top"}, false)
+	argStr = strings.Join(args, " ")
+	if !strings.Contains(argStr, " -t -t ec2-user@192.0.2.1 top") {
+		t.Errorf("expected forced tty; got %v", args)
+	}
+}
+
+func TestGetSshExecArgsSudo(t *testing.T) {
+	opts := &sshOpts{sudo: true}
+	args := getSshExecArgs(newTestInstance(), opts, nil, true)
+	argStr := strings.Join(args, " ")
+	if !strings.HasSuffix(argStr, " -t ec2-user@192.0.2.1 sudo -i") {
+		t.Errorf("expected tty & root login shell; got %v", args)
+	}
+
+	args = getSshExecArgs(newTestInstance(), opts, []string{"dmesg"}, false)
+	argStr = strings.Join(args, " ")
+	if !strings.HasSuffix(argStr, " -T ec2-user@192.0.2.1 sudo dmesg") {
+		t.Errorf("expected remote command prefixed w/ sudo; got %v", args)
+	}
+}
+
+func TestGetSshPortPref(t *testing.T) {
+	for _, tc := range []struct {
+		prefPort int32
+		expected int32
+		wantErr  bool
+	}{
+		{0, iaws.DefaultSshPort, false},
+		{2222, 2222, false},
+		{-1, 0, true},
+		{65536, 0, true},
+	} {
+		port, err := getSshPortPref(&Prefs{SshPort: tc.prefPort})
+		if (err != nil) != tc.wantErr || port != tc.expected {
+			t.Errorf("SshPort %v: expected %v but got %v err:%v",
+				tc.prefPort, tc.expected, port, err)
+		}
+	}
+}
+
+func TestGetCommonSshArgsPort(t *testing.T) {
+	args := getCommonSshArgs("scp", newTestInstance(), &sshOpts{})
+	if strings.Contains(strings.Join(args, " "), "Port=") {
+		t.Errorf("expected no port option w/ the default port; got %v", args)
+	}
+
+	args = getCommonSshArgs("scp", newTestInstance(), &sshOpts{port: 2222})
+	if !strings.Contains(strings.Join(args, " "), "-o Port=2222") {
+		t.Errorf("expected port 2222 option; got %v", args)
+	}
+}
+
+// TestExecSshStreamsStdin re-runs the test binary as a helper process which
+// execs a fake ssh client that copies its stdin to stdout, verifying input
+// piped into spotsh reaches the exec'd ssh intact
+func TestExecSshStreamsStdin(t *testing.T) {
+	if os.Getenv("SPOTSH_TEST_EXEC_SSH") != "" {
+		sshPath = os.Getenv("SPOTSH_TEST_EXEC_SSH")
+		err := execSsh(newTestInstance(), &sshOpts{}, []string{"bash"})
+		// only reached if exec failed
+		t.Fatalf("exec failed: %v", err)
+	}
+
+	fakeSsh := filepath.Join(t.TempDir(), "ssh")
+	err := os.WriteFile(fakeSsh, []byte("#!/bin/sh\nexec cat\n"), 0700)
+	if err != nil {
+		t.Fatalf("failed to write fake ssh: %v", err)
+	}
+
+	script := "echo line1\necho line2\n"
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExecSshStreamsStdin$")
+	cmd.Env = append(os.Environ(), "SPOTSH_TEST_EXEC_SSH="+fakeSsh)
+	cmd.Stdin = strings.NewReader(script)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("helper process failed: %v", err)
+	}
+	if string(output) != script {
+		t.Errorf("expected stdin %q to stream through but got %q", script,
+			output)
+	}
+}
+
+func TestExpandTypeFamily(t *testing.T) {
+	iTypeList, err := expandTypeFamily("c7i", "large,xlarge")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if iTypeList != "c7i.large,c7i.xlarge" {
+		t.Errorf("expected c7i.large,c7i.xlarge but got %v", iTypeList)
+	}
+
+	iTypeList, err = expandTypeFamily("c7i", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, iType := range string2iTypeSlice(iTypeList) {
+		if !strings.HasPrefix(string(iType), "c7i.") {
+			t.Errorf("unexpected type %v in c7i family", iType)
+		}
+	}
+	if !strings.Contains(iTypeList, "c7i.48xlarge") {
+		t.Errorf("expected c7i.48xlarge in c7i family; got %v", iTypeList)
+	}
+
+	_, err = expandTypeFamily("c7i", "huge")
+	if err == nil {
+		t.Errorf("expected error for unknown size")
+	}
+	_, err = expandTypeFamily("zz9", "")
+	if err == nil {
+		t.Errorf("expected error for unknown family")
+	}
+	_, err = expandTypeFamily("c7i.large", "")
+	if err == nil {
+		t.Errorf("expected error for instance type rather than family")
+	}
+}
+
+func TestGetCommonSshArgsCompress(t *testing.T) {
+	args := getCommonSshArgs("scp", newTestInstance(), &sshOpts{})
+	for _, arg := range args {
+		if arg == "-C" {
+			t.Errorf("unexpected -C w/o --compress; got %v", args)
+		}
+	}
+
+	args = getCommonSshArgs("scp", newTestInstance(), &sshOpts{compress: true})
+	if args[len(args)-1] != "-C" {
+		t.Errorf("expected -C w/ --compress; got %v", args)
+	}
+}
+
+func TestFilterStaleInstances(t *testing.T) {
+	healthy := *newTestInstance()
+	healthy.Os = spotsh.AmazonLinux2023
+	noKey := healthy
+	noKey.InstanceId = "i-1"
+	noKey.LocalKeyFile = ""
+	unknownOs := healthy
+	unknownOs.InstanceId = "i-2"
+	unknownOs.Os = spotsh.OsInvalid
+
+	staleResults := filterStaleInstances([]iaws.LaunchEc2SpotResult{healthy,
+		noKey, unknownOs})
+	if len(staleResults) != 2 || staleResults[0].InstanceId != "i-1" ||
+		staleResults[1].InstanceId != "i-2" {
+		t.Errorf("expected i-1 & i-2 to be stale; got %v", staleResults)
+	}
+	if len(getStaleReasons(&healthy)) != 0 {
+		t.Errorf("expected no stale reasons; got %v",
+			getStaleReasons(&healthy))
+	}
+}
+
+func TestGetLaunchVersionDesc(t *testing.T) {
+	lr := newTestInstance()
+	if desc := getLaunchVersionDesc(lr); desc != "<unknown>" {
+		t.Errorf("expected <unknown> w/o version tag but got %v", desc)
+	}
+	lr.SpotshVersion = versionText
+	if desc := getLaunchVersionDesc(lr); desc != versionText {
+		t.Errorf("expected %v but got %v", versionText, desc)
+	}
+	lr.SpotshVersion = "v0.0.1"
+	if desc := getLaunchVersionDesc(lr); !strings.Contains(desc, "differs") {
+		t.Errorf("expected differing version to be noted but got %v", desc)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/tester")
+	t.Setenv("SPOTSH_TEST_DIR", "/opt/spotsh")
+
+	tests := map[string]string{
+		"~":                          "/home/tester",
+		"~/prefs.json":               "/home/tester/prefs.json",
+		"$SPOTSH_TEST_DIR/init.sh":   "/opt/spotsh/init.sh",
+		"${SPOTSH_TEST_DIR}/init.sh": "/opt/spotsh/init.sh",
+		"/abs/path":                  "/abs/path",
+		"rel/~/path":                 "rel/~/path",
+		"~other/path":                "~other/path",
+	}
+	for path, expected := range tests {
+		expanded, err := expandPath(path)
+		if err != nil || expanded != expected {
+			t.Errorf("expandPath(%v): expected %v but got %v err:%v", path,
+				expected, expanded, err)
+		}
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	cost := estimateCost(0.12, 30*time.Minute)
+	if cost < 0.0599 || cost > 0.0601 {
+		t.Errorf("expected $0.06 for 30m at $0.12/hr but got %v", cost)
+	}
+
+	// billing has a 1 minute minimum
+	cost = estimateCost(0.60, 5*time.Second)
+	if cost < 0.0099 || cost > 0.0101 {
+		t.Errorf("expected $0.01 for 1m minimum at $0.60/hr but got %v", cost)
+	}
+}
+
+func TestSwapBinaryWithBackup(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "spotsh")
+	err := swapBinaryWithBackup(binaryPath)
+	if err == nil {
+		t.Errorf("expected error w/o a backup")
+	}
+
+	err = os.WriteFile(binaryPath, []byte("new"), 0755)
+	if err != nil {
+		t.Fatalf("failed to write binary: %v", err)
+	}
+	err = os.WriteFile(binaryPath+".bak", []byte("old"), 0755)
+	if err != nil {
+		t.Fatalf("failed to write backup: %v", err)
+	}
+
+	err = swapBinaryWithBackup(binaryPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, _ := os.ReadFile(binaryPath)
+	bakContent, _ := os.ReadFile(binaryPath + ".bak")
+	if string(content) != "old" || string(bakContent) != "new" {
+		t.Errorf("expected binary & backup to be swapped; got %q %q",
+			content, bakContent)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "hook.out")
+	hookCmd := "echo $SPOTSH_HOOK $SPOTSH_INSTANCE_ID $SPOTSH_PUBLIC_IP > " +
+		outFile
+	err := runHook(PostLaunchHookName, hookCmd, newTestInstance(), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output, _ := os.ReadFile(outFile)
+	if string(output) != "post-launch i-0 192.0.2.1\n" {
+		t.Errorf("unexpected hook output %q", output)
+	}
+
+	err = runHook(PreTerminateHookName, "exit 1", newTestInstance(), false)
+	if err != nil {
+		t.Errorf("expected only a warning w/o strict; got %v", err)
+	}
+	err = runHook(PreTerminateHookName, "exit 1", newTestInstance(), true)
+	if err == nil {
+		t.Errorf("expected error w/ strict")
+	}
+}
+
+func TestDrainInstance(t *testing.T) {
+	binDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(binDir, "ssh"),
+		[]byte("#!/bin/sh\nexec /bin/sleep 10\n"), 0700)
+	if err != nil {
+		t.Fatalf("failed to write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", binDir)
+
+	start := time.Now()
+	err = drainInstance(newTestInstance(), iaws.DefaultSshPort,
+		"./flush.sh", 100*time.Millisecond, false)
+	if err != nil {
+		t.Errorf("expected only a warning w/o strict; got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("drainInstance was not bounded by its timeout")
+	}
+	if remoteCmdTimeout != DefaultRemoteCmdTimeout {
+		t.Errorf("expected remote timeout to be unchanged; got %v",
+			remoteCmdTimeout)
+	}
+
+	err = drainInstance(newTestInstance(), iaws.DefaultSshPort,
+		"./flush.sh", 100*time.Millisecond, true)
+	if err == nil {
+		t.Errorf("expected error w/ strict")
+	}
+}
+
+func TestGetTenancyMarket(t *testing.T) {
+	market, err := getTenancyMarket(iaws.TenancyDefault, iaws.MarketSpot,
+		false)
+	if err != nil || market != iaws.MarketSpot {
+		t.Errorf("expected spot w/ default tenancy; got %v err:%v", market,
+			err)
+	}
+
+	market, err = getTenancyMarket(iaws.TenancyDedicated, iaws.MarketSpot,
+		false)
+	if err != nil || market != iaws.MarketOnDemand {
+		t.Errorf("expected switch to on-demand; got %v err:%v", market, err)
+	}
+
+	_, err = getTenancyMarket(iaws.TenancyHost, iaws.MarketSpot, true)
+	if err == nil {
+		t.Errorf("expected error w/ explicit spot market")
+	}
+}
+
+func TestSelectInstances(t *testing.T) {
+	launchResults := []iaws.LaunchEc2SpotResult{
+		{InstanceId: "i-0", Os: spotsh.AmazonLinux2023,
+			InstanceType: types.InstanceTypeC7iLarge},
+		{InstanceId: "i-1", Os: spotsh.AmazonLinux2023,
+			InstanceType: types.InstanceTypeC5aLarge},
+		{InstanceId: "i-2", Os: spotsh.Ubuntu22_04,
+			InstanceType: types.InstanceTypeC7iLarge},
+	}
+
+	matches := selectInstances(launchResults,
+		spotsh.AmazonLinux2023.String(), "c7i.large")
+	if len(matches) != 1 || matches[0].InstanceId != "i-0" {
+		t.Errorf("expected only i-0 but got %v", matches)
+	}
+	matches = selectInstances(launchResults, "", "c7i.large")
+	if len(matches) != 2 {
+		t.Errorf("expected 2 c7i.large matches but got %v", len(matches))
+	}
+	matches = selectInstances(launchResults, "", "m5.large")
+	if len(matches) != 0 {
+		t.Errorf("expected no matches but got %v", len(matches))
+	}
+}
+
+func TestRunScpRetriesTransientFailure(t *testing.T) {
+	binDir := t.TempDir()
+	countFile := filepath.Join(binDir, "count")
+	// fail w/ a connection reset on the first attempt only
+	fakeScp := "#!/bin/sh\n" +
+		"if [ ! -f " + countFile + " ]; then\n" +
+		"  touch " + countFile + "\n" +
+		"  echo 'kex_exchange_identification: read: Connection reset by peer' >&2\n" +
+		"  exit 1\n" +
+		"fi\n" +
+		"exit 0\n"
+	err := os.WriteFile(filepath.Join(binDir, "scp"), []byte(fakeScp), 0700)
+	if err != nil {
+		t.Fatalf("failed to write fake scp: %v", err)
+	}
+	origScpPath, origDelay := scpPath, sshRetryDelay
+	scpPath, sshRetryDelay = filepath.Join(binDir, "scp"), time.Millisecond
+	defer func() { scpPath, sshRetryDelay = origScpPath, origDelay }()
+
+	err = retryTransientSsh(func() (string, error) {
+		return runScp([]string{"a", "b"})
+	})
+	if err != nil {
+		t.Errorf("expected retry to succeed but got %v", err)
+	}
+
+	// non-transient failures are not retried
+	attempts := 0
+	err = retryTransientSsh(func() (string, error) {
+		attempts++
+		return "scp: /nonexistent: No such file or directory", os.ErrNotExist
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("expected a single failed attempt but got %v err:%v",
+			attempts, err)
+	}
+}
+
+func TestTestSshReachability(t *testing.T) {
+	launchResults := []iaws.LaunchEc2SpotResult{
+		{InstanceId: "i-0", PublicIp: "192.0.2.1"},
+		{InstanceId: "i-1", PublicIp: "192.0.2.2"},
+		{InstanceId: "i-2"},
+	}
+	status := testSshReachability(launchResults,
+		func(lr *iaws.LaunchEc2SpotResult) error {
+			if lr.PublicIp == "192.0.2.2" {
+				return fmt.Errorf("connection refused")
+			}
+			return nil
+		})
+	expected := map[string]string{
+		"i-0": "reachable",
+		"i-1": "unreachable",
+		"i-2": "unreachable",
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("expected %v but got %v", expected, status)
+	}
+}
+
+func TestRunSshReconnectingExitStatus(t *testing.T) {
+	binDir := t.TempDir()
+	fakeSsh := filepath.Join(binDir, "ssh")
+	origSshPath := sshPath
+	sshPath = fakeSsh
+	defer func() { sshPath = origSshPath }()
+
+	for _, tc := range []struct {
+		status   string
+		stderr   string
+		wantErr  bool
+		wantLost bool
+	}{
+		{"0", "", false, false},
+		{"3", "", true, false},
+		{"255", "", true, false},
+		{"255", "Connection to 192.0.2.1 closed.", true, false},
+		{"255", "client_loop: send disconnect: Broken pipe", true, true},
+		{"255", "Connection to 192.0.2.1 closed by remote host.", true, true},
+	} {
+		script := "#!/bin/sh\necho '" + tc.stderr + "' >&2\nexit " +
+			tc.status + "\n"
+		err := os.WriteFile(fakeSsh, []byte(script), 0700)
+		if err != nil {
+			t.Fatalf("failed to write fake ssh: %v", err)
+		}
+		var stderr stderrTail
+		cmd := exec.Command(fakeSsh)
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		if isSshConnectionLost(err, stderr.String()) != tc.wantLost {
+			t.Errorf("exit %v %q: expected connection lost %v; got err:%v",
+				tc.status, tc.stderr, tc.wantLost, err)
+		}
+		if tc.wantLost {
+			continue
+		}
+		// a remote exit 255 or ~. is not reconnected
+		err = runSshReconnecting(aws.Config{}, newTestInstance(), &sshOpts{},
+			nil)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("exit %v %q: unexpected err:%v", tc.status, tc.stderr,
+				err)
+		}
+	}
+}
+
+func TestCheckReconnectArgs(t *testing.T) {
+	if err := checkReconnectArgs(nil, true); err != nil {
+		t.Errorf("unexpected err for interactive session: %v", err)
+	}
+	if err := checkReconnectArgs([]string{"make"}, true); err == nil {
+		t.Errorf("expected err for remote command")
+	}
+	if err := checkReconnectArgs(nil, false); err == nil {
+		t.Errorf("expected err for non-interactive session")
+	}
+}
+
+func TestPrintInstancesCsv(t *testing.T) {
+	launchTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	launchResults := []iaws.LaunchEc2SpotResult{
+		{InstanceId: "i-2", PublicIp: "192.0.2.2", User: "ubuntu",
+			InstanceType: types.InstanceTypeC5Large, Os: spotsh.Ubuntu22_04,
+			AzName: "us-west-2a", Region: "us-west-2", CurrentPrice: 0.031},
+		{InstanceId: "i-1", PublicIp: "192.0.2.1", User: "ec2-user",
+			InstanceType: types.InstanceTypeC6iLarge,
+			Os:           spotsh.AmazonLinux2023, AzName: "us-east-2b",
+			Region: "us-east-2", CurrentPrice: 0.04, LaunchTime: launchTime},
+	}
+
+	var out strings.Builder
+	err := printInstancesCsv(&out, launchResults)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "instanceId,publicIp,user,instanceType,os,az,region,price,launchTime\n" +
+		"i-1,192.0.2.1,ec2-user,c6i.large," + spotsh.AmazonLinux2023.String() +
+		",us-east-2b,us-east-2,0.04,2024-03-01T12:00:00Z\n" +
+		"i-2,192.0.2.2,ubuntu,c5.large," + spotsh.Ubuntu22_04.String() +
+		",us-west-2a,us-west-2,0.031,\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, out.String())
+	}
+}
+
+func TestParseValidFrom(t *testing.T) {
+	now := time.Date(2024, 5, 1, 22, 30, 0, 0, time.Local)
+
+	tests := map[string]time.Time{
+		"2am":                  time.Date(2024, 5, 2, 2, 0, 0, 0, time.Local),
+		"11:45pm":              time.Date(2024, 5, 1, 23, 45, 0, 0, time.Local),
+		"23:00":                time.Date(2024, 5, 1, 23, 0, 0, 0, time.Local),
+		"22:30":                time.Date(2024, 5, 2, 22, 30, 0, 0, time.Local),
+		"2024-05-03T04:00:00Z": time.Date(2024, 5, 3, 4, 0, 0, 0, time.UTC),
+	}
+	for spec, expected := range tests {
+		validFrom, err := parseValidFrom(spec, now)
+		if err != nil || !validFrom.Equal(expected) {
+			t.Errorf("parseValidFrom(%v): expected %v but got %v err:%v", spec,
+				expected, validFrom, err)
+		}
+	}
+
+	for _, spec := range []string{"soon", "2024-04-01T00:00:00Z"} {
+		if _, err := parseValidFrom(spec, now); err == nil {
+			t.Errorf("parseValidFrom(%v): expected error", spec)
+		}
+	}
+}
+
+func TestGetSshfsArgsCompress(t *testing.T) {
+	args := getSshfsArgs("/usr/bin/sshfs", newTestInstance(),
+		&sshOpts{compress: true}, "/home/ec2-user", "/mnt/box")
+	argStr := strings.Join(args, " ")
+	if !strings.Contains(argStr, "-o IdentityFile=/tmp/key.pem") {
+		t.Errorf("expected identity file option; got %v", args)
+	}
+	if !strings.Contains(argStr, "-o ConnectTimeout=5") {
+		t.Errorf("expected ssh options to be preserved; got %v", args)
+	}
+	if args[len(args)-1] != "-C" {
+		t.Errorf("expected -C to be passed on its own; got %v", args)
+	}
+}
+
+func TestExtractStringArg(t *testing.T) {
+	value, remaining, err := extractStringArg([]string{"--quiet",
+		"--from-config", "gpu", "--types", "g5.xlarge"}, "from-config")
+	if err != nil || value != "gpu" ||
+		strings.Join(remaining, " ") != "--quiet --types g5.xlarge" {
+		t.Errorf("unexpected result %v %v %v", value, remaining, err)
+	}
+
+	value, remaining, err = extractStringArg([]string{"--from-config=gpu"},
+		"from-config")
+	if err != nil || value != "gpu" || len(remaining) != 0 {
+		t.Errorf("unexpected result %v %v %v", value, remaining, err)
+	}
+
+	value, remaining, err = extractStringArg([]string{"--", "--from-config",
+		"gpu"}, "from-config")
+	if err != nil || value != "" || len(remaining) != 3 {
+		t.Errorf("expected args after -- to be untouched; got %v %v %v",
+			value, remaining, err)
+	}
+
+	_, _, err = extractStringArg([]string{"--from-config"}, "from-config")
+	if err == nil {
+		t.Errorf("expected error for missing value")
+	}
+}
+
+func TestParseWithRegionFlags(t *testing.T) {
+	globalRegionFlags = regionFlags{region: "us-east-2", regionSet: true}
+	defer func() { globalRegionFlags = regionFlags{} }()
+
+	awsCfg := aws.Config{Region: "us-east-2"}
+	var instanceId string
+	f := flag.NewFlagSet("spotsh image", flag.ContinueOnError)
+	f.StringVar(&instanceId, "instance-id", "", "EC2 instance id")
+	err := parseWithRegionFlags(&awsCfg, f, []string{"--instance-id", "i-0",
+		"uptime", "--region", "eu-west-1"})
+	if err != nil || instanceId != "i-0" || awsCfg.Region != "us-east-2" {
+		t.Errorf("expected flags after a positional arg to be ignored; got %v %v %v",
+			instanceId, awsCfg.Region, err)
+	}
+	if strings.Join(f.Args(), " ") != "uptime --region eu-west-1" {
+		t.Errorf("expected remote command to be untouched; got %v", f.Args())
+	}
+
+	f = flag.NewFlagSet("spotsh image", flag.ContinueOnError)
+	err = parseWithRegionFlags(&awsCfg, f, []string{"--region", "us-west-2"})
+	if err != nil || awsCfg.Region != "us-west-2" {
+		t.Errorf("expected region us-west-2; got %v %v", awsCfg.Region, err)
+	}
+}
+
+func TestRsyncQuote(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected string
+	}{
+		{"-i", "-i"},
+		{"/home/me/.ssh/key", "/home/me/.ssh/key"},
+		{"/home/me/My Keys/key", "'/home/me/My Keys/key'"},
+		{"it's", `'it'"'"'s'`},
+		{"", "''"},
+	}
+
+	for _, tc := range tests {
+		actual := rsyncQuote(tc.arg)
+		if actual != tc.expected {
+			t.Errorf("rsyncQuote(%q): expected %v got %v", tc.arg, tc.expected,
+				actual)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+func metricsMain(awsCfg aws.Config, args []string) error {
+	var instanceId string
+	var since, period time.Duration
+	f := flag.NewFlagSet("spotsh metrics", flag.ContinueOnError)
+	f.StringVar(&instanceId, "instance-id", "", "EC2 instance id")
+	f.DurationVar(&since, "since", time.Hour,
+		"How far back to fetch metrics; e.g. 1h")
+	f.DurationVar(&period, "period", 5*time.Minute,
+		"Granularity of each datapoint; e.g. 5m")
+	err := parseWithRegionFlags(&awsCfg, f, args)
+	if err != nil {
+		return err
+	}
+	err = checkMetricsPeriod(period)
+	if err != nil {
+		return err
+	}
+	if since < period {
+		return fmt.Errorf("--since must be at least --period")
+	}
+
+	selectedInstance, err := selectOrLaunch(awsCfg, false, instanceId)
+	if err != nil {
+		return err
+	}
+	metrics, err := iaws.LookupInstanceMetrics(awsCfg,
+		selectedInstance.InstanceId, since, period)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Metrics of %v over the last %v (per %v):\n",
+		selectedInstance.InstanceId, since, period)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "METRIC\tLATEST\tAVG\tMAX\tTREND\n")
+	for _, metric := range metrics {
+		if len(metric.Values) == 0 {
+			fmt.Fprintf(w, "%v\t-\t-\t-\tno datapoints\n", metric.Name)
+			continue
+		}
+		sum, max := 0.0, metric.Values[0]
+		for _, val := range metric.Values {
+			sum += val
+			if val > max {
+				max = val
+			}
+		}
+		latest := metric.Values[len(metric.Values)-1]
+		avg := sum / float64(len(metric.Values))
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", metric.Name,
+			formatMetricValue(latest, metric.Unit),
+			formatMetricValue(avg, metric.Unit),
+			formatMetricValue(max, metric.Unit), sparkline(metric.Values))
+	}
+
+	return w.Flush()
+}
+
+// checkMetricsPeriod verifies period is one CloudWatch accepts for the
+// standard (non high resolution) EC2 metrics
+func checkMetricsPeriod(period time.Duration) error {
+	if period < time.Minute || period%time.Minute != 0 {
+		return fmt.Errorf("Invalid --period %v; must be a multiple of 1m such as 5m",
+			period)
+	}
+
+	return nil
+}
+
+func formatMetricValue(val float64, unit string) string {
+	switch unit {
+	case "Percent":
+		return fmt.Sprintf("%.1f%%", val)
+	case "Bytes":
+		units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+		idx := 0
+		for val >= 1024 && idx < len(units)-1 {
+			val /= 1024
+			idx++
+		}
+		return fmt.Sprintf("%.1f%v", val, units[idx])
+	}
+
+	return fmt.Sprintf("%.2f", val)
+}
+
+// sparkline renders vals as a compact bar chart scaled between their min &
+// max
+func sparkline(vals []float64) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	min, max := vals[0], vals[0]
+	for _, val := range vals {
+		if val < min {
+			min = val
+		}
+		if val > max {
+			max = val
+		}
+	}
+
+	var sb strings.Builder
+	for _, val := range vals {
+		idx := 0
+		if max > min {
+			idx = int((val - min) / (max - min) * float64(len(sparkChars)-1))
+		}
+		sb.WriteRune(sparkChars[idx])
+	}
+
+	return sb.String()
+}
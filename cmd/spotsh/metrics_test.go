@@ -0,0 +1,43 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSparkline(t *testing.T) {
+	spark := sparkline([]float64{0, 50, 100})
+	if spark != "▁▄█" {
+		t.Errorf("expected ▁▄█ but got %v", spark)
+	}
+	spark = sparkline([]float64{7, 7})
+	if spark != "▁▁" {
+		t.Errorf("expected flat sparkline but got %v", spark)
+	}
+}
+
+func TestFormatMetricValue(t *testing.T) {
+	if val := formatMetricValue(12.345, "Percent"); val != "12.3%" {
+		t.Errorf("expected 12.3%% but got %v", val)
+	}
+	if val := formatMetricValue(3*1024*1024, "Bytes"); val != "3.0MiB" {
+		t.Errorf("expected 3.0MiB but got %v", val)
+	}
+}
+
+func TestCheckMetricsPeriod(t *testing.T) {
+	for _, period := range []time.Duration{time.Minute, 5 * time.Minute} {
+		if err := checkMetricsPeriod(period); err != nil {
+			t.Errorf("unexpected error for %v: %v", period, err)
+		}
+	}
+	for _, period := range []time.Duration{0, 30 * time.Second, 90 * time.Second} {
+		if err := checkMetricsPeriod(period); err == nil {
+			t.Errorf("expected error for %v", period)
+		}
+	}
+}
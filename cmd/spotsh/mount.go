@@ -0,0 +1,122 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func mountMain(awsCfg aws.Config, args []string) error {
+	selectedInstance, opts, err := selectOrLaunchWithArgs(&awsCfg,
+		"spotsh mount", false, &args, nil)
+	if err != nil {
+		return err
+	}
+	err = checkSshHost(selectedInstance, opts)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("spotsh mount [<SSHFLAGS>] <remote_path> <local_mountpoint> must be specified")
+	}
+	remotePath := args[0]
+	localMountPoint, err := expandPath(args[1])
+	if err != nil {
+		return err
+	}
+
+	sshfsPath, err := exec.LookPath("sshfs")
+	if err != nil {
+		return fmt.Errorf("spotsh mount requires sshfs; please install it: %w",
+			err)
+	}
+	// as w/ ssh & scp; a mount right after launch otherwise fails w/ a raw
+	// sshfs error
+	if opts.jumpHost == "" && !opts.noFirewall {
+		err = waitForSsh(awsCfg, selectedInstance, opts.port)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(localMountPoint, 0755)
+	if err != nil {
+		return fmt.Errorf("Could not create mountpoint %v: %w", localMountPoint,
+			err)
+	}
+
+	sshfsArgs := getSshfsArgs(sshfsPath, selectedInstance, opts, remotePath,
+		localMountPoint)
+
+	fmt.Fprintf(os.Stderr, "exec %v\n", sshfsArgs)
+	_, err = runLocal(sshfsArgs, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to mount %v: %w", remotePath, err)
+	}
+
+	return nil
+}
+
+// getSshfsArgs returns the sshfs command line mounting remotePath at
+// localMountPoint. sshfs accepts ssh's options via -o but not ssh's -i flag
+// so the common ssh args are converted accordingly.
+func getSshfsArgs(sshfsPath string, selectedInstance *iaws.LaunchEc2SpotResult,
+	opts *sshOpts, remotePath string, localMountPoint string) []string {
+
+	commonArgs := getCommonSshArgs("sshfs", selectedInstance, opts)
+	sshfsArgs := []string{sshfsPath,
+		selectedInstance.User + "@" + getSshHost(selectedInstance, opts) + ":" +
+			remotePath, localMountPoint}
+	for idx := 1; idx < len(commonArgs); idx++ {
+		switch commonArgs[idx] {
+		case "-i":
+			if idx+1 < len(commonArgs) {
+				sshfsArgs = append(sshfsArgs, "-o",
+					"IdentityFile="+commonArgs[idx+1])
+				idx++
+			}
+		case "-o":
+			if idx+1 < len(commonArgs) {
+				sshfsArgs = append(sshfsArgs, "-o", commonArgs[idx+1])
+				idx++
+			}
+		default:
+			// flags w/o an argument such as -C are shared by ssh & sshfs
+			sshfsArgs = append(sshfsArgs, commonArgs[idx])
+		}
+	}
+
+	return sshfsArgs
+}
+
+func umountMain(awsCfg aws.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("spotsh umount <local_mountpoint> must be specified")
+	}
+	localMountPoint, err := expandPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	// fusermount is the unprivileged way to unmount a fuse filesystem on
+	// linux; elsewhere (e.g. macOS) plain umount is used
+	cmdAndArgs := []string{"umount", localMountPoint}
+	fusermountPath, err := exec.LookPath("fusermount")
+	if err == nil {
+		cmdAndArgs = []string{fusermountPath, "-u", localMountPoint}
+	}
+
+	_, err = runLocal(cmdAndArgs, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to unmount %v: %w", localMountPoint, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,508 @@
+/* Copyright © 2022-2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func priceMain(awsCfg aws.Config, args []string) error {
+	launchArgs, err := newLaunchArgsFromPrefs(awsCfg)
+	if err != nil {
+		return err
+	}
+
+	var csvOutput, jsonOutput, perVcpu, watch, checkCapacity bool
+	var best int
+	var below float64
+	var watchInterval, watchTimeout time.Duration
+	var execCmd string
+
+	f := flag.NewFlagSet("spotsh price", flag.ContinueOnError)
+	iTypeList := iTypeSlice2String(iaws.DefaultInstanceTypes)
+	if len(launchArgs.InstanceTypes) > 0 {
+		iTypeList = iTypeSlice2String(launchArgs.InstanceTypes)
+	}
+	f.StringVar(&iTypeList, "types", iTypeList, "Instance types")
+	var typeFamily, sizeList string
+	f.StringVar(&typeFamily, "type-family", "",
+		"Instance type family to price; e.g. c7i")
+	f.StringVar(&sizeList, "size", "",
+		"Comma separated sizes within --type-family; e.g. large,xlarge")
+	f.BoolVar(&csvOutput, "csv", false, "Display prices in csv format")
+	f.BoolVar(&jsonOutput, "json", false,
+		"Display each type's cheapest price as json suitable for launch --types-from")
+	f.IntVar(&best, "best", 0,
+		"Only display the N cheapest instance types w/ --json")
+	f.BoolVar(&perVcpu, "per-vcpu", false,
+		"Rank prices by cost per vCPU hour")
+	f.BoolVar(&checkCapacity, "check-capacity", false,
+		"Annotate prices w/ EC2's spot placement score")
+	f.BoolVar(&watch, "watch", false,
+		"Poll prices until the cheapest is at or below --below")
+	f.Float64Var(&below, "below", 0, "Target price w/ --watch")
+	f.DurationVar(&watchInterval, "interval", 5*time.Minute,
+		"Interval between spot price checks w/ --watch")
+	f.DurationVar(&watchTimeout, "timeout", 24*time.Hour,
+		"Maximum time to wait w/ --watch")
+	f.StringVar(&execCmd, "exec", "",
+		"Command to run once the target price is reached w/ --watch")
+	err = parseWithRegionFlags(&awsCfg, f, args)
+	if err != nil {
+		return err
+	}
+
+	iTypeList, err = applyTypeFamily(f, iTypeList, typeFamily, sizeList)
+	if err != nil {
+		return err
+	}
+	iTypes, err := dropUnofferedITypes(awsCfg, string2iTypeSlice(iTypeList))
+	if err != nil {
+		return err
+	}
+	if watch {
+		return watchPrice(awsCfg, iTypes, below, watchInterval, watchTimeout,
+			execCmd)
+	}
+	if below != 0 || execCmd != "" {
+		return fmt.Errorf("--below and --exec may only be specified along with --watch")
+	}
+	if best < 0 {
+		return fmt.Errorf("--best must be positive")
+	}
+	if best != 0 && !jsonOutput {
+		return fmt.Errorf("--best may only be specified along with --json")
+	}
+	if jsonOutput && (csvOutput || perVcpu) {
+		return fmt.Errorf("--json may not be combined w/ --csv or --per-vcpu")
+	}
+	lookupResult, err := iaws.LookupEc2SpotPrices(awsCfg, iTypes)
+	if err != nil {
+		return err
+	}
+	reportMissingPrices(os.Stderr, lookupResult)
+	if jsonOutput {
+		return printPricesJson(os.Stdout, lookupResult, best)
+	}
+
+	if checkCapacity {
+		err = iaws.LookupSpotPlacementScores(awsCfg, lookupResult)
+		if err != nil {
+			return err
+		}
+	}
+
+	var vcpus map[types.InstanceType]int32
+	if perVcpu {
+		vcpus, err = iaws.LookupInstanceTypeVcpus(awsCfg, iTypes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if csvOutput {
+		return printPricesCsv(lookupResult, vcpus, checkCapacity)
+	}
+	if perVcpu {
+		printPricesPerVcpu(lookupResult, vcpus, checkCapacity)
+		return nil
+	}
+
+	for _, lookupInst := range lookupResult.InstanceTypes {
+		for _, lookupReg := range lookupInst.Regions {
+			if lookupReg.CheapestAz == nil {
+				continue
+			}
+
+			lookupAz := lookupReg.CheapestAz
+			if lookupReg == lookupInst.CheapestRegion &&
+				lookupInst == lookupResult.CheapestIType {
+				fmt.Printf(" ** ")
+			}
+
+			fmt.Printf("%v - %v - %v - $%v/hr%v\n", lookupInst.InstanceType,
+				lookupReg.Region, lookupAz.AzName, lookupAz.CurPrice,
+				placementScoreSuffix(lookupAz, checkCapacity))
+		}
+	}
+
+	return nil
+}
+
+// dropUnofferedITypes returns iTypes less any which EC2 does not offer in
+// the selected region(s), warning about each one dropped. An error is
+// returned when none of iTypes are offered.
+func dropUnofferedITypes(awsCfg aws.Config,
+	iTypes []types.InstanceType) ([]types.InstanceType, error) {
+
+	unoffered, err := iaws.LookupUnofferedInstanceTypes(awsCfg, iTypes)
+	if err != nil {
+		return nil, err
+	}
+	if len(unoffered) == len(iTypes) {
+		return nil, fmt.Errorf("None of the instance types %v are offered in region %v; please check --types",
+			iTypeSlice2String(iTypes), awsCfg.Region)
+	}
+	if len(unoffered) == 0 {
+		return iTypes, nil
+	}
+
+	isUnoffered := make(map[types.InstanceType]bool)
+	for _, iType := range unoffered {
+		fmt.Fprintf(os.Stderr, "Warning: instance type %v is not offered in region %v; skipping\n",
+			iType, awsCfg.Region)
+		isUnoffered[iType] = true
+	}
+	offered := make([]types.InstanceType, 0, len(iTypes)-len(unoffered))
+	for _, iType := range iTypes {
+		if !isUnoffered[iType] {
+			offered = append(offered, iType)
+		}
+	}
+
+	return offered, nil
+}
+
+// reportMissingPrices notes each instance type & region combination for
+// which no spot price data was returned rather than silently omitting it
+func reportMissingPrices(out io.Writer,
+	lookupResult *iaws.LookupEc2SpotPriceResult) {
+
+	missing := make([]string, 0)
+	for _, lookupInst := range lookupResult.InstanceTypes {
+		for _, lookupReg := range lookupInst.Regions {
+			if lookupReg.CheapestAz != nil {
+				continue
+			}
+			missing = append(missing, fmt.Sprintf("no spot price data for %v in %v",
+				lookupInst.InstanceType, lookupReg.Region))
+		}
+	}
+	sort.Strings(missing)
+	for _, msg := range missing {
+		fmt.Fprintln(out, msg)
+	}
+}
+
+// placementScoreSuffix returns lookupAz's spot placement score formatted for
+// display after its price, or nothing when capacity was not checked
+func placementScoreSuffix(lookupAz *iaws.LookupEc2SpotPriceAz,
+	checkCapacity bool) string {
+
+	if !checkCapacity {
+		return ""
+	}
+	if lookupAz.PlacementScore == 0 {
+		return " - capacity: unscored"
+	}
+
+	return fmt.Sprintf(" - capacity: %v/10", lookupAz.PlacementScore)
+}
+
+// printPricesPerVcpu displays the cheapest az of each region & instance
+// type ordered from the lowest to the highest cost per vCPU hour
+func printPricesPerVcpu(lookupResult *iaws.LookupEc2SpotPriceResult,
+	vcpus map[types.InstanceType]int32, checkCapacity bool) {
+
+	type perVcpuPrice struct {
+		iType   types.InstanceType
+		region  string
+		az      *iaws.LookupEc2SpotPriceAz
+		price   float64
+		vcpus   int32
+		perVcpu float64
+	}
+
+	prices := make([]perVcpuPrice, 0)
+	for _, lookupInst := range lookupResult.InstanceTypes {
+		numVcpus := vcpus[lookupInst.InstanceType]
+		if numVcpus == 0 {
+			fmt.Fprintf(os.Stderr, "Skipping %v: could not determine vCPU count\n",
+				lookupInst.InstanceType)
+			continue
+		}
+		for _, lookupReg := range lookupInst.Regions {
+			if lookupReg.CheapestAz == nil {
+				continue
+			}
+			prices = append(prices, perVcpuPrice{
+				iType:   lookupInst.InstanceType,
+				region:  lookupReg.Region,
+				az:      lookupReg.CheapestAz,
+				price:   lookupReg.CheapestAz.CurPrice,
+				vcpus:   numVcpus,
+				perVcpu: lookupReg.CheapestAz.CurPrice / float64(numVcpus),
+			})
+		}
+	}
+
+	sort.Slice(prices, func(i, j int) bool {
+		if prices[i].perVcpu != prices[j].perVcpu {
+			return prices[i].perVcpu < prices[j].perVcpu
+		}
+		if prices[i].iType != prices[j].iType {
+			return prices[i].iType < prices[j].iType
+		}
+		return prices[i].region < prices[j].region
+	})
+
+	for idx, p := range prices {
+		if idx == 0 {
+			fmt.Printf(" ** ")
+		}
+		fmt.Printf("%v - %v - %v - $%.5f/vCPU-hr ($%v/hr / %v vCPUs)%v\n",
+			p.iType, p.region, p.az.AzName, p.perVcpu, p.price, p.vcpus,
+			placementScoreSuffix(p.az, checkCapacity))
+	}
+}
+
+// printPricesCsv emits one row per instance type & availability zone sorted
+// by instance type, region, and then availability zone. When vcpus is
+// non-nil vCPU count and per vCPU price columns are included. When
+// checkCapacity is set a spot placement score column is included.
+func printPricesCsv(lookupResult *iaws.LookupEc2SpotPriceResult,
+	vcpus map[types.InstanceType]int32, checkCapacity bool) error {
+
+	rows := make([][]string, 0)
+	for _, lookupInst := range lookupResult.InstanceTypes {
+		for _, lookupReg := range lookupInst.Regions {
+			for _, lookupAz := range lookupReg.Azs {
+				row := []string{
+					string(lookupInst.InstanceType),
+					lookupReg.Region,
+					lookupAz.AzName,
+					strconv.FormatFloat(lookupAz.CurPrice, 'f', -1, 64),
+				}
+				if vcpus != nil {
+					numVcpus := vcpus[lookupInst.InstanceType]
+					perVcpu := ""
+					if numVcpus != 0 {
+						perVcpu = strconv.FormatFloat(
+							lookupAz.CurPrice/float64(numVcpus), 'f', -1, 64)
+					}
+					row = append(row, strconv.Itoa(int(numVcpus)), perVcpu)
+				}
+				if checkCapacity {
+					row = append(row,
+						strconv.Itoa(int(lookupAz.PlacementScore)))
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		for col := 0; col < 3; col++ {
+			if rows[i][col] != rows[j][col] {
+				return rows[i][col] < rows[j][col]
+			}
+		}
+		return false
+	})
+
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"instanceType", "region", "az", "price"}
+	if vcpus != nil {
+		header = append(header, "vcpus", "pricePerVcpu")
+	}
+	if checkCapacity {
+		header = append(header, "placementScore")
+	}
+	err := w.Write(header)
+	if err != nil {
+		return fmt.Errorf("Failed to write csv header: %w", err)
+	}
+	err = w.WriteAll(rows)
+	if err != nil {
+		return fmt.Errorf("Failed to write csv rows: %w", err)
+	}
+
+	return nil
+}
+
+// priceJsonEntry is the stable interchange format emitted by price --json
+// and consumed by launch --types-from
+type priceJsonEntry struct {
+	InstanceType string  `json:"instanceType"`
+	Region       string  `json:"region"`
+	Az           string  `json:"az"`
+	Price        float64 `json:"price"`
+}
+
+// printPricesJson emits a json array containing the cheapest region & az of
+// each instance type ordered from the lowest to the highest price. When best
+// is non-zero only the best cheapest instance types are included.
+func printPricesJson(out io.Writer, lookupResult *iaws.LookupEc2SpotPriceResult,
+	best int) error {
+
+	entries := make([]priceJsonEntry, 0)
+	for _, lookupInst := range lookupResult.InstanceTypes {
+		lookupReg := lookupInst.CheapestRegion
+		if lookupReg == nil || lookupReg.CheapestAz == nil {
+			continue
+		}
+		entries = append(entries, priceJsonEntry{
+			InstanceType: string(lookupInst.InstanceType),
+			Region:       lookupReg.Region,
+			Az:           lookupReg.CheapestAz.AzName,
+			Price:        lookupReg.CheapestAz.CurPrice,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Price != entries[j].Price {
+			return entries[i].Price < entries[j].Price
+		}
+		return entries[i].InstanceType < entries[j].InstanceType
+	})
+	if best != 0 && best < len(entries) {
+		entries = entries[:best]
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(entries)
+	if err != nil {
+		return fmt.Errorf("Failed to write json: %w", err)
+	}
+
+	return nil
+}
+
+// readPriceJson parses the output of price --json into a comma separated
+// list of instance types in the same order
+func readPriceJson(in io.Reader) (string, error) {
+	var entries []priceJsonEntry
+
+	err := json.NewDecoder(in).Decode(&entries)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse price --json output: %w", err)
+	}
+
+	iTypes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.InstanceType == "" {
+			continue
+		}
+		iTypes = append(iTypes, entry.InstanceType)
+	}
+	if len(iTypes) == 0 {
+		return "", fmt.Errorf("price --json output contains no instance types")
+	}
+
+	return strings.Join(iTypes, ","), nil
+}
+
+// readTypesFrom reads price --json output from the specified file, or from
+// stdin when path is -
+func readTypesFrom(path string) (string, error) {
+	if path == "-" {
+		return readPriceJson(os.Stdin)
+	}
+
+	path, err := expandPath(path)
+	if err != nil {
+		return "", err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open %v: %w", path, err)
+	}
+	defer in.Close()
+
+	return readPriceJson(in)
+}
+
+// watchPrice waits for the cheapest spot price of iTypes to drop to maxPrice
+// and then, if execCmd is set, replaces spotsh w/ execCmd run via /bin/sh
+func watchPrice(awsCfg aws.Config, iTypes []types.InstanceType,
+	maxPrice float64, interval time.Duration, timeout time.Duration,
+	execCmd string) error {
+
+	if maxPrice <= 0 {
+		return fmt.Errorf("--watch requires a positive --below price")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	err := waitForSpotPrice(awsCfg, iTypes, maxPrice, interval, timeout)
+	if err != nil {
+		return err
+	}
+	if execCmd == "" {
+		return nil
+	}
+
+	err = syscall.Exec("/bin/sh", []string{"sh", "-c", execCmd}, os.Environ())
+	if err != nil {
+		return fmt.Errorf("Failed to exec '%v': %w", execCmd, err)
+	}
+
+	return nil
+}
+
+// waitForSpotPrice polls spot prices for iTypes every interval until the
+// cheapest is at or below maxPrice, or returns an error once timeout elapses
+func waitForSpotPrice(awsCfg aws.Config, iTypes []types.InstanceType,
+	maxPrice float64, interval time.Duration, timeout time.Duration) error {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		lookupResult, err := iaws.LookupEc2SpotPrices(awsCfg, iTypes)
+		if err != nil {
+			return err
+		}
+
+		cheapest := lookupResult.CheapestIType
+		if cheapest == nil || cheapest.CheapestRegion == nil ||
+			cheapest.CheapestRegion.CheapestAz == nil {
+			fmt.Fprintf(os.Stderr, "%v: no spot prices currently available\n",
+				time.Now().Format(time.TimeOnly))
+		} else {
+			bestPrice := cheapest.CheapestRegion.CheapestAz.CurPrice
+			fmt.Fprintf(os.Stderr, "%v: best spot price %v - %v - %v - $%v/hr (target $%v/hr)\n",
+				time.Now().Format(time.TimeOnly), cheapest.InstanceType,
+				cheapest.CheapestRegion.Region,
+				cheapest.CheapestRegion.CheapestAz.AzName, bestPrice, maxPrice)
+			if bestPrice <= maxPrice {
+				return nil
+			}
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("Timed out waiting for spot price of $%v/hr or less",
+				maxPrice)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// capSpotPrice returns the lower of maxSpotPrice and capPrice so that a
+// launch following --wait-for-price cannot settle on a pool costing more than
+// the target; an empty or unparseable maxSpotPrice is replaced by capPrice
+func capSpotPrice(maxSpotPrice string, capPrice float64) string {
+	price, err := strconv.ParseFloat(maxSpotPrice, 64)
+	if err == nil && price <= capPrice {
+		return maxSpotPrice
+	}
+
+	return strconv.FormatFloat(capPrice, 'f', -1, 64)
+}
@@ -0,0 +1,109 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func newTestPriceIType(iType types.InstanceType,
+	price float64) *iaws.LookupEc2SpotPriceIType {
+
+	lookupAz := &iaws.LookupEc2SpotPriceAz{AzName: "us-east-2a",
+		CurPrice: price}
+	lookupReg := &iaws.LookupEc2SpotPriceRegion{
+		Region:     "us-east-2",
+		Azs:        map[string]*iaws.LookupEc2SpotPriceAz{"us-east-2a": lookupAz},
+		CheapestAz: lookupAz,
+	}
+
+	return &iaws.LookupEc2SpotPriceIType{
+		InstanceType:   iType,
+		Regions:        map[string]*iaws.LookupEc2SpotPriceRegion{"us-east-2": lookupReg},
+		CheapestRegion: lookupReg,
+	}
+}
+
+func TestPriceJsonRoundTrip(t *testing.T) {
+	lookupResult := &iaws.LookupEc2SpotPriceResult{
+		InstanceTypes: map[types.InstanceType]*iaws.LookupEc2SpotPriceIType{
+			"c7i.large": newTestPriceIType("c7i.large", 0.04),
+			"c6i.large": newTestPriceIType("c6i.large", 0.03),
+			"c5.large":  newTestPriceIType("c5.large", 0.05),
+		},
+	}
+
+	var out bytes.Buffer
+	err := printPricesJson(&out, lookupResult, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	iTypeList, err := readPriceJson(&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if iTypeList != "c6i.large,c7i.large" {
+		t.Errorf("expected 2 cheapest types in price order but got %v",
+			iTypeList)
+	}
+
+	_, err = readPriceJson(bytes.NewBufferString("[]"))
+	if err == nil {
+		t.Errorf("expected error for empty price json")
+	}
+}
+
+func TestReportMissingPrices(t *testing.T) {
+	c5 := newTestPriceIType("c5.large", 0.05)
+	c5.Regions["us-west-2"] = &iaws.LookupEc2SpotPriceRegion{
+		Region: "us-west-2",
+		Azs:    map[string]*iaws.LookupEc2SpotPriceAz{},
+	}
+	lookupResult := &iaws.LookupEc2SpotPriceResult{
+		InstanceTypes: map[types.InstanceType]*iaws.LookupEc2SpotPriceIType{
+			"c5.large": c5,
+			"c6i.large": {
+				InstanceType: "c6i.large",
+				Regions: map[string]*iaws.LookupEc2SpotPriceRegion{
+					"us-east-2": {Region: "us-east-2"},
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	reportMissingPrices(&out, lookupResult)
+	expected := "no spot price data for c5.large in us-west-2\n" +
+		"no spot price data for c6i.large in us-east-2\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, out.String())
+	}
+}
+
+func TestCapSpotPrice(t *testing.T) {
+	tests := []struct {
+		maxSpotPrice string
+		capPrice     float64
+		expected     string
+	}{
+		{"0.08", 0.05, "0.05"},
+		{"0.03", 0.05, "0.03"},
+		{"0.05", 0.05, "0.05"},
+		{"", 0.05, "0.05"},
+	}
+
+	for _, tc := range tests {
+		actual := capSpotPrice(tc.maxSpotPrice, tc.capPrice)
+		if actual != tc.expected {
+			t.Errorf("capSpotPrice(%q, %v): expected %v got %v",
+				tc.maxSpotPrice, tc.capPrice, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// newRegionProgress returns an iaws.RegionProgress implementation which
+// draws a single self-overwriting status line on out & erases it once every
+// region has completed
+func newRegionProgress(out io.Writer) func(int, int, time.Duration) {
+	return func(done int, total int, elapsed time.Duration) {
+		if done >= total {
+			fmt.Fprintf(out, "\r\033[K")
+			return
+		}
+
+		eta := ""
+		if done > 0 {
+			remaining := elapsed / time.Duration(done) *
+				time.Duration(total-done)
+			eta = fmt.Sprintf("; ~%v remaining", remaining.Round(time.Second))
+		}
+		fmt.Fprintf(out, "\r\033[K%v queried %v/%v regions%v",
+			spinnerFrames[done%len(spinnerFrames)], done, total, eta)
+	}
+}
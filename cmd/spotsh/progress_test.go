@@ -0,0 +1,29 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegionProgress(t *testing.T) {
+	var out bytes.Buffer
+	progress := newRegionProgress(&out)
+
+	progress(1, 4, 2*time.Second)
+	if !strings.Contains(out.String(), "queried 1/4 regions; ~6s remaining") {
+		t.Errorf("unexpected progress output %q", out.String())
+	}
+
+	out.Reset()
+	progress(4, 4, 8*time.Second)
+	if out.String() != "\r\033[K" {
+		t.Errorf("expected progress line to be erased but got %q",
+			out.String())
+	}
+}
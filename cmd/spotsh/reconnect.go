@@ -0,0 +1,187 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+// sshConnectionLostStatus is the exit status ssh itself uses for connection
+// errors as opposed to the exit status of the remote command
+const sshConnectionLostStatus = 255
+
+// sshReconnectDelay is the pause between attempts to find & reach the
+// instance again; tests may shorten it
+var sshReconnectDelay = 15 * time.Second
+
+// sshReconnectTimeout bounds how long ssh --reconnect waits for a lost
+// instance to become reachable again
+var sshReconnectTimeout = 30 * time.Minute
+
+// sshConnectionLostErrs are ssh client errors reported when an established
+// session's connection is lost as opposed to the user closing it (e.g. via
+// the ~. escape) or the remote shell itself exiting w/ status 255
+var sshConnectionLostErrs = []string{
+	"closed by remote host",
+	"Broken pipe",
+	"Connection reset",
+	"Connection timed out",
+	"Timeout, server",
+	"Network is unreachable",
+	"No route to host",
+}
+
+// isSshConnectionLost returns true when err & ssh's stderr output indicate
+// ssh exited due to a connection error, e.g. because its spot instance was
+// interrupted
+func isSshConnectionLost(err error, stderr string) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) ||
+		exitErr.ExitCode() != sshConnectionLostStatus {
+		return false
+	}
+	for _, lostErr := range sshConnectionLostErrs {
+		if strings.Contains(stderr, lostErr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stderrTail retains the last stderrTailLen bytes written to it
+type stderrTail struct {
+	buf []byte
+}
+
+const stderrTailLen = 4096
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrTailLen {
+		t.buf = t.buf[len(t.buf)-stderrTailLen:]
+	}
+
+	return len(p), nil
+}
+
+func (t *stderrTail) String() string {
+	return string(t.buf)
+}
+
+// checkReconnectArgs verifies --reconnect is only used for interactive
+// sessions; a remote command may not be safe to run again after a lost
+// connection since it may have partially or even fully completed
+func checkReconnectArgs(args []string, interactive bool) error {
+	if len(args) > 0 {
+		return fmt.Errorf("--reconnect may not be combined w/ a remote command")
+	}
+	if !interactive {
+		return fmt.Errorf("--reconnect requires an interactive terminal")
+	}
+
+	return nil
+}
+
+// runSshReconnecting runs ssh as a child process rather than exec'ing it so
+// that when the session is lost (e.g. due to a spot interruption) the
+// instance, which may have been re-provisioned w/ a new ip, can be looked up
+// & reconnected to once it is reachable again
+func runSshReconnecting(awsCfg aws.Config,
+	selectedInstance *iaws.LaunchEc2SpotResult, opts *sshOpts,
+	args []string) error {
+
+	for {
+		sshArgs := getSshExecArgs(selectedInstance, opts, args,
+			isTerminal(os.Stdin))
+		fmt.Fprintf(os.Stderr, "exec %v\n", sshArgs)
+		cmd := exec.Command(sshPath, sshArgs[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		var stderr stderrTail
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+		err := cmd.Run()
+		if !isSshConnectionLost(err, stderr.String()) {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Connection to %v lost; waiting up to %v for it to become reachable again (ctrl-c to give up)...\n",
+			selectedInstance.InstanceId, sshReconnectTimeout)
+		selectedInstance, err = waitForReconnect(awsCfg,
+			selectedInstance.InstanceId, opts)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// reselectInstance looks up the spot shell instances via their spotsh tags
+// preferring prevInstanceId while it is still running; otherwise the sole
+// spot shell instance, e.g. one re-provisioned after a spot interruption,
+// is selected
+func reselectInstance(awsCfg aws.Config,
+	prevInstanceId string) (*iaws.LaunchEc2SpotResult, error) {
+
+	launchResults, err := iaws.LookupEc2Spot(context.Background(), awsCfg,
+		iaws.DefaultTagPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to lookup instance: %w", err)
+	}
+	for _, lr := range launchResults {
+		if lr.InstanceId == prevInstanceId {
+			return iaws.SelectEc2Spot(launchResults, prevInstanceId)
+		}
+	}
+
+	return iaws.SelectEc2Spot(launchResults, "")
+}
+
+// waitForReconnect polls for prevInstanceId or its replacement until it is
+// running & reachable via ssh, sshReconnectTimeout elapses, or the user
+// gives up via ctrl-c
+func waitForReconnect(awsCfg aws.Config, prevInstanceId string,
+	opts *sshOpts) (*iaws.LaunchEc2SpotResult, error) {
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	deadline := time.Now().Add(sshReconnectTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Gave up reconnecting to %v", prevInstanceId)
+		case <-time.After(sshReconnectDelay):
+		}
+
+		selectedInstance, err := reselectInstance(awsCfg, prevInstanceId)
+		if err == nil && selectedInstance.PublicIp == "" {
+			err = fmt.Errorf("%v has no public ip yet",
+				selectedInstance.InstanceId)
+		}
+		if err == nil && opts.jumpHost == "" && !opts.noFirewall {
+			err = waitForSsh(awsCfg, selectedInstance, opts.port)
+		}
+		if err == nil {
+			return selectedInstance, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Gave up reconnecting after %v: %w",
+				sshReconnectTimeout, err)
+		}
+		fmt.Fprintf(os.Stderr, "Instance not yet reachable (%v); retrying in %v...\n",
+			err, sshReconnectDelay)
+	}
+}
@@ -0,0 +1,119 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+// minBilledDuration is the minimum duration EC2 bills a linux instance for
+const minBilledDuration = time.Minute
+
+// selftestMain is an end-to-end smoke test which launches an instance per
+// the user's preferences, verifies it can be reached via ssh, & terminates
+// it. Unlike the package's integration tests it is only ever run
+// deliberately since it incurs a (small) cost.
+func selftestMain(awsCfg aws.Config, args []string) error {
+	f := flag.NewFlagSet("spotsh selftest", flag.ContinueOnError)
+	err := parseWithRegionFlags(&awsCfg, f, args)
+	if err != nil {
+		return err
+	}
+	if f.NArg() != 0 {
+		return fmt.Errorf("Unexpected selftest arguments %v", f.Args())
+	}
+
+	launchArgs, err := newLaunchArgsFromPrefs(awsCfg)
+	if err != nil {
+		return err
+	}
+	// nothing beyond the instance itself should be created
+	launchArgs.IdleCpuAlarmPct = 0
+
+	startTime := time.Now()
+	fmt.Printf("[1/4] Launching %v instance in %v...\n", launchArgs.Os,
+		awsCfg.Region)
+	launchCtx, cancel := newLaunchContext()
+	defer cancel()
+	launchResult, err := iaws.LaunchEc2Spot(launchCtx, awsCfg, launchArgs)
+	if err != nil {
+		return fmt.Errorf("Selftest failed to launch: %w", err)
+	}
+	fmt.Printf("      launched %v (%v) in %v\n", launchResult.InstanceId,
+		launchResult.InstanceType, launchResult.AzName)
+
+	// always cleanup regardless of which step fails
+	testErr := selftestSsh(awsCfg, &launchResult)
+
+	fmt.Printf("[4/4] Terminating %v...\n", launchResult.InstanceId)
+	price := launchResult.CurrentPrice
+	lookupResult, lookupErr := selectOrLaunch(awsCfg, false,
+		launchResult.InstanceId)
+	if lookupErr == nil {
+		price = lookupResult.CurrentPrice
+	}
+	_, termErr := iaws.TerminateInstance(awsCfg, launchResult.InstanceId)
+	elapsed := time.Since(startTime)
+	if termErr != nil {
+		termErr = fmt.Errorf("Selftest failed to terminate %v; please terminate it manually: %w",
+			launchResult.InstanceId, termErr)
+	}
+
+	fmt.Printf("Elapsed: %v; estimated cost: $%.4f ($%v/hr)\n",
+		elapsed.Round(time.Second), estimateCost(price, elapsed), price)
+	if testErr != nil {
+		if termErr != nil {
+			return fmt.Errorf("%w\n%v", testErr, termErr)
+		}
+		return testErr
+	}
+	if termErr != nil {
+		return termErr
+	}
+	fmt.Printf("selftest passed\n")
+
+	return nil
+}
+
+func selftestSsh(awsCfg aws.Config,
+	launchResult *iaws.LaunchEc2SpotResult) error {
+
+	fmt.Printf("[2/4] Waiting for ssh on %v...\n", launchResult.PublicIp)
+	port, err := loadSshPort(awsCfg)
+	if err != nil {
+		return err
+	}
+	err = waitForSsh(awsCfg, launchResult, port)
+	if err != nil {
+		return fmt.Errorf("Selftest failed to reach ssh: %w", err)
+	}
+
+	fmt.Printf("[3/4] Running uname -a...\n")
+	output, err := runRemote(launchResult, port, []string{"uname", "-a"}, nil,
+		remoteCmdTimeout)
+	if err != nil {
+		return fmt.Errorf("Selftest failed to run remote command: %w", err)
+	}
+	fmt.Printf("      %v\n", strings.TrimSpace(output))
+
+	return nil
+}
+
+// estimateCost returns the approximate USD cost of running an instance at
+// hourly price for elapsed, accounting for EC2's minimum billing duration
+func estimateCost(price float64, elapsed time.Duration) float64 {
+	if elapsed < minBilledDuration {
+		elapsed = minBilledDuration
+	}
+
+	return price * elapsed.Hours()
+}
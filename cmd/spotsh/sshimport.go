@@ -0,0 +1,83 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sshImportUrlFmts maps ssh-import-id's protocol prefixes to the url from
+// which each user's public keys are fetched; tests may substitute them
+var sshImportUrlFmts = map[string]string{
+	"gh": "https://github.com/%v.keys",
+	"lp": "https://launchpad.net/~%v/+sshkeys",
+}
+
+// fetchSshImportIds fetches the public keys of each of the comma separated
+// ssh-import-id style ids (e.g. gh:alice,lp:bob) so that a mistyped user
+// fails the launch rather than silently authorizing nothing
+func fetchSshImportIds(idList string) ([]string, error) {
+	client := http.Client{
+		Timeout: time.Second * 30,
+	}
+
+	keys := make([]string, 0)
+	for _, id := range strings.Split(idList, ",") {
+		id = strings.TrimSpace(id)
+		proto, user, found := strings.Cut(id, ":")
+		if !found {
+			// ssh-import-id itself defaults to launchpad
+			proto, user = "lp", id
+		}
+		urlFmt, ok := sshImportUrlFmts[proto]
+		if !ok || user == "" {
+			return nil, fmt.Errorf("Invalid ssh import id '%v'; expected gh:<user> or lp:<user>",
+				id)
+		}
+		url := fmt.Sprintf(urlFmt, user)
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to fetch keys of %v: %w", id, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to fetch keys of %v: %w", id, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Failed to fetch keys of %v from %v: %v",
+				id, url, resp.Status)
+		}
+
+		userKeys := parsePublicKeys(string(body))
+		if len(userKeys) == 0 {
+			return nil, fmt.Errorf("No ssh public keys found for %v at %v",
+				id, url)
+		}
+		keys = append(keys, userKeys...)
+	}
+
+	return keys, nil
+}
+
+// parsePublicKeys returns the lines of keysText which look like ssh public
+// keys
+func parsePublicKeys(keysText string) []string {
+	keys := make([]string, 0)
+	for _, line := range strings.Split(keysText, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "ssh-") ||
+			strings.HasPrefix(line, "ecdsa-") ||
+			strings.HasPrefix(line, "sk-") {
+			keys = append(keys, line)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,47 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchSshImportIds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		switch r.URL.Path {
+		case "/gh/alice.keys":
+			fmt.Fprintf(w, "ssh-ed25519 AAAA1\nssh-rsa AAAA2\n")
+		case "/gh/empty.keys":
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	origUrlFmts := sshImportUrlFmts
+	sshImportUrlFmts = map[string]string{"gh": server.URL + "/gh/%v.keys"}
+	defer func() { sshImportUrlFmts = origUrlFmts }()
+
+	keys, err := fetchSshImportIds("gh:alice")
+	if err != nil || len(keys) != 2 {
+		t.Errorf("expected 2 keys but got %v err:%v", keys, err)
+	}
+	_, err = fetchSshImportIds("gh:alice,gh:typo")
+	if err == nil {
+		t.Errorf("expected error w/ unknown user")
+	}
+	_, err = fetchSshImportIds("gh:empty")
+	if err == nil {
+		t.Errorf("expected error w/ no keys")
+	}
+	_, err = fetchSshImportIds("xx:alice")
+	if err == nil {
+		t.Errorf("expected error w/ unknown protocol")
+	}
+}
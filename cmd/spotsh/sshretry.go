@@ -0,0 +1,62 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// transientSshErrs are ssh client errors seen when an instance's ssh port
+// is open but sshd (or cloud-init's installation of the authorized key) has
+// not yet finished initializing
+var transientSshErrs = []string{
+	"Connection reset by peer",
+	"Connection closed by",
+	"Connection refused",
+	"kex_exchange_identification",
+	"ssh_exchange_identification",
+	"Permission denied (publickey",
+}
+
+const sshRetryAttempts = 3
+
+// sshClientExitCode is ssh's exit status when ssh itself, rather than the
+// remote command, fails
+const sshClientExitCode = 255
+
+// sshRetryDelay is the pause between attempts; tests may shorten it
+var sshRetryDelay = 2 * time.Second
+
+func isTransientSshFailure(stderr string) bool {
+	for _, transientErr := range transientSshErrs {
+		if strings.Contains(stderr, transientErr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryTransientSsh invokes run, which returns its ssh client's stderr
+// output, until it succeeds, fails for a non-transient reason, or
+// sshRetryAttempts is reached. Only non-interactive invocations should be
+// retried since the user's input cannot be replayed, and run should only
+// return stderr which the ssh client itself rather than a remote command
+// wrote.
+func retryTransientSsh(run func() (string, error)) error {
+	for attempt := 1; ; attempt++ {
+		stderr, err := run()
+		if err == nil || attempt >= sshRetryAttempts ||
+			!isTransientSshFailure(stderr) {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: ssh connection failed; retrying in %v (attempt %v of %v)...\n",
+			sshRetryDelay, attempt+1, sshRetryAttempts)
+		time.Sleep(sshRetryDelay)
+	}
+}
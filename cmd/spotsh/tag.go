@@ -0,0 +1,82 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+const tagUsage = "spotsh tag <set <key>=<value>|rm <key>|get <key>> must be specified"
+
+func tagMain(awsCfg aws.Config, args []string) error {
+	selectedInstance, _, err := selectOrLaunchWithArgs(&awsCfg, "spotsh tag",
+		false, &args, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf(tagUsage)
+	}
+	instanceId := selectedInstance.InstanceId
+
+	switch strings.ToLower(args[0]) {
+	case "set":
+		key, value, found := strings.Cut(args[1], "=")
+		if !found || key == "" {
+			return fmt.Errorf("Invalid tag '%v'; expected <key>=<value>",
+				args[1])
+		}
+		err = checkTagModifiable(key)
+		if err != nil {
+			return err
+		}
+		err = iaws.UpdateTag(awsCfg, instanceId, key, value)
+		if err != nil {
+			return fmt.Errorf("Failed to set tag %v on %v: %w", key,
+				instanceId, err)
+		}
+	case "rm":
+		key := args[1]
+		err = checkTagModifiable(key)
+		if err != nil {
+			return err
+		}
+		if _, ok := selectedInstance.Tags[key]; !ok {
+			return fmt.Errorf("Tag %v is not set on %v", key, instanceId)
+		}
+		err = iaws.DeleteTag(awsCfg, instanceId, key)
+		if err != nil {
+			return fmt.Errorf("Failed to remove tag %v from %v: %w", key,
+				instanceId, err)
+		}
+	case "get":
+		key := args[1]
+		value, ok := selectedInstance.Tags[key]
+		if !ok {
+			return fmt.Errorf("Tag %v is not set on %v", key, instanceId)
+		}
+		fmt.Printf("%v\n", value)
+	default:
+		return fmt.Errorf(tagUsage)
+	}
+
+	return nil
+}
+
+// checkTagModifiable refuses changes to tags which spotsh relies upon to
+// identify its instances or which AWS itself manages
+func checkTagModifiable(key string) error {
+	if iaws.IsReservedTag(iaws.DefaultTagPrefix, key) {
+		return fmt.Errorf("Tag %v is reserved and cannot be modified", key)
+	}
+
+	return nil
+}
@@ -5,7 +5,10 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,6 +16,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/mikeb26/spotsh"
@@ -27,8 +31,16 @@ const (
 	SetupVpnServerScript    = "setupVpnServer.sh"
 	SetupVpnClientScript    = "setupVpnClient.sh"
 	TeardownVpnClientScript = "teardownVpnClient.sh"
+	DefaultRemoteCmdTimeout = 5 * time.Minute
+	VpnInterface            = "wg0"
+	VpnServerTunnelIp       = "10.226.0.1" // see setupVpnClient.sh
 )
 
+// remoteCmdTimeout is the runRemote timeout of the vpn's remote commands
+// (see --remote-timeout) so that an instance which becomes unreachable
+// mid-operation can't hang spotsh
+var remoteCmdTimeout = DefaultRemoteCmdTimeout
+
 //go:embed setupVpnServer.sh
 var setupVpnServerText string
 
@@ -38,10 +50,24 @@ var setupVpnClientText string
 //go:embed teardownVpnClient.sh
 var teardownVpnClientText string
 
+// addVpnFlags defines vpn's own flags; as documented these may either precede
+// or follow the vpn action
+func addVpnFlags(f *flag.FlagSet, dryRun *bool, ping *bool) {
+	f.BoolVar(dryRun, "dry-run", *dryRun,
+		"Display the steps vpn start would take w/o taking them")
+	f.BoolVar(ping, "ping", *ping,
+		"Check the server is reachable through the tunnel")
+	f.DurationVar(&remoteCmdTimeout, "remote-timeout", remoteCmdTimeout,
+		"Fail any command vpn runs on the instance which takes longer")
+}
+
 func vpnMain(awsCfg aws.Config, args []string) error {
+	var dryRun, ping bool
 	fmt.Fprintf(os.Stderr, "Selecting or launching spot instance...\n")
-	selectedResult, err := selectOrLaunchWithArgs(awsCfg, "spotsh vpn", false,
-		&args)
+	selectedResult, opts, err := selectOrLaunchWithArgs(&awsCfg, "spotsh vpn",
+		false, &args, func(f *flag.FlagSet) {
+			addVpnFlags(f, &dryRun, &ping)
+		})
 	if err != nil {
 		return err
 	}
@@ -51,18 +77,54 @@ func vpnMain(awsCfg aws.Config, args []string) error {
 		return fmt.Errorf("spotsh vpn is only currently supported on Amazon Linux 2023 spot instances")
 	}
 
-	if len(args) != 1 || (strings.ToLower(args[0]) != "start" &&
-		strings.ToLower(args[0]) != "stop") {
-		return fmt.Errorf("spotsh vpn <start|stop> must be specified")
+	if len(args) < 1 || (strings.ToLower(args[0]) != "start" &&
+		strings.ToLower(args[0]) != "stop" &&
+		strings.ToLower(args[0]) != "status") {
+		return fmt.Errorf("spotsh vpn <start|stop|status> must be specified")
+	}
+	actionFlags := flag.NewFlagSet("spotsh vpn "+strings.ToLower(args[0]),
+		flag.ContinueOnError)
+	addVpnFlags(actionFlags, &dryRun, &ping)
+	err = actionFlags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if actionFlags.NArg() != 0 {
+		return fmt.Errorf("spotsh vpn <start|stop|status> must be specified")
+	}
+	if remoteCmdTimeout <= 0 {
+		return fmt.Errorf("Invalid --remote-timeout %v; must be a positive duration such as 90s",
+			remoteCmdTimeout)
+	}
+
+	if ping && strings.ToLower(args[0]) != "status" {
+		return fmt.Errorf("--ping is only supported w/ spotsh vpn status")
+	}
+	if strings.ToLower(args[0]) == "status" {
+		return vpnStatus(awsCfg, selectedResult, ping)
+	}
+
+	if dryRun && strings.ToLower(args[0]) != "start" {
+		return fmt.Errorf("--dry-run is only supported w/ spotsh vpn start")
+	}
+	if dryRun {
+		return printVpnStartPlan(os.Stdout, selectedResult)
 	}
 
 	if strings.ToLower(args[0]) == "start" {
-		err = startVpnServer(selectedResult)
+		// a freshly launched instance may not be accepting ssh yet
+		if !opts.noFirewall {
+			err = waitForSsh(awsCfg, selectedResult, opts.port)
+			if err != nil {
+				return err
+			}
+		}
+		err = startVpnServer(selectedResult, opts.port)
 		if err != nil {
 			return err
 		}
 
-		err = startVpnClient(awsCfg, selectedResult)
+		err = startVpnClient(awsCfg, selectedResult, opts.port)
 		if err != nil {
 			return err
 		}
@@ -77,6 +139,75 @@ func vpnMain(awsCfg aws.Config, args []string) error {
 	return nil
 }
 
+// printVpnStartPlan describes each step 'spotsh vpn start' would take
+// against selectedResult, including the local networking commands run by
+// setupVpnClient.sh, without performing any of them
+func printVpnStartPlan(out io.Writer,
+	selectedResult *iaws.LaunchEc2SpotResult) error {
+
+	configDir, err := getConfigDir()
+	if err != nil {
+		return err
+	}
+	clientPrivKeyFilePath := filepath.Join(configDir, ClientPrivKeyFile)
+	clientPubKey, err := readClientPubKey()
+	if err != nil {
+		clientPubKey = "<missing; run 'spotsh config'>"
+	}
+	serverPubKey := "<generated by " + SetupVpnServerScript + ">"
+	userAtIp := selectedResult.User + "@" + selectedResult.PublicIp
+
+	fmt.Fprintf(out, "spotsh vpn start would:\n")
+	fmt.Fprintf(out, "  1. on %v: mkdir -p %v\n", userAtIp, VpnServerWorkingDir)
+	fmt.Fprintf(out, "  2. on %v: write %v/%v (%v bytes) & chmod 755 it\n",
+		userAtIp, VpnServerWorkingDir, SetupVpnServerScript,
+		len(setupVpnServerText))
+	fmt.Fprintf(out, "  3. on %v: cd %v; ./%v %v %v\n", userAtIp,
+		VpnServerWorkingDir, SetupVpnServerScript, clientPubKey,
+		ServerPubKeyFile)
+	fmt.Fprintf(out, "  4. on %v: read the server public key from %v/%v\n",
+		userAtIp, VpnServerWorkingDir, ServerPubKeyFile)
+	fmt.Fprintf(out, "  5. set tag %v.%v=true on %v\n", iaws.DefaultTagPrefix,
+		iaws.VpnTagSuffix, selectedResult.InstanceId)
+	fmt.Fprintf(out, "  6. locally: %v %v %v %v which runs:\n",
+		SetupVpnClientScript, serverPubKey, selectedResult.PublicIp,
+		clientPrivKeyFilePath)
+	for _, cmd := range expandScriptCommands(setupVpnClientText,
+		[]string{serverPubKey, selectedResult.PublicIp,
+			clientPrivKeyFilePath}) {
+		fmt.Fprintf(out, "       %v\n", cmd)
+	}
+
+	return nil
+}
+
+// expandScriptCommands returns the sudo commands of a simple shell script w/
+// references to its positional parameters & NAME=value assignments expanded
+func expandScriptCommands(scriptText string, params []string) []string {
+	vars := make(map[string]string)
+	for idx, param := range params {
+		vars[fmt.Sprintf("%v", idx+1)] = param
+	}
+
+	var cmds []string
+	for _, line := range strings.Split(scriptText, "\n") {
+		line = strings.TrimSpace(line)
+		expanded := os.Expand(line, func(name string) string {
+			return vars[name]
+		})
+		if strings.HasPrefix(line, "sudo ") {
+			cmds = append(cmds, expanded)
+			continue
+		}
+		name, value, found := strings.Cut(expanded, "=")
+		if found && name != "" && !strings.ContainsAny(name, " \t[$") {
+			vars[name] = value
+		}
+	}
+
+	return cmds
+}
+
 func setupVpnClientKey(awsCfg aws.Config, args []string,
 	configDir string) error {
 
@@ -123,26 +254,90 @@ func setupVpnClientKey(awsCfg aws.Config, args []string,
 	return nil
 }
 
-func runRemote(selectedResult *iaws.LaunchEc2SpotResult,
-	cmdAndArgs []string, stdinReader io.Reader) (string, error) {
+// runRemote runs cmdAndArgs on selectedResult via ssh returning its output.
+// Transient ssh failures are retried but timeout bounds the overall time
+// spent including any retries.
+func runRemote(selectedResult *iaws.LaunchEc2SpotResult, port int32,
+	cmdAndArgs []string, stdinReader io.Reader,
+	timeout time.Duration) (string, error) {
+
+	// stdin can't be replayed so don't retry
+	return runRemoteCommon(selectedResult, port, cmdAndArgs, stdinReader,
+		timeout, stdinReader == nil)
+}
+
+// runRemoteNoRetry is runRemote w/o retries for commands which aren't safe
+// to run again should the connection fail after they've started; e.g. drain
+// commands & the vpn server setup script
+func runRemoteNoRetry(selectedResult *iaws.LaunchEc2SpotResult, port int32,
+	cmdAndArgs []string, timeout time.Duration) (string, error) {
 
-	sshArgs := []string{"-i", selectedResult.LocalKeyFile, "-o",
-		"StrictHostKeyChecking=no",
-		selectedResult.User + "@" + selectedResult.PublicIp}
+	return runRemoteCommon(selectedResult, port, cmdAndArgs, nil, timeout,
+		false)
+}
+
+func runRemoteCommon(selectedResult *iaws.LaunchEc2SpotResult, port int32,
+	cmdAndArgs []string, stdinReader io.Reader, timeout time.Duration,
+	retry bool) (string, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var output string
+	var err error
+	if !retry {
+		output, _, err = runRemoteOnce(ctx, selectedResult, port, cmdAndArgs,
+			stdinReader)
+	} else {
+		err = retryTransientSsh(func() (string, error) {
+			var err error
+			var sshFailed bool
+			output, sshFailed, err = runRemoteOnce(ctx, selectedResult, port,
+				cmdAndArgs, nil)
+			if err != nil && sshFailed {
+				return err.Error(), err
+			}
+			// the remote command's own stderr may mention e.g. "Connection
+			// refused" after it has already run so don't consider it
+			return "", err
+		})
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("Remote command '%v' on %v timed out after %v",
+			strings.Join(cmdAndArgs, " "), selectedResult.PublicIp, timeout)
+	}
+
+	return output, err
+}
+
+// runRemoteOnce runs cmdAndArgs on selectedResult via ssh returning its
+// output; sshFailed is set when ssh itself rather than the remote command
+// failed
+func runRemoteOnce(ctx context.Context,
+	selectedResult *iaws.LaunchEc2SpotResult, port int32, cmdAndArgs []string,
+	stdinReader io.Reader) (output string, sshFailed bool, err error) {
+
+	sshArgs := []string{"-i", selectedResult.LocalKeyFile}
+	sshArgs = append(sshArgs, getHostKeyArgs(selectedResult)...)
+	sshArgs = append(sshArgs, getSshPortArgs(port)...)
+	sshArgs = append(sshArgs, selectedResult.User+"@"+selectedResult.PublicIp)
 	sshArgs = append(sshArgs, cmdAndArgs...)
-	cmd := exec.Command("ssh", sshArgs...)
+	cmd := exec.CommandContext(ctx, "ssh", sshArgs...)
+	// don't wait indefinitely on output pipes held open by ssh's children
+	// once ssh itself has been killed
+	cmd.WaitDelay = time.Second
 	if stdinReader != nil {
 		cmd.Stdin = stdinReader
 	}
-	output, err := cmd.Output()
+	outputRaw, err := cmd.Output()
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
+			sshFailed = exitError.ExitCode() == sshClientExitCode
 			err = fmt.Errorf(string(exitError.Stderr))
 		}
-		return "", err
+		return "", sshFailed, err
 	}
 
-	return string(output), nil
+	return string(outputRaw), false, nil
 }
 
 func runLocal(cmdAndArgs []string, stdinReader io.Reader) (string, error) {
@@ -175,10 +370,13 @@ func readClientPubKey() (string, error) {
 	return strings.Split(fileContent, "\n")[0], nil
 }
 
-func readServerPubKey(selectedResult *iaws.LaunchEc2SpotResult) (string, error) {
+func readServerPubKey(selectedResult *iaws.LaunchEc2SpotResult,
+	port int32) (string, error) {
+
 	serverPubKeyPath := VpnServerWorkingDir + "/" + ServerPubKeyFile
 	cmdAndArgs := []string{"cat", serverPubKeyPath}
-	serverPubKey, err := runRemote(selectedResult, cmdAndArgs, nil)
+	serverPubKey, err := runRemote(selectedResult, port, cmdAndArgs, nil,
+		remoteCmdTimeout)
 	if err != nil {
 		return "", fmt.Errorf("Failed to read vpn server public key: %w", err)
 	}
@@ -186,23 +384,27 @@ func readServerPubKey(selectedResult *iaws.LaunchEc2SpotResult) (string, error)
 	return strings.Split(serverPubKey, "\n")[0], nil
 }
 
-func startVpnServer(selectedResult *iaws.LaunchEc2SpotResult) error {
+func startVpnServer(selectedResult *iaws.LaunchEc2SpotResult,
+	port int32) error {
+
 	fmt.Fprintf(os.Stderr, "Copying vpn setup scripts to spot instance...\n")
 
 	cmdAndArgs := []string{"mkdir", "-p", VpnServerWorkingDir}
-	_, err := runRemote(selectedResult, cmdAndArgs, nil)
+	_, err := runRemote(selectedResult, port, cmdAndArgs, nil,
+		remoteCmdTimeout)
 	if err != nil {
 		return fmt.Errorf("Failed to create vpn working dir: %w", err)
 	}
 	vpnSetupScriptPath := VpnServerWorkingDir + "/" + SetupVpnServerScript
 	cmdAndArgs = []string{"cat", ">" + vpnSetupScriptPath}
-	_, err = runRemote(selectedResult, cmdAndArgs,
-		strings.NewReader(setupVpnServerText))
+	_, err = runRemote(selectedResult, port, cmdAndArgs,
+		strings.NewReader(setupVpnServerText), remoteCmdTimeout)
 	if err != nil {
 		return fmt.Errorf("Failed to copy vpn server setup script: %w", err)
 	}
 	cmdAndArgs = []string{"chmod", "755", vpnSetupScriptPath}
-	_, err = runRemote(selectedResult, cmdAndArgs, nil)
+	_, err = runRemote(selectedResult, port, cmdAndArgs, nil,
+		remoteCmdTimeout)
 	if err != nil {
 		return fmt.Errorf("Failed to set vpn server setup permissions: %w", err)
 	}
@@ -215,7 +417,8 @@ func startVpnServer(selectedResult *iaws.LaunchEc2SpotResult) error {
 
 	cmdAndArgs = []string{"cd " + VpnServerWorkingDir + ";",
 		"./" + SetupVpnServerScript, clientPubKey, ServerPubKeyFile}
-	_, err = runRemote(selectedResult, cmdAndArgs, nil)
+	_, err = runRemoteNoRetry(selectedResult, port, cmdAndArgs,
+		remoteCmdTimeout)
 	if err != nil {
 		return fmt.Errorf("Failed to start vpn server: %w", err)
 	}
@@ -224,7 +427,7 @@ func startVpnServer(selectedResult *iaws.LaunchEc2SpotResult) error {
 }
 
 func startVpnClient(awsCfg aws.Config,
-	selectedResult *iaws.LaunchEc2SpotResult) error {
+	selectedResult *iaws.LaunchEc2SpotResult, port int32) error {
 
 	tempDir, err := ioutil.TempDir("", "spotsh.vpn.*")
 	if err != nil {
@@ -239,7 +442,7 @@ func startVpnClient(awsCfg aws.Config,
 		return fmt.Errorf("Failed to copy vpn client setup script: %w", err)
 	}
 
-	serverPubKey, err := readServerPubKey(selectedResult)
+	serverPubKey, err := readServerPubKey(selectedResult, port)
 	if err != nil {
 		return err
 	}
@@ -301,3 +504,89 @@ func stopVpnClient(awsCfg aws.Config,
 
 	return nil
 }
+
+// vpnStatus reports whether selectedResult is tagged as a vpn server along
+// w/ the local wireguard interface's view of the tunnel, & when ping is set
+// whether traffic actually reaches the server through it
+func vpnStatus(awsCfg aws.Config, selectedResult *iaws.LaunchEc2SpotResult,
+	ping bool) error {
+
+	vpnTagKey := iaws.DefaultTagPrefix + "." + iaws.VpnTagSuffix
+	tagValue, err := iaws.GetTagValue(awsCfg, selectedResult.InstanceId,
+		vpnTagKey)
+	if err != nil {
+		return fmt.Errorf("Failed to read instance's vpn tag: %w", err)
+	}
+	if tagValue == "" {
+		tagValue = "false"
+	}
+
+	wgOutput, wgErr := runLocal([]string{"sudo", "wg", "show", VpnInterface},
+		nil)
+	printVpnStatus(os.Stdout, selectedResult, tagValue, wgOutput, wgErr)
+
+	if !ping {
+		return nil
+	}
+	_, err = runLocal([]string{"ping", "-c", "1", "-W", "2",
+		VpnServerTunnelIp}, nil)
+	if err != nil {
+		fmt.Printf("Tunnel ping %v: failed\n", VpnServerTunnelIp)
+		return fmt.Errorf("vpn server %v is unreachable through the tunnel",
+			VpnServerTunnelIp)
+	}
+	fmt.Printf("Tunnel ping %v: ok\n", VpnServerTunnelIp)
+
+	return nil
+}
+
+func printVpnStatus(out io.Writer, selectedResult *iaws.LaunchEc2SpotResult,
+	tagValue string, wgOutput string, wgErr error) {
+
+	fmt.Fprintf(out, "Instance: %v (%v)\n", selectedResult.InstanceId,
+		selectedResult.PublicIp)
+	fmt.Fprintf(out, "VPN tag (%v.%v): %v\n", iaws.DefaultTagPrefix,
+		iaws.VpnTagSuffix, tagValue)
+	if wgErr != nil {
+		fmt.Fprintf(out, "Local interface %v: down (%v)\n", VpnInterface,
+			strings.TrimSpace(wgErr.Error()))
+		return
+	}
+	fmt.Fprintf(out, "Local interface %v: up\n", VpnInterface)
+
+	endpoint, handshake, transfer := parseWgShow(wgOutput)
+	if endpoint == "" {
+		endpoint = "<none>"
+	}
+	if handshake == "" {
+		handshake = "never"
+	}
+	if transfer == "" {
+		transfer = "none"
+	}
+	fmt.Fprintf(out, "Peer endpoint: %v\n", endpoint)
+	fmt.Fprintf(out, "Latest handshake: %v\n", handshake)
+	fmt.Fprintf(out, "Transfer: %v\n", transfer)
+}
+
+// parseWgShow extracts the server peer's stats from 'wg show <iface>'
+// output; a tunnel is only passing traffic once a handshake has occurred
+func parseWgShow(wgOutput string) (endpoint, handshake, transfer string) {
+	for _, line := range strings.Split(wgOutput, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "endpoint":
+			endpoint = value
+		case "latest handshake":
+			handshake = value
+		case "transfer":
+			transfer = value
+		}
+	}
+
+	return endpoint, handshake, transfer
+}
@@ -0,0 +1,146 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+func TestExpandScriptCommands(t *testing.T) {
+	cmds := expandScriptCommands(setupVpnClientText,
+		[]string{"SRVKEY", "192.0.2.1", "/cfg/wg.private.key"})
+
+	expected := []string{
+		"sudo ip addr add 10.226.0.2/24 dev wg0",
+		"sudo wg set wg0 private-key /cfg/wg.private.key",
+		"sudo wg set wg0 peer SRVKEY allowed-ips 0.0.0.0/0 endpoint 192.0.2.1:26026 persistent-keepalive 25",
+	}
+	allCmds := strings.Join(cmds, "\n")
+	for _, cmd := range expected {
+		if !strings.Contains(allCmds, cmd) {
+			t.Errorf("expected %q in plan; got:\n%v", cmd, allCmds)
+		}
+	}
+	if strings.Contains(allCmds, "$") {
+		t.Errorf("expected all variables to be expanded; got:\n%v", allCmds)
+	}
+}
+
+func TestRunRemoteTimeout(t *testing.T) {
+	binDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(binDir, "ssh"),
+		[]byte("#!/bin/sh\nexec /bin/sleep 10\n"), 0700)
+	if err != nil {
+		t.Fatalf("failed to write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", binDir)
+	start := time.Now()
+	_, err = runRemote(newTestInstance(), iaws.DefaultSshPort,
+		[]string{"true"}, nil, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error but got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("runRemote did not kill ssh on timeout")
+	}
+}
+
+func TestRunRemoteNoRetry(t *testing.T) {
+	binDir := t.TempDir()
+	countFile := filepath.Join(binDir, "count")
+	script := "#!/bin/sh\necho x >> " + countFile +
+		"\necho 'Connection closed by 192.0.2.1 port 22' >&2\nexit 255\n"
+	err := os.WriteFile(filepath.Join(binDir, "ssh"), []byte(script), 0700)
+	if err != nil {
+		t.Fatalf("failed to write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+	origDelay := sshRetryDelay
+	sshRetryDelay = time.Millisecond
+	defer func() { sshRetryDelay = origDelay }()
+
+	_, err = runRemoteNoRetry(newTestInstance(), iaws.DefaultSshPort,
+		[]string{"true"}, 5*time.Second)
+	if err == nil {
+		t.Fatalf("expected error from failed ssh")
+	}
+	count, _ := os.ReadFile(countFile)
+	if attempts := strings.Count(string(count), "x"); attempts != 1 {
+		t.Errorf("expected 1 attempt but got %v", attempts)
+	}
+
+	_, _ = runRemote(newTestInstance(), iaws.DefaultSshPort,
+		[]string{"true"}, nil, 5*time.Second)
+	count, _ = os.ReadFile(countFile)
+	if attempts := strings.Count(string(count), "x"); attempts !=
+		1+sshRetryAttempts {
+		t.Errorf("expected runRemote to retry %v times but got %v",
+			sshRetryAttempts, attempts-1)
+	}
+}
+
+func TestRunRemoteNoRetryOfRemoteFailure(t *testing.T) {
+	binDir := t.TempDir()
+	countFile := filepath.Join(binDir, "count")
+	// the remote command rather than ssh fails w/ a connection error
+	script := "#!/bin/sh\necho x >> " + countFile +
+		"\necho 'curl: (7) Connection refused' >&2\nexit 7\n"
+	err := os.WriteFile(filepath.Join(binDir, "ssh"), []byte(script), 0700)
+	if err != nil {
+		t.Fatalf("failed to write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+	origDelay := sshRetryDelay
+	sshRetryDelay = time.Millisecond
+	defer func() { sshRetryDelay = origDelay }()
+
+	_, err = runRemote(newTestInstance(), iaws.DefaultSshPort,
+		[]string{"curl", "localhost"}, nil, 5*time.Second)
+	if err == nil {
+		t.Fatalf("expected error from failed remote command")
+	}
+	count, _ := os.ReadFile(countFile)
+	if attempts := strings.Count(string(count), "x"); attempts != 1 {
+		t.Errorf("expected 1 attempt but got %v", attempts)
+	}
+}
+
+func TestParseWgShow(t *testing.T) {
+	wgOutput := `interface: wg0
+  public key: CLIENTKEY
+  private key: (hidden)
+  listening port: 26026
+  fwmark: 0x4d2
+
+peer: SRVKEY
+  endpoint: 192.0.2.1:26026
+  allowed ips: 0.0.0.0/0
+  latest handshake: 1 minute, 2 seconds ago
+  transfer: 1.21 KiB received, 3.05 KiB sent
+  persistent keepalive: every 25 seconds
+`
+	endpoint, handshake, transfer := parseWgShow(wgOutput)
+	if endpoint != "192.0.2.1:26026" {
+		t.Errorf("unexpected endpoint %v", endpoint)
+	}
+	if handshake != "1 minute, 2 seconds ago" {
+		t.Errorf("unexpected handshake %v", handshake)
+	}
+	if transfer != "1.21 KiB received, 3.05 KiB sent" {
+		t.Errorf("unexpected transfer %v", transfer)
+	}
+
+	var out strings.Builder
+	printVpnStatus(&out, newTestInstance(), "true", "interface: wg0\n", nil)
+	if !strings.Contains(out.String(), "Latest handshake: never") {
+		t.Errorf("expected no handshake; got %v", out.String())
+	}
+}
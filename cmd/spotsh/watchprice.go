@@ -0,0 +1,75 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	iaws "github.com/mikeb26/spotsh/aws"
+)
+
+const DefaultPriceWarnPct = 20.0
+
+// watchInstancePrices polls the current spot price of each running spot
+// shell instance every interval, warning about any whose price is within
+// warnPct percent of the max spot price it was launched w/ & thus at
+// increased risk of being reclaimed. It runs until interrupted.
+func watchInstancePrices(awsCfg aws.Config, interval time.Duration,
+	warnPct float64) error {
+
+	for {
+		launchResults, err := iaws.LookupEc2Spot(context.Background(), awsCfg,
+			iaws.DefaultTagPrefix)
+		if err != nil {
+			return fmt.Errorf("Failed to lookup instance: %w", err)
+		}
+
+		now := time.Now().Format(time.TimeOnly)
+		for idx := range launchResults {
+			lr := &launchResults[idx]
+			if lr.Lifecycle == iaws.MarketOnDemand {
+				continue
+			}
+			warning := getPriceProximityWarning(lr, warnPct)
+			if warning != "" {
+				fmt.Fprintf(os.Stderr, "%v: *WARN*: %v\n", now, warning)
+			} else {
+				fmt.Fprintf(os.Stderr, "%v: %v %v $%v/hr\n", now,
+					lr.InstanceId, lr.InstanceType, lr.CurrentPrice)
+			}
+		}
+		if len(launchResults) == 0 {
+			fmt.Fprintf(os.Stderr, "%v: no spot shell instances running\n",
+				now)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// getPriceProximityWarning returns a warning when lr's current spot price is
+// within warnPct percent of its max spot price, or "" otherwise including
+// when lr predates spotsh recording its max price
+func getPriceProximityWarning(lr *iaws.LaunchEc2SpotResult,
+	warnPct float64) string {
+
+	maxPrice, err := strconv.ParseFloat(lr.MaxSpotPrice, 64)
+	if err != nil || maxPrice <= 0 || lr.CurrentPrice <= 0 {
+		return ""
+	}
+	if lr.CurrentPrice < maxPrice*(100-warnPct)/100 {
+		return ""
+	}
+
+	return fmt.Sprintf("%v %v spot price $%v/hr is %.0f%% of its $%v/hr max; it is at risk of being reclaimed",
+		lr.InstanceId, lr.InstanceType, lr.CurrentPrice,
+		lr.CurrentPrice/maxPrice*100, lr.MaxSpotPrice)
+}
@@ -0,0 +1,29 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"testing"
+)
+
+func TestGetPriceProximityWarning(t *testing.T) {
+	lr := newTestInstance()
+	lr.MaxSpotPrice = "0.10"
+
+	lr.CurrentPrice = 0.05
+	if warning := getPriceProximityWarning(lr, 20); warning != "" {
+		t.Errorf("unexpected warning at 50%% of max: %v", warning)
+	}
+	lr.CurrentPrice = 0.085
+	if warning := getPriceProximityWarning(lr, 20); warning == "" {
+		t.Errorf("expected warning at 85%% of max")
+	}
+
+	// instances launched before the max price tag existed can't be checked
+	lr.MaxSpotPrice = ""
+	if warning := getPriceProximityWarning(lr, 20); warning != "" {
+		t.Errorf("unexpected warning w/o max price tag: %v", warning)
+	}
+}
@@ -34,7 +34,7 @@ var osMap = make(map[string]OperatingSystem)
 
 func (os OperatingSystem) String() string {
 	idx := int(os)
-	if idx < 0 || idx > len(osTab) {
+	if idx < 0 || idx >= len(osTab) {
 		idx = int(OsInvalid)
 	}
 
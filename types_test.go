@@ -38,4 +38,7 @@ func TestOsToFromString(t *testing.T) {
 	if OperatingSystem(0xdeadbeef).String() != "invalid" {
 		t.Fatalf("Os.String() invalid test failed")
 	}
+	if OperatingSystem(len(osTab)).String() != "invalid" {
+		t.Fatalf("Os.String() one past the end test failed")
+	}
 }